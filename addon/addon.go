@@ -75,3 +75,7 @@ func (a *Addon) HasErr(errCode policy.PolicyCode) bool {
 func (a *Addon) ResyncPingTimer() {
 	a.msk.ResyncPingTimer()
 }
+
+func (a *Addon) CheckAutoIncrement() []error {
+	return a.msk.CheckAutoIncrement()
+}
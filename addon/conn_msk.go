@@ -23,9 +23,8 @@ type MSKConn struct {
 
 func (mskc *MSKConn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*MSKTx, error) {
 	tx, err := mskc.Conn.BeginTx(ctx, opts)
-	msTx := &MSKTx{msk: mskc.msk}
+	msTx := newMSKTx(tx, mskc.msk)
 
-	msTx.Tx = tx
 	return msTx, err
 }
 
@@ -39,6 +38,7 @@ func (mskc *MSKConn) PrepareContext(ctx context.Context, query string) (*MSKStmt
 
 func (mskc *MSKConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
 	nargs, _ := converter{}.ConvertValues(args)
+	trackQueryScope(ctx, mskc.msk, query, nargs)
 	defer mskc.msk.AfterProcess(time.Now(), query, nargs)
 
 	return mskc.Conn.QueryRowContext(ctx, query, args...)
@@ -46,6 +46,7 @@ func (mskc *MSKConn) QueryRowContext(ctx context.Context, query string, args ...
 
 func (mskc *MSKConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
 	nargs, _ := converter{}.ConvertValues(args)
+	trackQueryScope(ctx, mskc.msk, query, nargs)
 	defer mskc.msk.AfterProcess(time.Now(), query, nargs)
 
 	return mskc.Conn.QueryContext(ctx, query, args...)
@@ -53,6 +54,8 @@ func (mskc *MSKConn) QueryContext(ctx context.Context, query string, args ...int
 
 func (mskc *MSKConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
 	nargs, _ := converter{}.ConvertValues(args)
+	trackQueryScope(ctx, mskc.msk, query, nargs)
+	mskc.msk.BeforeProcess(query, nargs)
 	defer mskc.msk.AfterProcess(time.Now(), query, nargs)
 
 	return mskc.Conn.ExecContext(ctx, query, args...)
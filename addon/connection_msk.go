@@ -16,21 +16,21 @@ import (
 
 func (mska *Addon) Begin() (*MSKTx, error) {
 	tx, err := mska.db.Begin()
-	msTx := &MSKTx{msk: mska.msk}
+	msTx := newMSKTx(tx, mska.msk)
 
-	msTx.Tx = tx
 	return msTx, err
 }
 
 func (mska *Addon) BeginTx(ctx context.Context, opts *sql.TxOptions) (*MSKTx, error) {
 	tx, err := mska.db.BeginTx(ctx, opts)
-	msTx := &MSKTx{msk: mska.msk}
+	msTx := newMSKTx(tx, mska.msk)
 
-	msTx.Tx = tx
 	return msTx, err
 }
 
+// 同时停止mskeeper的周期任务
 func (mska *Addon) Close() error {
+	mska.msk.StopLoops()
 
 	return mska.db.Close()
 }
@@ -58,6 +58,8 @@ func (mska *Addon) PingContext(ctx context.Context) error {
 func (mska *Addon) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
 
 	nargs, _ := converter{}.ConvertValues(args)
+	trackQueryScope(ctx, mska.msk, query, nargs)
+	mska.msk.BeforeProcess(query, nargs)
 	defer mska.msk.AfterProcess(time.Now(), query, nargs)
 
 	return mska.db.ExecContext(ctx, query, args...)
@@ -66,6 +68,7 @@ func (mska *Addon) ExecContext(ctx context.Context, query string, args ...interf
 func (mska *Addon) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
 
 	nargs, _ := converter{}.ConvertValues(args)
+	trackQueryScope(ctx, mska.msk, query, nargs)
 	defer mska.msk.AfterProcess(time.Now(), query, nargs)
 
 	return mska.db.QueryContext(ctx, query, args...)
@@ -74,6 +77,7 @@ func (mska *Addon) QueryContext(ctx context.Context, query string, args ...inter
 func (mska *Addon) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
 
 	nargs, _ := converter{}.ConvertValues(args)
+	trackQueryScope(ctx, mska.msk, query, nargs)
 	defer mska.msk.AfterProcess(time.Now(), query, nargs)
 
 	return mska.db.QueryRowContext(ctx, query, args...)
@@ -111,6 +115,7 @@ func (mska *Addon) Query(query string, args ...interface{}) (*sql.Rows, error) {
 
 func (mska *Addon) Exec(query string, args ...interface{}) (sql.Result, error) {
 	nargs, _ := converter{}.ConvertValues(args)
+	mska.msk.BeforeProcess(query, nargs)
 	defer mska.msk.AfterProcess(time.Now(), query, nargs)
 
 	return mska.db.Exec(query, args...)
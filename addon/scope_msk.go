@@ -0,0 +1,135 @@
+// Go MSKeeper Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2020 The MSKeeper Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package addon
+
+import (
+	"context"
+	sqldriver "database/sql/driver"
+	"fmt"
+	"gitlab.papegames.com/fringe/mskeeper/driver"
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+	"gitlab.papegames.com/fringe/mskeeper/options"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+/*
+
+N+1查询的作用域
+
+	ctx = addon.WithQueryScope(r.Context(), r.URL.Path)
+	for _, uid := range uids {
+		safeDB.QueryRowContext(ctx, "select * from user where uid = ?", uid)
+	}
+
+作用域内，相同指纹的SQL（包括MSKStmt的*Context方法）执行次数超过MaxRepeatedQueries则告警，同一指纹在一个作用域内只告警一次。
+告警经mskeeper的检查队列异步上报，不阻塞查询。
+
+*/
+
+type queryScopeKey struct{}
+
+type QueryScope struct {
+	mutex    sync.Mutex
+	name     string
+	counts   map[string]int
+	reported map[string]struct{}
+}
+
+func NewQueryScope(name string) *QueryScope {
+	return &QueryScope{
+		name:     name,
+		counts:   make(map[string]int),
+		reported: make(map[string]struct{}),
+	}
+}
+
+// 返回携带新作用域的context，通常在一次请求的入口处调用
+func WithQueryScope(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryScopeKey{}, NewQueryScope(name))
+}
+
+func QueryScopeFromContext(ctx context.Context) *QueryScope {
+	if ctx == nil {
+		return nil
+	}
+	scope, _ := ctx.Value(queryScopeKey{}).(*QueryScope)
+	return scope
+}
+
+func (qs *QueryScope) Name() string {
+	return qs.name
+}
+
+// 相同指纹的SQL在作用域内的执行次数
+func (qs *QueryScope) Count(query string) int {
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+
+	return qs.counts[misc.FingerprintSQL(query)]
+}
+
+// 记录一次执行，返回指纹、累计次数以及是否首次超出maxRepeated
+func (qs *QueryScope) add(query string, maxRepeated int) (string, int, bool) {
+	fingerprint := misc.FingerprintSQL(query)
+
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+
+	qs.counts[fingerprint]++
+	count := qs.counts[fingerprint]
+	if count <= maxRepeated {
+		return fingerprint, count, false
+	}
+	if _, ok := qs.reported[fingerprint]; ok {
+		return fingerprint, count, false
+	}
+	qs.reported[fingerprint] = struct{}{}
+	return fingerprint, count, true
+}
+
+// mskeeper之外的调用位置，即业务代码中发起查询的位置
+func callerOutsideMSKeeper() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		inMSKeeper := strings.HasPrefix(frame.Function, "gitlab.papegames.com/fringe/mskeeper/addon.") &&
+			!strings.HasSuffix(frame.File, "_test.go")
+		if !inMSKeeper && !strings.HasPrefix(frame.Function, "database/sql.") {
+			return fmt.Sprintf("%v:%v %v", frame.File, frame.Line, frame.Function)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// 在ctx的作用域内统计query的执行次数，超出则上报N+1查询
+func trackQueryScope(ctx context.Context, msk driver.MSKeeperInter, query string, args []sqldriver.Value) {
+	scope := QueryScopeFromContext(ctx)
+	if scope == nil {
+		return
+	}
+	opts := msk.GetOptions()
+	if !options.FetchSwitch(opts) {
+		return
+	}
+
+	maxRepeated := options.FetchMaxRepeatedQueries(opts)
+	fingerprint, count, exceeded := scope.add(query, maxRepeated)
+	if !exceeded {
+		return
+	}
+	err := policy.NewRepeatedQueryError(fingerprint, count, maxRepeated, scope.Name(), callerOutsideMSKeeper())
+	msk.NotifyErrorsAsync(query, []error{err}, args)
+}
@@ -0,0 +1,127 @@
+package addon
+
+import (
+	"context"
+	"database/sql"
+	"gitlab.papegames.com/fringe/mskeeper/notifier"
+	"gitlab.papegames.com/fringe/mskeeper/options"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+	"strings"
+	"testing"
+)
+
+func TestQueryScopeCount(t *testing.T) {
+	scope := NewQueryScope("/user/list")
+	for i := 0; i < 3; i++ {
+		if _, _, exceeded := scope.add("select * from user where uid = ?", 3); exceeded {
+			t.Fatalf("exceeded before maxRepeated")
+		}
+	}
+	// 指纹相同
+	if _, count, exceeded := scope.add("select * from user where uid = 5", 3); !exceeded || count != 4 {
+		t.Fatalf("exceeded not reported, count %v", count)
+	}
+	// 同一指纹只告警一次
+	if _, _, exceeded := scope.add("select * from user where uid = ?", 3); exceeded {
+		t.Fatalf("exceeded reported twice")
+	}
+	if scope.Count("SELECT * FROM user WHERE uid = 100") != 5 {
+		t.Fatalf("Count() got %v", scope.Count("select * from user where uid = ?"))
+	}
+	if scope.Count("select * from role where uid = ?") != 0 {
+		t.Fatalf("Count() of other fingerprint should be zero")
+	}
+}
+
+func TestQueryScopeFromContext(t *testing.T) {
+	if QueryScopeFromContext(context.Background()) != nil {
+		t.Fatalf("scope should be nil without WithQueryScope")
+	}
+	ctx := WithQueryScope(context.Background(), "/user/list")
+	scope := QueryScopeFromContext(ctx)
+	if scope == nil || scope.Name() != "/user/list" {
+		t.Fatalf("scope not found in context")
+	}
+	// 派生的context共享同一个作用域
+	sub, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if QueryScopeFromContext(sub) != scope {
+		t.Fatalf("scope not inherited")
+	}
+}
+
+func TestQueryScopeTrack(t *testing.T) {
+	rawDB, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatalf("error connecting: %s", err.Error())
+	}
+	nut := notifier.NewNotifierUnitTest()
+	db := NewMSKeeperAddon(rawDB, options.WithSwitch(true), options.WithNotifier(nut), options.WithMaxRepeatedQueries(5))
+	defer db.Close()
+
+	ctx := WithQueryScope(context.Background(), "TestQueryScopeTrack")
+	for i := 0; i < 5; i++ {
+		trackQueryScope(ctx, db.msk, "select * from user where uid = ?", nil)
+	}
+	_ = db.Flush()
+	if nut.HasErr(policy.ErrPolicyCodeRepeatedQuery) {
+		t.Fatalf("repeated query reported before maxRepeatedQueries")
+	}
+	trackQueryScope(ctx, db.msk, "select * from user where uid = ?", nil)
+	_ = db.Flush()
+	if !nut.HasErr(policy.ErrPolicyCodeRepeatedQuery) || !db.HasErr(policy.ErrPolicyCodeRepeatedQuery) {
+		t.Fatalf("repeated query not reported")
+	}
+	errs := nut.GetErrs()
+	if !strings.Contains(errs[0].Error(), "scope_msk_test.go") || !strings.Contains(errs[0].Error(), "IN (...)") {
+		t.Fatalf("caller location or suggestion missing in %v", errs[0])
+	}
+
+	// 没有作用域则不统计
+	nut.ClearErr()
+	db.ClearErr()
+	for i := 0; i < 10; i++ {
+		trackQueryScope(context.Background(), db.msk, "select * from role where uid = ?", nil)
+	}
+	_ = db.Flush()
+	if nut.HasErr(policy.ErrPolicyCodeRepeatedQuery) {
+		t.Fatalf("repeated query reported without scope")
+	}
+}
+
+func TestPolicyRepeatedQueryInScope(t *testing.T) {
+	runDefaultPolicyTests(t, dsn, func(dbt *DBTest) {
+
+		dbt.db.SetOption(options.WithMaxRepeatedQueries(10))
+		dbt.mustExec("CREATE TABLE testaddon (value int, value1 int)")
+		dbt.db.Flush()
+		notifierUnitTest.ClearErr()
+
+		ctx := WithQueryScope(context.Background(), "TestPolicyRepeatedQueryInScope")
+		for i := 0; i < 20; i++ {
+			var value int
+			_ = dbt.db.QueryRowContext(ctx, "select value from testaddon where value1 = ?", i).Scan(&value)
+		}
+		dbt.db.Flush()
+		if !notifierUnitTest.HasErr(policy.ErrPolicyCodeRepeatedQuery) {
+			dbt.Errorf("N+1 query not banned")
+		}
+
+		// 预编译语句的N+1查询
+		notifierUnitTest.ClearErr()
+		stmt, err := dbt.db.PrepareContext(ctx, "select value1 from testaddon where value = ?")
+		if err != nil {
+			dbt.Fatalf("error on PrepareContext %s", err.Error())
+		}
+		for i := 0; i < 20; i++ {
+			var value int
+			_ = stmt.QueryRowContext(ctx, i).Scan(&value)
+		}
+		_ = stmt.Close()
+		dbt.db.Flush()
+		if !notifierUnitTest.HasErr(policy.ErrPolicyCodeRepeatedQuery) {
+			dbt.Errorf("N+1 query of prepared statement not banned")
+		}
+		dbt.db.SetOption(options.WithMaxRepeatedQueries(policy.DefaultMaxRepeatedQueries))
+	})
+}
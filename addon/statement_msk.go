@@ -9,6 +9,7 @@
 package addon
 
 import (
+	"context"
 	"database/sql"
 	"gitlab.papegames.com/fringe/mskeeper/driver"
 	"time"
@@ -18,6 +19,14 @@ type MSKStmt struct {
 	*sql.Stmt
 	querysql string
 	msk      driver.MSKeeperInter
+	tx       *MSKTx // 通过MSKTx预处理的语句，执行时计入事务的语句序列
+}
+
+// 记录事务内的一条语句，start为语句开始执行的时间
+func (msks *MSKStmt) track(start time.Time) {
+	if msks.tx != nil {
+		msks.tx.track(start, msks.querysql)
+	}
 }
 
 func (msks *MSKStmt) Close() error {
@@ -27,6 +36,8 @@ func (msks *MSKStmt) Close() error {
 
 func (msks *MSKStmt) Exec(args ...interface{}) (sql.Result, error) {
 	nargs, _ := converter{}.ConvertValues(args)
+	defer msks.track(time.Now())
+	msks.msk.BeforeProcess(msks.querysql, nargs)
 	defer msks.msk.AfterProcess(time.Now(), msks.querysql, nargs)
 
 	return msks.Stmt.Exec(args...)
@@ -34,6 +45,7 @@ func (msks *MSKStmt) Exec(args ...interface{}) (sql.Result, error) {
 
 func (msks *MSKStmt) QueryRow(args ...interface{}) *sql.Row {
 	nargs, _ := converter{}.ConvertValues(args)
+	defer msks.track(time.Now())
 	defer msks.msk.AfterProcess(time.Now(), msks.querysql, nargs)
 
 	return msks.Stmt.QueryRow(args...)
@@ -41,7 +53,36 @@ func (msks *MSKStmt) QueryRow(args ...interface{}) *sql.Row {
 
 func (msks *MSKStmt) Query(args ...interface{}) (*sql.Rows, error) {
 	nargs, _ := converter{}.ConvertValues(args)
+	defer msks.track(time.Now())
 	defer msks.msk.AfterProcess(time.Now(), msks.querysql, nargs)
 
 	return msks.Stmt.Query(args...)
 }
+
+func (msks *MSKStmt) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	nargs, _ := converter{}.ConvertValues(args)
+	trackQueryScope(ctx, msks.msk, msks.querysql, nargs)
+	defer msks.track(time.Now())
+	msks.msk.BeforeProcess(msks.querysql, nargs)
+	defer msks.msk.AfterProcess(time.Now(), msks.querysql, nargs)
+
+	return msks.Stmt.ExecContext(ctx, args...)
+}
+
+func (msks *MSKStmt) QueryRowContext(ctx context.Context, args ...interface{}) *sql.Row {
+	nargs, _ := converter{}.ConvertValues(args)
+	trackQueryScope(ctx, msks.msk, msks.querysql, nargs)
+	defer msks.track(time.Now())
+	defer msks.msk.AfterProcess(time.Now(), msks.querysql, nargs)
+
+	return msks.Stmt.QueryRowContext(ctx, args...)
+}
+
+func (msks *MSKStmt) QueryContext(ctx context.Context, args ...interface{}) (*sql.Rows, error) {
+	nargs, _ := converter{}.ConvertValues(args)
+	trackQueryScope(ctx, msks.msk, msks.querysql, nargs)
+	defer msks.track(time.Now())
+	defer msks.msk.AfterProcess(time.Now(), msks.querysql, nargs)
+
+	return msks.Stmt.QueryContext(ctx, args...)
+}
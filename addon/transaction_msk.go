@@ -12,6 +12,11 @@ import (
 	"context"
 	"database/sql"
 	"gitlab.papegames.com/fringe/mskeeper/driver"
+	"gitlab.papegames.com/fringe/mskeeper/options"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -27,20 +32,90 @@ type Tx interface {
 type MSKTx struct {
 	*sql.Tx
 	msk driver.MSKeeperInter
+
+	mutex    sync.Mutex
+	record   *policy.TxRecord // 事务内的语句序列，nil表示不跟踪
+	finished bool
+}
+
+func newMSKTx(tx *sql.Tx, msk driver.MSKeeperInter) *MSKTx {
+	msTx := &MSKTx{Tx: tx, msk: msk}
+	if tx == nil {
+		return msTx
+	}
+	msTx.record = policy.NewTxRecord(time.Now())
+	// 事务直到被回收都没有Commit/Rollback
+	runtime.SetFinalizer(msTx, func(tx *MSKTx) {
+		tx.finish(false)
+		// 释放事务持有的锁及连接
+		_ = tx.Tx.Rollback()
+	})
+	return msTx
+}
+
+// 记录事务内的一条语句，start为语句开始执行的时间
+func (tx *MSKTx) track(start time.Time, query string) {
+	end := time.Now()
+
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	if tx.record == nil || tx.finished {
+		return
+	}
+	tx.record.Record(query, start, end)
+}
+
+// 事务结束时进行事务级别的检查，finished为false表示事务被回收时仍未结束
+// 告警经mskeeper的检查队列异步上报，不阻塞Commit/Rollback以及runtime的finalizer
+func (tx *MSKTx) finish(finished bool) {
+	end := time.Now()
+	if finished {
+		runtime.SetFinalizer(tx, nil)
+	}
+
+	tx.mutex.Lock()
+	if tx.record == nil || tx.finished {
+		tx.mutex.Unlock()
+		return
+	}
+	tx.finished = true
+	record := tx.record
+	tx.mutex.Unlock()
+
+	opts := tx.msk.GetOptions()
+	if !options.FetchSwitch(opts) {
+		return
+	}
+	errs := record.Check(end, finished,
+		options.FetchMaxTxDuration(opts), options.FetchMaxTxStatements(opts), options.FetchMaxTxIdleTime(opts))
+	if len(errs) <= 0 {
+		return
+	}
+
+	queries := make([]string, 0, len(record.Statements))
+	for i := 0; i < len(record.Statements); i++ {
+		queries = append(queries, record.Statements[i].Query)
+	}
+	tx.msk.NotifyErrorsAsync("BEGIN; "+strings.Join(queries, "; ")+"; END", errs, nil)
 }
 
 func (tx *MSKTx) Commit() (err error) {
+	defer tx.finish(true)
 
 	return tx.Tx.Commit()
 }
 
 func (tx *MSKTx) Rollback() (err error) {
+	defer tx.finish(true)
 
 	return tx.Tx.Rollback()
 }
 
 func (tx *MSKTx) Exec(query string, args ...interface{}) (sql.Result, error) {
 	nargs, _ := converter{}.ConvertValues(args)
+	defer tx.track(time.Now(), query)
+	tx.msk.BeforeProcess(query, nargs)
 	defer tx.msk.AfterProcess(time.Now(), query, nargs)
 
 	return tx.Tx.Exec(query, args...)
@@ -48,6 +123,9 @@ func (tx *MSKTx) Exec(query string, args ...interface{}) (sql.Result, error) {
 
 func (tx *MSKTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
 	nargs, _ := converter{}.ConvertValues(args)
+	trackQueryScope(ctx, tx.msk, query, nargs)
+	defer tx.track(time.Now(), query)
+	tx.msk.BeforeProcess(query, nargs)
 	defer tx.msk.AfterProcess(time.Now(), query, nargs)
 
 	return tx.Tx.ExecContext(ctx, query, args...)
@@ -55,6 +133,8 @@ func (tx *MSKTx) ExecContext(ctx context.Context, query string, args ...interfac
 
 func (tx *MSKTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
 	nargs, _ := converter{}.ConvertValues(args)
+	trackQueryScope(ctx, tx.msk, query, nargs)
+	defer tx.track(time.Now(), query)
 	defer tx.msk.AfterProcess(time.Now(), query, nargs)
 
 	return tx.Tx.QueryRowContext(ctx, query, args...)
@@ -62,6 +142,8 @@ func (tx *MSKTx) QueryRowContext(ctx context.Context, query string, args ...inte
 
 func (tx *MSKTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
 	nargs, _ := converter{}.ConvertValues(args)
+	trackQueryScope(ctx, tx.msk, query, nargs)
+	defer tx.track(time.Now(), query)
 	defer tx.msk.AfterProcess(time.Now(), query, nargs)
 
 	return tx.Tx.QueryContext(ctx, query, args...)
@@ -69,7 +151,7 @@ func (tx *MSKTx) QueryContext(ctx context.Context, query string, args ...interfa
 
 func (tx *MSKTx) PrepareContext(ctx context.Context, query string) (*MSKStmt, error) {
 	stmt, err := tx.Tx.PrepareContext(ctx, query)
-	msStmt := &MSKStmt{msk: tx.msk, querysql: query}
+	msStmt := &MSKStmt{msk: tx.msk, querysql: query, tx: tx}
 
 	msStmt.Stmt = stmt
 	return msStmt, err
@@ -77,7 +159,7 @@ func (tx *MSKTx) PrepareContext(ctx context.Context, query string) (*MSKStmt, er
 
 func (tx *MSKTx) Prepare(query string) (*MSKStmt, error) {
 	stmt, err := tx.Tx.Prepare(query)
-	msStmt := &MSKStmt{msk: tx.msk, querysql: query}
+	msStmt := &MSKStmt{msk: tx.msk, querysql: query, tx: tx}
 
 	msStmt.Stmt = stmt
 	return msStmt, err
@@ -85,6 +167,7 @@ func (tx *MSKTx) Prepare(query string) (*MSKStmt, error) {
 
 func (tx *MSKTx) QueryRow(query string, args ...interface{}) *sql.Row {
 	nargs, _ := converter{}.ConvertValues(args)
+	defer tx.track(time.Now(), query)
 	defer tx.msk.AfterProcess(time.Now(), query, nargs)
 
 	return tx.Tx.QueryRow(query, args...)
@@ -92,6 +175,7 @@ func (tx *MSKTx) QueryRow(query string, args ...interface{}) *sql.Row {
 
 func (tx *MSKTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
 	nargs, _ := converter{}.ConvertValues(args)
+	defer tx.track(time.Now(), query)
 	defer tx.msk.AfterProcess(time.Now(), query, nargs)
 
 	return tx.Tx.Query(query, args...)
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -367,3 +368,108 @@ func TestTransMSKTouchAllTypeOfDMLs(t *testing.T) {
 		logmsk.MSKLog().SetOutput(ioutil.Discard)
 	})
 }
+
+func TestPolicyTransIdle(t *testing.T) {
+	runDefaultPolicyTests(t, dsn+"&columnsWithAlias=true", func(dbt *DBTest) {
+
+		dbt.db.SetOptions(options.WithMaxTxIdleTime(100*time.Millisecond), options.WithMaxTxStatements(2))
+		dbt.mustExec("CREATE TABLE testaddon (value int, value1 int)")
+		dbt.db.Flush()
+		notifierUnitTest.ClearErr()
+
+		tx, err := dbt.db.Begin()
+		if err != nil {
+			dbt.Fatalf("error on Begin %s", err.Error())
+		}
+		_, err = tx.Exec("insert into testaddon value(5026111, 5026111)")
+		if err != nil {
+			dbt.Fatalf("error on Exec %s", err.Error())
+		}
+		// 持有锁期间的外部调用
+		time.Sleep(200 * time.Millisecond)
+		_, err = tx.Exec("update testaddon set value1 = 1 where value = 5026111")
+		if err != nil {
+			dbt.Fatalf("error on Exec %s", err.Error())
+		}
+		_, err = tx.Exec("delete from testaddon where value = 5026111")
+		if err != nil {
+			dbt.Fatalf("error on Exec %s", err.Error())
+		}
+		err = tx.Commit()
+		if err != nil {
+			dbt.Fatalf("error on Commit %s", err.Error())
+		}
+		dbt.db.Flush()
+		if !dbt.db.HasErr(policy.ErrPolicyCodeTxIdle) {
+			dbt.Errorf("transaction idle not banned")
+		}
+		if !dbt.db.HasErr(policy.ErrPolicyCodeTxStatements) {
+			dbt.Errorf("transaction statements not banned")
+		}
+		if !notifierUnitTest.HasErr(policy.ErrPolicyCodeTxIdle) {
+			dbt.Errorf("transaction idle not notified")
+		}
+		dbt.db.SetOptions(options.WithMaxTxIdleTime(policy.DefaultMaxTxIdleTime), options.WithMaxTxStatements(policy.DefaultMaxTxStatements))
+	})
+}
+
+// 事务内预处理语句的执行同样计入语句序列
+func TestPolicyTransPreparedStatements(t *testing.T) {
+	runDefaultPolicyTests(t, dsn+"&columnsWithAlias=true", func(dbt *DBTest) {
+
+		dbt.db.SetOptions(options.WithMaxTxStatements(2))
+		dbt.mustExec("CREATE TABLE testaddon (value int, value1 int)")
+		dbt.db.Flush()
+		notifierUnitTest.ClearErr()
+
+		tx, err := dbt.db.Begin()
+		if err != nil {
+			dbt.Fatalf("error on Begin %s", err.Error())
+		}
+		stmt, err := tx.Prepare("insert into testaddon value(?, ?)")
+		if err != nil {
+			dbt.Fatalf("error on Prepare %s", err.Error())
+		}
+		for i := 0; i < 3; i++ {
+			if _, err = stmt.Exec(i, i); err != nil {
+				dbt.Fatalf("error on Exec %s", err.Error())
+			}
+		}
+		err = tx.Commit()
+		if err != nil {
+			dbt.Fatalf("error on Commit %s", err.Error())
+		}
+		dbt.db.Flush()
+		if !dbt.db.HasErr(policy.ErrPolicyCodeTxStatements) {
+			dbt.Errorf("prepared statements of transaction not tracked")
+		}
+		dbt.db.SetOptions(options.WithMaxTxStatements(policy.DefaultMaxTxStatements))
+	})
+}
+
+func TestPolicyTransNotFinished(t *testing.T) {
+	runDefaultPolicyTests(t, dsn+"&columnsWithAlias=true", func(dbt *DBTest) {
+
+		dbt.mustExec("CREATE TABLE testaddon (value int, value1 int)")
+		dbt.db.Flush()
+
+		func() {
+			tx, err := dbt.db.Begin()
+			if err != nil {
+				dbt.Fatalf("error on Begin %s", err.Error())
+			}
+			_, err = tx.Exec("insert into testaddon value(5026111, 5026111)")
+			if err != nil {
+				dbt.Fatalf("error on Exec %s", err.Error())
+			}
+		}()
+		for i := 0; i < 3; i++ {
+			runtime.GC()
+			time.Sleep(100 * time.Millisecond)
+		}
+		dbt.db.Flush()
+		if !dbt.db.HasErr(policy.ErrPolicyCodeTxNotFinished) {
+			dbt.Errorf("transaction not finished not banned")
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package driver
+
+import (
+	"strings"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+	"gitlab.papegames.com/fringe/mskeeper/options"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+/*
+
+performance_schema语句摘要的扫描（options.WithDigestScanPeriod）
+
+未接入mskeeper的服务同样写入performance_schema.events_statements_summary_by_digest，
+周期性地读取当前库的摘要，对比相邻两次采样的计数器（policy.DigestMonitor），
+有QUERY_SAMPLE_TEXT时对其explain并执行已挂载的策略，告警经NotifyErrors上报。
+告警中的SQL为QUERY_SAMPLE_TEXT，没有时为DIGEST_TEXT。
+
+本服务经AfterProcess检查过的SQL（按指纹，至多MaxDigestSeenFingerprints个）不再检查，以免重复告警；
+同一个库的其他服务执行的相同指纹的SQL也随之跳过。
+
+*/
+
+const MaxDigestSeenFingerprints = 10000
+
+// DigestScanPeriod>0时由startLoops启动，周期变为<=0或StopLoops之后退出
+func (msk *MSKeeper) digestScanLoop(period time.Duration) {
+	timer := time.NewTimer(period)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-msk.stop:
+			msk.nextPeriodOfLoop(&msk.digestRunning, options.FetchDigestScanPeriod)
+			return
+		}
+
+		period, ok := msk.nextPeriodOfLoop(&msk.digestRunning, options.FetchDigestScanPeriod)
+		if !ok {
+			return
+		}
+		if options.FetchSwitch(msk.opts) {
+			errs := msk.ScanDigests()
+			log.MSKLog().Infof("MSKeeper:digestScanLoop at %v with period %v, %v alerts", time.Now(), period, len(errs))
+		}
+		_ = timer.Reset(period)
+	}
+}
+
+// 扫描当前库的语句摘要，相对于上一次扫描的增量触发的告警通过Notifier上报
+func (msk *MSKeeper) ScanDigests() []error {
+	defer misc.PrintPanicStack()
+
+	samples, err := policy.QueryDigestSamples(msk.RawDB(), policy.MaxTimeoutOfExplain)
+	if err != nil {
+		log.MSKLog().Warnf("MSKeeper:ScanDigests QueryDigestSamples failed %v", err)
+		return nil
+	}
+	return msk.digestCheck(msk.digestMonitor.Diff(samples))
+}
+
+// 与performance_schema的DIGEST_TEXT可比较的指纹：去掉反引号及限定符两侧的空格，IN列表统一为(?+)
+func digestFingerprint(query string) string {
+	query = strings.Replace(strings.Replace(query, "`", "", -1), " . ", ".", -1)
+	return strings.Replace(misc.FingerprintSQL(query), "(...)", "(?+)", -1)
+}
+
+// 记录经AfterProcess检查的SQL，在process中调用，没有开启扫描时不记录
+func (msk *MSKeeper) markDigestSeen(query string) {
+	if msk.digestSeen == nil || options.FetchDigestScanPeriod(msk.opts) <= 0 {
+		return
+	}
+	msk.digestSeen.Add(digestFingerprint(query), struct{}{})
+}
+
+func (msk *MSKeeper) checkIfDigestSeen(query string) bool {
+	if msk.digestSeen == nil {
+		return false
+	}
+	return msk.digestSeen.Contains(digestFingerprint(query))
+}
+
+// mskeeper自身的explain及采样语句不检查
+func checkIfDigestSelf(query string) bool {
+	lquery := strings.ToLower(query)
+	return checkIfSQLExplainLike(query) ||
+		strings.Contains(lquery, "performance_schema") || strings.Contains(lquery, "information_schema")
+}
+
+func (msk *MSKeeper) digestCheck(deltas []policy.DigestDelta) []error {
+	if len(deltas) > policy.MaxDigestsPerScan {
+		deltas = deltas[:policy.MaxDigestsPerScan]
+	}
+	execTime := options.FetchMaxExecTime(msk.opts)
+	ratio := options.FetchDigestRowsExaminedRatio(msk.opts)
+
+	rawerrors := make([]error, 0)
+	for i := 0; i < len(deltas); i++ {
+		query, explainable := deltas[i].ExplainableQuery()
+		if !explainable {
+			query = deltas[i].Sample.DigestText
+		}
+		query = misc.TrimConsecutiveSpaces(query)
+		if query == "" || checkIfDigestSelf(query) || options.CheckIfInSQLWhiteLists(msk.opts, query) {
+			continue
+		}
+		if msk.checkIfDigestSeen(query) {
+			log.MSKLog().Infof("MSKeeper:digestCheck skip digest %v since checked by AfterProcess", deltas[i].Sample.Digest)
+			continue
+		}
+
+		errs := policy.CheckDigestDelta(deltas[i], execTime, ratio)
+		if explainable && msk.RawDB() != nil && !checkIfSQLHardcore(query) {
+			msk.lock.Lock()
+			_, serrs := msk.statementCheck(query, nil)
+			msk.lock.Unlock()
+			errs = append(errs, serrs...)
+		}
+		if len(errs) <= 0 {
+			continue
+		}
+		msk.NotifyErrors(query, errs, nil)
+		rawerrors = append(rawerrors, errs...)
+	}
+	return rawerrors
+}
@@ -0,0 +1,101 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/notifier"
+	"gitlab.papegames.com/fringe/mskeeper/options"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+func TestDigestCheckNotify(t *testing.T) {
+	nut := notifier.NewNotifierUnitTest()
+	msk := NewMSKeeperInstance(nil, options.WithSwitch(true), options.WithNotifier(nut),
+		options.WithSQLWhiteLists("SELECT * FROM `config`"))
+
+	deltas := []policy.DigestDelta{
+		{Sample: policy.DigestSample{Digest: "a", DigestText: "SELECT * FROM `user` WHERE `name` = ?"},
+			ExecCount: 10, RowsExamined: 100000, RowsSent: 10, NoIndexUsed: 10},
+		{Sample: policy.DigestSample{Digest: "b", DigestText: "SELECT * FROM `config`"},
+			ExecCount: 10, NoIndexUsed: 10},
+		{Sample: policy.DigestSample{Digest: "c", DigestText: "SELECT * FROM `performance_schema` . `events_statements_summary_by_digest`"},
+			ExecCount: 1, NoIndexUsed: 1},
+		{Sample: policy.DigestSample{Digest: "d", DigestText: "SELECT * FROM `user` WHERE `id` = ?"},
+			ExecCount: 10, RowsExamined: 10, RowsSent: 10},
+	}
+	errs := msk.digestCheck(deltas)
+	if len(errs) != 1 || !nut.HasErr(policy.WarnPolicyCodeDigestInefficient) {
+		t.Fatalf("unexpected errs %v", errs)
+	}
+	if sqls := nut.GetSQLs(); len(sqls) != 1 || sqls[0] != "SELECT * FROM `user` WHERE `name` = ?" {
+		t.Fatalf("unexpected notified sqls %v", sqls)
+	}
+}
+
+func TestDigestCheckSkipSeen(t *testing.T) {
+	nut := notifier.NewNotifierUnitTest()
+	msk := NewMSKeeperInstance(nil, options.WithSwitch(true), options.WithNotifier(nut),
+		options.WithDigestScanPeriod(time.Hour))
+	defer msk.StopLoops()
+
+	delta := policy.DigestDelta{Sample: policy.DigestSample{Digest: "a",
+		DigestText: "SELECT * FROM `user` WHERE `user` . `name` = ? AND `id` IN (...)"},
+		ExecCount: 10, RowsExamined: 100000, RowsSent: 10, NoIndexUsed: 10}
+
+	// 本服务经AfterProcess检查过的SQL不再重复告警
+	msk.markDigestSeen("select * from user where user.name = 'bob' and id in (1, 2, 3)")
+	if errs := msk.digestCheck([]policy.DigestDelta{delta}); len(errs) != 0 || len(nut.GetErrs()) != 0 {
+		t.Fatalf("digest checked by AfterProcess should be skipped, got %v", errs)
+	}
+
+	delta.Sample.DigestText = "SELECT * FROM `user` WHERE `age` = ?"
+	if errs := msk.digestCheck([]policy.DigestDelta{delta}); len(errs) != 1 {
+		t.Fatalf("unexpected errs %v", errs)
+	}
+}
+
+func digestRunningOf(msk *MSKeeper) bool {
+	msk.loopMutex.Lock()
+	defer msk.loopMutex.Unlock()
+	return msk.digestRunning
+}
+
+func waitDigestStopped(t *testing.T, msk *MSKeeper) {
+	deadline := time.Now().Add(5 * time.Second)
+	for digestRunningOf(msk) {
+		if time.Now().After(deadline) {
+			t.Fatalf("digestScanLoop should be stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDigestScanLoopOptIn(t *testing.T) {
+	msk := NewMSKeeperInstance(nil)
+
+	// 默认不扫描
+	if options.FetchDigestScanPeriod(msk.GetOptions()) != 0 || digestRunningOf(msk) {
+		t.Fatalf("digest scan should be opt-in")
+	}
+
+	msk.SetOption(options.WithDigestScanPeriod(10 * time.Millisecond))
+	if !digestRunningOf(msk) {
+		t.Fatalf("digestScanLoop should be started")
+	}
+	// 周期变为0后退出，再次开启时重新启动
+	msk.SetOption(options.WithDigestScanPeriod(0))
+	waitDigestStopped(t, msk)
+	msk.SetOption(options.WithDigestScanPeriod(10 * time.Millisecond))
+	if !digestRunningOf(msk) {
+		t.Fatalf("digestScanLoop should be restarted")
+	}
+
+	// StopLoops之后不再启动
+	msk.StopLoops()
+	waitDigestStopped(t, msk)
+	msk.SetOption(options.WithDigestScanPeriod(10 * time.Millisecond))
+	if digestRunningOf(msk) {
+		t.Fatalf("digestScanLoop should not be started after StopLoops")
+	}
+}
@@ -59,3 +59,27 @@ func checkIfSQLHardcore(sql string) bool {
 	// syslog.Printf("checkIfSQLHardcore sql %v", sql)
 	return findIn(kw, keywords2)
 }
+
+// ALTER TABLE、CREATE INDEX、DROP INDEX 虽然不做explain，但需要做Online DDL的分析
+func checkIfSQLOnlineDDL(sql string) bool {
+	kws := strings.Fields(strings.ToUpper(sql))
+	if len(kws) < 3 {
+		return false
+	}
+	switch kws[0] {
+	case "ALTER":
+		return true
+	case "CREATE":
+		for i := 1; i < len(kws) && i <= 3; i++ {
+			if kws[i] == "INDEX" {
+				return true
+			}
+			if kws[i] == "TABLE" {
+				return false
+			}
+		}
+	case "DROP":
+		return kws[1] == "INDEX" || (kws[2] == "INDEX" && (kws[1] == "ONLINE" || kws[1] == "OFFLINE"))
+	}
+	return false
+}
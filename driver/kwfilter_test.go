@@ -322,3 +322,41 @@ func TestCheckIfSQLHardcore(t *testing.T) {
 	}
 
 }
+
+func TestCheckIfSQLOnlineDDL(t *testing.T) {
+
+	validSQL := []struct {
+		input  string
+		output bool
+	}{{
+		input:  "ALTER TABLE t1 ADD COLUMN c int",
+		output: true,
+	}, {
+		input:  "create unique index idx_a on t1 (a)",
+		output: true,
+	}, {
+		input:  "drop index idx_a on t1",
+		output: true,
+	}, {
+		input:  "CREATE TABLE t1 (a int)",
+		output: false,
+	}, {
+		input:  "drop table t1",
+		output: false,
+	}, {
+		input:  "select * from t1",
+		output: false,
+	}, {
+		input:  "ALTER",
+		output: false,
+	},
+	}
+
+	for _, testCase := range validSQL {
+		str := testCase.input
+		res := checkIfSQLOnlineDDL(str)
+		if res != testCase.output {
+			t.Fatalf("checkIfSQLOnlineDDL failed for %v with res %v", str, res)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/options"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+func TestPrecheckOfJobLatencyAnomaly(t *testing.T) {
+	msk := NewMSKeeperInstance(nil, options.WithSwitch(true),
+		options.WithLatencyWarmupSamples(3), options.WithMinLatencyAnomaly(5*time.Millisecond))
+
+	// 基线在process中计算，不在SQL执行的路径上
+	query := "SELECT * FROM user WHERE id = 1"
+	job := msk.precheckOfJob(time.Now().Add(-time.Millisecond), query, nil)
+	if job == nil || job.latencyErr != nil || job.cost < time.Millisecond {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	msk.wg.Done()
+
+	// 被签名排重跳过的执行，每DedupedLatencySampleInterval次采样1次计入基线
+	for i := 0; i < 3*DedupedLatencySampleInterval; i++ {
+		if job := msk.precheckOfJob(time.Now().Add(-time.Millisecond), query, nil); job != nil {
+			t.Fatalf("normal query should be skipped by signature")
+		}
+	}
+	_ = msk.Flush()
+	err := msk.observeLatency(query, 50*time.Millisecond)
+	pe, ok := err.(*policy.PolicyError)
+	if !ok || pe.Code != policy.WarnPolicyCodeLatencyAnomaly {
+		t.Fatalf("latency anomaly not covered, got %v", err)
+	}
+
+	// LatencyAnomalyFactor<=0则不检测
+	msk.SetOption(options.WithLatencyAnomalyFactor(0))
+	if err := msk.observeLatency(query, time.Second); err != nil {
+		t.Fatalf("unexpected latency error %v", err)
+	}
+}
@@ -17,8 +17,10 @@ import (
 
 	"gitlab.papegames.com/fringe/mskeeper/log"
 	"math"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru"
@@ -41,9 +43,11 @@ const (
 	MaxMSKConnections                = 2
 	MaxMSKIdleConnections            = 1
 	MySQLKeepAlivePeriod             = 1 * time.Hour
+	DedupedLatencySampleInterval     = 10 // 被签名排重跳过的执行，每10次取1次计入执行时长的基线
 )
 
 type MSKeeperInter interface {
+	BeforeProcess(query string, args []sqldriver.Value)
 	AfterProcess(t time.Time, query string, args []sqldriver.Value)
 	AttachPolicy(policy policy.PolicyChecker) error
 	ResetOptions(opts *options.Options)
@@ -59,6 +63,11 @@ type MSKeeperInter interface {
 	HasErr(errCode policy.PolicyCode) bool
 	RawDB() *sql.DB
 	ClearPolicies()
+	NotifyErrors(query string, errs []error, args []sqldriver.Value)
+	NotifyErrorsAsync(query string, errs []error, args []sqldriver.Value)
+	CheckAutoIncrement() []error
+	ScanDigests() []error
+	StopLoops()
 }
 
 type MSKeeper struct {
@@ -69,9 +78,25 @@ type MSKeeper struct {
 	lastestErr []NotifyInfo
 	ch         chan *mskeeperInfo
 	sigmap     *lru.Cache
+	findings   *lru.Cache // SQL签名 => 上一次检查的[]finding，用于被签名排重跳过的执行计入EscalationRule
+	deduped    uint32     // 被签名排重跳过的执行次数，用于基线的采样
 	wg         sync.WaitGroup
 	pingTimer  *time.Timer
 	lock       sync.RWMutex
+
+	autoIncMonitor *policy.AutoIncrementMonitor
+
+	digestMonitor *policy.DigestMonitor
+	digestSeen    *lru.Cache // 经AfterProcess检查过的SQL的指纹
+
+	escalator *severityEscalator
+	latency   *policy.LatencyBaseline
+
+	loopMutex      sync.Mutex    // 周期任务的启动、停止
+	loopStopped    bool          // StopLoops之后不再启动周期任务
+	stop           chan struct{} // StopLoops时关闭
+	autoIncRunning bool
+	digestRunning  bool
 }
 
 // type MSKeeperWarnInfo struct {
@@ -89,6 +114,19 @@ type mskeeperInfo struct {
 	cost  time.Duration
 	query string
 	args  []interface{}
+
+	resultSet  *policy.ResultSetStats // 非nil表示Rows.Close时上报的结果集检查
+	latencyErr error                  // 相对于指纹基线的执行时长异常
+	errs       []error                // 非nil表示无需explain、直接上报的告警，eg. 事务级别的检测结果
+	sig        string                 // 不带告警的SQL签名
+	deduped    bool                   // 被签名排重跳过的执行，只计入执行时长的基线及重放上一次检查的告警
+	replays    []finding              // 重放的上一次检查的告警
+}
+
+// 策略检查的告警及其所属的语句（multiStatements中的单条语句），同样的SQL及参数每次执行的结果相同
+type finding struct {
+	query string
+	err   error
 }
 
 type NotifyInfo struct {
@@ -104,12 +142,19 @@ func NewMSKeeperInstance(db *sql.DB, opts ...options.Option) *MSKeeper {
 func newMSKDB(db *sql.DB, opts ...options.Option) *MSKeeper {
 
 	msg := &MSKeeper{
-		pcs:  []policy.PolicyChecker{},
-		opts: options.NewOptions(opts...),
+		pcs:            []policy.PolicyChecker{},
+		opts:           options.NewOptions(opts...),
+		autoIncMonitor: policy.NewAutoIncrementMonitor(),
+		digestMonitor:  policy.NewDigestMonitor(),
+		escalator:      newSeverityEscalator(),
+		latency:        policy.NewLatencyBaseline(),
+		stop:           make(chan struct{}),
 	}
 	msg.ch = make(chan *mskeeperInfo, msg.opts.Capacity)
+	msg.digestSeen, _ = lru.New(MaxDigestSeenFingerprints)
 	if options.FetchSQLCacheSize(msg.opts) > 0 {
 		msg.sigmap, _ = lru.New(options.FetchSQLCacheSize(msg.opts))
+		msg.findings, _ = lru.New(options.FetchSQLCacheSize(msg.opts))
 	}
 	msg.clearErr()
 
@@ -121,6 +166,8 @@ func newMSKDB(db *sql.DB, opts ...options.Option) *MSKeeper {
 	fap := options.FetchKeepAlivePeriod(msg.opts)
 	go msg.keepAliveLoop(fap)
 
+	msg.startLoops()
+
 	return msg
 }
 
@@ -128,12 +175,19 @@ func newMSKDB(db *sql.DB, opts ...options.Option) *MSKeeper {
 func NewMSK(connector sqldriver.Connector, opts ...options.Option) *MSKeeper {
 
 	msg := &MSKeeper{
-		pcs:  []policy.PolicyChecker{},
-		opts: options.NewOptions(opts...),
+		pcs:            []policy.PolicyChecker{},
+		opts:           options.NewOptions(opts...),
+		autoIncMonitor: policy.NewAutoIncrementMonitor(),
+		digestMonitor:  policy.NewDigestMonitor(),
+		escalator:      newSeverityEscalator(),
+		latency:        policy.NewLatencyBaseline(),
+		stop:           make(chan struct{}),
 	}
 	msg.ch = make(chan *mskeeperInfo, msg.opts.Capacity)
+	msg.digestSeen, _ = lru.New(MaxDigestSeenFingerprints)
 	if options.FetchSQLCacheSize(msg.opts) > 0 {
 		msg.sigmap, _ = lru.New(options.FetchSQLCacheSize(msg.opts))
+		msg.findings, _ = lru.New(options.FetchSQLCacheSize(msg.opts))
 	}
 	msg.clearErr()
 
@@ -147,6 +201,8 @@ func NewMSK(connector sqldriver.Connector, opts ...options.Option) *MSKeeper {
 	fap := options.FetchKeepAlivePeriod(msg.opts)
 	go msg.keepAliveLoop(fap)
 
+	msg.startLoops()
+
 	return msg
 }
 
@@ -159,7 +215,12 @@ func (msk *MSKeeper) keepAliveLoop(period time.Duration) {
 	msk.pingTimer = time.NewTimer(period)
 
 	for {
-		<-msk.pingTimer.C
+		select {
+		case <-msk.pingTimer.C:
+		case <-msk.stop:
+			msk.pingTimer.Stop()
+			return
+		}
 
 		err := msk.RawDB().Ping()
 		if err != nil {
@@ -173,6 +234,56 @@ func (msk *MSKeeper) keepAliveLoop(period time.Duration) {
 	}
 }
 
+// AutoIncrementCheckPeriod>0时由startLoops启动，周期变为<=0或StopLoops之后退出
+func (msk *MSKeeper) autoIncrementLoop(period time.Duration) {
+	timer := time.NewTimer(period)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-msk.stop:
+			msk.nextPeriodOfLoop(&msk.autoIncRunning, options.FetchAutoIncrementCheckPeriod)
+			return
+		}
+
+		period, ok := msk.nextPeriodOfLoop(&msk.autoIncRunning, options.FetchAutoIncrementCheckPeriod)
+		if !ok {
+			return
+		}
+		if options.FetchSwitch(msk.opts) {
+			errs := msk.CheckAutoIncrement()
+			log.MSKLog().Infof("MSKeeper:autoIncrementLoop at %v with period %v, %v alerts", time.Now(), period, len(errs))
+		}
+		_ = timer.Reset(period)
+	}
+}
+
+// 检测当前库中自增列的使用率，达到阈值的通过Notifier上报
+func (msk *MSKeeper) CheckAutoIncrement() []error {
+	defer misc.PrintPanicStack()
+
+	samples, err := policy.QueryAutoIncrementSamples(msk.RawDB(), policy.MaxTimeoutOfExplain)
+	if err != nil {
+		log.MSKLog().Warnf("MSKeeper:CheckAutoIncrement QueryAutoIncrementSamples failed %v", err)
+		return nil
+	}
+	alerts := msk.autoIncMonitor.Check(samples, options.FetchAutoIncrementThresholds(msk.opts))
+
+	keys := make([]string, 0, len(alerts))
+	for key := range alerts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	errs := make([]error, 0, len(keys))
+	for _, key := range keys {
+		errs = append(errs, alerts[key])
+		msk.NotifyErrors("AUTO_INCREMENT of "+key, []error{alerts[key]}, nil)
+	}
+	return errs
+}
+
 func (msk *MSKeeper) closeCh() {
 	defer func() {
 		if err := recover(); err != nil {
@@ -196,6 +307,49 @@ func (msk *MSKeeper) ClearStatus() {
 
 func (msk *MSKeeper) ResetOptions(opts *options.Options) {
 	msk.opts = opts
+	msk.startLoops()
+}
+
+// 按配置启动尚未运行的周期任务，周期<=0的任务不启动；正在运行的任务在周期变为<=0后自行退出
+func (msk *MSKeeper) startLoops() {
+	msk.loopMutex.Lock()
+	defer msk.loopMutex.Unlock()
+
+	if msk.loopStopped {
+		return
+	}
+	if period := options.FetchAutoIncrementCheckPeriod(msk.opts); period > 0 && !msk.autoIncRunning {
+		msk.autoIncRunning = true
+		go msk.autoIncrementLoop(period)
+	}
+	if period := options.FetchDigestScanPeriod(msk.opts); period > 0 && !msk.digestRunning {
+		msk.digestRunning = true
+		go msk.digestScanLoop(period)
+	}
+}
+
+// 周期任务的下一个周期，周期<=0或StopLoops之后返回false，任务随之退出
+func (msk *MSKeeper) nextPeriodOfLoop(running *bool, fetch func(*options.Options) time.Duration) (time.Duration, bool) {
+	msk.loopMutex.Lock()
+	defer msk.loopMutex.Unlock()
+
+	period := fetch(msk.opts)
+	if period <= 0 || msk.loopStopped {
+		*running = false
+		return 0, false
+	}
+	return period, true
+}
+
+// 停止KeepAlive、AUTO_INCREMENT检测及语句摘要扫描等周期任务，之后修改配置也不再启动；不影响SQL的检查
+func (msk *MSKeeper) StopLoops() {
+	msk.loopMutex.Lock()
+	defer msk.loopMutex.Unlock()
+
+	if !msk.loopStopped {
+		msk.loopStopped = true
+		close(msk.stop)
+	}
 }
 
 func (msk *MSKeeper) ResyncInfoQueue() {
@@ -207,6 +361,7 @@ func (msk *MSKeeper) ResyncInfoQueue() {
 
 func (msk *MSKeeper) SetOption(o options.Option) {
 	o(msk.opts)
+	msk.startLoops()
 }
 
 func (msk *MSKeeper) GetOptions() *options.Options {
@@ -217,6 +372,7 @@ func (msk *MSKeeper) SetOptions(opts ...options.Option) {
 	for _, o := range opts {
 		o(msk.opts)
 	}
+	msk.startLoops()
 }
 func (msk *MSKeeper) GetErr() []NotifyInfo {
 	return msk.lastestErr
@@ -269,6 +425,7 @@ func (msqlsg *MSKeeper) ClearPolicies() {
 func (msqlsg *MSKeeper) ClearSigs() {
 	if options.FetchSQLCacheSize(msqlsg.opts) > 0 {
 		msqlsg.sigmap.Purge()
+		msqlsg.findings.Purge()
 	}
 }
 
@@ -290,9 +447,11 @@ func (msqlsg *MSKeeper) SyncProcess(t time.Time, query string, args []sqldriver.
 
 	job := msqlsg.precheckOfJob(t, query, args)
 	if job == nil {
-		log.MSKLog().Infof("MSKeeper:SyncProcess(%v, %v, %v) job ignored", t, query, args)
+		lquery, largs := redactForLog(msqlsg.opts, query, args)
+		log.MSKLog().Infof("MSKeeper:SyncProcess(%v, %v, %v) job ignored", t, lquery, largs)
 		return ErrMSKeeperSQLIgnore
 	}
+	job.latencyErr = msqlsg.observeLatency(job.query, job.cost)
 	*reterrors = msqlsg.policiesCheck(job)
 
 	// syslog.Printf("MSKeeper:SyncProcess(%v, %v, %v)", query, args, reterrors)
@@ -308,35 +467,102 @@ func (msqlsg *MSKeeper) precheckOfJob(t time.Time, query string, args []sqldrive
 		// log.MSKLog().Infof("MSKeeper:AfterProcess skip explain like sql %v", query)
 		return nil
 	}
-	defer log.MSKLog().Infof("MSKeeper:precheckOfJob(%v, %v, %v) started", t, query, args)
+	lquery, largs := redactForLog(msqlsg.opts, query, args)
+	defer log.MSKLog().Infof("MSKeeper:precheckOfJob(%v, %v, %v) started", t, lquery, largs)
 
 	// 去掉连续、前后缀空格（包括\t\n)
 	query = misc.TrimConsecutiveSpaces(query)
 
-	// 不带告警的纯SQL签名，不会影响同样SQL的告警触发，只是防止快速同样的SQL导致channel满。
-	sqlsig := misc.MD5String(query, args)
-	if msqlsg.sigmapUpdate(sqlsig) {
-		log.MSKLog().Infof("MSKeeper:precheckOfJob skip of query %v args %v since sigmapUpdate %v return true",
-			query, args, sqlsig)
-		return nil
-	}
 	inWhiteList := options.CheckIfInSQLWhiteLists(msqlsg.opts, query)
 	if inWhiteList {
 		log.MSKLog().Infof("MSKeeper:precheckOfJob skip of query %v args %v since whitelist",
-			query, args)
+			lquery, largs)
 		return nil
 	}
+
+	// 不带告警的纯SQL签名，不会影响同样SQL的告警触发，只是防止快速同样的SQL导致channel满。
+	sqlsig := misc.MD5String(query, args)
 	iargs := []interface{}{}
 	for i := 0; i < len(args); i++ {
 		iargs = append(iargs, args[i])
 	}
+	job := &mskeeperInfo{
+		query: query,
+		cost:  time.Since(t),
+		args:  iargs,
+		sig:   sqlsig}
+	if msqlsg.sigmapUpdate(sqlsig) {
+		log.MSKLog().Infof("MSKeeper:precheckOfJob skip of query %v args %v since sigmapUpdate %v return true",
+			lquery, largs, sqlsig)
+		msqlsg.dedupedOfJob(job)
+		return nil
+	}
 
 	// will be done in 1, finished checking; 2, channel queue was full
 	msqlsg.wg.Add(1)
-	return &mskeeperInfo{
-		query: query,
-		cost:  time.Since(t),
-		args:  iargs}
+	return job
+}
+
+// 被签名排重跳过的执行不做检查，但每DedupedLatencySampleInterval次采样1次由process计入指纹的基线
+// （执行时长异常时完整检查），以及配置了EscalationRule时重放上一次检查的告警，计入升级的次数
+// 签名排重是为了防止快速同样的SQL导致channel满，因此只有采样及需要重放的执行入队，且队列过半时不再入队
+func (msqlsg *MSKeeper) dedupedOfJob(job *mskeeperInfo) {
+	if msqlsg.findings != nil && len(options.FetchEscalationRules(msqlsg.opts)) > 0 {
+		if v, ok := msqlsg.findings.Get(job.sig); ok {
+			job.replays, _ = v.([]finding)
+		}
+	}
+	sampled := options.FetchLatencyAnomalyFactor(msqlsg.opts) > 0 &&
+		atomic.AddUint32(&msqlsg.deduped, 1)%DedupedLatencySampleInterval == 0
+	if !sampled && len(job.replays) <= 0 {
+		return
+	}
+	if len(msqlsg.ch) >= cap(msqlsg.ch)/2 {
+		return
+	}
+	job.deduped = true
+	msqlsg.wg.Add(1)
+	msqlsg.enqueue(job)
+}
+
+// 被签名排重跳过的执行：执行时长相对于基线异常时完整检查，否则只重放上一次检查的告警
+func (msqlsg *MSKeeper) dedupedCheck(info *mskeeperInfo) {
+	msqlsg.markDigestSeen(info.query)
+	info.latencyErr = msqlsg.observeLatency(info.query, info.cost)
+	if info.latencyErr != nil {
+		_ = msqlsg.policiesCheck(info)
+		return
+	}
+	if len(info.replays) > 0 {
+		msqlsg.notifyReplays(info)
+	}
+	msqlsg.wg.Done()
+}
+
+// 按指纹学习执行时长的基线，LatencyAnomalyFactor<=0则不检测
+// 在process中调用，指纹的计算及基线的锁不在SQL执行的路径上
+func (msqlsg *MSKeeper) observeLatency(query string, cost time.Duration) error {
+	factor := options.FetchLatencyAnomalyFactor(msqlsg.opts)
+	if factor <= 0 {
+		return nil
+	}
+	return msqlsg.latency.Observe(misc.FingerprintSQL(query), cost, factor,
+		options.FetchLatencyWarmupSamples(msqlsg.opts), options.FetchMinLatencyAnomaly(msqlsg.opts))
+}
+
+// 执行前的检查，在语句发送给MySQL之前同步调用
+// ALTER TABLE/CREATE INDEX/DROP INDEX 执行之后表结构及大小已经改变（且阻塞已经发生），Online DDL的分析须在执行前完成，
+// 结果同步上报；其他语句直接返回，由AfterProcess异步检查
+func (msqlsg *MSKeeper) BeforeProcess(query string, args []sqldriver.Value) {
+	defer misc.PrintPanicStack()
+
+	if !options.FetchSwitch(msqlsg.opts) {
+		return
+	}
+	if !checkIfSQLHardcore(query) || !checkIfSQLOnlineDDL(query) {
+		return
+	}
+	msqlsg.NotifyErrors(query, msqlsg.ddlCheck(query), args)
 }
 
 func (msqlsg *MSKeeper) AfterProcess(t time.Time, query string, args []sqldriver.Value) {
@@ -349,23 +575,27 @@ func (msqlsg *MSKeeper) AfterProcess(t time.Time, query string, args []sqldriver
 	if job == nil {
 		return
 	}
-	func() {
-		defer func() {
-			if err := recover(); err != nil {
-				log.MSKLog().Warnf("MSKeeper:AfterProcess queue closed, when query %v", query)
-				msqlsg.wg.Done()
-			}
-		}()
-		select {
-		case msqlsg.ch <- job:
-		default:
+	msqlsg.enqueue(job)
+	// syslog.Printf("+++++++++++++++++++++++AfterProcess %v %v", query, args)
+}
+
+// job须已经wg.Add(1)
+func (msqlsg *MSKeeper) enqueue(job *mskeeperInfo) {
+	lquery, _ := redactForLog(msqlsg.opts, job.query, job.args)
+	defer func() {
+		if err := recover(); err != nil {
+			log.MSKLog().Warnf("MSKeeper:enqueue queue closed, when query %v", lquery)
 			msqlsg.wg.Done()
-			// 处理队列满，则丢弃
-			log.MSKLog().Warnf("MSKeeper:AfterProcess queue %v was full, query %v check skipped",
-				len(msqlsg.ch), query)
 		}
 	}()
-	// syslog.Printf("+++++++++++++++++++++++AfterProcess %v %v", query, args)
+	select {
+	case msqlsg.ch <- job:
+	default:
+		msqlsg.wg.Done()
+		// 处理队列满，则丢弃
+		log.MSKLog().Warnf("MSKeeper:enqueue queue %v was full, query %v check skipped",
+			len(msqlsg.ch), lquery)
+	}
 }
 
 // ！！！！ 单元测试或需要hook某一句SQL结果的时候，可以用。！！！！
@@ -422,23 +652,94 @@ func (msqlsg *MSKeeper) sigmapUpdate(errsig string) bool {
 	return false
 }
 
-// 周期内（比如1小时），相同SQL query的告警只显示一次
-func (msqlsg *MSKeeper) notify(sql string, notifs []NotifyInfo, args ...interface{}) {
+// 上报不经过explain分析得出的告警，例如事务级别的检测结果
+func (msqlsg *MSKeeper) NotifyErrors(query string, errs []error, args []sqldriver.Value) {
+	defer misc.PrintPanicStack()
+
+	if !options.FetchSwitch(msqlsg.opts) || len(errs) <= 0 {
+		return
+	}
+
+	iargs := []interface{}{}
+	for i := 0; i < len(args); i++ {
+		iargs = append(iargs, args[i])
+	}
+	msqlsg.notifyErrors(query, errs, iargs)
+}
+
+// 同NotifyErrors，但告警经检查队列由process上报，不阻塞调用方（eg. 应用的Commit、runtime的finalizer）
+func (msqlsg *MSKeeper) NotifyErrorsAsync(query string, errs []error, args []sqldriver.Value) {
+	defer misc.PrintPanicStack()
+
+	if !options.FetchSwitch(msqlsg.opts) || len(errs) <= 0 {
+		return
+	}
+
+	iargs := []interface{}{}
+	for i := 0; i < len(args); i++ {
+		iargs = append(iargs, args[i])
+	}
+	msqlsg.wg.Add(1)
+	msqlsg.enqueue(&mskeeperInfo{
+		query: query,
+		args:  iargs,
+		errs:  append([]error{}, errs...),
+	})
+}
+
+func (msqlsg *MSKeeper) notifyErrors(query string, errs []error, iargs []interface{}) {
+	notifies := make([]NotifyInfo, 0, len(errs))
+	for i := 0; i < len(errs); i++ {
+		notifies = append(notifies, NotifyInfo{err: errs[i], lvl: msqlsg.notifyLevelOf(errs[i], query)})
+	}
+
+	msqlsg.lock.Lock()
+	defer msqlsg.lock.Unlock()
+
+	rd := newRedactor(msqlsg.opts, query, iargs)
+	notifies = rd.notifies(notifies)
+	msqlsg.recordLastestErr(notifies)
+	msqlsg.notify(query, notifies, rd, iargs)
+}
+
+// 重放的告警重新计算级别（计入EscalationRule），未升级的告警受MaxSilentPeriod的限制不会重复通知
+func (msqlsg *MSKeeper) notifyReplays(info *mskeeperInfo) {
+	notifies := make([]NotifyInfo, 0, len(info.replays))
+	for i := 0; i < len(info.replays); i++ {
+		notifies = append(notifies, NotifyInfo{err: info.replays[i].err, lvl: msqlsg.notifyLevelOf(info.replays[i].err, info.replays[i].query)})
+	}
+
+	msqlsg.lock.Lock()
+	defer msqlsg.lock.Unlock()
+
+	rd := newRedactor(msqlsg.opts, info.query, info.args)
+	notifies = rd.notifies(notifies)
+	msqlsg.recordLastestErr(notifies)
+	msqlsg.notify(info.query, notifies, rd, info.args)
+}
+
+// 周期内（比如1小时），相同SQL query的告警只显示一次，签名按原始的SQL、参数及告警级别计算，上报脱敏后的SQL及参数
+func (msqlsg *MSKeeper) notify(sql string, notifs []NotifyInfo, rd *redactor, args ...interface{}) {
 
 	var errcontent string
 	for i := 0; i < len(notifs); i++ {
 		errStrBuf := bytes.NewBufferString("")
 		errStrBuf.WriteString(sql)
 		errStrBuf.WriteString("|")
-		errMSK, _ := notifs[i].err.(*policy.PolicyError)
-		errStrBuf.WriteString(errMSK.Code.String() + "|")
+		if errMSK, ok := notifs[i].err.(*policy.PolicyError); ok {
+			errStrBuf.WriteString(errMSK.Code.String() + "|")
+		} else {
+			// 通过NotifyErrors上报的普通error，按内容计算签名
+			errStrBuf.WriteString(notifs[i].err.Error() + "|")
+		}
+		errStrBuf.WriteString(notifs[i].lvl.String() + "|")
 
 		errcontent = errStrBuf.String()
 		errsig := misc.MD5String(errcontent, args)
 
 		if !msqlsg.sigmapUpdate(errsig) {
 			// 非周期内重复告警，则继续上报。
-			msqlsg.opts.Notifier.Notify(notifs[i].lvl, sql, []error{notifs[i].err}, args)
+			msqlsg.opts.Notifier.Notify(notifs[i].lvl, rd.query, []error{notifs[i].err}, []interface{}{rd.args})
 		}
 	}
 }
@@ -477,62 +778,150 @@ func (msqlsg *MSKeeper) policiesCheck(info *mskeeperInfo) []error {
 
 	notifies := make([]NotifyInfo, 0)
 	rawerrors := make([]error, 0)
+	findings := make([]finding, 0)
 
 	var explainRecords []policy.ExplainRecord
-	var err error
-	var execTime time.Duration
-
-	// 过滤不需要做解析的语句, 例如 DROP TABLE
-	if hc := checkIfSQLHardcore(info.query); hc {
-		log.MSKLog().Infof("MSKeeper:policiesCheck checkIfSQLHardcore skip sql %v", info.query)
-		goto SAFE_SQL
+	execTime := options.FetchMaxExecTime(msqlsg.opts)
+
+	// multiStatements的SQL逐条检查，告警中标明触发的是第几条
+	statements := splitMultiStatements(info.query, info.args)
+	hardcore := true
+	for i := 0; i < len(statements); i++ {
+		hardcore = hardcore && checkIfSQLHardcore(statements[i].query)
+		ers, errs := msqlsg.statementCheck(statements[i].query, statements[i].args)
+		explainRecords = append(explainRecords, ers...)
+		for _, err := range errs {
+			if len(statements) > 1 {
+				err = withStatementIndex(err, i, len(statements), statements[i].query)
+			}
+			notifies = append(notifies, NotifyInfo{err: err, lvl: msqlsg.notifyLevelOf(err, statements[i].query)})
+			rawerrors = append(rawerrors, err)
+			findings = append(findings, finding{query: statements[i].query, err: err})
+		}
 	}
 
-	execTime = options.FetchMaxExecTime(msqlsg.opts)
-	explainRecords, err = policy.MakeExplainRecords(msqlsg.RawDB(), info.query, policy.MaxTimeoutOfExplain, info.args)
-	if err == nil {
+	if len(statements) > 1 {
+		queries := make([]string, 0, len(statements))
+		for i := 0; i < len(statements); i++ {
+			queries = append(queries, statements[i].query)
+		}
 		for _, pc := range msqlsg.pcs {
-			err := pc.Check(msqlsg.RawDB(), explainRecords, info.query, info.args)
-			if err != nil && !strings.Contains(err.Error(), "1146") { // 1146 table deleted by other routine
-				log.MSKLog().Warnf("MSKeeper.policiesCheck(%+v) pc.Check(%v, %v, %v) error %v",
-					info.query, explainRecords, info.query, info.args, err)
-				notifies = append(notifies, NotifyInfo{err: err, lvl: getNotifyLevelByPolicyCode(err)})
+			mpc, ok := pc.(policy.MultiStatementsPolicyChecker)
+			if !ok {
+				continue
+			}
+			if err := mpc.CheckMultiStatements(msqlsg.RawDB(), info.query, queries); err != nil {
+				lquery, _ := redactForLog(msqlsg.opts, info.query, info.args)
+				log.MSKLog().Warnf("MSKeeper.policiesCheck(%+v) pc.CheckMultiStatements error %v", lquery, err)
+				notifies = append(notifies, NotifyInfo{err: err, lvl: msqlsg.notifyLevelOf(err, info.query)})
 				rawerrors = append(rawerrors, err)
+				findings = append(findings, finding{query: info.query, err: err})
 			}
 		}
 	}
+	// 执行时长每次不同，不重放
+	if msqlsg.findings != nil && info.sig != "" && len(options.FetchEscalationRules(msqlsg.opts)) > 0 {
+		msqlsg.findings.Add(info.sig, findings)
+	}
 
-	if info.cost > execTime {
+	// DROP TABLE等语句不检查执行时间
+	if !hardcore && info.cost > execTime {
 		err := policy.NewPolicyError(policy.ErrPolicyCodeExeCost,
 			fmt.Sprintf("Too much time spent in execution sql: cost(%0.3vms) > msqlsg.opts.MaxExecTime(%v)",
 				float64(info.cost.Nanoseconds())/float64(1000000), execTime))
-		notifies = append(notifies, NotifyInfo{err: err, lvl: getNotifyLevelByPolicyCode(err)})
+		notifies = append(notifies, NotifyInfo{err: err, lvl: msqlsg.notifyLevelOf(err, info.query)})
 		rawerrors = append(rawerrors, err)
+	} else if !hardcore && info.latencyErr != nil {
+		// 未超出全局上限MaxExecTime，但相对于自身的基线异常
+		notifies = append(notifies, NotifyInfo{err: info.latencyErr, lvl: msqlsg.notifyLevelOf(info.latencyErr, info.query)})
+		rawerrors = append(rawerrors, info.latencyErr)
 	}
-SAFE_SQL:
+
 	if len(notifies) <= 0 {
 		maxRows := policy.MaxRowsFromExplainRecords(explainRecords)
 		errSuccess := policy.NewPolicyErrorSafe(maxRows, info.cost)
-		notifies = append(notifies, NotifyInfo{err: errSuccess, lvl: getNotifyLevelByPolicyCode(errSuccess)})
+		notifies = append(notifies, NotifyInfo{err: errSuccess, lvl: msqlsg.notifyLevelOf(errSuccess, info.query)})
 		rawerrors = append(rawerrors, errSuccess)
 	}
 
+	// 通知、错误缓存以及返回的错误均为脱敏后的
+	rd := newRedactor(msqlsg.opts, info.query, info.args)
+	notifies = rd.notifies(notifies)
+	for i := 0; i < len(rawerrors); i++ {
+		rawerrors[i] = rd.err(rawerrors[i])
+	}
 	msqlsg.recordLastestErr(notifies)
-	msqlsg.notify(info.query, notifies, info.args)
+	msqlsg.notify(info.query, notifies, rd, info.args)
 
 	log.MSKLog().Infof("MSKeeper.policiesCheck(%+v, %v) execution time limit(%v) cost %v with notifies %v",
-		info.query, info.args, execTime, info.cost, notifies)
+		rd.query, rd.args, execTime, info.cost, notifies)
 	msqlsg.wg.Done()
 
 	return rawerrors
 }
 
+// Online DDL的检查，只调用实现了DDLPolicyChecker的策略
+func (msqlsg *MSKeeper) ddlCheck(query string) []error {
+	errs := make([]error, 0)
+	for _, pc := range msqlsg.pcs {
+		dpc, ok := pc.(policy.DDLPolicyChecker)
+		if !ok {
+			continue
+		}
+		if err := dpc.CheckDDL(msqlsg.RawDB(), query); err != nil {
+			log.MSKLog().Warnf("MSKeeper.ddlCheck(%+v) pc.CheckDDL error %v", query, err)
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// 单条语句的检查
+func (msqlsg *MSKeeper) statementCheck(query string, args []interface{}) ([]policy.ExplainRecord, []error) {
+	errs := make([]error, 0)
+
+	// 过滤不需要做解析的语句, 例如 DROP TABLE；Online DDL已由BeforeProcess在执行前检查
+	if hc := checkIfSQLHardcore(query); hc {
+		log.MSKLog().Infof("MSKeeper:statementCheck checkIfSQLHardcore skip sql %v", query)
+		return nil, errs
+	}
+
+	explainRecords, err := policy.MakeExplainRecords(msqlsg.RawDB(), query, policy.MaxTimeoutOfExplain, args)
+	if err == nil {
+		for _, pc := range msqlsg.pcs {
+			err := pc.Check(msqlsg.RawDB(), explainRecords, query, args)
+			if err != nil && !strings.Contains(err.Error(), "1146") { // 1146 table deleted by other routine
+				lquery, largs := redactForLog(msqlsg.opts, query, args)
+				log.MSKLog().Warnf("MSKeeper.statementCheck(%+v) pc.Check(%v, %v, %v) error %v",
+					lquery, explainRecords, lquery, largs, err)
+				errs = append(errs, err)
+			}
+		}
+	}
+	return explainRecords, errs
+}
+
 func (msqlsg *MSKeeper) process() {
 	log.MSKLog().Infof("MSKeeper:process() started")
 
 	defer misc.PrintPanicStack()
 	s := time.Now()
 	for info := range msqlsg.ch {
+		if info.errs != nil {
+			msqlsg.notifyErrors(info.query, info.errs, info.args)
+			msqlsg.wg.Done()
+			continue
+		}
+		if info.deduped {
+			msqlsg.dedupedCheck(info)
+			continue
+		}
+		if info.resultSet != nil {
+			_ = msqlsg.resultSetCheck(info)
+			continue
+		}
+		msqlsg.markDigestSeen(info.query)
+		info.latencyErr = msqlsg.observeLatency(info.query, info.cost)
 		_ = msqlsg.policiesCheck(info)
 	}
 	log.MSKLog().Infof("MSKeeper.process() ended, took %vs",
@@ -544,13 +933,19 @@ func getNotifyLevelByPolicyCode(err error) notifier.Level {
 	perror, ok := err.(*policy.PolicyError)
 	if !ok {
 		lvl = notifier.WarnLevel
+	} else if severity, ok := perror.Severity(); ok {
+		lvl = severity
 	} else {
 		switch perror.Code {
 		case policy.ErrPolicyCodeSafe:
 			lvl = notifier.InfoLevel
-		case policy.WarnPolicyCodeDataTruncate:
+		case policy.WarnPolicyCodeDataTruncate, policy.WarnPolicyCodeIndexSelectivity,
+			policy.WarnPolicyCodeOnlineDDL, policy.WarnPolicyCodeAutoIncrement,
+			policy.WarnPolicyCodeUnparameterized, policy.WarnPolicyCodeRowsEstimate, policy.WarnPolicyCodeLatencyAnomaly,
+			policy.WarnPolicyCodeDigestInefficient, policy.WarnPolicyCodeUnclassified:
 			lvl = notifier.WarnLevel
 		default:
+			// 包括ErrPolicyCodeDataTruncateStrict：严格sql_mode下语句会在运行时报错
 			lvl = notifier.ErrorLevel
 		}
 	}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
 	// "io/ioutil"
 	"log"
@@ -746,6 +747,97 @@ func TestGetNotifyLevelByPolicyCode(t *testing.T) {
 		t.Fatalf("unexpteced level %v", lvl)
 	}
 
+	pe = policy.NewPolicyError(policy.WarnPolicyCodeIndexSelectivity, fmt.Sprintf("%v", policy.WarnPolicyCodeIndexSelectivity))
+	lvl = getNotifyLevelByPolicyCode(pe)
+
+	if lvl != notifier.WarnLevel {
+		t.Fatalf("unexpteced level %v", lvl)
+	}
+
+	pe = policy.NewPolicyError(policy.WarnPolicyCodeOnlineDDL, fmt.Sprintf("%v", policy.WarnPolicyCodeOnlineDDL))
+	lvl = getNotifyLevelByPolicyCode(pe)
+
+	if lvl != notifier.WarnLevel {
+		t.Fatalf("unexpteced level %v", lvl)
+	}
+
+	pe = policy.NewPolicyError(policy.ErrPolicyCodeOnlineDDL, fmt.Sprintf("%v", policy.ErrPolicyCodeOnlineDDL))
+	lvl = getNotifyLevelByPolicyCode(pe)
+
+	if lvl != notifier.ErrorLevel {
+		t.Fatalf("unexpteced level %v", lvl)
+	}
+
+	pe = policy.NewPolicyError(policy.ErrPolicyCodeDataTruncateStrict, fmt.Sprintf("%v", policy.ErrPolicyCodeDataTruncateStrict))
+	lvl = getNotifyLevelByPolicyCode(pe)
+
+	if lvl != notifier.ErrorLevel {
+		t.Fatalf("unexpteced level %v", lvl)
+	}
+
+	pe = policy.NewPolicyError(policy.WarnPolicyCodeAutoIncrement, fmt.Sprintf("%v", policy.WarnPolicyCodeAutoIncrement))
+	lvl = getNotifyLevelByPolicyCode(pe)
+
+	if lvl != notifier.WarnLevel {
+		t.Fatalf("unexpteced level %v", lvl)
+	}
+
+	pe = policy.NewPolicyError(policy.ErrPolicyCodeAutoIncrement, fmt.Sprintf("%v", policy.ErrPolicyCodeAutoIncrement))
+	lvl = getNotifyLevelByPolicyCode(pe)
+
+	if lvl != notifier.ErrorLevel {
+		t.Fatalf("unexpteced level %v", lvl)
+	}
+
+	pe = policy.NewPolicyError(policy.ErrPolicyCodeSQLInjection, fmt.Sprintf("%v", policy.ErrPolicyCodeSQLInjection))
+	lvl = getNotifyLevelByPolicyCode(pe)
+
+	if lvl != notifier.ErrorLevel {
+		t.Fatalf("unexpteced level %v", lvl)
+	}
+
+	pe = policy.NewPolicyError(policy.WarnPolicyCodeUnparameterized, fmt.Sprintf("%v", policy.WarnPolicyCodeUnparameterized))
+	lvl = getNotifyLevelByPolicyCode(pe)
+
+	if lvl != notifier.WarnLevel {
+		t.Fatalf("unexpteced level %v", lvl)
+	}
+
+	pe = policy.NewPolicyError(policy.ErrPolicyCodeResultSetSize, fmt.Sprintf("%v", policy.ErrPolicyCodeResultSetSize))
+	lvl = getNotifyLevelByPolicyCode(pe)
+
+	if lvl != notifier.ErrorLevel {
+		t.Fatalf("unexpteced level %v", lvl)
+	}
+
+	pe = policy.NewPolicyError(policy.WarnPolicyCodeRowsEstimate, fmt.Sprintf("%v", policy.WarnPolicyCodeRowsEstimate))
+	lvl = getNotifyLevelByPolicyCode(pe)
+
+	if lvl != notifier.WarnLevel {
+		t.Fatalf("unexpteced level %v", lvl)
+	}
+
+	pe = policy.NewPolicyError(policy.WarnPolicyCodeLatencyAnomaly, fmt.Sprintf("%v", policy.WarnPolicyCodeLatencyAnomaly))
+	lvl = getNotifyLevelByPolicyCode(pe)
+
+	if lvl != notifier.WarnLevel {
+		t.Fatalf("unexpteced level %v", lvl)
+	}
+
+	pe = policy.NewPolicyError(policy.WarnPolicyCodeDigestInefficient, fmt.Sprintf("%v", policy.WarnPolicyCodeDigestInefficient))
+	lvl = getNotifyLevelByPolicyCode(pe)
+
+	if lvl != notifier.WarnLevel {
+		t.Fatalf("unexpteced level %v", lvl)
+	}
+
+	pe = policy.NewPolicyError(policy.ErrPolicyCodeRowsAbs, "rows").WithSeverity(notifier.WarnLevel)
+	lvl = getNotifyLevelByPolicyCode(pe)
+
+	if lvl != notifier.WarnLevel {
+		t.Fatalf("unexpteced level %v", lvl)
+	}
+
 	lvl = getNotifyLevelByPolicyCode(fmt.Errorf("any other type of errors"))
 
 	if lvl != notifier.WarnLevel {
@@ -1624,3 +1716,118 @@ func TestNewMSKWithKeepAlive(t *testing.T) {
 // 		logmsk.MSKLog().SetOutput(ioutil.Discard)
 // 	})
 // }
+
+func TestNotifyErrorsAsync(t *testing.T) {
+	nut := notifier.NewNotifierUnitTest()
+	nut.SetNotifyDelay(200 * time.Millisecond)
+	msk := NewMSKeeperInstance(nil, options.WithSwitch(true), options.WithNotifier(nut))
+
+	// 不等待Notifier
+	start := time.Now()
+	msk.NotifyErrorsAsync("BEGIN; UPDATE t SET a = 1; END",
+		[]error{policy.NewPolicyError(policy.ErrPolicyCodeTxIdle, "idle")}, nil)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("NotifyErrorsAsync should not wait for the notifier, took %v", elapsed)
+	}
+
+	_ = msk.Flush()
+	if !nut.HasErr(policy.ErrPolicyCodeTxIdle) || !msk.HasErr(policy.ErrPolicyCodeTxIdle) {
+		t.Fatalf("async errors not notified %v", nut.GetErrs())
+	}
+}
+
+func TestNotifyErrorsAsyncPlainError(t *testing.T) {
+	nut := notifier.NewNotifierUnitTest()
+	msk := NewMSKeeperInstance(nil, options.WithSwitch(true), options.WithNotifier(nut))
+
+	// 非PolicyError不应导致process退出
+	msk.NotifyErrorsAsync("SELECT 1", []error{errors.New("plain")}, nil)
+	if err := msk.Flush(); err != nil {
+		t.Fatalf("flush failed %v", err)
+	}
+	msk.NotifyErrorsAsync("SELECT 2", []error{policy.NewPolicyError(policy.ErrPolicyCodeTxIdle, "idle")}, nil)
+	if err := msk.Flush(); err != nil {
+		t.Fatalf("flush failed %v", err)
+	}
+	if nut.ErrsCount() != 2 || !nut.HasErr(policy.ErrPolicyCodeTxIdle) {
+		t.Fatalf("unexpected errors %v", nut.GetErrs())
+	}
+}
+
+type ddlPolicyCheckerStub struct {
+	ddlCalls int
+}
+
+func (pcs *ddlPolicyCheckerStub) Check(db *sql.DB, explainRecords []policy.ExplainRecord, query string, args []interface{}) error {
+	return nil
+}
+
+func (pcs *ddlPolicyCheckerStub) CheckDDL(db *sql.DB, query string) error {
+	pcs.ddlCalls++
+	return policy.NewPolicyError(policy.ErrPolicyCodeOnlineDDL, "blocks DML")
+}
+
+func TestBeforeProcessOnlineDDL(t *testing.T) {
+	nut := notifier.NewNotifierUnitTest()
+	msk := NewMSKeeperInstance(nil, options.WithSwitch(true), options.WithNotifier(nut))
+	ddl := &ddlPolicyCheckerStub{}
+	_ = msk.AttachPolicy(ddl)
+
+	// 非DDL语句不检查
+	msk.BeforeProcess("select * from user where uid = ?", []driver.Value{1})
+	if ddl.ddlCalls != 0 || nut.HasErr(policy.ErrPolicyCodeOnlineDDL) {
+		t.Fatalf("BeforeProcess should skip non DDL, calls %v", ddl.ddlCalls)
+	}
+
+	// 执行前同步上报，无需Flush
+	msk.BeforeProcess("ALTER TABLE user ADD COLUMN age INT", nil)
+	if ddl.ddlCalls != 1 || !nut.HasErr(policy.ErrPolicyCodeOnlineDDL) {
+		t.Fatalf("BeforeProcess should check DDL before execution, calls %v errs %v", ddl.ddlCalls, nut.GetErrs())
+	}
+
+	// 执行后不再重复分析
+	var errs []error
+	_ = msk.SyncProcess(time.Now(), "ALTER TABLE user ADD COLUMN age INT", nil, &errs)
+	if ddl.ddlCalls != 1 {
+		t.Fatalf("DDL should not be analyzed after execution, calls %v", ddl.ddlCalls)
+	}
+
+	msk.SetOption(options.WithSwitch(false))
+	msk.BeforeProcess("CREATE INDEX idx_age ON user (age)", nil)
+	if ddl.ddlCalls != 1 {
+		t.Fatalf("BeforeProcess should respect the switch, calls %v", ddl.ddlCalls)
+	}
+}
+
+func autoIncRunningOf(msk *MSKeeper) bool {
+	msk.loopMutex.Lock()
+	defer msk.loopMutex.Unlock()
+	return msk.autoIncRunning
+}
+
+func TestAutoIncrementLoopOptIn(t *testing.T) {
+	msk := NewMSKeeperInstance(nil)
+
+	// 默认不检测
+	if options.FetchAutoIncrementCheckPeriod(msk.GetOptions()) != 0 || autoIncRunningOf(msk) {
+		t.Fatalf("auto increment check should be opt-in")
+	}
+
+	msk.SetOption(options.WithAutoIncrementCheckPeriod(10 * time.Millisecond))
+	if !autoIncRunningOf(msk) {
+		t.Fatalf("autoIncrementLoop should be started")
+	}
+
+	msk.StopLoops()
+	deadline := time.Now().Add(5 * time.Second)
+	for autoIncRunningOf(msk) {
+		if time.Now().After(deadline) {
+			t.Fatalf("autoIncrementLoop should be stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	msk.SetOption(options.WithAutoIncrementCheckPeriod(time.Hour))
+	if autoIncRunningOf(msk) {
+		t.Fatalf("autoIncrementLoop should not be started after StopLoops")
+	}
+}
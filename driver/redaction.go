@@ -0,0 +1,274 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+	"gitlab.papegames.com/fringe/mskeeper/options"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser/dependency/querypb"
+)
+
+/*
+
+通知、错误缓存以及server返回的SQL和参数的脱敏（options.WithRedaction）
+
+1. RedactionOff：原样输出
+2. RedactionArgs（默认）：参数替换为RedactedValue
+3. RedactionFull：参数替换为RedactedValue，SQL中的常量通过sqlparser.RedactSQLQuery替换为?，无法解析的SQL输出其指纹
+
+通过options.WithRedactionAllowlist(columns...)，与列直接比较、赋值或插入的参数及常量原样输出，eg. status = ?、SET level = 3。
+告警信息中作为完整的词出现的被脱敏的值（不短于MinRedactedValueLength）同样替换为RedactedValue，eg. 参数100不替换10000中的100。
+
+注：各Policy的日志不输出参数，WarnLevel及以上日志中的SQL为其指纹；调试日志（InfoLevel）中的SQL不做脱敏，生产环境的日志级别应不低于WarnLevel。
+
+*/
+
+const (
+	RedactedValue          = "***"
+	MinRedactedValueLength = 3
+)
+
+type redactor struct {
+	mode    options.RedactionMode
+	query   string        // 脱敏后的SQL
+	args    []interface{} // 脱敏后的参数
+	secrets []string      // 被脱敏的原值
+}
+
+// ?参数:vN的下标
+func bindArgIndex(val []byte) (int, bool) {
+	if !strings.HasPrefix(string(val), ":v") {
+		return 0, false
+	}
+	idx, err := strconv.Atoi(string(val[2:]))
+	if err != nil || idx <= 0 {
+		return 0, false
+	}
+	return idx - 1, true
+}
+
+func isLiteral(val *sqlparser.SQLVal) bool {
+	switch val.Type {
+	case sqlparser.StrVal, sqlparser.IntVal, sqlparser.FloatVal, sqlparser.HexNum, sqlparser.HexVal, sqlparser.BitVal:
+		return true
+	}
+	return false
+}
+
+// SQL中与列直接比较、赋值或插入的值（常量及?参数）=> 列名（小写）
+func bindColumnsOf(stmt sqlparser.Statement) map[*sqlparser.SQLVal]string {
+	columns := map[*sqlparser.SQLVal]string{}
+	bind := func(column string, expr sqlparser.Expr) {
+		switch expr := expr.(type) {
+		case *sqlparser.SQLVal:
+			columns[expr] = column
+		case sqlparser.ValTuple:
+			for _, e := range expr {
+				if val, ok := e.(*sqlparser.SQLVal); ok {
+					columns[val] = column
+				}
+			}
+		}
+	}
+
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.ComparisonExpr:
+			if col, ok := node.Left.(*sqlparser.ColName); ok {
+				bind(col.Name.Lowered(), node.Right)
+			}
+			if col, ok := node.Right.(*sqlparser.ColName); ok {
+				bind(col.Name.Lowered(), node.Left)
+			}
+		case *sqlparser.UpdateExpr:
+			bind(node.Name.Name.Lowered(), node.Expr)
+		case *sqlparser.Insert:
+			rows, ok := node.Rows.(sqlparser.Values)
+			if !ok {
+				return true, nil
+			}
+			for _, row := range rows {
+				for i := 0; i < len(row) && i < len(node.Columns); i++ {
+					bind(node.Columns[i].Lowered(), row[i])
+				}
+			}
+		}
+		return true, nil
+	}, stmt)
+	return columns
+}
+
+func newRedactor(opts *options.Options, query string, args []interface{}) *redactor {
+	rd := &redactor{mode: options.FetchRedaction(opts), query: query, args: args}
+	if rd.mode == options.RedactionOff {
+		return rd
+	}
+
+	sqlStripped, comments := sqlparser.SplitMarginComments(query)
+	stmt, err := sqlparser.Parse(sqlStripped)
+	columns := map[*sqlparser.SQLVal]string{}
+	if err == nil {
+		columns = bindColumnsOf(stmt)
+	}
+	allowed := func(val *sqlparser.SQLVal) bool {
+		column, ok := columns[val]
+		return ok && options.CheckIfInRedactionAllowlist(opts, column)
+	}
+
+	// 参数
+	allowedArgs := map[int]struct{}{}
+	for val := range columns {
+		if idx, ok := bindArgIndex(val.Val); ok && val.Type == sqlparser.ValArg && allowed(val) {
+			allowedArgs[idx] = struct{}{}
+		}
+	}
+	rd.args = make([]interface{}, len(args))
+	for i := 0; i < len(args); i++ {
+		if _, ok := allowedArgs[i]; ok {
+			rd.args[i] = args[i]
+			continue
+		}
+		rd.args[i] = RedactedValue
+		if b, ok := args[i].([]byte); ok {
+			rd.addSecret(string(b))
+		} else if args[i] != nil {
+			rd.addSecret(fmt.Sprintf("%v", args[i]))
+		}
+	}
+
+	if rd.mode != options.RedactionFull {
+		return rd
+	}
+
+	// SQL中的常量
+	if err != nil {
+		rd.query = misc.FingerprintSQL(query)
+		return rd
+	}
+	keptVals := map[*sqlparser.SQLVal]sqlparser.SQLVal{}
+	keptTuples := map[*sqlparser.ComparisonExpr]sqlparser.Expr{}
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.SQLVal:
+			if !isLiteral(node) {
+				return true, nil
+			}
+			if allowed(node) {
+				keptVals[node] = *node
+			} else {
+				rd.addSecret(string(node.Val))
+			}
+		case *sqlparser.ComparisonExpr:
+			if tuple, ok := node.Right.(sqlparser.ValTuple); ok && len(tuple) > 0 {
+				if val, ok := tuple[0].(*sqlparser.SQLVal); ok && allowed(val) {
+					keptTuples[node] = node.Right
+				}
+			}
+		}
+		return true, nil
+	}, stmt)
+
+	if len(keptVals) == 0 && len(keptTuples) == 0 {
+		redacted, err := sqlparser.RedactSQLQuery(query)
+		if err != nil {
+			rd.query = misc.FingerprintSQL(query)
+			return rd
+		}
+		rd.query = misc.ReplaceColonMark(redacted)
+		return rd
+	}
+
+	// 白名单中的列：Normalize之后还原
+	sqlparser.Normalize(stmt, map[string]*querypb.BindVariable{}, "redacted")
+	for node, val := range keptVals {
+		*node = val
+	}
+	for node, right := range keptTuples {
+		node.Right = right
+	}
+	rd.query = misc.ReplaceColonMark(comments.Leading + sqlparser.String(stmt) + comments.Trailing)
+	return rd
+}
+
+func (rd *redactor) addSecret(secret string) {
+	if len(secret) < MinRedactedValueLength {
+		return
+	}
+	rd.secrets = append(rd.secrets, secret)
+}
+
+// 替换文本中出现的被脱敏的值，较长的值优先
+func (rd *redactor) text(s string) string {
+	if rd.mode == options.RedactionOff || len(rd.secrets) == 0 {
+		return s
+	}
+	secrets := append([]string{}, rd.secrets...)
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	for _, secret := range secrets {
+		s = replaceToken(s, secret, RedactedValue)
+	}
+	return s
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// 替换s中作为完整的词出现的token：token首尾的字母、数字不能与前后的字母、数字相连
+func replaceToken(s string, token string, repl string) string {
+	buf := strings.Builder{}
+	last, from := 0, 0
+	for {
+		idx := strings.Index(s[from:], token)
+		if idx < 0 {
+			break
+		}
+		start, end := from+idx, from+idx+len(token)
+		leftOK := !isWordByte(token[0]) || start == 0 || !isWordByte(s[start-1])
+		rightOK := !isWordByte(token[len(token)-1]) || end == len(s) || !isWordByte(s[end])
+		if !leftOK || !rightOK {
+			from = start + 1
+			continue
+		}
+		buf.WriteString(s[last:start])
+		buf.WriteString(repl)
+		last, from = end, end
+	}
+	buf.WriteString(s[last:])
+	return buf.String()
+}
+
+func (rd *redactor) err(err error) error {
+	if rd.mode == options.RedactionOff || len(rd.secrets) == 0 || err == nil {
+		return err
+	}
+	if pe, ok := err.(*policy.PolicyError); ok {
+		return pe.WithMsg(rd.text(pe.Msg))
+	}
+	return errors.New(rd.text(err.Error()))
+}
+
+func (rd *redactor) notifies(notifies []NotifyInfo) []NotifyInfo {
+	redacted := make([]NotifyInfo, 0, len(notifies))
+	for i := 0; i < len(notifies); i++ {
+		redacted = append(redacted, NotifyInfo{err: rd.err(notifies[i].err), lvl: notifies[i].lvl})
+	}
+	return redacted
+}
+
+// 日志中的SQL及参数，不做语法分析，参数全部脱敏
+func redactForLog(opts *options.Options, query string, args interface{}) (string, interface{}) {
+	switch options.FetchRedaction(opts) {
+	case options.RedactionOff:
+		return query, args
+	case options.RedactionFull:
+		return misc.FingerprintSQL(query), RedactedValue
+	}
+	return query, RedactedValue
+}
@@ -0,0 +1,112 @@
+package driver
+
+import (
+	sqldriver "database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.papegames.com/fringe/mskeeper/notifier"
+	"gitlab.papegames.com/fringe/mskeeper/options"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+func TestRedactorModes(t *testing.T) {
+	query := "SELECT * FROM user WHERE email = 'player@example.com' AND phone = ? AND status = ?"
+	args := []interface{}{"13800138000", 1}
+
+	rd := newRedactor(options.NewOptions(options.WithRedaction(options.RedactionOff)), query, args)
+	if rd.query != query || !reflect.DeepEqual(rd.args, args) {
+		t.Fatalf("RedactionOff got %v %v", rd.query, rd.args)
+	}
+
+	// 默认只脱敏参数
+	rd = newRedactor(options.NewOptions(), query, args)
+	if rd.query != query || !reflect.DeepEqual(rd.args, []interface{}{RedactedValue, RedactedValue}) {
+		t.Fatalf("RedactionArgs got %v %v", rd.query, rd.args)
+	}
+
+	rd = newRedactor(options.NewOptions(options.WithRedaction(options.RedactionFull)), query, args)
+	if rd.query != "select * from user where email = ? and phone = ? and `status` = ?" ||
+		!reflect.DeepEqual(rd.args, []interface{}{RedactedValue, RedactedValue}) {
+		t.Fatalf("RedactionFull got %v %v", rd.query, rd.args)
+	}
+	if msg := rd.text("value 'player@example.com' of phone 13800138000"); msg != "value '***' of phone ***" {
+		t.Fatalf("RedactionFull text got %v", msg)
+	}
+}
+
+func TestRedactorAllowlist(t *testing.T) {
+	opts := options.NewOptions(options.WithRedaction(options.RedactionFull), options.WithRedactionAllowlist("Status", "level"))
+
+	rd := newRedactor(opts, "SELECT * FROM user WHERE email = ? AND status = ? AND level IN (1, 2) AND name = 'bob'",
+		[]interface{}{"player@example.com", 3})
+	if rd.query != "select * from user where email = ? and `status` = ? and `level` in (1, 2) and name = ?" ||
+		!reflect.DeepEqual(rd.args, []interface{}{RedactedValue, 3}) {
+		t.Fatalf("allowlist of select got %v %v", rd.query, rd.args)
+	}
+
+	rd = newRedactor(opts, "UPDATE user SET level = 3, token = 'abcdef' WHERE id = ?", []interface{}{10})
+	if rd.query != "update user set `level` = 3, token = ? where id = ?" || !reflect.DeepEqual(rd.args, []interface{}{RedactedValue}) {
+		t.Fatalf("allowlist of update got %v %v", rd.query, rd.args)
+	}
+
+	rd = newRedactor(opts, "INSERT INTO user(email, status) VALUES (?, ?), ('bob@example.com', 2)", []interface{}{"a@example.com", 1})
+	if rd.query != "insert into user(email, `status`) values (?, ?), (?, 2)" || !reflect.DeepEqual(rd.args, []interface{}{RedactedValue, 1}) {
+		t.Fatalf("allowlist of insert got %v %v", rd.query, rd.args)
+	}
+
+	// 无法解析的SQL输出指纹，参数全部脱敏
+	rd = newRedactor(opts, "SELECT * FROM user WHERE status = 1; SELECT 'secret'", []interface{}{"x"})
+	if strings.Contains(rd.query, "secret") || !reflect.DeepEqual(rd.args, []interface{}{RedactedValue}) {
+		t.Fatalf("unparsable sql got %v %v", rd.query, rd.args)
+	}
+}
+
+func TestRedactorErrors(t *testing.T) {
+	rd := newRedactor(options.NewOptions(), "INSERT INTO user(email) VALUES (?)", []interface{}{[]byte("player@example.com")})
+
+	err := rd.err(policy.NewPolicyError(policy.ErrPolicyCodeDataTruncate, "value player@example.com too long"))
+	pe, ok := err.(*policy.PolicyError)
+	if !ok || pe.Code != policy.ErrPolicyCodeDataTruncate || pe.Msg != "value *** too long" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// 过短的值不做替换，防止破坏告警信息
+	rd = newRedactor(options.NewOptions(), "SELECT * FROM user WHERE id = ?", []interface{}{1})
+	if err := rd.err(policy.NewPolicyError(policy.ErrPolicyCodeRowsAbs, "rows 1000 > 100")); err.Error() != policy.NewPolicyError(policy.ErrPolicyCodeRowsAbs, "rows 1000 > 100").Error() {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// 只替换完整的词
+	rd = newRedactor(options.NewOptions(), "UPDATE user SET coin = ? WHERE name = ?", []interface{}{100, "bob"})
+	msg := rd.text("coin 100 of bob, 10000 of bobby, '100' of (bob)")
+	if msg != "coin *** of ***, 10000 of bobby, '***' of (***)" {
+		t.Fatalf("unexpected text %v", msg)
+	}
+}
+
+func TestRedactionOfNotifyErrors(t *testing.T) {
+	nut := notifier.NewNotifierUnitTest()
+	msk := NewMSKeeperInstance(nil,
+		options.WithSwitch(true),
+		options.WithNotifier(nut),
+		options.WithRedaction(options.RedactionFull),
+		options.WithRedactionAllowlist("status"),
+	)
+
+	msk.NotifyErrors("SELECT * FROM user WHERE email = 'player@example.com' AND status = ?",
+		[]error{policy.NewPolicyError(policy.ErrPolicyCodeRepeatedQuery, "email player@example.com repeated")},
+		[]sqldriver.Value{int64(1)})
+
+	sqls := nut.GetSQLs()
+	if len(sqls) != 1 || sqls[0] != "select * from user where email = ? and `status` = ?" {
+		t.Fatalf("unexpected notified sql %v", sqls)
+	}
+	for _, errs := range [][]error{nut.GetErrs(), {msk.GetErr()[0].err}} {
+		pe, ok := errs[0].(*policy.PolicyError)
+		if !ok || strings.Contains(pe.Msg, "player@example.com") {
+			t.Fatalf("error not redacted %v", errs[0])
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package driver
+
+import (
+	sqldriver "database/sql/driver"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+	"gitlab.papegames.com/fringe/mskeeper/options"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+/*
+
+结果集的检查（Driver方式）
+
+mysql的textRows/binaryRows统计实际读取的行数及字节数，在Rows.Close时通过AfterRowsClose上报，
+只有挂载了检查结果集的策略（eg. NewPolicyCheckerResultSet，或组合了它的策略，见policy.SupportsResultSet）时才进入检查队列，
+读取的行数不少于policy.MinRowsOfEstimateCheck时才explain以对比估算的行数。
+与AfterProcess的检查相互独立，相同的SQL及参数在MaxSilentPeriod内只检查一次。
+
+*/
+
+func (msqlsg *MSKeeper) hasResultSetPolicies() bool {
+	for _, pc := range msqlsg.pcs {
+		if policy.SupportsResultSet(pc) {
+			return true
+		}
+	}
+	return false
+}
+
+func (msqlsg *MSKeeper) AfterRowsClose(t time.Time, query string, args []sqldriver.Value, stats policy.ResultSetStats) {
+	defer misc.PrintPanicStack()
+
+	if !options.FetchSwitch(msqlsg.opts) || !msqlsg.hasResultSetPolicies() {
+		return
+	}
+	if checkIfSQLExplainLike(query) {
+		return
+	}
+
+	query = misc.TrimConsecutiveSpaces(query)
+	if options.CheckIfInSQLWhiteLists(msqlsg.opts, query) {
+		return
+	}
+	if msqlsg.sigmapUpdate(misc.MD5String("ResultSet|"+query, args)) {
+		return
+	}
+
+	iargs := []interface{}{}
+	for i := 0; i < len(args); i++ {
+		iargs = append(iargs, args[i])
+	}
+	stats.Duration = time.Since(t)
+
+	msqlsg.wg.Add(1)
+	msqlsg.enqueue(&mskeeperInfo{
+		query:     query,
+		cost:      stats.Duration,
+		args:      iargs,
+		resultSet: &stats,
+	})
+}
+
+func (msqlsg *MSKeeper) resultSetCheck(info *mskeeperInfo) []error {
+	msqlsg.lock.Lock()
+	defer msqlsg.lock.Unlock()
+	defer msqlsg.wg.Done()
+
+	// multiStatements的结果集为各语句之和，无法与单条语句的explain对比
+	var explainRecords []policy.ExplainRecord
+	if msqlsg.RawDB() != nil && info.resultSet.Rows >= policy.MinRowsOfEstimateCheck &&
+		len(splitMultiStatements(info.query, info.args)) == 1 && !checkIfSQLHardcore(info.query) {
+		explainRecords, _ = policy.MakeExplainRecords(msqlsg.RawDB(), info.query, policy.MaxTimeoutOfExplain, info.args)
+	}
+
+	notifies := make([]NotifyInfo, 0)
+	rawerrors := make([]error, 0)
+	for _, pc := range msqlsg.pcs {
+		rpc, ok := pc.(policy.ResultSetPolicyChecker)
+		if !ok || !policy.SupportsResultSet(pc) {
+			continue
+		}
+		if err := rpc.CheckResultSet(msqlsg.RawDB(), explainRecords, info.query, info.args, *info.resultSet); err != nil {
+			lquery, largs := redactForLog(msqlsg.opts, info.query, info.args)
+			log.MSKLog().Warnf("MSKeeper.resultSetCheck(%+v, %v) pc.CheckResultSet(%+v) error %v",
+				lquery, largs, *info.resultSet, err)
+			notifies = append(notifies, NotifyInfo{err: err, lvl: msqlsg.notifyLevelOf(err, info.query)})
+			rawerrors = append(rawerrors, err)
+		}
+	}
+	if len(notifies) <= 0 {
+		return rawerrors
+	}
+
+	rd := newRedactor(msqlsg.opts, info.query, info.args)
+	notifies = rd.notifies(notifies)
+	for i := 0; i < len(rawerrors); i++ {
+		rawerrors[i] = rd.err(rawerrors[i])
+	}
+	msqlsg.recordLastestErr(notifies)
+	msqlsg.notify(info.query, notifies, rd, info.args)
+	return rawerrors
+}
@@ -0,0 +1,46 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/notifier"
+	"gitlab.papegames.com/fringe/mskeeper/options"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+func TestAfterRowsClose(t *testing.T) {
+	nut := notifier.NewNotifierUnitTest()
+	msk := NewMSKeeperInstance(nil, options.WithSwitch(true), options.WithNotifier(nut))
+
+	query := "SELECT * FROM user WHERE level > 1"
+	stats := policy.ResultSetStats{Rows: 200, Bytes: 4096}
+
+	// 没有挂载结果集的策略时不检查
+	msk.AfterRowsClose(time.Now(), query, nil, stats)
+	_ = msk.Flush()
+	if len(nut.GetErrs()) != 0 {
+		t.Fatalf("unexpected notified errors %v", nut.GetErrs())
+	}
+
+	// 组合策略中没有结果集的策略时同样不检查
+	msk.AttachPolicy(policy.All(policy.NewPolicyCheckerRowsAbsolute(100)))
+	if msk.hasResultSetPolicies() {
+		t.Fatalf("combinator without result set policies should not enqueue result sets")
+	}
+
+	msk.AttachPolicy(policy.All(policy.NewPolicyCheckerResultSet(100, 0, 0)))
+	msk.AfterRowsClose(time.Now(), query, nil, stats)
+	_ = msk.Flush()
+	if !nut.HasErr(policy.ErrPolicyCodeResultSetSize) || !msk.HasErr(policy.ErrPolicyCodeResultSetSize) {
+		t.Fatalf("large result set not covered, got %v", nut.GetErrs())
+	}
+
+	// MaxSilentPeriod内相同的SQL只检查一次
+	nut.ClearErr()
+	msk.AfterRowsClose(time.Now(), query, nil, stats)
+	_ = msk.Flush()
+	if len(nut.GetErrs()) != 0 {
+		t.Fatalf("unexpected notified errors %v", nut.GetErrs())
+	}
+}
@@ -0,0 +1,157 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+	"gitlab.papegames.com/fringe/mskeeper/notifier"
+	"gitlab.papegames.com/fringe/mskeeper/options"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+/*
+
+告警级别（options.WithSeverityRules, options.WithEscalationRules）
+
+1. policy.WithSeverity指定了级别的告警，按指定的级别
+2. 否则按顺序第一条匹配的SeverityRule，没有匹配的按告警码的默认级别（getNotifyLevelByPolicyCode）
+3. 之后按EscalationRule升级：周期内同一告警（规则+告警码+SQL指纹）出现超过Times次，From升级为To
+   次数按执行计：MaxSilentPeriod内被SQL签名排重跳过的执行不再explain，重放该签名上一次检查的告警
+
+通知的签名包含级别，升级后的告警不受原级别告警的MaxSilentPeriod的限制，会立即通知一次。
+
+*/
+
+const MaxEscalationKeys = 10000
+
+// 告警所属的SQL，指纹及涉及的表按需计算
+type severitySubject struct {
+	query       string
+	fingerprint string
+	tables      []string
+}
+
+func (ss *severitySubject) fingerprintOf() string {
+	if ss.fingerprint == "" {
+		ss.fingerprint = misc.FingerprintSQL(ss.query)
+	}
+	return ss.fingerprint
+}
+
+func (ss *severitySubject) tablesOf() []string {
+	if ss.tables == nil {
+		ss.tables = policy.TablesOfQuery(ss.query, nil)
+	}
+	return ss.tables
+}
+
+func matchSeverityRule(rule options.SeverityRule, code policy.PolicyCode, ss *severitySubject) bool {
+	if rule.Code == 0 && code == policy.ErrPolicyCodeSafe {
+		return false
+	}
+	if rule.Code != 0 && rule.Code != code {
+		return false
+	}
+	if rule.Fingerprint != "" && rule.Fingerprint != ss.fingerprintOf() {
+		return false
+	}
+	if rule.Table == "" {
+		return true
+	}
+	glob := strings.ToLower(rule.Table)
+	for _, table := range ss.tablesOf() {
+		if policy.MatchTableGlobs(table, []string{glob}) {
+			return true
+		}
+	}
+	return false
+}
+
+type escalationEntry struct {
+	window time.Duration
+	hits   []time.Time
+}
+
+type severityEscalator struct {
+	mutex   sync.Mutex
+	entries map[string]*escalationEntry
+}
+
+func newSeverityEscalator() *severityEscalator {
+	return &severityEscalator{entries: map[string]*escalationEntry{}}
+}
+
+// 记录key的一次出现，返回周期window内出现的次数，至多limit
+func (se *severityEscalator) hit(key string, window time.Duration, limit int, now time.Time) int {
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+
+	entry, ok := se.entries[key]
+	if !ok {
+		if len(se.entries) >= MaxEscalationKeys {
+			se.prune(now)
+		}
+		entry = &escalationEntry{window: window}
+		se.entries[key] = entry
+	}
+
+	hits := entry.hits[:0]
+	for _, t := range entry.hits {
+		if now.Sub(t) < window {
+			hits = append(hits, t)
+		}
+	}
+	hits = append(hits, now)
+	if len(hits) > limit {
+		hits = hits[len(hits)-limit:]
+	}
+	entry.hits = hits
+	return len(hits)
+}
+
+// 清理周期外的记录，仍然超出MaxEscalationKeys时全部清空
+func (se *severityEscalator) prune(now time.Time) {
+	for key, entry := range se.entries {
+		if len(entry.hits) == 0 || now.Sub(entry.hits[len(entry.hits)-1]) >= entry.window {
+			delete(se.entries, key)
+		}
+	}
+	if len(se.entries) >= MaxEscalationKeys {
+		se.entries = map[string]*escalationEntry{}
+	}
+}
+
+// 告警的通知级别，query为原始的SQL
+func (msqlsg *MSKeeper) notifyLevelOf(err error, query string) notifier.Level {
+	lvl := getNotifyLevelByPolicyCode(err)
+	perror, ok := err.(*policy.PolicyError)
+	if !ok {
+		return lvl
+	}
+	ss := &severitySubject{query: query}
+
+	if _, ok := perror.Severity(); !ok {
+		for _, rule := range options.FetchSeverityRules(msqlsg.opts) {
+			if matchSeverityRule(rule, perror.Code, ss) {
+				lvl = rule.Level
+				break
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, rule := range options.FetchEscalationRules(msqlsg.opts) {
+		if rule.From != lvl || !matchSeverityRule(options.SeverityRule{Code: rule.Code}, perror.Code, ss) {
+			continue
+		}
+		key := fmt.Sprintf("%v|%v|%v|%v|%v|%v", rule.Code, rule.From, rule.To, rule.Times, rule.Window, perror.Code) +
+			"|" + ss.fingerprintOf()
+		if msqlsg.escalator.hit(key, rule.Window, rule.Times+1, now) > rule.Times {
+			lvl = rule.To
+		}
+	}
+	return lvl
+}
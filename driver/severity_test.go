@@ -0,0 +1,151 @@
+package driver
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/notifier"
+	"gitlab.papegames.com/fringe/mskeeper/options"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+func TestNotifyLevelOfSeverityRules(t *testing.T) {
+	msk := NewMSKeeperInstance(nil, options.WithSeverityRules(
+		options.SeverityRule{Code: policy.ErrPolicyCodeRowsAbs, Table: "log_*", Level: notifier.InfoLevel},
+		options.SeverityRule{Code: policy.ErrPolicyCodeRowsAbs, Fingerprint: "SELECT * FROM user WHERE id = 1", Level: notifier.WarnLevel},
+		options.SeverityRule{Level: notifier.DebugLevel, Table: "tmp"},
+	))
+
+	errRows := policy.NewPolicyError(policy.ErrPolicyCodeRowsAbs, "rows")
+	cases := []struct {
+		err   error
+		query string
+		lvl   notifier.Level
+	}{
+		{err: errRows, query: "SELECT * FROM LOG_login", lvl: notifier.InfoLevel},
+		{err: errRows, query: "select * from user where id = 2", lvl: notifier.WarnLevel},
+		{err: errRows, query: "SELECT * FROM user WHERE name = 'a'", lvl: notifier.ErrorLevel},
+		{err: policy.NewPolicyError(policy.ErrPolicyCodeAllTableScan, "scan"), query: "SELECT * FROM tmp", lvl: notifier.DebugLevel},
+		// Code为0的规则不包括ErrPolicyCodeSafe
+		{err: policy.NewPolicyErrorSafe(0, 0), query: "SELECT * FROM tmp", lvl: notifier.InfoLevel},
+		// policy.WithSeverity指定的级别优先
+		{err: errRows.WithSeverity(notifier.ErrorLevel), query: "SELECT * FROM log_login", lvl: notifier.ErrorLevel},
+	}
+	for _, testCase := range cases {
+		if lvl := msk.notifyLevelOf(testCase.err, testCase.query); lvl != testCase.lvl {
+			t.Fatalf("notifyLevelOf(%v, %v) got %v, expect %v", testCase.err, testCase.query, lvl, testCase.lvl)
+		}
+	}
+}
+
+func TestNotifyLevelOfEscalationRules(t *testing.T) {
+	msk := NewMSKeeperInstance(nil, options.WithEscalationRules(
+		options.EscalationRule{From: notifier.WarnLevel, To: notifier.ErrorLevel, Times: 2, Window: time.Hour},
+		options.EscalationRule{From: notifier.WarnLevel, To: notifier.ErrorLevel, Times: 0, Window: time.Hour},
+	))
+
+	errWarn := policy.NewPolicyError(policy.WarnPolicyCodeIndexSelectivity, "selectivity")
+	for i := 0; i < 2; i++ {
+		if lvl := msk.notifyLevelOf(errWarn, "SELECT * FROM user WHERE level = 1"); lvl != notifier.WarnLevel {
+			t.Fatalf("%v: unexpected level %v", i, lvl)
+		}
+	}
+	// 相同指纹的第三次升级为Error
+	if lvl := msk.notifyLevelOf(errWarn, "SELECT * FROM user WHERE level = 2"); lvl != notifier.ErrorLevel {
+		t.Fatalf("unexpected level %v", lvl)
+	}
+	if lvl := msk.notifyLevelOf(errWarn, "SELECT * FROM item WHERE level = 1"); lvl != notifier.WarnLevel {
+		t.Fatalf("unexpected level %v", lvl)
+	}
+	// Error级别的告警不在规则内
+	errRows := policy.NewPolicyError(policy.ErrPolicyCodeRowsAbs, "rows")
+	for i := 0; i < 5; i++ {
+		if lvl := msk.notifyLevelOf(errRows, "SELECT * FROM user"); lvl != notifier.ErrorLevel {
+			t.Fatalf("unexpected level %v", lvl)
+		}
+	}
+}
+
+func TestSeverityEscalatorWindow(t *testing.T) {
+	se := newSeverityEscalator()
+	now := time.Now()
+
+	if n := se.hit("k", time.Minute, 3, now); n != 1 {
+		t.Fatalf("unexpected hits %v", n)
+	}
+	if n := se.hit("k", time.Minute, 3, now.Add(30*time.Second)); n != 2 {
+		t.Fatalf("unexpected hits %v", n)
+	}
+	// 第一次已在周期外
+	if n := se.hit("k", time.Minute, 3, now.Add(70*time.Second)); n != 2 {
+		t.Fatalf("unexpected hits %v", n)
+	}
+	for i := 0; i < 5; i++ {
+		se.hit("k", time.Minute, 3, now.Add(80*time.Second))
+	}
+	if n := len(se.entries["k"].hits); n != 3 {
+		t.Fatalf("hits should be limited, got %v", n)
+	}
+
+	se.prune(now.Add(time.Hour))
+	if len(se.entries) != 0 {
+		t.Fatalf("expired entries should be pruned, got %v", se.entries)
+	}
+}
+
+func TestEscalatedNotifyBypassesSilentPeriod(t *testing.T) {
+	nut := notifier.NewNotifierUnitTest()
+	msk := NewMSKeeperInstance(nil,
+		options.WithSwitch(true),
+		options.WithNotifier(nut),
+		options.WithEscalationRules(options.EscalationRule{From: notifier.WarnLevel, To: notifier.ErrorLevel, Times: 1, Window: time.Hour}),
+	)
+
+	query := "SELECT * FROM user WHERE level = 1"
+	for i := 0; i < 3; i++ {
+		msk.NotifyErrors(query, []error{policy.NewPolicyError(policy.WarnPolicyCodeIndexSelectivity, "selectivity")}, nil)
+	}
+	// Warn一次，升级后的Error一次
+	if errs := nut.GetErrs(); len(errs) != 2 {
+		t.Fatalf("unexpected notified errors %v", errs)
+	}
+}
+
+// 检查multiStatements的策略，无需explain
+type multiStatementsCheckerStub struct {
+	calls int
+}
+
+func (pcs *multiStatementsCheckerStub) Check(db *sql.DB, explainRecords []policy.ExplainRecord, query string, args []interface{}) error {
+	return nil
+}
+
+func (pcs *multiStatementsCheckerStub) CheckMultiStatements(db *sql.DB, query string, statements []string) error {
+	pcs.calls++
+	return policy.NewPolicyError(policy.WarnPolicyCodeIndexSelectivity, "selectivity")
+}
+
+func TestEscalationCountsDedupedExecutions(t *testing.T) {
+	nut := notifier.NewNotifierUnitTest()
+	msk := NewMSKeeperInstance(nil,
+		options.WithSwitch(true),
+		options.WithNotifier(nut),
+		options.WithEscalationRules(options.EscalationRule{From: notifier.WarnLevel, To: notifier.ErrorLevel, Times: 2, Window: time.Hour}),
+	)
+	pc := &multiStatementsCheckerStub{}
+	_ = msk.AttachPolicy(pc)
+
+	query := "DROP TABLE tmp_a; DROP TABLE tmp_b"
+	for i := 0; i < 3; i++ {
+		msk.AfterProcess(time.Now(), query, nil)
+		_ = msk.Flush()
+	}
+	// 只检查了一次，签名排重跳过的两次执行重放告警，第3次升级为Error
+	if pc.calls != 1 {
+		t.Fatalf("deduped executions should not be checked again, calls %v", pc.calls)
+	}
+	if errs := nut.GetErrs(); len(errs) != 2 {
+		t.Fatalf("unexpected notified errors %v", errs)
+	}
+}
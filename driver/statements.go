@@ -0,0 +1,78 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+)
+
+// DSN中设置multiStatements=true时，一次Exec可以执行多条以;分隔的语句，
+// 整体做explain会失败，需要拆分后逐条检查。
+type sqlStatement struct {
+	query string
+	args  []interface{}
+}
+
+// 扫描一条语句，返回其中?占位符的个数，以及是否只有注释
+func scanStatement(query string) (int, bool) {
+	tokenizer := sqlparser.NewStringTokenizer(query)
+	numArgs := 0
+	empty := true
+	for {
+		tkn, val := tokenizer.Scan()
+		switch tkn {
+		case 0, sqlparser.LEX_ERROR:
+			return numArgs, empty
+		case sqlparser.COMMENT:
+			continue
+		case sqlparser.VALUE_ARG:
+			if strings.HasPrefix(string(val), ":v") {
+				numArgs++
+			}
+		}
+		empty = false
+	}
+}
+
+// 按;拆分多语句的SQL，引号及注释中的;不做拆分，参数按?的顺序分配给各条语句。
+// 只有一条语句或无法拆分时，原样返回。
+func splitMultiStatements(query string, args []interface{}) []sqlStatement {
+	single := []sqlStatement{{query: query, args: args}}
+	if !strings.Contains(query, ";") {
+		return single
+	}
+	pieces, err := sqlparser.SplitStatementToPieces(query)
+	if err != nil {
+		return single
+	}
+
+	statements := make([]sqlStatement, 0, len(pieces))
+	for _, piece := range pieces {
+		piece = strings.TrimSpace(piece)
+		numArgs, empty := scanStatement(piece)
+		if empty {
+			continue
+		}
+		if numArgs > len(args) {
+			numArgs = len(args)
+		}
+		statements = append(statements, sqlStatement{query: piece, args: args[:numArgs]})
+		args = args[numArgs:]
+	}
+	if len(statements) <= 1 {
+		return single
+	}
+	return statements
+}
+
+// 在告警中标明是批量语句中的第几条，语句以指纹给出：批量语句无法整体解析，其中的常量不会被脱敏
+func withStatementIndex(err error, index int, total int, query string) error {
+	pe, ok := err.(*policy.PolicyError)
+	if !ok {
+		pe = policy.NewPolicyError(policy.WarnPolicyCodeUnclassified, err.Error())
+	}
+	return pe.WithMsg(fmt.Sprintf("Statement %v/%v of multi-statements(%v): %v", index+1, total, misc.FingerprintSQL(query), pe.Msg))
+}
@@ -0,0 +1,95 @@
+package driver
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/options"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+func TestSplitMultiStatements(t *testing.T) {
+	cases := []struct {
+		query      string
+		args       []interface{}
+		statements []sqlStatement
+	}{{
+		query:      "SELECT * FROM t WHERE a = ?",
+		args:       []interface{}{1},
+		statements: []sqlStatement{{query: "SELECT * FROM t WHERE a = ?", args: []interface{}{1}}},
+	}, {
+		query:      "SELECT * FROM t;",
+		statements: []sqlStatement{{query: "SELECT * FROM t;"}},
+	}, {
+		query: "UPDATE t SET a = ? WHERE b = ?; DELETE FROM t WHERE c = ?",
+		args:  []interface{}{1, 2, 3},
+		statements: []sqlStatement{
+			{query: "UPDATE t SET a = ? WHERE b = ?", args: []interface{}{1, 2}},
+			{query: "DELETE FROM t WHERE c = ?", args: []interface{}{3}},
+		},
+	}, {
+		query: "UPDATE t SET a = 'x;y' WHERE b = \"?;\"; /* ; */ DELETE FROM t; -- tail ;",
+		statements: []sqlStatement{
+			{query: "UPDATE t SET a = 'x;y' WHERE b = \"?;\""},
+			{query: "/* ; */ DELETE FROM t"},
+		},
+	}, {
+		query:      "UPDATE t SET a = 'unterminated; DELETE FROM t",
+		statements: []sqlStatement{{query: "UPDATE t SET a = 'unterminated; DELETE FROM t"}},
+	}}
+
+	for _, testCase := range cases {
+		statements := splitMultiStatements(testCase.query, testCase.args)
+		if !reflect.DeepEqual(statements, testCase.statements) {
+			t.Fatalf("splitMultiStatements(%v) got %#v, expect %#v", testCase.query, statements, testCase.statements)
+		}
+	}
+}
+
+func TestWithStatementIndex(t *testing.T) {
+	err := withStatementIndex(policy.NewPolicyError(policy.ErrPolicyCodeAllTableScan, "all table scan"), 1, 2, "DELETE FROM t WHERE name = 'secret'")
+	pe, ok := err.(*policy.PolicyError)
+	if !ok || pe.Code != policy.ErrPolicyCodeAllTableScan || pe.Msg != "Statement 2/2 of multi-statements(delete from t where name = ?): all table scan" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	err = withStatementIndex(fmt.Errorf("any other type of errors"), 0, 2, "SELECT 1")
+	pe, ok = err.(*policy.PolicyError)
+	if !ok || pe.Code != policy.WarnPolicyCodeUnclassified || !strings.HasPrefix(pe.Msg, "Statement 1/2 of multi-statements(select ?)") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestPolicyMultiStatements(t *testing.T) {
+	runDefaultPolicyTests(t, dsn+"&multiStatements=true&interpolateParams=true", func(dbt *DBTest) {
+		notifierUnitTest.ClearErr()
+		dbt.db.SetOption(options.WithMaxExecTime(3 * time.Second))
+		dbt.db.AttachPolicy(policy.NewPolicyCheckerRowsAbsolute(100))
+
+		dbt.mustExec("CREATE TABLE testdriver (value int, value1 int)")
+		for i := 0; i < 200; i++ {
+			dbt.mustExec("INSERT INTO testdriver VALUES (?, ?)", i, i)
+		}
+		dbt.db.Flush()
+		notifierUnitTest.ClearErr()
+		dbt.db.ClearErr()
+
+		dbt.mustExec("UPDATE testdriver SET value1 = ? WHERE value = ?; DELETE FROM testdriver WHERE value1 > ?", 1, 1, 10000)
+		dbt.db.Flush()
+
+		found := false
+		for _, ni := range dbt.db.GetErr() {
+			pe, ok := ni.err.(*policy.PolicyError)
+			if ok && pe.Code == policy.ErrPolicyCodeRowsAbs && strings.HasPrefix(pe.Msg, "Statement 2/2 of multi-statements") {
+				found = true
+			}
+		}
+		if !found {
+			dbt.Errorf("the DELETE of multi-statements not banned, got %v", dbt.db.GetErr())
+		}
+		dbt.db.ClearPolicies()
+	})
+}
@@ -8,10 +8,18 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"regexp"
 	"runtime"
 	"strings"
 )
 
+var (
+	fingerprintStringReg = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.|"")*"`)
+	fingerprintNumberReg = regexp.MustCompile(`\b0x[0-9a-f]+\b|\b\d+(?:\.\d+)?(?:e[+-]?\d+)?\b`)
+	fingerprintListReg   = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	fingerprintRowsReg   = regexp.MustCompile(`\(\?\+\)(?:\s*,\s*\(\?\+\))+`)
+)
+
 func PrintPanicStack() {
 	if x := recover(); x != nil {
 		panicInfo := fmt.Sprintf("[PANIC] %v\n", x)
@@ -157,3 +165,15 @@ func ReplaceColonMark(str string) string {
 
 	return string(result)
 }
+
+// SQL的指纹，字符串、数字常量替换为?，IN列表及VALUES的多行合并为(?+)，
+// 只是参数不同的SQL拥有相同的指纹
+// eg. select * from t where id in (1, 2, 3) and name = 'abc' => select * from t where id in (?+) and name = ?
+func FingerprintSQL(sql string) string {
+	fp := strings.ToLower(TrimConsecutiveSpaces(sql))
+	fp = fingerprintStringReg.ReplaceAllString(fp, "?")
+	fp = fingerprintNumberReg.ReplaceAllString(fp, "?")
+	fp = fingerprintListReg.ReplaceAllString(fp, "(?+)")
+	fp = fingerprintRowsReg.ReplaceAllString(fp, "(?+)")
+	return strings.TrimRight(fp, "; ")
+}
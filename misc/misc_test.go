@@ -287,3 +287,40 @@ func TestReplaceColonMark(t *testing.T) {
 
 	}
 }
+
+func TestFingerprintSQL(t *testing.T) {
+
+	validSQL := []struct {
+		input  string
+		output string
+	}{{
+		input:  "SELECT * FROM test WHERE id = 1",
+		output: "select * from test where id = ?",
+	}, {
+		input:  "select * from test where id = ?",
+		output: "select * from test where id = ?",
+	}, {
+		input:  "select * from test   where name = 'a''b\\'c' and value = \"x\" and f = 1.5e3",
+		output: "select * from test where name = ? and value = ? and f = ?",
+	}, {
+		input:  "select * from test where id in (1, 2,3)",
+		output: "select * from test where id in (?+)",
+	}, {
+		input:  "select * from test where id in (?)",
+		output: "select * from test where id in (?+)",
+	}, {
+		input:  "insert into test1 values (1, 'a'), (2, 'b'),(3, 'c');",
+		output: "insert into test1 values (?+)",
+	}, {
+		input:  "select * from t_2020 where hex = 0xFF",
+		output: "select * from t_2020 where hex = ?",
+	},
+	}
+
+	for _, testCase := range validSQL {
+		res := FingerprintSQL(testCase.input)
+		if res != testCase.output {
+			t.Fatalf("fingerprint failed for %v with res %v", testCase.input, res)
+		}
+	}
+}
@@ -320,6 +320,9 @@ func (mc *mysqlConn) interpolateParams(query string, args []driver.Value) (strin
 }
 
 func (mc *mysqlConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	if mc.connector != nil {
+		mc.connector.msk.BeforeProcess(query, args)
+	}
 	ts := time.Now()
 	defer func() {
 		if mc.connector != nil {
@@ -431,6 +434,7 @@ func (mc *mysqlConn) query(query string, args []driver.Value) (*textRows, error,
 			}
 
 			// Columns
+			rows.trackResultSet(mc, ts, query, args)
 			rows.rs.columns, err = mc.readColumns(resLen)
 			return rows, err, 1
 		}
@@ -1520,3 +1520,43 @@ func TestPolicyTouchAllTypeOfDMLs(t *testing.T) {
 		logmsk.MSKLog().SetOutput(ioutil.Discard)
 	})
 }
+
+func TestPolicyResultSet(t *testing.T) {
+	runDefaultPolicyTests(t, dsn, func(dbt *DBTest) {
+		notifierUnitTest.ClearErr()
+		msk := MSKeeperInstance(dsn)
+		if msk == nil {
+			t.Fatalf("msk is nil")
+		}
+		msk.ClearPolicies()
+		msk.AttachPolicy(policy.NewPolicyCheckerResultSet(100, 0, 0))
+		defer msk.ClearPolicies()
+
+		dbt.mustExec("CREATE TABLE test (value int, value1 int)")
+		for i := 0; i < 200; i++ {
+			dbt.mustExec("INSERT INTO test VALUES (?, ?)", i, i)
+		}
+		msk.Flush()
+		msk.ClearErr()
+
+		// 只读取了一部分就Close，不告警
+		rows := dbt.mustQuery("SELECT * FROM test WHERE value1 >= 0")
+		for i := 0; i < 10 && rows.Next(); i++ {
+		}
+		rows.Close()
+		msk.Flush()
+		if msk.HasErr(policy.ErrPolicyCodeResultSetSize) {
+			dbt.Errorf("partially read result set should not be banned")
+		}
+
+		rows = dbt.mustQuery("SELECT * FROM test")
+		for rows.Next() {
+		}
+		rows.Close()
+		msk.Flush()
+		if !msk.HasErr(policy.ErrPolicyCodeResultSetSize) {
+			dbt.Errorf("large result set not covered, got %v", msk.GetErr())
+		}
+		msk.ClearErr()
+	})
+}
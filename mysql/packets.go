@@ -759,6 +759,7 @@ func (rows *textRows) readRow(dest []driver.Value) error {
 		rows.mc = nil
 		return mc.handleErrorPacket(data)
 	}
+	rows.countRow(data)
 
 	// RowSet Packet
 	var n int
@@ -1190,6 +1191,7 @@ func (rows *binaryRows) readRow(dest []driver.Value) error {
 		// Error otherwise
 		return mc.handleErrorPacket(data)
 	}
+	rows.countRow(data)
 
 	// NULL-bitmap,  [(column-count + 7 + 2) / 8 bytes]
 	pos := 1 + (len(dest)+7+2)>>3
@@ -13,6 +13,10 @@ import (
 	"io"
 	"math"
 	"reflect"
+	"time"
+
+	mskdriver "gitlab.papegames.com/fringe/mskeeper/driver"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
 )
 
 type resultSet struct {
@@ -25,6 +29,13 @@ type mysqlRows struct {
 	mc     *mysqlConn
 	rs     resultSet
 	finish func()
+
+	// for mskeeper, 实际读取的行数及字节数，Rows.Close时上报
+	msk   *mskdriver.MSKeeper
+	query string
+	args  []driver.Value
+	start time.Time
+	stats policy.ResultSetStats
 }
 
 type binaryRows struct {
@@ -97,11 +108,41 @@ func (rows *mysqlRows) ColumnTypeScanType(i int) reflect.Type {
 	return rows.rs.columns[i].scanType()
 }
 
+// 统计结果集的大小，只有mskeeper的连接才统计
+func (rows *mysqlRows) trackResultSet(mc *mysqlConn, start time.Time, query string, args []driver.Value) {
+	if mc.connector == nil || mc.connector.msk == nil {
+		return
+	}
+	rows.msk = mc.connector.msk
+	rows.query = query
+	rows.args = args
+	rows.start = start
+}
+
+func (rows *mysqlRows) countRow(data []byte) {
+	if rows.msk == nil {
+		return
+	}
+	rows.stats.Rows++
+	rows.stats.Bytes += int64(len(data))
+}
+
+// 上报读取的结果集，未读取而在Close时丢弃的行不计入
+func (rows *mysqlRows) reportResultSet() {
+	msk := rows.msk
+	if msk == nil {
+		return
+	}
+	rows.msk = nil
+	msk.AfterRowsClose(rows.start, rows.query, rows.args, rows.stats)
+}
+
 func (rows *mysqlRows) Close() (err error) {
 	if f := rows.finish; f != nil {
 		f()
 		rows.finish = nil
 	}
+	rows.reportResultSet()
 
 	mc := rows.mc
 	if mc == nil {
@@ -54,6 +54,9 @@ func (stmt *mysqlStmt) Exec(args []driver.Value) (driver.Result, error) {
 		return nil, driver.ErrBadConn
 	}
 	mc := stmt.mc
+	if mc.connector != nil {
+		mc.connector.msk.BeforeProcess(stmt.sqlPrepared, args)
+	}
 	ts := time.Now()
 	defer func() {
 		if mc.connector != nil {
@@ -131,6 +134,7 @@ func (stmt *mysqlStmt) query(args []driver.Value) (*binaryRows, error) {
 
 	if resLen > 0 {
 		rows.mc = mc
+		rows.trackResultSet(mc, ts, stmt.sqlPrepared, args)
 		rows.rs.columns, err = mc.readColumns(resLen)
 	} else {
 		rows.rs.done = true
@@ -0,0 +1,227 @@
+package notifier
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+/*
+
+聚合通知（包装任意Notifier）
+
+一次有问题的发布会在短时间内产生大量不同SQL的告警，MaxSilentPeriod只能排重相同签名的告警。
+NotifierAggregate将周期window内的告警按 告警码+SQL指纹 分组计数，每个周期向下游发送一条汇总：
+
+	[mskeeper] 37 findings in 5m0s, 2 groups
+	[35×] ErrPolicyCodeAllTableScan Level=error SQL=select * from user where name = ?: <第一次的告警信息>
+	[2×] ...
+
+1. 汇总的级别为周期内最高的级别，分组按次数从多到少排列，至多列出MaxAggregateSummaryGroups组
+2. SetCriticalCodes指定的告警码不进入缓冲，立即发送给下游
+3. 周期内至多MaxAggregateGroups个分组，超出的告警只计入总数
+4. Flush立即发送当前的汇总，Close发送剩余的汇总并停止周期发送
+5. ErrPolicyCodeSafe以及级别低于SetLogLevel（默认WarnLevel）的通知不进入缓冲，避免挤占真正的告警
+
+*/
+
+const (
+	DefaultAggregateWindow    = 5 * time.Minute
+	MaxAggregateGroups        = 1000
+	MaxAggregateSummaryGroups = 50
+)
+
+type aggregateGroup struct {
+	code        string
+	fingerprint string
+	level       Level
+	first       string // 第一次的告警信息
+	count       int
+}
+
+type NotifierAggregate struct {
+	notifier Notifier
+	window   time.Duration
+
+	mutex    sync.Mutex
+	level    Level
+	critical map[policy.PolicyCode]struct{}
+	groups   map[string]*aggregateGroup
+	total    int
+	start    time.Time
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// window<=0时使用DefaultAggregateWindow
+func NewNotifierAggregate(notifier Notifier, window time.Duration) *NotifierAggregate {
+	if window <= 0 {
+		window = DefaultAggregateWindow
+	}
+
+	na := &NotifierAggregate{
+		notifier: notifier,
+		window:   window,
+		level:    WarnLevel,
+		critical: map[policy.PolicyCode]struct{}{},
+		groups:   map[string]*aggregateGroup{},
+		start:    time.Now(),
+		stop:     make(chan struct{}),
+	}
+	go na.loop()
+	return na
+}
+
+// 立即发送、不参与聚合的告警码
+func (na *NotifierAggregate) SetCriticalCodes(codes ...policy.PolicyCode) *NotifierAggregate {
+	na.mutex.Lock()
+	defer na.mutex.Unlock()
+
+	na.critical = map[policy.PolicyCode]struct{}{}
+	for _, code := range codes {
+		na.critical[code] = struct{}{}
+	}
+	return na
+}
+
+func (na *NotifierAggregate) loop() {
+	ticker := time.NewTicker(na.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			na.Flush()
+		case <-na.stop:
+			return
+		}
+	}
+}
+
+func (na *NotifierAggregate) Notify(level Level, sql string, errors []error, args ...interface{}) {
+	immediate := make([]error, 0)
+
+	na.mutex.Lock()
+	if level > na.level {
+		na.mutex.Unlock()
+		return
+	}
+	fingerprint := misc.FingerprintSQL(sql)
+	for i := 0; i < len(errors); i++ {
+		code := "error"
+		if perror, ok := errors[i].(*policy.PolicyError); ok {
+			if perror.Code == policy.ErrPolicyCodeSafe {
+				continue
+			}
+			if _, ok := na.critical[perror.Code]; ok {
+				immediate = append(immediate, errors[i])
+				continue
+			}
+			code = perror.Code.String()
+		}
+
+		na.total++
+		key := code + "|" + fingerprint
+		group, ok := na.groups[key]
+		if !ok {
+			if len(na.groups) >= MaxAggregateGroups {
+				continue
+			}
+			group = &aggregateGroup{code: code, fingerprint: fingerprint, level: level, first: errors[i].Error()}
+			na.groups[key] = group
+		}
+		group.count++
+		if level < group.level {
+			group.level = level
+		}
+	}
+	na.mutex.Unlock()
+
+	if len(immediate) > 0 {
+		na.notifier.Notify(level, sql, immediate, args...)
+	}
+}
+
+// 生成当前周期的汇总并重置，没有告警时返回false
+func (na *NotifierAggregate) summarize(now time.Time) (Level, string, bool) {
+	na.mutex.Lock()
+	defer na.mutex.Unlock()
+
+	groups := make([]*aggregateGroup, 0, len(na.groups))
+	for _, group := range na.groups {
+		groups = append(groups, group)
+	}
+	total := na.total
+	elapsed := now.Sub(na.start).Round(time.Second)
+	na.groups = map[string]*aggregateGroup{}
+	na.total = 0
+	na.start = now
+
+	if total <= 0 {
+		return 0, "", false
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].count != groups[j].count {
+			return groups[i].count > groups[j].count
+		}
+		if groups[i].code != groups[j].code {
+			return groups[i].code < groups[j].code
+		}
+		return groups[i].fingerprint < groups[j].fingerprint
+	})
+
+	level := TraceLevel
+	buf := bytes.NewBufferString(fmt.Sprintf("[mskeeper] %v findings in %v, %v groups", total, elapsed, len(groups)))
+	for i, group := range groups {
+		if group.level < level {
+			level = group.level
+		}
+		if i >= MaxAggregateSummaryGroups {
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("\n[%v×] %v Level=%v SQL=%v: %v", group.count, group.code, group.level, group.fingerprint, group.first))
+	}
+	if len(groups) > MaxAggregateSummaryGroups {
+		buf.WriteString(fmt.Sprintf("\n... and %v more groups", len(groups)-MaxAggregateSummaryGroups))
+	}
+	return level, buf.String(), true
+}
+
+// 立即向下游发送当前周期的汇总
+func (na *NotifierAggregate) Flush() {
+	defer misc.PrintPanicStack()
+
+	level, summary, ok := na.summarize(time.Now())
+	if !ok {
+		return
+	}
+	log.MSKLog().Infof("NotifierAggregate:Flush %v", summary)
+	na.notifier.Notify(level, "", []error{errors.New(summary)})
+}
+
+// 停止周期发送，并发送剩余的汇总
+func (na *NotifierAggregate) Close() {
+	na.closeOnce.Do(func() {
+		close(na.stop)
+	})
+	na.Flush()
+}
+
+// 同时设置被包装的Notifier的级别
+func (na *NotifierAggregate) SetLogLevel(level Level) Notifier {
+	na.mutex.Lock()
+	na.level = level
+	na.mutex.Unlock()
+
+	na.notifier.SetLogLevel(level)
+	return na
+}
@@ -0,0 +1,126 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+// 记录每次通知的级别
+type levelNotifier struct {
+	*NotifierUnitTest
+	levels []Level
+}
+
+func (ln *levelNotifier) Notify(level Level, sql string, errors []error, args ...interface{}) {
+	ln.levels = append(ln.levels, level)
+	ln.NotifierUnitTest.Notify(level, sql, errors, args...)
+}
+
+func TestNotifierAggregateSummary(t *testing.T) {
+	ln := &levelNotifier{NotifierUnitTest: NewNotifierUnitTest()}
+	na := NewNotifierAggregate(ln, time.Hour)
+	defer na.Close()
+
+	errScan := policy.NewPolicyError(policy.ErrPolicyCodeAllTableScan, "all table scan")
+	for i := 0; i < 3; i++ {
+		na.Notify(ErrorLevel, "SELECT * FROM user WHERE name = 'a'", []error{errScan})
+	}
+	na.Notify(WarnLevel, "SELECT * FROM user WHERE name = 'b'", []error{errScan})
+	na.Notify(WarnLevel, "SELECT * FROM item WHERE level > 1",
+		[]error{policy.NewPolicyError(policy.WarnPolicyCodeIndexSelectivity, "selectivity")})
+	if ln.ErrsCount() != 0 {
+		t.Fatalf("findings should be buffered, got %v", ln.GetErrs())
+	}
+
+	na.Flush()
+	errs := ln.GetErrs()
+	if len(errs) != 1 || len(ln.levels) != 1 || ln.levels[0] != ErrorLevel {
+		t.Fatalf("unexpected summary %v, levels %v", errs, ln.levels)
+	}
+	lines := strings.Split(errs[0].Error(), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "[mskeeper] 5 findings in") ||
+		!strings.HasPrefix(lines[1], "[4×] "+policy.ErrPolicyCodeAllTableScan.String()+" Level=error") ||
+		!strings.HasPrefix(lines[2], "[1×] "+policy.WarnPolicyCodeIndexSelectivity.String()+" Level=warning") {
+		t.Fatalf("unexpected summary %q", errs[0].Error())
+	}
+
+	// 没有新的告警时不发送
+	na.Flush()
+	if ln.ErrsCount() != 1 {
+		t.Fatalf("empty window should not be sent")
+	}
+}
+
+func TestNotifierAggregateCriticalCodes(t *testing.T) {
+	nut := NewNotifierUnitTest()
+	na := NewNotifierAggregate(nut, time.Hour).SetCriticalCodes(policy.ErrPolicyCodeSQLInjection)
+
+	na.Notify(ErrorLevel, "SELECT * FROM user WHERE id = 1 OR 1 = 1", []error{
+		policy.NewPolicyError(policy.ErrPolicyCodeSQLInjection, "injection"),
+		errors.New("other"),
+	})
+	if errs := nut.GetErrs(); len(errs) != 1 || !nut.HasErr(policy.ErrPolicyCodeSQLInjection) {
+		t.Fatalf("critical code should be sent immediately, got %v", errs)
+	}
+
+	na.Close()
+	if errs := nut.GetErrs(); len(errs) != 2 || !strings.Contains(errs[1].Error(), "[1×] error") {
+		t.Fatalf("unexpected summary %v", errs)
+	}
+}
+
+func TestNotifierAggregateWindow(t *testing.T) {
+	nut := NewNotifierUnitTest()
+	na := NewNotifierAggregate(nut, 50*time.Millisecond)
+	defer na.Close()
+
+	for i := 0; i < 10; i++ {
+		na.Notify(WarnLevel, "SELECT 1", []error{errors.New("err")})
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for nut.ErrsCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if errs := nut.GetErrs(); len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "[mskeeper] 10 findings") {
+		t.Fatalf("summary should be sent once per window, got %v", errs)
+	}
+}
+
+func TestNotifierAggregateSkipSafe(t *testing.T) {
+	nut := NewNotifierUnitTest()
+	na := NewNotifierAggregate(nut, time.Hour)
+	defer na.Close()
+
+	// 每条SQL都会产生的ErrPolicyCodeSafe不进入汇总
+	for i := 0; i < MaxAggregateGroups+10; i++ {
+		na.Notify(InfoLevel, fmt.Sprintf("SELECT * FROM t%v WHERE id = 1", i),
+			[]error{policy.NewPolicyErrorSafe(1, time.Millisecond)})
+	}
+	na.Notify(ErrorLevel, "SELECT * FROM user WHERE name = 'a'",
+		[]error{policy.NewPolicyError(policy.ErrPolicyCodeAllTableScan, "all table scan")})
+	na.Notify(InfoLevel, "SELECT * FROM item",
+		[]error{policy.NewPolicyError(policy.WarnPolicyCodeIndexSelectivity, "selectivity").WithSeverity(InfoLevel)})
+
+	na.Flush()
+	errs := nut.GetErrs()
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "[mskeeper] 1 findings in") ||
+		!strings.Contains(errs[0].Error(), "[1×] "+policy.ErrPolicyCodeAllTableScan.String()) {
+		t.Fatalf("unexpected summary %v", errs)
+	}
+
+	// 降低级别后Info的告警进入汇总，ErrPolicyCodeSafe仍被忽略
+	na.SetLogLevel(InfoLevel)
+	na.Notify(InfoLevel, "SELECT 1", []error{policy.NewPolicyErrorSafe(1, time.Millisecond)})
+	na.Notify(InfoLevel, "SELECT * FROM item",
+		[]error{policy.NewPolicyError(policy.WarnPolicyCodeIndexSelectivity, "selectivity").WithSeverity(InfoLevel)})
+	na.Flush()
+	if errs := nut.GetErrs(); len(errs) != 2 || !strings.HasPrefix(errs[1].Error(), "[mskeeper] 1 findings in") ||
+		!strings.Contains(errs[1].Error(), policy.WarnPolicyCodeIndexSelectivity.String()) {
+		t.Fatalf("unexpected summary %v", errs)
+	}
+}
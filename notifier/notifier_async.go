@@ -0,0 +1,192 @@
+package notifier
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+)
+
+/*
+
+异步的通知（包装任意Notifier）
+
+mskeeper在持有锁的情况下同步调用Notify，较慢的通知（eg. 钉钉）会阻塞所有SQL的检查。
+NotifierAsync将通知放入有界的队列，由workers个goroutine调用被包装的Notifier：
+
+1. 队列满时按DropPolicy丢弃：DropNewest丢弃本次的通知，DropOldest丢弃队列中最早的通知
+2. Flush等待已入队的通知发送完成；Close之后不再接受新的通知，并等待队列中的通知发送完成
+3. 级别低于SetLogLevel（默认WarnLevel）的通知在入队前丢弃（不计入Dropped），eg. 每条SQL的ErrPolicyCodeSafe不占用队列
+
+*/
+
+const (
+	DefaultAsyncQueueSize = 1024
+	DefaultAsyncWorkers   = 1
+)
+
+var (
+	ErrNotifierAsyncTimeout = errors.New("NotifierAsync timed out")
+)
+
+type DropPolicy int
+
+const (
+	DropNewest DropPolicy = iota
+	DropOldest
+)
+
+type asyncNotification struct {
+	level  Level
+	sql    string
+	errors []error
+	args   []interface{}
+}
+
+type NotifierAsync struct {
+	notifier   Notifier
+	dropPolicy DropPolicy
+	queue      chan *asyncNotification
+	level      uint32 // Level，SetLogLevel可能与Notify并发
+
+	mutex   sync.RWMutex // closed及关闭queue
+	closed  bool
+	workers sync.WaitGroup
+	pending int64 // 已入队、尚未发送完成的通知
+	dropped uint64
+}
+
+// queueSize、workers<=0时使用默认值
+func NewNotifierAsync(notifier Notifier, queueSize int, workers int, dropPolicy DropPolicy) *NotifierAsync {
+	if queueSize <= 0 {
+		queueSize = DefaultAsyncQueueSize
+	}
+	if workers <= 0 {
+		workers = DefaultAsyncWorkers
+	}
+
+	na := &NotifierAsync{
+		notifier:   notifier,
+		dropPolicy: dropPolicy,
+		queue:      make(chan *asyncNotification, queueSize),
+		level:      uint32(WarnLevel),
+	}
+	for i := 0; i < workers; i++ {
+		na.workers.Add(1)
+		go na.work()
+	}
+	return na
+}
+
+func (na *NotifierAsync) work() {
+	defer na.workers.Done()
+
+	for an := range na.queue {
+		na.send(an)
+	}
+}
+
+func (na *NotifierAsync) send(an *asyncNotification) {
+	defer atomic.AddInt64(&na.pending, -1)
+	defer misc.PrintPanicStack()
+
+	na.notifier.Notify(an.level, an.sql, an.errors, an.args...)
+}
+
+func (na *NotifierAsync) Notify(level Level, sql string, errors []error, args ...interface{}) {
+	if level > Level(atomic.LoadUint32(&na.level)) {
+		return
+	}
+
+	an := &asyncNotification{
+		level:  level,
+		sql:    sql,
+		errors: append([]error{}, errors...),
+		args:   append([]interface{}{}, args...),
+	}
+
+	na.mutex.RLock()
+	defer na.mutex.RUnlock()
+
+	if na.closed {
+		atomic.AddUint64(&na.dropped, 1)
+		log.MSKLog().Warnf("NotifierAsync:Notify(%v) dropped since closed", sql)
+		return
+	}
+
+	atomic.AddInt64(&na.pending, 1)
+	for {
+		select {
+		case na.queue <- an:
+			return
+		default:
+		}
+
+		if na.dropPolicy != DropOldest {
+			na.drop(sql)
+			return
+		}
+		select {
+		case old := <-na.queue:
+			na.drop(old.sql)
+		default:
+		}
+	}
+}
+
+func (na *NotifierAsync) drop(sql string) {
+	atomic.AddInt64(&na.pending, -1)
+	atomic.AddUint64(&na.dropped, 1)
+	log.MSKLog().Warnf("NotifierAsync:Notify queue %v was full, notification of %v dropped", cap(na.queue), sql)
+}
+
+// 同时设置被包装的Notifier的级别
+func (na *NotifierAsync) SetLogLevel(level Level) Notifier {
+	atomic.StoreUint32(&na.level, uint32(level))
+	na.notifier.SetLogLevel(level)
+	return na
+}
+
+// 因队列满或已关闭而丢弃的通知数
+func (na *NotifierAsync) Dropped() uint64 {
+	return atomic.LoadUint64(&na.dropped)
+}
+
+// 等待已入队的通知发送完成，至多timeout
+func (na *NotifierAsync) Flush(timeout time.Duration) error {
+	start := time.Now()
+	for atomic.LoadInt64(&na.pending) > 0 {
+		if time.Since(start) > timeout {
+			log.MSKLog().Warnf("NotifierAsync:Flush timed out with %v pending", atomic.LoadInt64(&na.pending))
+			return ErrNotifierAsyncTimeout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// 不再接受新的通知，等待队列中的通知发送完成，至多timeout
+func (na *NotifierAsync) Close(timeout time.Duration) error {
+	na.mutex.Lock()
+	if !na.closed {
+		na.closed = true
+		close(na.queue)
+	}
+	na.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		na.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		log.MSKLog().Warnf("NotifierAsync:Close timed out with %v pending", atomic.LoadInt64(&na.pending))
+		return ErrNotifierAsyncTimeout
+	}
+}
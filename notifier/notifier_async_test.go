@@ -0,0 +1,156 @@
+package notifier
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 第一条通知阻塞直到release被关闭
+type blockingNotifier struct {
+	entered chan struct{}
+	release chan struct{}
+	lock    sync.Mutex
+	sqls    []string
+}
+
+func newBlockingNotifier() *blockingNotifier {
+	return &blockingNotifier{entered: make(chan struct{}, 1), release: make(chan struct{})}
+}
+
+func (bn *blockingNotifier) Notify(level Level, sql string, errors []error, args ...interface{}) {
+	select {
+	case bn.entered <- struct{}{}:
+	default:
+	}
+	<-bn.release
+
+	bn.lock.Lock()
+	defer bn.lock.Unlock()
+	bn.sqls = append(bn.sqls, sql)
+}
+
+func (bn *blockingNotifier) SetLogLevel(level Level) Notifier {
+	return bn
+}
+
+func (bn *blockingNotifier) getSQLs() []string {
+	bn.lock.Lock()
+	defer bn.lock.Unlock()
+	return append([]string{}, bn.sqls...)
+}
+
+func TestNotifierAsyncSlowSink(t *testing.T) {
+	nut := NewNotifierUnitTest()
+	nut.SetNotifyDelay(100 * time.Millisecond)
+	na := NewNotifierAsync(nut, 0, 2, DropNewest)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		na.Notify(ErrorLevel, "SELECT 1", []error{errors.New("err")})
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("Notify should not wait for the sink, took %v", elapsed)
+	}
+	if err := na.Flush(5 * time.Second); err != nil {
+		t.Fatalf("Flush failed %v", err)
+	}
+	if nut.ErrsCount() != 4 || na.Dropped() != 0 {
+		t.Fatalf("unexpected notified %v, dropped %v", nut.ErrsCount(), na.Dropped())
+	}
+}
+
+func TestNotifierAsyncDropPolicy(t *testing.T) {
+	cases := []struct {
+		dropPolicy DropPolicy
+		expected   []string
+	}{
+		{dropPolicy: DropNewest, expected: []string{"1", "2", "3"}},
+		{dropPolicy: DropOldest, expected: []string{"1", "4", "5"}},
+	}
+	for _, testCase := range cases {
+		bn := newBlockingNotifier()
+		na := NewNotifierAsync(bn, 2, 1, testCase.dropPolicy)
+
+		na.Notify(ErrorLevel, "1", nil)
+		<-bn.entered
+		for _, sql := range []string{"2", "3", "4", "5"} {
+			na.Notify(ErrorLevel, sql, nil)
+		}
+		if na.Dropped() != 2 {
+			t.Fatalf("%v: unexpected dropped %v", testCase.dropPolicy, na.Dropped())
+		}
+		if err := na.Flush(10 * time.Millisecond); err != ErrNotifierAsyncTimeout {
+			t.Fatalf("%v: Flush should time out, got %v", testCase.dropPolicy, err)
+		}
+
+		close(bn.release)
+		if err := na.Flush(5 * time.Second); err != nil {
+			t.Fatalf("%v: Flush failed %v", testCase.dropPolicy, err)
+		}
+		if sqls := bn.getSQLs(); !reflect.DeepEqual(sqls, testCase.expected) {
+			t.Fatalf("%v: unexpected notified %v", testCase.dropPolicy, sqls)
+		}
+	}
+}
+
+func TestNotifierAsyncLevel(t *testing.T) {
+	bn := newBlockingNotifier()
+	na := NewNotifierAsync(bn, 2, 1, DropNewest)
+
+	na.Notify(ErrorLevel, "1", nil)
+	<-bn.entered
+	// 低于WarnLevel的通知不占用队列
+	for i := 0; i < 10; i++ {
+		na.Notify(InfoLevel, "safe", nil)
+	}
+	na.Notify(ErrorLevel, "2", nil)
+	na.Notify(WarnLevel, "3", nil)
+	if na.Dropped() != 0 {
+		t.Fatalf("unexpected dropped %v", na.Dropped())
+	}
+
+	close(bn.release)
+	if err := na.Flush(5 * time.Second); err != nil {
+		t.Fatalf("Flush failed %v", err)
+	}
+	if sqls := bn.getSQLs(); !reflect.DeepEqual(sqls, []string{"1", "2", "3"}) {
+		t.Fatalf("unexpected notified %v", sqls)
+	}
+
+	na.SetLogLevel(InfoLevel)
+	na.Notify(InfoLevel, "safe", nil)
+	if err := na.Flush(5 * time.Second); err != nil {
+		t.Fatalf("Flush failed %v", err)
+	}
+	if sqls := bn.getSQLs(); len(sqls) != 4 || sqls[3] != "safe" {
+		t.Fatalf("unexpected notified %v", sqls)
+	}
+}
+
+func TestNotifierAsyncClose(t *testing.T) {
+	nut := NewNotifierUnitTest()
+	nut.SetNotifyDelay(20 * time.Millisecond)
+	na := NewNotifierAsync(nut, 10, 1, DropNewest)
+
+	for i := 0; i < 3; i++ {
+		na.Notify(ErrorLevel, "SELECT 1", []error{errors.New("err")})
+	}
+	if err := na.Close(5 * time.Second); err != nil {
+		t.Fatalf("Close failed %v", err)
+	}
+	if nut.ErrsCount() != 3 {
+		t.Fatalf("queued notifications should be sent before Close returns, got %v", nut.ErrsCount())
+	}
+
+	// 关闭之后的通知被丢弃，重复Close不会panic
+	na.Notify(ErrorLevel, "SELECT 1", []error{errors.New("err")})
+	if na.Dropped() != 1 || nut.ErrsCount() != 3 {
+		t.Fatalf("unexpected dropped %v, notified %v", na.Dropped(), nut.ErrsCount())
+	}
+	if err := na.Close(time.Second); err != nil {
+		t.Fatalf("Close failed %v", err)
+	}
+}
@@ -1,64 +1,75 @@
 package notifier
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"gitlab.papegames.com/fringe/mskeeper/log"
-	"net/http"
-	"strings"
+	"net/url"
+	"strconv"
+	"time"
 )
 
+const (
+	DingDingWebhookURL = "https://oapi.dingtalk.com/robot/send"
+	DingDingPayload    = `{"msgtype": "text", "text": {"content": {{json .Text}}}}`
+)
+
+// 钉钉机器人，基于NotifierWebhook，secret非空时按加签方式发送
 type NotifierDingDing struct {
+	*NotifierWebhook
 	accessToken string
-	level       Level
+	secret      string
 }
 
 func NewNotifierDingDing(accessToken string) *NotifierDingDing {
-
-	notifier := &NotifierDingDing{}
-	notifier.accessToken = accessToken
-
-	return notifier
+	return NewNotifierDingDingWithSecret(accessToken, "")
 }
 
-func (nl *NotifierDingDing) Notify(level Level, sql string, errors []error, args ...interface{}) {
-	log.MSKLog().Infof("NotifierDingDing:Notify(%v, %v, %v)", sql, args, errors)
+// 机器人安全设置为加签时使用，secret为 SEC 开头的密钥
+func NewNotifierDingDingWithSecret(accessToken string, secret string) *NotifierDingDing {
 
-	if level > nl.level {
-		return
-	}
-	for i := 0; i < len(errors); i++ {
-		sendDingMsg(fmt.Sprintf("[mskeeper] Error=%v Level=%v SQL=%v PARAM=%v", errors[i], level, sql, args), nl.accessToken)
+	webhook, _ := NewNotifierWebhook(DingDingWebhookURL+"?access_token="+url.QueryEscape(accessToken), DingDingPayload)
+	notifier := &NotifierDingDing{NotifierWebhook: webhook}
+	notifier.accessToken = accessToken
+	notifier.secret = secret
+	webhook.checkResponse = checkDingDingResponse
+	if secret != "" {
+		webhook.signURL = func(target string) string {
+			return signDingDingURL(target, secret, time.Now())
+		}
 	}
+
+	return notifier
 }
 
 func (nl *NotifierDingDing) SetLogLevel(level Level) Notifier {
 
-	nl.level = level
+	nl.NotifierWebhook.SetLogLevel(level)
 	return nl
 }
 
-func sendDingMsg(msg string, accessToken string) {
-	//请求地址模板
-	webHook := fmt.Sprintf(`https://oapi.dingtalk.com/robot/send?access_token=%v`, accessToken)
-	content := `{"msgtype": "text",
-		"text": {"content": "` + msg + `"}
-	}`
+// sign = Base64(HmacSHA256(secret, timestamp + "\n" + secret))，timestamp为毫秒
+func signDingDingURL(target string, secret string, now time.Time) string {
+	timestamp := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "\n" + secret))
+	sign := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return target + "&timestamp=" + timestamp + "&sign=" + url.QueryEscape(sign)
+}
 
-	req, err := http.NewRequest("POST", webHook, strings.NewReader(content))
-	if err != nil {
-		log.MSKLog().Errorf("sendDingMsg(%v, %v) failed %v", msg, accessToken, err)
-		return
+// 钉钉的HTTP状态码总是200，错误通过errcode返回，eg. 310000 sign not match
+func checkDingDingResponse(body []byte) error {
+	var resp struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
 	}
-
-	client := &http.Client{}
-
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-
-	resp, err := client.Do(req)
-
-	if err != nil {
-		log.MSKLog().Errorf("sendDingMsg(%v, %v) failed %v", msg, accessToken, err)
-		return
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("invalid response %s: %v", body, err)
+	}
+	if resp.ErrCode != 0 {
+		return fmt.Errorf("errcode %v: %v", resp.ErrCode, resp.ErrMsg)
 	}
-	defer resp.Body.Close()
+	return nil
 }
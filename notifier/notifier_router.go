@@ -0,0 +1,280 @@
+package notifier
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+/*
+
+按规则路由的通知
+
+NotifierMux将每条告警发送给所有的Notifier，NotifierRouter按规则选择接收的Notifier，eg.
+
+	router := notifier.NewNotifierRouter().
+		AddRoute(notifier.RouteRule{Codes: []policy.PolicyCode{policy.ErrPolicyCodeDataTruncate}}, dataTeam).
+		AddRoute(notifier.RouteRule{Codes: []policy.PolicyCode{policy.ErrPolicyCodeAllTableScan}, Tables: []string{"pay_*"}}, payOnCall).
+		AddRoute(notifier.RouteRule{}, logFile)
+
+1. 每条告警按顺序匹配所有规则，发送给所有匹配的规则的Notifier（同一个Notifier只发送一次），
+   规则的Stop为true时匹配后不再匹配后续的规则；没有匹配任何规则时发送给SetDefault的Notifier
+2. 规则的各条件之间为且的关系，为空表示不限
+3. 告警的标签来自SetTableLabels（语句涉及的表的标签），以及SQL块注释中的 key=value（空格或逗号分隔），eg. owner=payments
+4. SetLogLevel只设置路由自身的级别（默认TraceLevel，即不过滤），不修改各Notifier的级别
+
+*/
+
+var routerLabelReg = regexp.MustCompile(`/\*(.*?)\*/`)
+
+// 路由规则，为空的条件表示不限
+type RouteRule struct {
+	Codes       []policy.PolicyCode // 告警码，非PolicyError的告警不匹配
+	Levels      []Level
+	Tables      []string          // 语句涉及的任一表匹配即可，path.Match语法，不区分大小写，!开头表示排除
+	Fingerprint string            // SQL的指纹（misc.FingerprintSQL），也可直接填写SQL
+	Labels      map[string]string // 全部标签相同才匹配
+	Stop        bool              // 匹配后不再匹配后续的规则
+}
+
+type notifierRoute struct {
+	rule      RouteRule
+	includes  []string
+	excludes  []string
+	notifiers []Notifier
+}
+
+type tableLabels struct {
+	glob   string
+	labels map[string]string
+}
+
+type NotifierRouter struct {
+	mutex       sync.RWMutex
+	routes      []*notifierRoute
+	defaults    []Notifier
+	tableLabels []tableLabels
+	level       Level
+}
+
+func NewNotifierRouter() *NotifierRouter {
+	return &NotifierRouter{level: TraceLevel}
+}
+
+func (nr *NotifierRouter) AddRoute(rule RouteRule, notifiers ...Notifier) *NotifierRouter {
+	nr.mutex.Lock()
+	defer nr.mutex.Unlock()
+
+	route := &notifierRoute{rule: rule, notifiers: append([]Notifier{}, notifiers...)}
+	if rule.Fingerprint != "" {
+		route.rule.Fingerprint = misc.FingerprintSQL(rule.Fingerprint)
+	}
+	for _, glob := range rule.Tables {
+		glob = strings.ToLower(strings.TrimSpace(glob))
+		if strings.HasPrefix(glob, "!") {
+			route.excludes = append(route.excludes, glob[1:])
+		} else if glob != "" {
+			route.includes = append(route.includes, glob)
+		}
+	}
+	nr.routes = append(nr.routes, route)
+	return nr
+}
+
+// 没有匹配任何规则的告警的接收者
+func (nr *NotifierRouter) SetDefault(notifiers ...Notifier) *NotifierRouter {
+	nr.mutex.Lock()
+	defer nr.mutex.Unlock()
+
+	nr.defaults = append([]Notifier{}, notifiers...)
+	return nr
+}
+
+// 为匹配glob的表设置标签，eg. SetTableLabels("pay_*", map[string]string{"owner": "payments"})
+func (nr *NotifierRouter) SetTableLabels(glob string, labels map[string]string) *NotifierRouter {
+	nr.mutex.Lock()
+	defer nr.mutex.Unlock()
+
+	nr.tableLabels = append(nr.tableLabels, tableLabels{glob: strings.ToLower(strings.TrimSpace(glob)), labels: labels})
+	return nr
+}
+
+func (nr *NotifierRouter) SetLogLevel(level Level) Notifier {
+	nr.mutex.Lock()
+	defer nr.mutex.Unlock()
+
+	nr.level = level
+	return nr
+}
+
+// 告警所属的SQL，指纹、表及标签按需计算
+type routeSubject struct {
+	sql         string
+	fingerprint string
+	tables      []string
+	labels      map[string]string
+}
+
+func (rs *routeSubject) fingerprintOf() string {
+	if rs.fingerprint == "" {
+		rs.fingerprint = misc.FingerprintSQL(rs.sql)
+	}
+	return rs.fingerprint
+}
+
+func (rs *routeSubject) tablesOf() []string {
+	if rs.tables == nil {
+		rs.tables = policy.TablesOfQuery(rs.sql, nil)
+	}
+	return rs.tables
+}
+
+func (rs *routeSubject) labelsOf(tls []tableLabels) map[string]string {
+	if rs.labels != nil {
+		return rs.labels
+	}
+	rs.labels = map[string]string{}
+	for _, tl := range tls {
+		for _, table := range rs.tablesOf() {
+			if policy.MatchTableGlobs(table, []string{tl.glob}) {
+				for k, v := range tl.labels {
+					rs.labels[k] = v
+				}
+				break
+			}
+		}
+	}
+	// SQL注释中的标签优先
+	for _, comment := range routerLabelReg.FindAllStringSubmatch(rs.sql, -1) {
+		for _, field := range strings.FieldsFunc(comment[1], func(r rune) bool { return r == ' ' || r == ',' || r == ';' }) {
+			if kv := strings.SplitN(field, "=", 2); len(kv) == 2 && kv[0] != "" {
+				rs.labels[kv[0]] = kv[1]
+			}
+		}
+	}
+	return rs.labels
+}
+
+func (nr *NotifierRouter) match(route *notifierRoute, level Level, err error, rs *routeSubject) bool {
+	rule := route.rule
+	if len(rule.Codes) > 0 {
+		perror, ok := err.(*policy.PolicyError)
+		if !ok {
+			return false
+		}
+		found := false
+		for _, code := range rule.Codes {
+			found = found || code == perror.Code
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(rule.Levels) > 0 {
+		found := false
+		for _, lvl := range rule.Levels {
+			found = found || lvl == level
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.Fingerprint != "" && rule.Fingerprint != rs.fingerprintOf() {
+		return false
+	}
+	if len(route.includes) > 0 || len(route.excludes) > 0 {
+		found := false
+		for _, table := range rs.tablesOf() {
+			if len(route.includes) > 0 && !policy.MatchTableGlobs(table, route.includes) {
+				continue
+			}
+			if policy.MatchTableGlobs(table, route.excludes) {
+				continue
+			}
+			found = true
+			break
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(rule.Labels) > 0 {
+		labels := rs.labelsOf(nr.tableLabels)
+		for k, v := range rule.Labels {
+			if labels[k] != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func appendNotifier(notifiers []Notifier, notifier Notifier) []Notifier {
+	for _, n := range notifiers {
+		if n == notifier {
+			return notifiers
+		}
+	}
+	return append(notifiers, notifier)
+}
+
+// 告警的接收者
+func (nr *NotifierRouter) route(level Level, err error, rs *routeSubject) []Notifier {
+	notifiers := []Notifier{}
+	matched := false
+	for _, route := range nr.routes {
+		if !nr.match(route, level, err, rs) {
+			continue
+		}
+		matched = true
+		for _, notifier := range route.notifiers {
+			notifiers = appendNotifier(notifiers, notifier)
+		}
+		if route.rule.Stop {
+			break
+		}
+	}
+	if !matched {
+		for _, notifier := range nr.defaults {
+			notifiers = appendNotifier(notifiers, notifier)
+		}
+	}
+	return notifiers
+}
+
+func (nr *NotifierRouter) Notify(level Level, sql string, errors []error, args ...interface{}) {
+	nr.mutex.RLock()
+	if level > nr.level {
+		nr.mutex.RUnlock()
+		return
+	}
+
+	// 按接收者汇总，每个Notifier只调用一次
+	rs := &routeSubject{sql: sql}
+	notifiers := []Notifier{}
+	errs := [][]error{}
+	for i := 0; i < len(errors); i++ {
+		for _, notifier := range nr.route(level, errors[i], rs) {
+			idx := len(notifiers)
+			for j := 0; j < len(notifiers); j++ {
+				if notifiers[j] == notifier {
+					idx = j
+					break
+				}
+			}
+			if idx == len(notifiers) {
+				notifiers = append(notifiers, notifier)
+				errs = append(errs, []error{})
+			}
+			errs[idx] = append(errs[idx], errors[i])
+		}
+	}
+	nr.mutex.RUnlock()
+
+	for i := 0; i < len(notifiers); i++ {
+		notifiers[i].Notify(level, sql, errs[i], args...)
+	}
+}
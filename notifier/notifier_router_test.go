@@ -0,0 +1,79 @@
+package notifier
+
+import (
+	"errors"
+	"testing"
+
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+func TestNotifierRouter(t *testing.T) {
+	dataTeam := NewNotifierUnitTest()
+	payOnCall := NewNotifierUnitTest()
+	logFile := NewNotifierUnitTest()
+	fallback := NewNotifierUnitTest()
+
+	router := NewNotifierRouter().
+		AddRoute(RouteRule{Codes: []policy.PolicyCode{policy.ErrPolicyCodeDataTruncate, policy.ErrPolicyCodeDataTruncateStrict}}, dataTeam).
+		AddRoute(RouteRule{Codes: []policy.PolicyCode{policy.ErrPolicyCodeAllTableScan}, Tables: []string{"pay_*", "!pay_log"}}, payOnCall).
+		AddRoute(RouteRule{}, logFile)
+
+	errTruncate := policy.NewPolicyError(policy.ErrPolicyCodeDataTruncate, "truncate")
+	errScan := policy.NewPolicyError(policy.ErrPolicyCodeAllTableScan, "scan")
+
+	router.Notify(ErrorLevel, "INSERT INTO user(name) VALUES(?)", []error{errTruncate})
+	router.Notify(ErrorLevel, "SELECT * FROM pay_order o JOIN user u ON o.uid = u.id", []error{errScan, errTruncate})
+	router.Notify(ErrorLevel, "SELECT * FROM pay_log", []error{errScan})
+
+	if dataTeam.ErrsCount() != 2 || payOnCall.ErrsCount() != 1 || logFile.ErrsCount() != 4 {
+		t.Fatalf("unexpected routed errors, data %v, pay %v, log %v",
+			dataTeam.GetErrs(), payOnCall.GetErrs(), logFile.GetErrs())
+	}
+	// 每个Notifier每次只调用一次
+	if sqls := logFile.GetSQLs(); len(sqls) != 3 {
+		t.Fatalf("unexpected notified sqls %v", sqls)
+	}
+
+	// Stop之后的规则不再匹配，没有匹配任何规则的发送给默认的Notifier
+	router = NewNotifierRouter().
+		AddRoute(RouteRule{Levels: []Level{WarnLevel}, Stop: true}, payOnCall).
+		AddRoute(RouteRule{Fingerprint: "SELECT * FROM user WHERE id = 1"}, logFile).
+		SetDefault(fallback)
+	router.Notify(WarnLevel, "select * from user where id = 2", []error{errScan})
+	router.Notify(ErrorLevel, "select * from user where id = 3", []error{errScan})
+	router.Notify(ErrorLevel, "select * from item", []error{errors.New("other")})
+	if payOnCall.ErrsCount() != 2 || logFile.ErrsCount() != 5 || fallback.ErrsCount() != 1 {
+		t.Fatalf("unexpected routed errors, pay %v, log %v, default %v",
+			payOnCall.GetErrs(), logFile.GetErrs(), fallback.GetErrs())
+	}
+}
+
+func TestNotifierRouterLabels(t *testing.T) {
+	payments := NewNotifierUnitTest()
+	router := NewNotifierRouter().
+		SetTableLabels("pay_*", map[string]string{"owner": "payments"}).
+		AddRoute(RouteRule{Labels: map[string]string{"owner": "payments"}}, payments)
+
+	errScan := policy.NewPolicyError(policy.ErrPolicyCodeAllTableScan, "scan")
+	router.Notify(ErrorLevel, "SELECT * FROM pay_order", []error{errScan})
+	router.Notify(ErrorLevel, "SELECT /* owner=payments, service=order */ * FROM user", []error{errScan})
+	router.Notify(ErrorLevel, "SELECT /* owner=growth */ * FROM pay_order", []error{errScan})
+	router.Notify(ErrorLevel, "SELECT * FROM user", []error{errScan})
+	if payments.ErrsCount() != 2 {
+		t.Fatalf("unexpected routed errors %v", payments.GetSQLs())
+	}
+}
+
+func TestNotifierRouterLogLevel(t *testing.T) {
+	nut := NewNotifierUnitTest()
+	nut.SetLogLevel(InfoLevel)
+	router := NewNotifierRouter().AddRoute(RouteRule{}, nut)
+
+	router.Notify(InfoLevel, "SELECT 1", []error{errors.New("info")})
+	router.SetLogLevel(WarnLevel)
+	router.Notify(InfoLevel, "SELECT 1", []error{errors.New("info")})
+	router.Notify(WarnLevel, "SELECT 1", []error{errors.New("warn")})
+	if nut.ErrsCount() != 2 {
+		t.Fatalf("unexpected routed errors %v", nut.GetErrs())
+	}
+}
@@ -0,0 +1,211 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+/*
+
+通用的Webhook通知
+
+每条告警按text/template渲染一次请求体，POST到url：
+
+1. 模板中的字符串通过json函数编码，eg. {"text": {{json .Text}}}，SQL中的引号、换行不会破坏JSON；
+   Content-Type为JSON（默认）时，渲染结果不是合法的JSON则不发送
+2. 网络错误、429以及5xx按 backoff, 2×backoff, 4×backoff... 重试至多retries次，其他非2xx不重试
+3. 请求的超时为timeout，Notify同步等待发送（含重试）完成；Notify在msk.lock内被调用，
+   因此默认不重试，通过SetRetries开启重试时，应使用NewNotifierAsync包装
+
+*/
+
+const (
+	DefaultWebhookTimeout = 5 * time.Second
+	DefaultWebhookRetries = 0
+	DefaultWebhookBackoff = 500 * time.Millisecond
+	MaxWebhookResponse    = 64 << 10 // 读取的响应体上限
+
+	DefaultWebhookPayload = `{"level": {{json .Level}}, "code": {{json .Code}}, "error": {{json .Error}}, ` +
+		`"sql": {{json .SQL}}, "args": {{json .Args}}, "text": {{json .Text}}}`
+)
+
+// 模板的数据
+type WebhookMessage struct {
+	Level string
+	Code  string // 告警码，非PolicyError时为空
+	Error string
+	SQL   string
+	Args  string
+	Text  string // [mskeeper] Error=... Level=... SQL=... PARAM=...
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+type NotifierWebhook struct {
+	url     string
+	headers map[string]string
+	payload *template.Template
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+	level   Level
+
+	// 每次发送前对url的处理，eg. 钉钉的加签
+	signURL func(url string) string
+	// 2xx响应的检查，eg. 钉钉的errcode
+	checkResponse func(body []byte) error
+}
+
+// payload为空时使用DefaultWebhookPayload
+func NewNotifierWebhook(url string, payload string) (*NotifierWebhook, error) {
+	if payload == "" {
+		payload = DefaultWebhookPayload
+	}
+	tmpl, err := template.New("webhook").Funcs(webhookFuncs).Parse(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier := &NotifierWebhook{
+		url:     url,
+		headers: map[string]string{"Content-Type": "application/json; charset=utf-8"},
+		payload: tmpl,
+		timeout: DefaultWebhookTimeout,
+		retries: DefaultWebhookRetries,
+		backoff: DefaultWebhookBackoff,
+	}
+	return notifier, nil
+}
+
+func (nw *NotifierWebhook) SetHeader(key string, value string) *NotifierWebhook {
+	nw.headers[key] = value
+	return nw
+}
+
+func (nw *NotifierWebhook) SetTimeout(timeout time.Duration) *NotifierWebhook {
+	nw.timeout = timeout
+	return nw
+}
+
+// retries<=0则不重试
+func (nw *NotifierWebhook) SetRetries(retries int, backoff time.Duration) *NotifierWebhook {
+	nw.retries = retries
+	nw.backoff = backoff
+	return nw
+}
+
+func (nw *NotifierWebhook) SetLogLevel(level Level) Notifier {
+
+	nw.level = level
+	return nw
+}
+
+func (nw *NotifierWebhook) Notify(level Level, sql string, errors []error, args ...interface{}) {
+	log.MSKLog().Infof("NotifierWebhook:Notify(%v, %v, %v)", sql, args, errors)
+
+	if level > nw.level {
+		return
+	}
+	for i := 0; i < len(errors); i++ {
+		msg := WebhookMessage{
+			Level: level.String(),
+			Error: errors[i].Error(),
+			SQL:   sql,
+			Args:  fmt.Sprintf("%v", args),
+			Text:  fmt.Sprintf("[mskeeper] Error=%v Level=%v SQL=%v PARAM=%v", errors[i], level, sql, args),
+		}
+		if perror, ok := errors[i].(*policy.PolicyError); ok {
+			msg.Code = perror.Code.String()
+		}
+		if err := nw.Send(msg); err != nil {
+			log.MSKLog().Errorf("NotifierWebhook:Notify(%v) failed %v", msg.Text, err)
+		}
+	}
+}
+
+func (nw *NotifierWebhook) render(msg WebhookMessage) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := nw.payload.Execute(buf, msg); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(nw.headers["Content-Type"], "application/json") && !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("invalid JSON payload %v", buf.String())
+	}
+	return buf.Bytes(), nil
+}
+
+// 渲染并发送一条消息，失败时按backoff重试
+func (nw *NotifierWebhook) Send(msg WebhookMessage) error {
+	body, err := nw.render(msg)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: nw.timeout}
+	backoff := nw.backoff
+	for i := 0; ; i++ {
+		retry, err := nw.post(client, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || i >= nw.retries {
+			return fmt.Errorf("after %v attempts: %v", i+1, err)
+		}
+		log.MSKLog().Warnf("NotifierWebhook:Send attempt %v failed %v, retry in %v", i+1, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// 返回的bool表示是否可以重试
+func (nw *NotifierWebhook) post(client *http.Client, body []byte) (bool, error) {
+	target := nw.url
+	if nw.signURL != nil {
+		target = nw.signURL(target)
+	}
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for key, value := range nw.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// url中可能带有access_token，不输出
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, MaxWebhookResponse))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("unexpected status %v: %s", resp.Status, respBody)
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+	}
+	if nw.checkResponse != nil {
+		if err := nw.checkResponse(respBody); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,152 @@
+package notifier
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/policy"
+)
+
+func TestNotifierWebhookJSONEscaping(t *testing.T) {
+	bodies := make(chan map[string]string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "abc" {
+			t.Errorf("header not set %v", r.Header)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		m := map[string]string{}
+		if err := json.Unmarshal(body, &m); err != nil {
+			t.Errorf("invalid JSON %s: %v", body, err)
+		}
+		bodies <- m
+	}))
+	defer ts.Close()
+
+	nw, err := NewNotifierWebhook(ts.URL, "")
+	if err != nil {
+		t.Fatalf("NewNotifierWebhook failed %v", err)
+	}
+	nw.SetHeader("X-Token", "abc").SetLogLevel(WarnLevel)
+
+	sql := "SELECT * FROM user WHERE name = \"a\\b\"\n AND note = 'x'"
+	nw.Notify(ErrorLevel, sql, []error{policy.NewPolicyError(policy.ErrPolicyCodeRowsAbs, "too many rows")}, 1)
+	m := <-bodies
+	if m["sql"] != sql || m["code"] != policy.ErrPolicyCodeRowsAbs.String() || m["level"] != "error" {
+		t.Fatalf("unexpected payload %v", m)
+	}
+
+	// 低于级别的不发送
+	nw.Notify(InfoLevel, sql, []error{errors.New("info")})
+	select {
+	case m := <-bodies:
+		t.Fatalf("unexpected payload %v", m)
+	default:
+	}
+}
+
+func TestNotifierWebhookInvalidPayload(t *testing.T) {
+	if _, err := NewNotifierWebhook("http://127.0.0.1", "{{json .Text"); err == nil {
+		t.Fatalf("invalid template should fail")
+	}
+	// 未经json编码的字符串不是合法的JSON
+	nw, _ := NewNotifierWebhook("http://127.0.0.1", `{"text": "{{.Text}}"}`)
+	if err := nw.Send(WebhookMessage{Text: `quote "`}); err == nil {
+		t.Fatalf("invalid JSON should not be sent")
+	}
+}
+
+func TestNotifierWebhookRetries(t *testing.T) {
+	var calls int32
+	status := http.StatusInternalServerError
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(status)
+		}
+	}))
+	defer ts.Close()
+
+	// 默认不重试
+	nw, _ := NewNotifierWebhook(ts.URL, "")
+	if err := nw.Send(WebhookMessage{Text: "no retry"}); err == nil || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("unexpected err %v, calls %v", err, calls)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	nw.SetRetries(2, time.Millisecond)
+	if err := nw.Send(WebhookMessage{Text: "retry"}); err != nil || atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("unexpected err %v, calls %v", err, calls)
+	}
+
+	// 4xx不重试
+	atomic.StoreInt32(&calls, 0)
+	status = http.StatusBadRequest
+	if err := nw.Send(WebhookMessage{Text: "bad request"}); err == nil || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("unexpected err %v, calls %v", err, calls)
+	}
+}
+
+func TestNotifierWebhookTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	nw, _ := NewNotifierWebhook(ts.URL, "")
+	nw.SetTimeout(20*time.Millisecond).SetRetries(0, 0)
+	if err := nw.Send(WebhookMessage{Text: "timeout"}); err == nil {
+		t.Fatalf("timeout expected")
+	}
+}
+
+func TestNotifierDingDingSigned(t *testing.T) {
+	secret := "SECtest"
+	var errcode int32
+	contents := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write([]byte(q.Get("timestamp") + "\n" + secret))
+		if q.Get("access_token") != "token" || q.Get("sign") != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("unexpected query %v", q)
+		}
+		var msg struct {
+			Msgtype string `json:"msgtype"`
+			Text    struct {
+				Content string `json:"content"`
+			} `json:"text"`
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &msg); err != nil || msg.Msgtype != "text" {
+			t.Errorf("invalid message %s: %v", body, err)
+		}
+		contents <- msg.Text.Content
+		_, _ = w.Write([]byte(`{"errcode": ` + strconv.Itoa(int(atomic.LoadInt32(&errcode))) + `, "errmsg": "ok"}`))
+	}))
+	defer ts.Close()
+
+	nd := NewNotifierDingDingWithSecret("token", secret)
+	nd.url = ts.URL + "?access_token=token"
+	nd.SetLogLevel(ErrorLevel)
+
+	nd.Notify(ErrorLevel, `SELECT "1"`, []error{errors.New("err")})
+	if content := <-contents; content != `[mskeeper] Error=err Level=error SQL=SELECT "1" PARAM=[]` {
+		t.Fatalf("unexpected content %v", content)
+	}
+
+	// errcode非0为失败，不重试
+	atomic.StoreInt32(&errcode, 1)
+	if err := nd.Send(WebhookMessage{Text: "errcode"}); err == nil {
+		t.Fatalf("errcode should fail")
+	}
+	<-contents
+}
@@ -3,25 +3,86 @@ package options
 import (
 	"io"
 	"io/ioutil"
+	"strings"
 	"sync"
 	"time"
 
 	"gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/misc"
 	"gitlab.papegames.com/fringe/mskeeper/notifier"
 	"gitlab.papegames.com/fringe/mskeeper/policy"
 )
 
 type Options struct {
-	mutex           sync.RWMutex
-	Switch          bool                // mskeeper的开关，可重入
-	Notifier        notifier.Notifier   // 检查结果的通知对象
-	MaxExecTime     time.Duration       // SQL的最大执行时间，超出则通知告警
-	Capacity        int                 // mskeeper队列的最大长度，超出则丢失后来的SQL的检查
-	MaxSilentPeriod time.Duration       // 最大的静默周期，即周期MaxSilentPeriod，相同签名的SQL告警至多只有一次。
-	LogOutput       io.Writer           // mskeeper自身的日志输出 eg. os.Stdout, logfile
-	SQLWhiteLists   map[string]struct{} // 不需要检测的SQL白名单
-	SQLCacheSize    int                 // SQL哈希缓存大小设置, 0为不设置缓存, 默认以及上限是2千
-	KeepAlivePeriod time.Duration       // KeepAlive包发送的周期, 默认 1h
+	mutex              sync.RWMutex
+	Switch             bool                // mskeeper的开关，可重入
+	Notifier           notifier.Notifier   // 检查结果的通知对象
+	MaxExecTime        time.Duration       // SQL的最大执行时间，超出则通知告警
+	Capacity           int                 // mskeeper队列的最大长度，超出则丢失后来的SQL的检查
+	MaxSilentPeriod    time.Duration       // 最大的静默周期，即周期MaxSilentPeriod，相同签名的SQL告警至多只有一次。
+	LogOutput          io.Writer           // mskeeper自身的日志输出 eg. os.Stdout, logfile
+	SQLWhiteLists      map[string]struct{} // 不需要检测的SQL白名单
+	SQLCacheSize       int                 // SQL哈希缓存大小设置, 0为不设置缓存, 默认以及上限是2千
+	KeepAlivePeriod    time.Duration       // KeepAlive包发送的周期, 默认 1h
+	MaxTxDuration      time.Duration       // 事务从开始到COMMIT/ROLLBACK的最大时长，超出则通知告警
+	MaxTxStatements    int                 // 事务内的最大语句数，超出则通知告警
+	MaxTxIdleTime      time.Duration       // 事务内两条语句之间的最大空闲时间，超出则通知告警
+	MaxRepeatedQueries int                 // 同一个context作用域内，相同指纹的SQL的最大执行次数，超出则通知告警(N+1查询)
+
+	AutoIncrementCheckPeriod time.Duration // AUTO_INCREMENT使用率的检测周期，<=0则不检测，默认 0（不检测），建议 policy.DefaultAutoIncrementCheckPeriod
+	AutoIncrementThresholds  []float64     // AUTO_INCREMENT使用率的告警阈值，默认 0.7, 0.9, 0.99
+
+	Redaction          RedactionMode       // 通知、日志、错误缓存中SQL及参数的脱敏方式，默认 RedactionArgs
+	RedactionAllowlist map[string]struct{} // 不需要脱敏的列（小写），这些列上的参数及常量原样输出
+
+	LatencyAnomalyFactor float64       // 执行时长 > 指纹的基线 × LatencyAnomalyFactor时告警，<=0则不检测，默认 10
+	LatencyWarmupSamples int           // 指纹的样本数达到LatencyWarmupSamples之后才检测，默认 20
+	MinLatencyAnomaly    time.Duration // 短于MinLatencyAnomaly的执行时长不告警，默认 10ms
+
+	DigestScanPeriod        time.Duration // performance_schema语句摘要的扫描周期，<=0则不扫描，默认 0（不扫描），建议 policy.DefaultDigestScanPeriod
+	DigestRowsExaminedRatio float64       // 语句摘要的扫描行数/返回行数 > DigestRowsExaminedRatio时告警，<=0则不检测，默认 100
+
+	SeverityRules   []SeverityRule   // 告警级别的映射规则，按顺序第一条匹配的生效，没有匹配的按告警码的默认级别
+	EscalationRules []EscalationRule // 告警级别的升级规则，eg. 一小时内出现超过N次的Warn升级为Error
+}
+
+// 告警级别的映射规则，Code为0表示所有告警（ErrPolicyCodeSafe除外），Table、Fingerprint为空表示不限
+type SeverityRule struct {
+	Code        policy.PolicyCode
+	Table       string // 语句涉及的表，path.Match语法，不区分大小写
+	Fingerprint string // SQL的指纹（misc.FingerprintSQL），也可直接填写SQL
+	Level       notifier.Level
+}
+
+// 告警级别的升级规则，周期Window内同一告警（告警码+SQL指纹）出现超过Times次时，From级别升级为To级别
+// Code为0表示所有告警（ErrPolicyCodeSafe除外）
+type EscalationRule struct {
+	Code   policy.PolicyCode
+	From   notifier.Level
+	To     notifier.Level
+	Times  int
+	Window time.Duration
+}
+
+// 脱敏方式
+type RedactionMode int
+
+const (
+	RedactionOff  RedactionMode = iota // 原样输出SQL及参数
+	RedactionArgs                      // 参数替换为RedactedValue，SQL原样输出
+	RedactionFull                      // 参数替换为RedactedValue，SQL中的常量替换为?
+)
+
+func (rm RedactionMode) String() string {
+	switch rm {
+	case RedactionOff:
+		return "RedactionOff"
+	case RedactionArgs:
+		return "RedactionArgs"
+	case RedactionFull:
+		return "RedactionFull"
+	}
+	return "RedactionUnknown"
 }
 
 const MaxSQLCacheSize = 2000
@@ -49,6 +110,25 @@ func (o *Options) Clone() *Options {
 	nop.LogOutput = o.LogOutput
 	nop.SQLCacheSize = o.SQLCacheSize
 	nop.KeepAlivePeriod = o.KeepAlivePeriod
+	nop.MaxTxDuration = o.MaxTxDuration
+	nop.MaxTxStatements = o.MaxTxStatements
+	nop.MaxTxIdleTime = o.MaxTxIdleTime
+	nop.MaxRepeatedQueries = o.MaxRepeatedQueries
+	nop.AutoIncrementCheckPeriod = o.AutoIncrementCheckPeriod
+	nop.AutoIncrementThresholds = append([]float64{}, o.AutoIncrementThresholds...)
+	nop.Redaction = o.Redaction
+	nop.LatencyAnomalyFactor = o.LatencyAnomalyFactor
+	nop.LatencyWarmupSamples = o.LatencyWarmupSamples
+	nop.MinLatencyAnomaly = o.MinLatencyAnomaly
+	nop.DigestScanPeriod = o.DigestScanPeriod
+	nop.DigestRowsExaminedRatio = o.DigestRowsExaminedRatio
+	nop.SeverityRules = append([]SeverityRule{}, o.SeverityRules...)
+	nop.EscalationRules = append([]EscalationRule{}, o.EscalationRules...)
+
+	nop.RedactionAllowlist = make(map[string]struct{})
+	for k, v := range o.RedactionAllowlist {
+		nop.RedactionAllowlist[k] = v
+	}
 
 	nop.SQLWhiteLists = make(map[string]struct{})
 	for k, v := range o.SQLWhiteLists {
@@ -59,15 +139,35 @@ func (o *Options) Clone() *Options {
 
 func DefaultOptions() *Options {
 	opt := &Options{
-		Switch:          false,
-		MaxExecTime:     policy.DefaultMaxExecTime,
-		MaxSilentPeriod: 1 * time.Hour,
-		Notifier:        notifier.NewDefaultNotifier(),
-		Capacity:        10240,
-		LogOutput:       ioutil.Discard,
-		SQLWhiteLists:   map[string]struct{}{},
-		SQLCacheSize:    MaxSQLCacheSize,
-		KeepAlivePeriod: DefaultKeepAlivePeriod,
+		Switch:             false,
+		MaxExecTime:        policy.DefaultMaxExecTime,
+		MaxSilentPeriod:    1 * time.Hour,
+		Notifier:           notifier.NewDefaultNotifier(),
+		Capacity:           10240,
+		LogOutput:          ioutil.Discard,
+		SQLWhiteLists:      map[string]struct{}{},
+		SQLCacheSize:       MaxSQLCacheSize,
+		KeepAlivePeriod:    DefaultKeepAlivePeriod,
+		MaxTxDuration:      policy.DefaultMaxTxDuration,
+		MaxTxStatements:    policy.DefaultMaxTxStatements,
+		MaxTxIdleTime:      policy.DefaultMaxTxIdleTime,
+		MaxRepeatedQueries: policy.DefaultMaxRepeatedQueries,
+
+		AutoIncrementCheckPeriod: 0,
+		AutoIncrementThresholds:  append([]float64{}, policy.DefaultAutoIncrementThresholds...),
+
+		Redaction:          RedactionArgs,
+		RedactionAllowlist: map[string]struct{}{},
+
+		LatencyAnomalyFactor: policy.DefaultLatencyAnomalyFactor,
+		LatencyWarmupSamples: policy.DefaultLatencyWarmupSamples,
+		MinLatencyAnomaly:    policy.DefaultMinLatencyAnomaly,
+
+		DigestScanPeriod:        0,
+		DigestRowsExaminedRatio: policy.DefaultDigestRowsExaminedRatio,
+
+		SeverityRules:   []SeverityRule{},
+		EscalationRules: []EscalationRule{},
 	}
 	return opt
 }
@@ -222,3 +322,264 @@ func WithKeepAlivePeriod(ka time.Duration) Option {
 		o.KeepAlivePeriod = ka
 	}
 }
+
+func FetchMaxTxDuration(o *Options) time.Duration {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.MaxTxDuration
+}
+
+func WithMaxTxDuration(t time.Duration) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.MaxTxDuration = t
+	}
+}
+
+func FetchMaxTxStatements(o *Options) int {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.MaxTxStatements
+}
+
+func WithMaxTxStatements(n int) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.MaxTxStatements = n
+	}
+}
+
+func FetchMaxTxIdleTime(o *Options) time.Duration {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.MaxTxIdleTime
+}
+
+func WithMaxTxIdleTime(t time.Duration) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.MaxTxIdleTime = t
+	}
+}
+
+func FetchMaxRepeatedQueries(o *Options) int {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.MaxRepeatedQueries
+}
+
+func WithMaxRepeatedQueries(n int) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.MaxRepeatedQueries = n
+	}
+}
+
+func FetchAutoIncrementCheckPeriod(o *Options) time.Duration {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.AutoIncrementCheckPeriod
+}
+
+func WithAutoIncrementCheckPeriod(t time.Duration) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.AutoIncrementCheckPeriod = t
+	}
+}
+
+func FetchAutoIncrementThresholds(o *Options) []float64 {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return append([]float64{}, o.AutoIncrementThresholds...)
+}
+
+func WithAutoIncrementThresholds(thresholds ...float64) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.AutoIncrementThresholds = append([]float64{}, thresholds...)
+	}
+}
+
+func FetchRedaction(o *Options) RedactionMode {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.Redaction
+}
+
+func WithRedaction(mode RedactionMode) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.Redaction = mode
+	}
+}
+
+func CheckIfInRedactionAllowlist(o *Options, column string) bool {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	_, ok := o.RedactionAllowlist[strings.ToLower(column)]
+
+	return ok
+}
+
+func WithRedactionAllowlist(columns ...string) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		if o.RedactionAllowlist == nil {
+			o.RedactionAllowlist = make(map[string]struct{})
+		}
+		for _, column := range columns {
+			o.RedactionAllowlist[strings.ToLower(column)] = struct{}{}
+		}
+	}
+}
+
+func FetchLatencyAnomalyFactor(o *Options) float64 {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.LatencyAnomalyFactor
+}
+
+func WithLatencyAnomalyFactor(factor float64) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.LatencyAnomalyFactor = factor
+	}
+}
+
+func FetchLatencyWarmupSamples(o *Options) int {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.LatencyWarmupSamples
+}
+
+func WithLatencyWarmupSamples(samples int) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.LatencyWarmupSamples = samples
+	}
+}
+
+func FetchMinLatencyAnomaly(o *Options) time.Duration {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.MinLatencyAnomaly
+}
+
+func WithMinLatencyAnomaly(d time.Duration) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.MinLatencyAnomaly = d
+	}
+}
+
+func FetchDigestScanPeriod(o *Options) time.Duration {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.DigestScanPeriod
+}
+
+func WithDigestScanPeriod(t time.Duration) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.DigestScanPeriod = t
+	}
+}
+
+func FetchDigestRowsExaminedRatio(o *Options) float64 {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.DigestRowsExaminedRatio
+}
+
+func WithDigestRowsExaminedRatio(ratio float64) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.DigestRowsExaminedRatio = ratio
+	}
+}
+
+func FetchSeverityRules(o *Options) []SeverityRule {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return append([]SeverityRule{}, o.SeverityRules...)
+}
+
+// 替换全部的映射规则，SQL形式的Fingerprint会转换为指纹
+func WithSeverityRules(rules ...SeverityRule) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.SeverityRules = make([]SeverityRule, 0, len(rules))
+		for _, rule := range rules {
+			if rule.Fingerprint != "" {
+				rule.Fingerprint = misc.FingerprintSQL(rule.Fingerprint)
+			}
+			o.SeverityRules = append(o.SeverityRules, rule)
+		}
+	}
+}
+
+func FetchEscalationRules(o *Options) []EscalationRule {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return append([]EscalationRule{}, o.EscalationRules...)
+}
+
+// 替换全部的升级规则，Times<=0或者Window<=0的规则无效
+func WithEscalationRules(rules ...EscalationRule) Option {
+	return func(o *Options) {
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		o.EscalationRules = make([]EscalationRule, 0, len(rules))
+		for _, rule := range rules {
+			if rule.Times <= 0 || rule.Window <= 0 {
+				continue
+			}
+			o.EscalationRules = append(o.EscalationRules, rule)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"gitlab.papegames.com/fringe/mskeeper/notifier"
+	"gitlab.papegames.com/fringe/mskeeper/policy"
 )
 
 func TestOptionsDefault(t *testing.T) {
@@ -51,6 +52,40 @@ func TestOptionsDefault(t *testing.T) {
 	if FetchKeepAlivePeriod(opts) != FetchKeepAlivePeriod(defaultOpt) {
 		t.Fatalf("defaultOpt.KeepAlivePeriod not initialized properly ")
 	}
+
+	if FetchMaxTxDuration(opts) != FetchMaxTxDuration(defaultOpt) ||
+		FetchMaxTxStatements(opts) != FetchMaxTxStatements(defaultOpt) ||
+		FetchMaxTxIdleTime(opts) != FetchMaxTxIdleTime(defaultOpt) {
+		t.Fatalf("defaultOpt.MaxTx* not initialized properly ")
+	}
+
+	if FetchMaxRepeatedQueries(opts) != FetchMaxRepeatedQueries(defaultOpt) {
+		t.Fatalf("defaultOpt.MaxRepeatedQueries not initialized properly ")
+	}
+
+	if FetchAutoIncrementCheckPeriod(opts) != 0 ||
+		!reflect.DeepEqual(FetchAutoIncrementThresholds(opts), FetchAutoIncrementThresholds(defaultOpt)) {
+		t.Fatalf("defaultOpt.AutoIncrement* not initialized properly ")
+	}
+
+	if FetchRedaction(opts) != RedactionArgs || FetchRedaction(opts) != FetchRedaction(defaultOpt) {
+		t.Fatalf("defaultOpt.Redaction not initialized properly ")
+	}
+
+	if FetchLatencyAnomalyFactor(opts) != policy.DefaultLatencyAnomalyFactor ||
+		FetchLatencyWarmupSamples(opts) != policy.DefaultLatencyWarmupSamples ||
+		FetchMinLatencyAnomaly(opts) != policy.DefaultMinLatencyAnomaly {
+		t.Fatalf("defaultOpt.Latency* not initialized properly ")
+	}
+
+	if len(FetchSeverityRules(opts)) != 0 || len(FetchEscalationRules(opts)) != 0 {
+		t.Fatalf("defaultOpt.SeverityRules/EscalationRules not initialized properly ")
+	}
+
+	if FetchDigestScanPeriod(opts) != 0 ||
+		FetchDigestRowsExaminedRatio(opts) != policy.DefaultDigestRowsExaminedRatio {
+		t.Fatalf("defaultOpt.Digest* not initialized properly ")
+	}
 }
 
 func TestOptionsSetting1(t *testing.T) {
@@ -69,6 +104,12 @@ func TestOptionsSetting1(t *testing.T) {
 		WithSQLWhiteLists("select * from client_config"),
 		WithSQLCacheSize(1412),
 		WithKeepAlivePeriod(1412*time.Second),
+		WithMaxTxDuration(7*time.Second),
+		WithMaxTxStatements(12),
+		WithMaxTxIdleTime(2*time.Second),
+		WithMaxRepeatedQueries(33),
+		WithAutoIncrementCheckPeriod(10*time.Minute),
+		WithAutoIncrementThresholds(0.5, 0.8),
 	)
 
 	if FetchCapacity(opts) != 1234 {
@@ -110,6 +151,30 @@ func TestOptionsSetting1(t *testing.T) {
 	if FetchKeepAlivePeriod(opts) != 1412*time.Second {
 		t.Fatalf("NewOptions.KeepAlivePeriod not initialized properly")
 	}
+
+	if FetchMaxTxDuration(opts) != 7*time.Second {
+		t.Fatalf("NewOptions.MaxTxDuration not initialized properly")
+	}
+
+	if FetchMaxTxStatements(opts) != 12 {
+		t.Fatalf("NewOptions.MaxTxStatements not initialized properly")
+	}
+
+	if FetchMaxTxIdleTime(opts) != 2*time.Second {
+		t.Fatalf("NewOptions.MaxTxIdleTime not initialized properly")
+	}
+
+	if FetchMaxRepeatedQueries(opts) != 33 {
+		t.Fatalf("NewOptions.MaxRepeatedQueries not initialized properly")
+	}
+
+	if FetchAutoIncrementCheckPeriod(opts) != 10*time.Minute {
+		t.Fatalf("NewOptions.AutoIncrementCheckPeriod not initialized properly")
+	}
+
+	if !reflect.DeepEqual(FetchAutoIncrementThresholds(opts), []float64{0.5, 0.8}) {
+		t.Fatalf("NewOptions.AutoIncrementThresholds not initialized properly")
+	}
 }
 
 func TestOptionsSetting2(t *testing.T) {
@@ -294,6 +359,66 @@ func TestOptionsSetting2(t *testing.T) {
 	if FetchKeepAlivePeriod(opts) != 1234*time.Minute {
 		t.Fatalf("SetOptions.KeepAlivePeriod not initialized properly ")
 	}
+
+	WithAutoIncrementCheckPeriod(0)(opts)
+	if FetchAutoIncrementCheckPeriod(opts) != 0 {
+		t.Fatalf("SetOptions.AutoIncrementCheckPeriod not initialized properly ")
+	}
+
+	thresholds := []float64{0.6}
+	WithAutoIncrementThresholds(thresholds...)(opts)
+	thresholds[0] = 0.1
+	if !reflect.DeepEqual(FetchAutoIncrementThresholds(opts), []float64{0.6}) {
+		t.Fatalf("SetOptions.AutoIncrementThresholds not initialized properly ")
+	}
+
+	WithRedaction(RedactionFull)(opts)
+	if FetchRedaction(opts) != RedactionFull || FetchRedaction(opts).String() != "RedactionFull" {
+		t.Fatalf("SetOptions.Redaction not initialized properly ")
+	}
+
+	if CheckIfInRedactionAllowlist(opts, "status") {
+		t.Fatalf("SetOptions.RedactionAllowlist not initialized properly ")
+	}
+	WithRedactionAllowlist("Status", "level")(opts)
+	if !CheckIfInRedactionAllowlist(opts, "status") || !CheckIfInRedactionAllowlist(opts, "LEVEL") {
+		t.Fatalf("SetOptions.RedactionAllowlist not initialized properly ")
+	}
+	if !CheckIfInRedactionAllowlist(opts.Clone(), "status") {
+		t.Fatalf("Clone.RedactionAllowlist not initialized properly ")
+	}
+
+	WithSeverityRules(SeverityRule{Code: policy.ErrPolicyCodeRowsAbs, Fingerprint: "SELECT * FROM user WHERE id = 1", Level: notifier.WarnLevel})(opts)
+	rules := FetchSeverityRules(opts.Clone())
+	if len(rules) != 1 || rules[0].Fingerprint != "select * from user where id = ?" || rules[0].Level != notifier.WarnLevel {
+		t.Fatalf("SetOptions.SeverityRules not initialized properly %v", rules)
+	}
+	WithEscalationRules(
+		EscalationRule{From: notifier.WarnLevel, To: notifier.ErrorLevel, Times: 10, Window: time.Hour},
+		EscalationRule{From: notifier.WarnLevel, To: notifier.ErrorLevel, Times: 10},
+	)(opts)
+	if escalations := FetchEscalationRules(opts.Clone()); len(escalations) != 1 || escalations[0].Times != 10 {
+		t.Fatalf("SetOptions.EscalationRules not initialized properly %v", escalations)
+	}
+	WithSeverityRules()(opts)
+	if len(FetchSeverityRules(opts)) != 0 {
+		t.Fatalf("SetOptions.SeverityRules not replaced")
+	}
+
+	WithLatencyAnomalyFactor(5)(opts)
+	WithLatencyWarmupSamples(100)(opts)
+	WithMinLatencyAnomaly(time.Second)(opts)
+	nop := opts.Clone()
+	if FetchLatencyAnomalyFactor(nop) != 5 || FetchLatencyWarmupSamples(nop) != 100 || FetchMinLatencyAnomaly(nop) != time.Second {
+		t.Fatalf("SetOptions.Latency* not initialized properly ")
+	}
+
+	WithDigestScanPeriod(0)(opts)
+	WithDigestRowsExaminedRatio(50)(opts)
+	nop = opts.Clone()
+	if FetchDigestScanPeriod(nop) != 0 || FetchDigestRowsExaminedRatio(nop) != 50 {
+		t.Fatalf("SetOptions.Digest* not initialized properly ")
+	}
 }
 
 func TestOptionsClone(t *testing.T) {
@@ -360,4 +485,14 @@ func TestOptionsClone(t *testing.T) {
 		t.Fatalf("Options.SQLWhiteLists not cloned properly ")
 	}
 
+	WithMaxTxDuration(7 * time.Second)(opts)
+	WithMaxTxStatements(12)(opts)
+	WithMaxTxIdleTime(2 * time.Second)(opts)
+	clone3 := opts.Clone()
+	if FetchMaxTxDuration(clone3) != 7*time.Second ||
+		FetchMaxTxStatements(clone3) != 12 ||
+		FetchMaxTxIdleTime(clone3) != 2*time.Second {
+		t.Fatalf("Options.MaxTx* not cloned properly ")
+	}
+
 }
@@ -0,0 +1,200 @@
+package policy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+
+AUTO_INCREMENT耗尽的检测
+
+自增列达到类型上限后，INSERT会报错 ER_AUTOINC_READ_FAILED / Duplicate entry，且只能通过修改列类型恢复（大表的COPY DDL）。
+周期性地读取information_schema.TABLES.AUTO_INCREMENT以及自增列的COLUMN_TYPE，计算使用率：
+
+	usage = (AUTO_INCREMENT - 1) / 类型上限，eg. int => 2147483647, int unsigned => 4294967295
+
+使用率达到阈值（默认70%、90%、99%）时告警，其中最高的阈值为ErrPolicyCodeAutoIncrement，其余为WarnPolicyCodeAutoIncrement。
+根据相邻两次采样之间的增长速度，估算距离耗尽的天数。
+
+MySQL 8.0中information_schema.TABLES的统计信息默认缓存24小时（information_schema_stats_expiry），
+采样时会尝试将当前会话的information_schema_stats_expiry设置为0。
+
+*/
+
+const (
+	DefaultAutoIncrementCheckPeriod time.Duration = 1 * time.Hour
+)
+
+var DefaultAutoIncrementThresholds = []float64{0.7, 0.9, 0.99}
+
+type AutoIncrementSample struct {
+	Table         string
+	Column        string
+	ColumnType    string
+	AutoIncrement uint64 // 下一个自增值
+	MaxValue      uint64 // 列类型的上限
+	SampledAt     time.Time
+}
+
+// 已使用的比例
+func (s *AutoIncrementSample) Usage() float64 {
+	if s.MaxValue == 0 || s.AutoIncrement == 0 {
+		return 0
+	}
+	return float64(s.AutoIncrement-1) / float64(s.MaxValue)
+}
+
+// 整数列类型的上限，非整数类型返回0，eg. int(11) unsigned => 4294967295
+func MaxValueOfColumnType(columnType string) uint64 {
+	typeString, _, unsigned := parseColumnType(columnType)
+	if !isIntegerFieldType(typeString) {
+		return 0
+	}
+	bits := uint(calNumberOfBitsByFieldTypeInt(typeString))
+	if bits == 0 {
+		return 0
+	}
+	if unsigned {
+		if bits >= 64 {
+			return math.MaxUint64
+		}
+		return 1<<bits - 1
+	}
+	return 1<<(bits-1) - 1
+}
+
+// 估算距离耗尽的天数，prev与cur之间没有增长（或AUTO_INCREMENT被重置）时返回false
+func ProjectDaysToExhaustion(prev, cur AutoIncrementSample) (float64, bool) {
+	elapsed := cur.SampledAt.Sub(prev.SampledAt)
+	if elapsed <= 0 || cur.AutoIncrement <= prev.AutoIncrement || cur.MaxValue == 0 {
+		return 0, false
+	}
+	perDay := float64(cur.AutoIncrement-prev.AutoIncrement) / elapsed.Hours() * 24
+	remain := float64(0)
+	if cur.MaxValue >= cur.AutoIncrement {
+		remain = float64(cur.MaxValue - cur.AutoIncrement + 1)
+	}
+	return remain / perDay, true
+}
+
+// 当前库（DATABASE()）中所有自增列的采样
+func QueryAutoIncrementSamples(db *sql.DB, timeout time.Duration) ([]AutoIncrementSample, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// 针对 mysql 5.7.x 版本在context方面的bug，workaround
+	if notSupportContext {
+		timeout = timeout * 100
+	}
+	defer time.AfterFunc(timeout, cancel).Stop()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = safeRollback("QueryAutoIncrementSamples() rollback", tx)
+	}()
+
+	// 5.7中没有该变量，忽略错误
+	_, _ = tx.ExecContext(ctx, "SET SESSION information_schema_stats_expiry = 0")
+
+	rows, err := tx.QueryContext(ctx, "SELECT t.TABLE_NAME, c.COLUMN_NAME, c.COLUMN_TYPE, t.AUTO_INCREMENT "+
+		"FROM information_schema.TABLES t JOIN information_schema.COLUMNS c "+
+		"ON c.TABLE_SCHEMA = t.TABLE_SCHEMA AND c.TABLE_NAME = t.TABLE_NAME "+
+		"WHERE t.TABLE_SCHEMA = DATABASE() AND t.AUTO_INCREMENT IS NOT NULL AND c.EXTRA LIKE '%auto_increment%'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	samples := []AutoIncrementSample{}
+	for rows.Next() {
+		var table, column, columnType, autoIncrement sql.NullString
+		if err := rows.Scan(&table, &column, &columnType, &autoIncrement); err != nil {
+			return nil, err
+		}
+		value, err := strconv.ParseUint(autoIncrement.String, 10, 64)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, AutoIncrementSample{
+			Table:         table.String,
+			Column:        column.String,
+			ColumnType:    columnType.String,
+			AutoIncrement: value,
+			MaxValue:      MaxValueOfColumnType(columnType.String),
+			SampledAt:     now,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return samples, tx.Commit()
+}
+
+// 记录各表上一次的采样，用于估算增长速度
+type AutoIncrementMonitor struct {
+	mutex   sync.Mutex
+	samples map[string]AutoIncrementSample
+}
+
+func NewAutoIncrementMonitor() *AutoIncrementMonitor {
+	return &AutoIncrementMonitor{samples: make(map[string]AutoIncrementSample)}
+}
+
+// 使用率达到的最高阈值，未达到任何阈值时返回false
+func crossedAutoIncrementThreshold(usage float64, thresholds []float64) (float64, bool, bool) {
+	sorted := append([]float64{}, thresholds...)
+	sort.Float64s(sorted)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if usage >= sorted[i] {
+			return sorted[i], i == len(sorted)-1, true
+		}
+	}
+	return 0, false, false
+}
+
+// 检测本次的采样，返回达到阈值的告警，key为 表.列
+func (aim *AutoIncrementMonitor) Check(samples []AutoIncrementSample, thresholds []float64) map[string]error {
+	aim.mutex.Lock()
+	defer aim.mutex.Unlock()
+
+	errs := make(map[string]error)
+	for i := 0; i < len(samples); i++ {
+		cur := samples[i]
+		key := cur.Table + "." + cur.Column
+		prev, hasPrev := aim.samples[key]
+		aim.samples[key] = cur
+
+		if cur.MaxValue == 0 {
+			continue
+		}
+		usage := cur.Usage()
+		threshold, highest, ok := crossedAutoIncrementThreshold(usage, thresholds)
+		if !ok {
+			continue
+		}
+
+		projection := "no growth observed since last sample"
+		if hasPrev {
+			if days, ok := ProjectDaysToExhaustion(prev, cur); ok {
+				projection = fmt.Sprintf("projected to be exhausted in %.1f days", days)
+			}
+		}
+		code := WarnPolicyCodeAutoIncrement
+		if highest {
+			code = ErrPolicyCodeAutoIncrement
+		}
+		errs[key] = NewPolicyError(code,
+			fmt.Sprintf("AUTO_INCREMENT of table %v column %v(%v) is %v, %.2f%% of max %v >= threshold %.2f%%, %v",
+				cur.Table, cur.Column, cur.ColumnType, cur.AutoIncrement, usage*100, cur.MaxValue, threshold*100, projection))
+	}
+	return errs
+}
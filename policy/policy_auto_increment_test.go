@@ -0,0 +1,118 @@
+package policy
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAutoIncrementMaxValueOfColumnType(t *testing.T) {
+	cases := map[string]uint64{
+		"tinyint(4)":            127,
+		"tinyint(3) unsigned":   255,
+		"smallint(6)":           32767,
+		"mediumint(8) unsigned": 16777215,
+		"int(11)":               2147483647,
+		"int unsigned":          4294967295,
+		"bigint(20)":            math.MaxInt64,
+		"bigint(20) unsigned":   math.MaxUint64,
+		"double":                0,
+		"varchar(10)":           0,
+	}
+	for columnType, max := range cases {
+		if got := MaxValueOfColumnType(columnType); got != max {
+			t.Fatalf("MaxValueOfColumnType(%v) should be %v, got %v", columnType, max, got)
+		}
+	}
+}
+
+func TestAutoIncrementUsageAndProjection(t *testing.T) {
+	now := time.Now()
+	prev := AutoIncrementSample{Table: "t", Column: "id", ColumnType: "tinyint(3) unsigned", AutoIncrement: 101, MaxValue: 255, SampledAt: now.Add(-24 * time.Hour)}
+	cur := AutoIncrementSample{Table: "t", Column: "id", ColumnType: "tinyint(3) unsigned", AutoIncrement: 201, MaxValue: 255, SampledAt: now}
+
+	if usage := cur.Usage(); math.Abs(usage-200.0/255) > 1e-9 {
+		t.Fatalf("unexpected usage %v", usage)
+	}
+
+	days, ok := ProjectDaysToExhaustion(prev, cur)
+	if !ok || math.Abs(days-0.55) > 1e-9 {
+		t.Fatalf("unexpected projection %v %v", days, ok)
+	}
+
+	// 没有增长，或AUTO_INCREMENT被重置（TRUNCATE）
+	if _, ok := ProjectDaysToExhaustion(cur, cur); ok {
+		t.Fatalf("no growth should not be projected")
+	}
+	if _, ok := ProjectDaysToExhaustion(cur, AutoIncrementSample{AutoIncrement: 1, MaxValue: 255, SampledAt: now.Add(time.Hour)}); ok {
+		t.Fatalf("reset AUTO_INCREMENT should not be projected")
+	}
+}
+
+func TestAutoIncrementMonitorCheck(t *testing.T) {
+	monitor := NewAutoIncrementMonitor()
+	now := time.Now()
+	sample := func(table string, autoIncrement uint64, at time.Time) AutoIncrementSample {
+		return AutoIncrementSample{Table: table, Column: "id", ColumnType: "tinyint(4)", AutoIncrement: autoIncrement, MaxValue: 127, SampledAt: at}
+	}
+
+	errs := monitor.Check([]AutoIncrementSample{sample("low", 10, now), sample("warn", 100, now), sample("err", 127, now)}, DefaultAutoIncrementThresholds)
+	if len(errs) != 2 {
+		t.Fatalf("unexpected alerts %v", errs)
+	}
+	if pe := errs["warn.id"].(*PolicyError); pe.Code != WarnPolicyCodeAutoIncrement || !strings.Contains(pe.Msg, "no growth observed") {
+		t.Fatalf("unexpected alert %v", pe)
+	}
+	if pe := errs["err.id"].(*PolicyError); pe.Code != ErrPolicyCodeAutoIncrement {
+		t.Fatalf("unexpected alert %v", pe)
+	}
+
+	errs = monitor.Check([]AutoIncrementSample{sample("warn", 110, now.Add(24*time.Hour))}, DefaultAutoIncrementThresholds)
+	if pe := errs["warn.id"].(*PolicyError); pe.Code != WarnPolicyCodeAutoIncrement || !strings.Contains(pe.Msg, "exhausted in 1.8 days") {
+		t.Fatalf("unexpected alert %v", pe)
+	}
+
+	// 阈值无序
+	errs = monitor.Check([]AutoIncrementSample{sample("warn", 110, now)}, []float64{0.8, 0.5})
+	if pe := errs["warn.id"].(*PolicyError); pe.Code != ErrPolicyCodeAutoIncrement {
+		t.Fatalf("unexpected alert %v", pe)
+	}
+
+	if errs := monitor.Check([]AutoIncrementSample{sample("warn", 110, now)}, nil); len(errs) != 0 {
+		t.Fatalf("no thresholds should not alert, got %v", errs)
+	}
+}
+
+func TestRawPolicyAutoIncrementSamples(t *testing.T) {
+	runRawPolicyTests(t, dsn, func(dbt *DBTest) {
+
+		dbt.mustExec("CREATE TABLE `test_policy` (`id` tinyint(4) NOT NULL AUTO_INCREMENT, `value` int(11) DEFAULT NULL, " +
+			"PRIMARY KEY (`id`)) ENGINE=InnoDB AUTO_INCREMENT=100 DEFAULT CHARSET=utf8;")
+
+		samples, err := QueryAutoIncrementSamples(dbt.db, MaxTimeoutOfExplain)
+		if err != nil {
+			dbt.Fatalf("QueryAutoIncrementSamples failed %v", err)
+		}
+		found := false
+		for _, sample := range samples {
+			if sample.Table != "test_policy" {
+				continue
+			}
+			found = true
+			if sample.Column != "id" || sample.AutoIncrement != 100 || sample.MaxValue != 127 {
+				dbt.Errorf("unexpected sample %+v", sample)
+			}
+		}
+		if !found {
+			dbt.Errorf("sample of test_policy not found in %+v", samples)
+		}
+
+		errs := NewAutoIncrementMonitor().Check(samples, DefaultAutoIncrementThresholds)
+		if pe, ok := errs["test_policy.id"].(*PolicyError); !ok || pe.Code != WarnPolicyCodeAutoIncrement {
+			dbt.Errorf("test_policy.id should be alerted, got %v", errs)
+		}
+
+		dbt.mustExec("DROP TABLE IF EXISTS test_policy")
+	})
+}
@@ -106,6 +106,38 @@ const (
 	ErrPolicyCodeAllTableScan  PolicyCode = 5204
 	ErrPolicyCodeDataTruncate  PolicyCode = 5205
 	WarnPolicyCodeDataTruncate PolicyCode = 5206
+
+	ErrPolicyCodeDependentSubquery PolicyCode = 5207
+	ErrPolicyCodeJoinFanOut        PolicyCode = 5208
+	WarnPolicyCodeIndexSelectivity PolicyCode = 5209
+	ErrPolicyCodeLockRisk          PolicyCode = 5210
+
+	ErrPolicyCodeTxDuration    PolicyCode = 5211
+	ErrPolicyCodeTxStatements  PolicyCode = 5212
+	ErrPolicyCodeTxIdle        PolicyCode = 5213
+	ErrPolicyCodeTxNotFinished PolicyCode = 5214
+
+	ErrPolicyCodeRepeatedQuery PolicyCode = 5215
+
+	ErrPolicyCodeOnlineDDL  PolicyCode = 5216
+	WarnPolicyCodeOnlineDDL PolicyCode = 5217
+
+	ErrPolicyCodeDataTruncateStrict PolicyCode = 5218
+
+	WarnPolicyCodeAutoIncrement PolicyCode = 5219
+	ErrPolicyCodeAutoIncrement  PolicyCode = 5220
+
+	ErrPolicyCodeSQLInjection     PolicyCode = 5221
+	WarnPolicyCodeUnparameterized PolicyCode = 5222
+
+	ErrPolicyCodeResultSetSize PolicyCode = 5223
+	WarnPolicyCodeRowsEstimate PolicyCode = 5224
+
+	WarnPolicyCodeLatencyAnomaly PolicyCode = 5225
+
+	WarnPolicyCodeDigestInefficient PolicyCode = 5226
+
+	WarnPolicyCodeUnclassified PolicyCode = 5227 // 非PolicyError的检查错误，eg. 批量语句中某条语句的检查失败
 )
 
 func (pl PolicyCode) String() string {
@@ -124,6 +156,48 @@ func (pl PolicyCode) String() string {
 		return "ErrPolicyCodeDataTruncate"
 	case WarnPolicyCodeDataTruncate:
 		return "WarnPolicyCodeDataTruncate"
+	case ErrPolicyCodeDependentSubquery:
+		return "ErrPolicyCodeDependentSubquery"
+	case ErrPolicyCodeJoinFanOut:
+		return "ErrPolicyCodeJoinFanOut"
+	case WarnPolicyCodeIndexSelectivity:
+		return "WarnPolicyCodeIndexSelectivity"
+	case ErrPolicyCodeLockRisk:
+		return "ErrPolicyCodeLockRisk"
+	case ErrPolicyCodeTxDuration:
+		return "ErrPolicyCodeTxDuration"
+	case ErrPolicyCodeTxStatements:
+		return "ErrPolicyCodeTxStatements"
+	case ErrPolicyCodeTxIdle:
+		return "ErrPolicyCodeTxIdle"
+	case ErrPolicyCodeTxNotFinished:
+		return "ErrPolicyCodeTxNotFinished"
+	case ErrPolicyCodeRepeatedQuery:
+		return "ErrPolicyCodeRepeatedQuery"
+	case ErrPolicyCodeOnlineDDL:
+		return "ErrPolicyCodeOnlineDDL"
+	case WarnPolicyCodeOnlineDDL:
+		return "WarnPolicyCodeOnlineDDL"
+	case ErrPolicyCodeDataTruncateStrict:
+		return "ErrPolicyCodeDataTruncateStrict"
+	case WarnPolicyCodeAutoIncrement:
+		return "WarnPolicyCodeAutoIncrement"
+	case ErrPolicyCodeAutoIncrement:
+		return "ErrPolicyCodeAutoIncrement"
+	case ErrPolicyCodeSQLInjection:
+		return "ErrPolicyCodeSQLInjection"
+	case WarnPolicyCodeUnparameterized:
+		return "WarnPolicyCodeUnparameterized"
+	case ErrPolicyCodeResultSetSize:
+		return "ErrPolicyCodeResultSetSize"
+	case WarnPolicyCodeRowsEstimate:
+		return "WarnPolicyCodeRowsEstimate"
+	case WarnPolicyCodeLatencyAnomaly:
+		return "WarnPolicyCodeLatencyAnomaly"
+	case WarnPolicyCodeDigestInefficient:
+		return "WarnPolicyCodeDigestInefficient"
+	case WarnPolicyCodeUnclassified:
+		return "WarnPolicyCodeUnclassified"
 	default:
 		str := strconv.Itoa(int(pl))
 		return str
@@ -133,6 +207,9 @@ func (pl PolicyCode) String() string {
 type PolicyError struct {
 	Code PolicyCode `json:"code"`
 	Msg  string     `json:"msg"`
+
+	severity    log.Level // 通过WithSeverity指定的通知级别
+	hasSeverity bool
 }
 
 func NewPolicyErrorSafe(rowsAffected int, cost time.Duration) *PolicyError {
@@ -144,6 +221,26 @@ func NewPolicyError(code PolicyCode, msg string) *PolicyError {
 	return &PolicyError{Code: code, Msg: msg}
 }
 
+// 返回指定了通知级别的副本
+func (err *PolicyError) WithSeverity(lvl log.Level) *PolicyError {
+	perr := *err
+	perr.severity = lvl
+	perr.hasSeverity = true
+	return &perr
+}
+
+// 返回替换了Msg的副本，保留指定的通知级别
+func (err *PolicyError) WithMsg(msg string) *PolicyError {
+	perr := *err
+	perr.Msg = msg
+	return &perr
+}
+
+// 通过WithSeverity指定的通知级别，false表示按Code决定
+func (err *PolicyError) Severity() (log.Level, bool) {
+	return err.severity, err.hasSeverity
+}
+
 func (err *PolicyError) Error() string {
 	return fmt.Sprintf("[policy_code=%v,policy_msg=%v]", err.Code, err.Msg)
 }
@@ -152,6 +249,28 @@ type PolicyChecker interface {
 	Check(db *sql.DB, er []ExplainRecord, query string, args []interface{}) error
 }
 
+// DDL语句无法explain，需要分析DDL的策略额外实现该接口
+type DDLPolicyChecker interface {
+	CheckDDL(db *sql.DB, query string) error
+}
+
+// multiStatements的SQL被拆分后逐条检查，需要整体分析的策略额外实现该接口
+type MultiStatementsPolicyChecker interface {
+	CheckMultiStatements(db *sql.DB, query string, statements []string) error
+}
+
+// 结果集的大小在Rows.Close时才能确定，需要分析结果集的策略额外实现该接口（目前只有Driver方式上报）
+type ResultSetPolicyChecker interface {
+	CheckResultSet(db *sql.DB, er []ExplainRecord, query string, args []interface{}, stats ResultSetStats) error
+}
+
+// 查询读取到应用内存的结果集
+type ResultSetStats struct {
+	Rows     int64         // 读取的行数
+	Bytes    int64         // 读取的行数据包的字节数
+	Duration time.Duration // 从执行到Rows.Close的时长
+}
+
 type ExplainRecord struct {
 	ID           sql.NullString
 	SelectType   sql.NullString
@@ -185,6 +304,19 @@ func (er *ExplainRecord) GetExplainRealRows() (int, error) {
 	}
 }
 
+// 分别获取 rows 和 filtered(百分比), filtered 为NULL时视为100
+func (er *ExplainRecord) GetExplainRowsAndFiltered() (int, float64, error) {
+	if !er.Rows.Valid {
+		return 0, 0, ErrExplainRowsFormatErr
+	}
+	rowCnt, _ := strconv.Atoi(er.Rows.String)
+	var rate float64 = 100.0
+	if er.Filtered.Valid {
+		rate, _ = strconv.ParseFloat(er.Filtered.String, 64)
+	}
+	return rowCnt, rate, nil
+}
+
 func NewExplainRecord() *ExplainRecord {
 	return &ExplainRecord{}
 }
@@ -236,11 +368,11 @@ func MakeExplainRecords(db *sql.DB, query string, timeout time.Duration, args []
 
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		log.MSKLog().Errorf("MakeExplainRecords(%v, %v) BeginTx failed %v", query, args, err)
+		log.MSKLog().Errorf("MakeExplainRecords(%v, %v args) BeginTx failed %v", misc.FingerprintSQL(query), len(args), err)
 		return explainRecords, err
 	}
 	defer func() {
-		_ = safeRollback(fmt.Sprintf("MakeExplainRecords() query of %v rollback", query), tx)
+		_ = safeRollback(fmt.Sprintf("MakeExplainRecords() query of %v rollback", misc.FingerprintSQL(query)), tx)
 	}()
 
 	if !delayVersion {
@@ -249,7 +381,7 @@ func MakeExplainRecords(db *sql.DB, query string, timeout time.Duration, args []
 		row := tx.QueryRowContext(ctx, versionQuery)
 		var version string
 		if err := row.Scan(&version); err != nil {
-			log.MSKLog().Errorf("MakeExplainRecords(%v, %v) QueryRowContext failed %v", query, args, err)
+			log.MSKLog().Errorf("MakeExplainRecords(%v, %v args) QueryRowContext failed %v", misc.FingerprintSQL(query), len(args), err)
 			return explainRecords, err
 		}
 
@@ -279,20 +411,20 @@ func MakeExplainRecords(db *sql.DB, query string, timeout time.Duration, args []
 
 	rows, err := tx.QueryContext(ctx, query, args...)
 	if err != nil {
-		log.MSKLog().Errorf("MakeExplainRecords(%v, %v) QueryContext failed %v", query, args, err)
+		log.MSKLog().Errorf("MakeExplainRecords(%v, %v args) QueryContext failed %v", misc.FingerprintSQL(query), len(args), err)
 		return explainRecords, err
 	}
 	defer rows.Close()
 
 	explainRecords, err = genExplainRecordsFromRows(rows)
 	if err != nil {
-		log.MSKLog().Errorf("MakeExplainRecords(%v, %v) GenExplainRecordsFromRows failed %v", query, args, err)
+		log.MSKLog().Errorf("MakeExplainRecords(%v, %v args) GenExplainRecordsFromRows failed %v", misc.FingerprintSQL(query), len(args), err)
 		return explainRecords, err
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.MSKLog().Errorf("MakeExplainRecords(%v, %v) Commit failed %v", query, args, err)
+		log.MSKLog().Errorf("MakeExplainRecords(%v, %v args) Commit failed %v", misc.FingerprintSQL(query), len(args), err)
 		return explainRecords, err
 	}
 	//log.Printf("MakeExplainRecords %v on origin query %v", explainRecords, originQuery)
@@ -340,6 +472,35 @@ func MaxRowsFromExplainRecords(explainRecords []ExplainRecord) int {
 	return maxRows
 }
 
+// 按explain的id分组，组内保持explain的输出顺序，即nested-loop的join顺序
+// 返回的ids按首次出现的顺序排列
+func groupExplainRecordsByID(explainRecords []ExplainRecord) ([]string, map[string][]ExplainRecord) {
+	ids := []string{}
+	groups := map[string][]ExplainRecord{}
+	for i := 0; i < len(explainRecords); i++ {
+		id := explainRecords[i].ID.String
+		if _, ok := groups[id]; !ok {
+			ids = append(ids, id)
+		}
+		groups[id] = append(groups[id], explainRecords[i])
+	}
+	return ids, groups
+}
+
+// 同一id下的join输出行数, 即 ∏(rows × filtered)
+// rows为NULL的记录（例如INSERT行）不参与计算
+func fanOutOfExplainRecords(explainRecords []ExplainRecord) float64 {
+	fanOut := 1.0
+	for i := 0; i < len(explainRecords); i++ {
+		rowCnt, rate, err := explainRecords[i].GetExplainRowsAndFiltered()
+		if err != nil {
+			continue
+		}
+		fanOut *= float64(rowCnt) * (rate / float64(100.0))
+	}
+	return fanOut
+}
+
 func isTableName(tn string) bool {
 
 	if len(strings.TrimSpace(tn)) <= 0 {
@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"database/sql"
+	"fmt"
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"strings"
+)
+
+/*
+
+相关子查询及派生表物化检测策略
+
+1. DEPENDENT SUBQUERY / DEPENDENT UNION / UNCACHEABLE SUBQUERY / UNCACHEABLE UNION
+   外层查询每输出一行，子查询都要重新执行一次，即使子查询本身的rows很小，总代价也是
+   外层行数 × 子查询行数
+   eg. explain select * from test t1 where t1.value = (select id from test t2 where t2.value = t1.value limit 1)
+
+2. DERIVED / MATERIALIZED
+   子查询的结果被物化为临时表，物化的行数即临时表的行数
+   eg. explain select * from (select * from test) b;
+
+*/
+
+const (
+	DefaultMaxDependentSubqueryCost = 10000 // 外层行数 × 子查询行数 超过1w则告警
+
+	SelectTypeDependentSubquery   = "DEPENDENT SUBQUERY"
+	SelectTypeDependentUnion      = "DEPENDENT UNION"
+	SelectTypeUncacheableSubquery = "UNCACHEABLE SUBQUERY"
+	SelectTypeUncacheableUnion    = "UNCACHEABLE UNION"
+	SelectTypeDerived             = "DERIVED"
+	SelectTypeMaterialized        = "MATERIALIZED"
+	SelectTypeSimple              = "SIMPLE"
+	SelectTypePrimary             = "PRIMARY"
+)
+
+type PolicyCheckerDependentSubquery struct {
+	maxCost int
+}
+
+func NewPolicyCheckerDependentSubquery(maxCost int) *PolicyCheckerDependentSubquery {
+
+	return &PolicyCheckerDependentSubquery{maxCost: maxCost}
+}
+
+func isDependentSelectType(selectType string) bool {
+	switch strings.ToUpper(selectType) {
+	case SelectTypeDependentSubquery, SelectTypeDependentUnion,
+		SelectTypeUncacheableSubquery, SelectTypeUncacheableUnion:
+		return true
+	}
+	return false
+}
+
+// 可以包含相关子查询的外层查询，非相关的SUBQUERY、物化的MATERIALIZED不是其外层
+func isOuterSelectType(selectType string) bool {
+	switch strings.ToUpper(selectType) {
+	case SelectTypeSimple, SelectTypePrimary, SelectTypeDerived:
+		return true
+	}
+	return false
+}
+
+func isMaterializedSelectType(selectType string) bool {
+	switch strings.ToUpper(selectType) {
+	case SelectTypeDerived, SelectTypeMaterialized:
+		return true
+	}
+	return false
+}
+
+// 子查询每执行一次扫描的行数, 即 ∏rows
+func examinedRowsOfExplainRecords(explainRecords []ExplainRecord) float64 {
+	examined := 1.0
+	for i := 0; i < len(explainRecords); i++ {
+		rowCnt, _, err := explainRecords[i].GetExplainRowsAndFiltered()
+		if err != nil {
+			continue
+		}
+		examined *= float64(rowCnt)
+	}
+	return examined
+}
+
+func (pcds *PolicyCheckerDependentSubquery) Check(db *sql.DB, explainRecords []ExplainRecord, query string, args []interface{}) error {
+
+	log.MSKLog().Infof("PolicyCheckerDependentSubquery:Check(%v, %v, %v args) with %v", explainRecords, query, len(args), pcds)
+
+	ids, groups := groupExplainRecordsByID(explainRecords)
+	// 最近的一个PRIMARY/SIMPLE/DERIVED的id组，视为相关子查询的外层查询
+	outerID := ""
+	for _, id := range ids {
+		group := groups[id]
+		selectType := group[0].SelectType.String
+
+		if isDependentSelectType(selectType) {
+			if outerID == "" {
+				continue
+			}
+			outerRows := fanOutOfExplainRecords(groups[outerID])
+			innerRows := examinedRowsOfExplainRecords(group)
+			cost := outerRows * innerRows
+			log.MSKLog().Debugf("PolicyCheckerDependentSubquery:Check id %v select_type %v outerRows %v innerRows %v cost %v",
+				id, selectType, outerRows, innerRows, cost)
+			if cost > float64(pcds.maxCost) {
+				return NewPolicyError(ErrPolicyCodeDependentSubquery, fmt.Sprintf("%v on table %v executed once per outer row: cost outer rows %0.f × inner rows %0.f = %0.f > maxCost %v",
+					selectType, group[0].Table.String, outerRows, innerRows, cost, pcds.maxCost))
+			}
+			continue
+		}
+
+		if isMaterializedSelectType(selectType) {
+			materialized := fanOutOfExplainRecords(group)
+			if materialized > float64(pcds.maxCost) {
+				return NewPolicyError(ErrPolicyCodeDependentSubquery, fmt.Sprintf("%v on table %v materialized into temporary table with rows %0.f > maxCost %v",
+					selectType, group[0].Table.String, materialized, pcds.maxCost))
+			}
+		}
+		if isOuterSelectType(selectType) {
+			outerID = id
+		}
+	}
+	return nil
+}
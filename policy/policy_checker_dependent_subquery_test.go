@@ -0,0 +1,115 @@
+package policy
+
+import (
+	logmsk "gitlab.papegames.com/fringe/mskeeper/log"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPolicyDependentSubqueryCost(t *testing.T) {
+	pcds := NewPolicyCheckerDependentSubquery(DefaultMaxDependentSubqueryCost)
+
+	// 外层1000行, 每行执行一次只扫描20行的相关子查询, 总代价 2w
+	explainRecords := []ExplainRecord{
+		newExplainRecordForTest("1", "PRIMARY", "t1", "ALL", "", "1000", "100.00", "Using where"),
+		newExplainRecordForTest("2", "DEPENDENT SUBQUERY", "t2", "ref", "value", "20", "100.00", ""),
+	}
+	err := pcds.Check(nil, explainRecords, "select * from test t1 where t1.value = (select id from test t2 where t2.value = t1.value limit 1)", nil)
+	pe, ok := err.(*PolicyError)
+	if !ok || pe.Code != ErrPolicyCodeDependentSubquery {
+		t.Fatalf("dependent subquery not covered %v", err)
+	}
+
+	// filtered 缩小了外层的行数
+	explainRecords[0] = newExplainRecordForTest("1", "PRIMARY", "t1", "ALL", "", "1000", "10.00", "Using where")
+	err = pcds.Check(nil, explainRecords, "", nil)
+	if err != nil {
+		t.Fatalf("dependent subquery cost 2000 should be safe %v", err)
+	}
+
+	// UNCACHEABLE SUBQUERY
+	explainRecords = []ExplainRecord{
+		newExplainRecordForTest("1", "PRIMARY", "t1", "ALL", "", "500", "100.00", "Using where"),
+		newExplainRecordForTest("2", "UNCACHEABLE SUBQUERY", "t2", "ALL", "", "500", "100.00", ""),
+	}
+	err = pcds.Check(nil, explainRecords, "", nil)
+	pe, ok = err.(*PolicyError)
+	if !ok || pe.Code != ErrPolicyCodeDependentSubquery {
+		t.Fatalf("uncacheable subquery not covered %v", err)
+	}
+
+	// 不相关子查询只执行一次
+	explainRecords = []ExplainRecord{
+		newExplainRecordForTest("1", "PRIMARY", "t1", "ALL", "", "5000", "100.00", "Using where"),
+		newExplainRecordForTest("2", "SUBQUERY", "t2", "ALL", "", "5000", "100.00", ""),
+	}
+	err = pcds.Check(nil, explainRecords, "", nil)
+	if err != nil {
+		t.Fatalf("subquery should be safe %v", err)
+	}
+
+	// 物化的派生表
+	explainRecords = []ExplainRecord{
+		newExplainRecordForTest("1", "PRIMARY", "<derived2>", "ALL", "", "20000", "100.00", ""),
+		newExplainRecordForTest("2", "DERIVED", "t2", "ALL", "", "20000", "100.00", ""),
+	}
+	err = pcds.Check(nil, explainRecords, "", nil)
+	pe, ok = err.(*PolicyError)
+	if !ok || pe.Code != ErrPolicyCodeDependentSubquery {
+		t.Fatalf("derived table not covered %v", err)
+	}
+
+	// 相关子查询排在非相关子查询之后，外层仍是PRIMARY：1000000 × 20 > 10000
+	explainRecords = []ExplainRecord{
+		newExplainRecordForTest("1", "PRIMARY", "t1", "ALL", "", "1000000", "100.00", "Using where"),
+		newExplainRecordForTest("2", "SUBQUERY", "t3", "ALL", "", "10", "100.00", ""),
+		newExplainRecordForTest("3", "DEPENDENT SUBQUERY", "t2", "ref", "value", "20", "100.00", ""),
+	}
+	err = pcds.Check(nil, explainRecords, "", nil)
+	pe, ok = err.(*PolicyError)
+	if !ok || pe.Code != ErrPolicyCodeDependentSubquery || !strings.Contains(pe.Msg, "outer rows 1000000") {
+		t.Fatalf("dependent subquery after an uncorrelated subquery not covered %v", err)
+	}
+
+	// 没有外层查询的相关子查询(非法的explain), 直接跳过
+	explainRecords = []ExplainRecord{
+		newExplainRecordForTest("2", "DEPENDENT SUBQUERY", "t2", "ALL", "", "20000", "100.00", ""),
+	}
+	err = pcds.Check(nil, explainRecords, "", nil)
+	if err != nil {
+		t.Fatalf("dependent subquery without outer should be skipped %v", err)
+	}
+
+	if ErrPolicyCodeDependentSubquery.String() != "ErrPolicyCodeDependentSubquery" {
+		t.Fatalf("PolicyCode ErrPolicyCodeDependentSubquery falling down %v", ErrPolicyCodeDependentSubquery)
+	}
+}
+
+func TestRawPolicyDependentSubquery(t *testing.T) {
+	runRawPolicyTests(t, dsn+"&columnsWithAlias=true", func(dbt *DBTest) {
+		logmsk.MSKLog().SetOutput(os.Stdout)
+
+		dbt.mustExec("CREATE TABLE `test_policy` (`id` int(11) NOT NULL AUTO_INCREMENT,`value` int(11) DEFAULT NULL,PRIMARY KEY (`id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+		for i := 0; i < 200; i++ {
+			dbt.mustExec("INSERT INTO test_policy(value) VALUES (?)", i)
+		}
+
+		npc := NewPolicyCheckWraper(NewPolicyCheckerDependentSubquery(1000), dbt.db)
+		err := npc.Check("select * from test_policy t1 where t1.value = (select id from test_policy t2 where t2.value = t1.value limit 1)")
+		log.Printf("err ==== %v", err)
+		pe, _ := err.(*PolicyError)
+		if pe == nil || pe.Code != ErrPolicyCodeDependentSubquery {
+			dbt.Errorf("dependent subquery not covered")
+		}
+
+		err = npc.Check("select * from test_policy where id = 1")
+		if err != nil {
+			dbt.Errorf("simple sql should be safe %v", err)
+		}
+
+		logmsk.MSKLog().SetOutput(ioutil.Discard)
+	})
+}
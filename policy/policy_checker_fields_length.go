@@ -14,17 +14,20 @@ import (
 	// "reflect"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 /*
 
 操作数长度检测策略，对应varchar，text，int等的长度检测。
+DECIMAL、ENUM、SET、FLOAT/DOUBLE、BIT以及时间类型的检测见policy_checker_fields_length_types.go
 
 背景：
 通过 SELECT @@GLOBAL.sql_mode;命令，可以查询MySQL长度硬检测的开关是否打开。
 如果包含"STRICT_TRANS_TABLES", 则数据过长是会即时返回错误。
 本地的话，5.5没开，5.6、5.7以及8.0的都开了
 可通过 set @@global.sql_mode=""; 来关闭严格检查。
+因此告警的级别取决于连接的@@SESSION.sql_mode，见policy_checker_fields_length_sqlmode.go
 
 
 调查了阿里云和腾讯云，
@@ -40,6 +43,7 @@ var (
 	ErrFieldDataTruncated     = fmt.Errorf("Data truncated")
 	ErrExprToSQLValueFail     = fmt.Errorf("Expr to sqlval failure")
 	WarnFieldDataMayTruncated = fmt.Errorf("Data might be truncated in future")
+	ErrFieldIncorrectString   = fmt.Errorf("Incorrect string value, 4-byte character into utf8(utf8mb3) column")
 	DataTruncationUplimit     = 0.8
 )
 
@@ -47,6 +51,8 @@ const (
 	NoTruncated   int = 0
 	TruncatedWarn int = 1
 	Truncated     int = 2
+	// 4字节的字符写入utf8(utf8mb3)列
+	TruncatedIncorrectString int = 3
 )
 
 type ColumnMap map[string]*ColumnRecord
@@ -60,11 +66,13 @@ type ColumnMap map[string]*ColumnRecord
 // }
 
 type ColumnRecord struct {
-	Field sql.NullString
-	Type  sql.NullString
-	// Key
-	// Default
-	// Extra
+	Field     sql.NullString
+	Type      sql.NullString
+	Collation sql.NullString
+	Null      sql.NullString
+	Key       sql.NullString
+	Default   sql.NullString
+	Extra     sql.NullString
 }
 
 func NewColumnRecord() *ColumnRecord {
@@ -87,7 +95,7 @@ func MakeColumnRecords(db *sql.DB, table string, timeout time.Duration) (map[str
 	//	originQuery := query
 	columnsMap := ColumnMap{}
 	var columnRecords []ColumnRecord
-	query := "show columns from " + table
+	query := "show full columns from " + table
 
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
@@ -162,7 +170,7 @@ func calExprValue(db *sql.DB, timeout time.Duration, expr string, args ...interf
 		return sqlval, ErrExprToSQLValueFail
 	}
 	sqlval = sv
-	log.MSKLog().Infof("calExprValue(%v, %v, %v, %v) got sqlval %v", db, timeout, expr, args, sqlval)
+	log.MSKLog().Infof("calExprValue(%v, %v, %v, %v args) got sqlval %v", db, timeout, expr, len(args), sqlval)
 	return sqlval, nil
 }
 
@@ -171,11 +179,16 @@ func genColumnRecordsFromRows(rows *sql.Rows) ([]ColumnRecord, error) {
 
 	for rows.Next() {
 		record := NewColumnRecord()
-		var noCare1, noCare2, noCare3, noCare4 sql.NullString
+		var noCare1, noCare2 sql.NullString
 		if err := rows.Scan(
 			&record.Field,
 			&record.Type,
-			&noCare1, &noCare2, &noCare3, &noCare4); err != nil {
+			&record.Collation,
+			&record.Null,
+			&record.Key,
+			&record.Default,
+			&record.Extra,
+			&noCare1, &noCare2); err != nil {
 			log.MSKLog().Warnf("genColumnRecordsFromRows(%v) failed %v", rows, err)
 			return records, err
 		}
@@ -191,6 +204,9 @@ func genColumnRecordsFromRows(rows *sql.Rows) ([]ColumnRecord, error) {
 
 type PolicyCheckerFieldsLength struct {
 	uplimit float64
+
+	mutex    sync.Mutex
+	sqlModes map[*sql.DB]sqlModeEntry
 }
 
 func NewPolicyCheckerFieldsLength(uplimits ...interface{}) *PolicyCheckerFieldsLength {
@@ -204,7 +220,7 @@ func NewPolicyCheckerFieldsLength(uplimits ...interface{}) *PolicyCheckerFieldsL
 			break
 		}
 	}
-	return &PolicyCheckerFieldsLength{uplimit: uplimit}
+	return &PolicyCheckerFieldsLength{uplimit: uplimit, sqlModes: make(map[*sql.DB]sqlModeEntry)}
 }
 
 //    REF: https://dev.mysql.com/doc/refman/5.6/en/integer-types.html
@@ -235,37 +251,6 @@ func calNumberOfBitsByFieldTypeInt(fieldType string) int {
 	}
 }
 
-// 对于Update语句支持的三类table：AliasedTable, (AliasedTable) 和 (AliasedTable join AliasedTable)
-// 只试图返回前两种， 暂时不支持join, eg. update test_policy join test on test_policy.value1 = test.value1 set test_policy.value = '123';
-func getAliasedTableFromUpdateStmt(tb sqlparser.TableExpr) *sqlparser.AliasedTableExpr {
-
-	aliaTable, ok := tb.(*sqlparser.AliasedTableExpr)
-	if ok {
-		return aliaTable
-	}
-
-	pTable, ok := tb.(*sqlparser.ParenTableExpr)
-	if !ok {
-		return nil
-	}
-
-	// TODO：暂时不支持 update (table1, table2)..., 只支持 update (table1), (table2)...
-	if len(pTable.Exprs) > 1 || len(pTable.Exprs) < 1 {
-		return nil
-	}
-	aliaTable, ok = pTable.Exprs[0].(*sqlparser.AliasedTableExpr)
-	if ok {
-		return aliaTable
-	}
-
-	pTable, ok = pTable.Exprs[0].(*sqlparser.ParenTableExpr)
-	if ok {
-		return getAliasedTableFromUpdateStmt(pTable)
-	}
-
-	return nil
-}
-
 //    REF:https://dev.mysql.com/doc/refman/5.6/en/string-types.html
 func calNumberOfBytesByFieldTypeValue(fieldType string, parenthesisLength int64) int64 {
 
@@ -294,32 +279,23 @@ func calNumberOfBytesByFieldTypeValue(fieldType string, parenthesisLength int64)
 
 // Check if value was truncated by the definition of cr
 // eg. value = 'abcdefg', cr.Type.String = varchar(5)  ==> data in db: 'abcde' was truncated
-func (pcri *PolicyCheckerFieldsLength) checkIfMySQLTruncate(cr *ColumnRecord, sqlV *sqlparser.SQLVal, value []byte) int {
+func (pcri *PolicyCheckerFieldsLength) checkIfMySQLTruncate(cr *ColumnRecord, sqlV *sqlparser.SQLVal, value []byte,
+	loc *time.Location) int {
 	if sqlV == nil {
 		log.MSKLog().Debugf("checkIfMySQLTruncate:Check(%v, %v, %v) nil of sqlV, possiblely FuncExpr",
 			cr, sqlV, value)
 		return NoTruncated
 	}
-	msFieldType := strings.Split(cr.Type.String, " ")
-	var unsigned bool = false
-	if len(msFieldType) > 1 && msFieldType[1] == "unsigned" {
-		unsigned = true
-	}
-	typeString := ""
-	if strings.Index(msFieldType[0], "(") > 0 {
-		typeString = msFieldType[0][0:strings.Index(msFieldType[0], "(")]
-	} else {
-		typeString = msFieldType[0]
-	}
+	typeString, typeParams, unsigned := parseColumnType(cr.Type.String)
 	typeLength := int64(0)
 
-	if strings.Contains(msFieldType[0], "(") && strings.Index(msFieldType[0], ")") > strings.Index(msFieldType[0], "(") {
-		typeLengthStr := msFieldType[0][strings.Index(msFieldType[0], "(")+1 : strings.Index(msFieldType[0], ")")]
+	// 只有整数、字符串等类型的参数为长度，DECIMAL(M,D)、ENUM/SET的参数由各自的检测解析
+	if typeParams != "" && !strings.ContainsAny(typeParams, ",'") {
 		var err error
-		typeLength, err = strconv.ParseInt(string(typeLengthStr), 0, 64)
+		typeLength, err = strconv.ParseInt(typeParams, 0, 64)
 		if err != nil {
-			log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, %v, %v) strconv.ParseInt of (%v) failed with err %v",
-				cr, sqlV, value, typeLengthStr, err)
+			log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, type %v, %v bytes) strconv.ParseInt of (%v) failed with err %v",
+				cr, sqlV.Type, len(value), typeParams, err)
 			return NoTruncated
 		}
 	}
@@ -327,7 +303,7 @@ func (pcri *PolicyCheckerFieldsLength) checkIfMySQLTruncate(cr *ColumnRecord, sq
 	var numOfBits int
 	switch typeString {
 	case msFieldTypeYear:
-		// TODO
+		return checkTemporalTruncate(typeString, string(value), loc)
 	case msFieldTypeTiny, msFieldTypeShort, msFieldTypeInt24,
 		msFieldTypeLong, msFieldTypeLongLong:
 
@@ -338,12 +314,12 @@ func (pcri *PolicyCheckerFieldsLength) checkIfMySQLTruncate(cr *ColumnRecord, sq
 			if err != nil {
 				err, _ := err.(*strconv.NumError)
 				if err.Err == strconv.ErrRange {
-					log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, %v, %v) strconv.ParseInt of %v failed with err %v",
-						cr, sqlV, value, string(value), err)
+					log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, type %v, %v bytes) strconv.ParseInt failed with err %v",
+						cr, sqlV.Type, len(value), err.Err)
 					return Truncated
 				} else {
-					log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, %v, %v) strconv.ParseInt of %v failed with err %v",
-						cr, sqlV, value, string(value), err)
+					log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, type %v, %v bytes) strconv.ParseInt failed with err %v",
+						cr, sqlV.Type, len(value), err.Err)
 					return NoTruncated
 				}
 			}
@@ -353,12 +329,12 @@ func (pcri *PolicyCheckerFieldsLength) checkIfMySQLTruncate(cr *ColumnRecord, sq
 			if err != nil {
 				err, _ := err.(*strconv.NumError)
 				if err.Err == strconv.ErrRange {
-					log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, %v, %v) strconv.ParseUint of %v failed with err %v",
-						cr, sqlV, value, string(value), err)
+					log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, type %v, %v bytes) strconv.ParseUint failed with err %v",
+						cr, sqlV.Type, len(value), err.Err)
 					return Truncated
 				} else {
-					log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, %v, %v) strconv.ParseUint of %v failed with err %v",
-						cr, sqlV, value, string(value), err)
+					log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, type %v, %v bytes) strconv.ParseUint failed with err %v",
+						cr, sqlV.Type, len(value), err.Err)
 					return NoTruncated
 				}
 			}
@@ -378,14 +354,17 @@ func (pcri *PolicyCheckerFieldsLength) checkIfMySQLTruncate(cr *ColumnRecord, sq
 				return Truncated
 			}
 		}
-	case msFieldTypeFloat:
-	case msFieldTypeDouble:
-		// TODO
+	case msFieldTypeFloat, msFieldTypeDouble:
+		return checkFloatTruncate(typeString, typeParams, unsigned, string(value), pcri.uplimit)
 	case msFieldTypeBit:
 		// https://www.twle.cn/c/yufei/mysqlfav/mysqlfav-basic-bit.html
-		// TODO
-	case msFieldTypeDecimal, msFieldTypeEnum, msFieldTypeSet:
-		// TODO
+		return checkBitTruncate(typeParams, sqlV.Type, value, pcri.uplimit)
+	case msFieldTypeDecimal:
+		return checkDecimalTruncate(typeParams, unsigned, string(value), pcri.uplimit)
+	case msFieldTypeEnum:
+		return checkEnumTruncate(typeParams, string(value))
+	case msFieldTypeSet:
+		return checkSetTruncate(typeParams, string(value))
 
 	case msFieldTypeTinyBLOB, msFieldTypeMediumBLOB, msFieldTypeLongBLOB, msFieldTypeBLOB,
 		msFieldTypeTinyTEXT, msFieldTypeMediumTEXT, msFieldTypeLongTEXT, msFieldTypeTEXT,
@@ -399,8 +378,8 @@ func (pcri *PolicyCheckerFieldsLength) checkIfMySQLTruncate(cr *ColumnRecord, sq
 			var realVal []byte
 			n, err := fmt.Sscanf(string(value), "%x", &realVal)
 			if err != nil || n != 1 {
-				log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, %v, %v) fmt.Sscanf failed %v",
-					cr, sqlV, value, err)
+				log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, type %v, %v bytes) fmt.Sscanf failed %v",
+					cr, sqlV.Type, len(value), err)
 				return NoTruncated
 			}
 			value = realVal
@@ -413,23 +392,44 @@ func (pcri *PolicyCheckerFieldsLength) checkIfMySQLTruncate(cr *ColumnRecord, sq
 		case sqlparser.ValArg:
 		default:
 		}
+
+		// CHAR/VARCHAR按字符数，TEXT按列字符集下的字节数，其他按字节数
+		charset := cr.Charset()
+		isString := sqlV.Type == sqlparser.StrVal || sqlV.Type == sqlparser.ValArg
+		if isString && isUTF8MB3Charset(charset) && hasSupplementaryRune(value) {
+			return TruncatedIncorrectString
+		}
+		valueLen := int64(len(value))
+		switch typeString {
+		case msFieldTypeString, msFieldTypeVarString:
+			if isString && charset != charsetBinary {
+				valueLen = charLengthOf(value)
+			}
+		case msFieldTypeTinyTEXT, msFieldTypeMediumTEXT, msFieldTypeLongTEXT, msFieldTypeTEXT:
+			if isString {
+				valueLen = byteLengthInCharset(charset, value)
+			}
+		}
+
 		dataTruncUplimit := pcri.uplimit
 		targetValLen := calNumberOfBytesByFieldTypeValue(typeString, typeLength)
-		log.MSKLog().Debugf("################### typeString %v, typeLength %v, targetValLen %v valueLen %v value %v",
-			typeString, typeLength, targetValLen, len(value), string(value))
-		if int64(len(value)) > targetValLen {
+		log.MSKLog().Debugf("################### typeString %v, typeLength %v, charset %v, targetValLen %v valueLen %v value %v",
+			typeString, typeLength, charset, targetValLen, valueLen, string(value))
+		if valueLen > targetValLen {
 			return Truncated
-		} else if int64(len(value)) > int64(float64(targetValLen)*dataTruncUplimit) {
+		} else if valueLen > int64(float64(targetValLen)*dataTruncUplimit) {
 			return TruncatedWarn
 		}
 
-	case msFieldTypeDate, msFieldTypeTimestamp, msFieldTypeDateTime, msFieldTypeTime:
-		// TODO
+	case msFieldTypeDate, msFieldTypeTimestamp, msFieldTypeDateTime:
+		return checkTemporalTruncate(typeString, string(value), loc)
+	case msFieldTypeTime:
+		return checkTimeTruncate(string(value), pcri.uplimit)
 	case msFieldTypeNULL:
 		// TODO
 	default:
-		log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, %v, %v) unknown typeString %v",
-			cr, sqlV, value, typeString)
+		log.MSKLog().Warnf("checkIfMySQLTruncate:Check(%v, type %v, %v bytes) unknown typeString %v",
+			cr, sqlV.Type, len(value), typeString)
 		return NoTruncated
 	}
 
@@ -437,7 +437,7 @@ func (pcri *PolicyCheckerFieldsLength) checkIfMySQLTruncate(cr *ColumnRecord, sq
 }
 
 func (pcri *PolicyCheckerFieldsLength) checkValueLengthBy(columnSlice []string, valueSlice []*sqlparser.SQLVal,
-	columnTypeValueMap ColumnMap, args interface{}, argsIdx *int) error {
+	columnTypeValueMap ColumnMap, args interface{}, argsIdx *int, loc *time.Location) error {
 
 	// argsIdx := 0
 	argCnt := 0
@@ -479,15 +479,22 @@ func (pcri *PolicyCheckerFieldsLength) checkValueLengthBy(columnSlice []string,
 		if sqlVal == nil {
 			// *sqlparser.FuncExpr, such as now()
 		} else if sqlVal.Type == sqlparser.ValArg {
-			value = []byte(fmt.Sprintf("%v", argsSlice[*argsIdx]))
-			*argsIdx++
+			// 优先按占位符的序号取参数，否则依次取
+			if idx, ok := posArgIndex(sqlVal.Val); ok && idx < len(argsSlice) {
+				value = formatArgValue(argsSlice[idx])
+			} else {
+				value = formatArgValue(argsSlice[*argsIdx])
+				*argsIdx++
+			}
 		} else {
 			value = sqlVal.Val
 		}
 
-		truncated := pcri.checkIfMySQLTruncate(columnRecord, sqlVal, value)
+		truncated := pcri.checkIfMySQLTruncate(columnRecord, sqlVal, value, loc)
 		if truncated == Truncated {
 			return ErrFieldDataTruncated
+		} else if truncated == TruncatedIncorrectString {
+			return ErrFieldIncorrectString
 		} else if truncated == TruncatedWarn {
 			return WarnFieldDataMayTruncated
 		}
@@ -499,161 +506,26 @@ func (pcri *PolicyCheckerFieldsLength) checkValueLengthBy(columnSlice []string,
 }
 
 func (pcri *PolicyCheckerFieldsLength) Check(db *sql.DB, explainRecords []ExplainRecord, query string, args []interface{}) error {
-	log.MSKLog().Infof("PolicyCheckerFieldsLength:Check(%v, %v, %v) with %v", explainRecords, query, args, pcri)
+	log.MSKLog().Infof("PolicyCheckerFieldsLength:Check(%v, %v, %v args) with %v", explainRecords, query, len(args), pcri)
 
 	stmt, err := sqlparser.Parse(query)
 	if err != nil {
-		log.MSKLog().Warnf("PolicyCheckerFieldsLength:Check(%v, %v, %v) sqlparser.Parse failed with err %v",
-			explainRecords, query, args, err)
+		log.MSKLog().Warnf("PolicyCheckerFieldsLength:Check(%v, %v, %v args) sqlparser.Parse failed with err %v",
+			explainRecords, misc.FingerprintSQL(query), len(args), err)
 		return nil
 	}
+	ignore := false
 	switch stmt := stmt.(type) {
 	case *sqlparser.Insert:
-		insertStruct := stmt
-		tableNameString := insertStruct.Table.Name.String()
-
-		columnTypeMap, columnNameSlices, err := MakeColumnRecords(db, tableNameString, MaxTimeoutOfExplain)
-		if err != nil {
-			log.MSKLog().Warnf("PolicyCheckerFieldsLength:Check(%v, %v, %v) MakeColumnRecords of %v failed",
-				explainRecords, query, args, tableNameString)
-			break
-		}
-		log.MSKLog().Debugf("insertStruct %v columnTypeMap %v", insertStruct, len(columnTypeMap))
-		columnSlice := []string{}
-		for _, column := range insertStruct.Columns {
-			columnName := column.CompliantName()
-			columnSlice = append(columnSlice, strings.ToUpper(columnName))
-
-			log.MSKLog().Debugf("tableName %v column %T column %v CompliantName %v",
-				tableNameString, column, column, column)
-		}
-		// For case of no explicit fields declared, eg. Insert TableName values ....
-		if len(columnSlice) <= 0 {
-			for i := 0; i < len(columnNameSlices); i++ {
-				fieldName := columnNameSlices[i].Field.String
-				columnSlice = append(columnSlice, fieldName)
-			}
-		}
-
-		insertValues, ok := insertStruct.Rows.(sqlparser.Values)
-		if !ok {
-			log.MSKLog().Infof("PolicyCheckerFieldsLength:Check(%v, %v, %v) insertStruct.Rows %T failed cast into sqlparser.Values",
-				explainRecords, query, args, insertStruct.Rows)
-			break
-		}
-		var argIdx int = 0
-		for _, rowValues := range insertValues {
-			valueSlice := []*sqlparser.SQLVal{}
-			for _, columnValue := range rowValues {
-
-				insertValueColumn, ok := columnValue.(*sqlparser.SQLVal)
-				if ok {
-					valueSlice = append(valueSlice, insertValueColumn)
-				} else {
-					valueSlice = append(valueSlice, nil)
-				}
-			}
-
-			err = pcri.checkValueLengthBy(columnSlice, valueSlice, columnTypeMap, args, &argIdx)
-			if err != nil {
-				if err == WarnFieldDataMayTruncated {
-					return NewPolicyError(WarnPolicyCodeDataTruncate, fmt.Sprintf("Possible data fields near the edge of overflow on table %v with err %v",
-						tableNameString, err))
-				} else if err != nil {
-					return NewPolicyError(ErrPolicyCodeDataTruncate, fmt.Sprintf("Possible data fields overflow on table %v with err %v",
-						tableNameString, err))
-				}
-			}
-		}
-
+		err = pcri.checkInsert(db, stmt, args)
+		ignore = stmt.Ignore != ""
 	case *sqlparser.Update:
-		updateStruct := stmt
-
-		for _, table := range updateStruct.TableExprs {
-
-			aliaTable := getAliasedTableFromUpdateStmt(table)
-			if aliaTable == nil {
-				log.MSKLog().Warnf("PolicyCheckerFieldsLength:Check(%v, %v, %v) table %T failed cast into sqlparser.AliasedTableExpr",
-					explainRecords, query, args, table)
-				break
-			}
-
-			tableName := sqlparser.GetTableName(aliaTable.Expr)
-			tableNameString := tableName.String()
-			columnTypeMap, _, err := MakeColumnRecords(db, tableNameString, MaxTimeoutOfExplain)
-			if err != nil {
-				log.MSKLog().Warnf("PolicyCheckerFieldsLength:Check(%v, %v, %v) MakeColumnRecords of %v failed",
-					explainRecords, query, args, tableNameString)
-				break
-			}
-
-			var argFilteredIdx int = 0
-			valueSlice := []*sqlparser.SQLVal{}
-			columnSlice := []string{}
-			var argsPrev int = 0
-			var argsFilterd []interface{}
-			for _, expr := range updateStruct.Exprs {
-				columnName := expr.Name
-				var updateExpr *sqlparser.SQLVal
-				var ok bool
-				switch expr.Expr.(type) {
-				case *sqlparser.SQLVal:
-					updateExpr, ok = expr.Expr.(*sqlparser.SQLVal)
-					if updateExpr.Type == sqlparser.ValArg {
-						if argFilteredIdx >= len(args) {
-							log.MSKLog().Warnf("PolicyCheckerFieldsLength:Check(%v, %v, %v) mismatch of number of valArg with argFilteredIdx %v >= len(args) %v",
-								explainRecords, query, args, argFilteredIdx, len(args))
-							ok = false
-							break
-						}
-						argsFilterd = append(argsFilterd, args[argFilteredIdx])
-						argFilteredIdx++
-					}
-				// Complex expression instead of single SQLVal
-				default:
-					// Firstly, calculate the expression by select statement
-					buf := sqlparser.NewTrackedBuffer(nil)
-					expr.Expr.Format(buf)
-
-					// 统计之前的？的个数，选择相应的args
-					strWithQues := misc.ReplaceColonMark(buf.String())
-					ac := misc.CountQuestionMark(strWithQues)
-					if argsPrev+ac > len(args) {
-						log.MSKLog().Warnf("PolicyCheckerFieldsLength:Check(%v, %v, %v) mismatch of number of valArg in %v",
-							explainRecords, query, args, strWithQues)
-						ok = false
-						break
-					}
-					updateExpr, err = calExprValue(db, MaxTimeoutOfExplain, strWithQues, args[argsPrev:argsPrev+ac]...)
-					if err != nil {
-						log.MSKLog().Warnf("PolicyCheckerFieldsLength:Check(%v, %v, %v) expr.Expr %T failed(%v) cast into SQLVal",
-							explainRecords, query, args, expr.Expr, err)
-						ok = false
-						break
-					}
-					ok = true
-					argsPrev += ac
-					argFilteredIdx += ac
-				}
-				if !ok {
-					break
-				}
-
-				valueSlice = append(valueSlice, updateExpr)
-				columnSlice = append(columnSlice, strings.ToUpper(columnName.Name.String()))
-				log.MSKLog().Debugf("tableNameString %T tableNameString %v columnName %v updateExpr %T updateExpr.Type %v",
-					tableNameString, tableNameString, columnName.Name.String(), updateExpr, updateExpr.Type)
-			}
-			var argIdx int
-			err = pcri.checkValueLengthBy(columnSlice, valueSlice, columnTypeMap, argsFilterd, &argIdx)
-			if err == WarnFieldDataMayTruncated {
-				return NewPolicyError(WarnPolicyCodeDataTruncate, fmt.Sprintf("Possible data fields near the edge of overflow on table %v with err %v",
-					tableNameString, err))
-			} else if err != nil {
-				return NewPolicyError(ErrPolicyCodeDataTruncate, fmt.Sprintf("Possible data fields overflow on table %v with err %v",
-					tableNameString, err))
-			}
-		}
+		err = pcri.checkUpdate(db, stmt, args)
+	default:
+		return nil
 	}
-	return nil
+	if err == nil {
+		return nil
+	}
+	return withSQLModeSeverity(err, pcri.sqlModeOf(db), ignore)
 }
@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+
+字符集相关的长度计算。
+
+REF: https://dev.mysql.com/doc/refman/5.7/en/string-type-syntax.html
+     https://dev.mysql.com/doc/refman/5.7/en/storage-requirements.html#data-types-storage-reqs-strings
+
+1. CHAR(N)/VARCHAR(N)的N为字符数，与字符集无关
+2. TINYTEXT/TEXT/MEDIUMTEXT/LONGTEXT的上限为字节数，同一个字符在latin1下占1字节，utf8下最多3字节，utf8mb4下最多4字节
+3. BINARY/VARBINARY/BLOB为字节串，按字节计算
+4. utf8(utf8mb3)无法存储4字节的字符（例如emoji），严格模式下报错，非严格模式下从该字符处截断
+
+列的字符集取自SHOW FULL COLUMNS的Collation，eg. utf8mb4_general_ci => utf8mb4，非字符串列的Collation为NULL。
+
+*/
+
+const (
+	charsetBinary  string = "binary"
+	charsetUTF8    string = "utf8"
+	charsetUTF8MB3 string = "utf8mb3"
+	charsetUTF8MB4 string = "utf8mb4"
+)
+
+// 各字符集中一个字符最多占用的字节数
+var charsetMaxLen = map[string]int{
+	"armscii8": 1, "ascii": 1, "big5": 2, "binary": 1, "cp1250": 1, "cp1251": 1, "cp1256": 1, "cp1257": 1,
+	"cp850": 1, "cp852": 1, "cp866": 1, "cp932": 2, "dec8": 1, "eucjpms": 3, "euckr": 2, "gb18030": 4,
+	"gb2312": 2, "gbk": 2, "geostd8": 1, "greek": 1, "hebrew": 1, "hp8": 1, "keybcs2": 1, "koi8r": 1,
+	"koi8u": 1, "latin1": 1, "latin2": 1, "latin5": 1, "latin7": 1, "macce": 1, "macroman": 1, "sjis": 2,
+	"swe7": 1, "tis620": 1, "ucs2": 2, "ujis": 3, "utf16": 4, "utf16le": 4, "utf32": 4, "utf8": 3,
+	"utf8mb3": 3, "utf8mb4": 4,
+}
+
+// 由Collation得到字符集，eg. utf8mb4_general_ci => utf8mb4, binary => binary
+func CharsetOfCollation(collation string) string {
+	collation = strings.ToLower(strings.TrimSpace(collation))
+	if idx := strings.Index(collation, "_"); idx != -1 {
+		return collation[:idx]
+	}
+	return collation
+}
+
+// 列的字符集，未知（例如非字符串列）时为空
+func (cr *ColumnRecord) Charset() string {
+	if !cr.Collation.Valid {
+		return ""
+	}
+	return CharsetOfCollation(cr.Collation.String)
+}
+
+func isUTF8MB3Charset(charset string) bool {
+	return charset == charsetUTF8 || charset == charsetUTF8MB3
+}
+
+// value中是否有4字节的UTF-8字符（码点大于U+FFFF，例如emoji）
+func hasSupplementaryRune(value []byte) bool {
+	for len(value) > 0 {
+		r, size := utf8.DecodeRune(value)
+		if r > 0xFFFF {
+			return true
+		}
+		value = value[size:]
+	}
+	return false
+}
+
+// 字符数，非法的UTF-8按字节计算
+func charLengthOf(value []byte) int64 {
+	if !utf8.Valid(value) {
+		return int64(len(value))
+	}
+	return int64(utf8.RuneCount(value))
+}
+
+// 客户端以UTF-8发送的value，转换为列的字符集后占用的字节数
+func byteLengthInCharset(charset string, value []byte) int64 {
+	if !utf8.Valid(value) {
+		return int64(len(value))
+	}
+	maxLen, ok := charsetMaxLen[charset]
+	if !ok || charset == charsetBinary || charset == charsetUTF8MB4 || isUTF8MB3Charset(charset) {
+		return int64(len(value))
+	}
+
+	length := int64(0)
+	for _, r := range string(value) {
+		switch {
+		case maxLen == 1:
+			length++
+		case charset == "ucs2":
+			length += 2
+		case charset == "utf32":
+			length += 4
+		case charset == "utf16" || charset == "utf16le":
+			if r > 0xFFFF {
+				length += 4
+			} else {
+				length += 2
+			}
+		case r < utf8.RuneSelf:
+			// 多字节的编码（gbk、big5、sjis等）中ASCII均为1字节
+			length++
+		case r > 0xFFFF:
+			length += int64(maxLen)
+		default:
+			length += int64(minInt(maxLen, 2))
+		}
+	}
+	return length
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
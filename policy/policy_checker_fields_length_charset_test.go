@@ -0,0 +1,90 @@
+package policy
+
+import (
+	"database/sql"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCharsetOfCollation(t *testing.T) {
+	cases := map[string]string{
+		"utf8mb4_general_ci": "utf8mb4",
+		"utf8_bin":           "utf8",
+		"latin1_swedish_ci":  "latin1",
+		"binary":             "binary",
+		"":                   "",
+	}
+	for collation, expected := range cases {
+		if got := CharsetOfCollation(collation); got != expected {
+			t.Errorf("CharsetOfCollation(%v) = %v, expected %v", collation, got, expected)
+		}
+	}
+
+	cr := &ColumnRecord{Type: sql.NullString{String: "int(11)", Valid: true}}
+	if cr.Charset() != "" {
+		t.Errorf("charset of column without collation should be empty, got %v", cr.Charset())
+	}
+}
+
+func TestByteLengthInCharset(t *testing.T) {
+	value := []byte("a中😀")
+	cases := map[string]int64{
+		"utf8mb4": 8,
+		"latin1":  3,
+		"gbk":     1 + 2 + 2,
+		"ucs2":    6,
+		"utf16":   2 + 2 + 4,
+		"utf32":   12,
+		"unknown": 8,
+	}
+	for charset, expected := range cases {
+		if got := byteLengthInCharset(charset, value); got != expected {
+			t.Errorf("byteLengthInCharset(%v, %v) = %v, expected %v", charset, string(value), got, expected)
+		}
+	}
+	if got := charLengthOf(value); got != 3 {
+		t.Errorf("charLengthOf(%v) = %v, expected 3", string(value), got)
+	}
+	if got := charLengthOf([]byte{0xff, 0xfe}); got != 2 {
+		t.Errorf("charLengthOf of invalid utf8 = %v, expected 2", got)
+	}
+}
+
+func TestCheckIfMySQLTruncateCharset(t *testing.T) {
+	pcfl := NewPolicyCheckerFieldsLength()
+	newColumn := func(columnType string, collation string) *ColumnRecord {
+		return &ColumnRecord{
+			Field:     sql.NullString{String: "field1", Valid: true},
+			Type:      sql.NullString{String: columnType, Valid: true},
+			Collation: sql.NullString{String: collation, Valid: collation != ""},
+		}
+	}
+	cases := []struct {
+		cr       *ColumnRecord
+		sqlV     *sqlparser.SQLVal
+		value    string
+		expected int
+	}{
+		// VARCHAR(N)按字符计算，5个汉字为15字节
+		{newColumn("varchar(5)", "utf8mb4_general_ci"), sqlparser.NewStrVal(nil), "中文字符串", TruncatedWarn},
+		{newColumn("varchar(5)", "utf8mb4_general_ci"), sqlparser.NewStrVal(nil), "中文字符串长", Truncated},
+		{newColumn("varchar(10)", "utf8mb4_general_ci"), sqlparser.NewValArg(nil), "中文", NoTruncated},
+		{newColumn("varbinary(5)", "binary"), sqlparser.NewStrVal(nil), "中文", Truncated},
+		// TINYTEXT按字节计算
+		{newColumn("tinytext", "utf8mb4_general_ci"), sqlparser.NewStrVal(nil), strings.Repeat("中", 90), Truncated},
+		{newColumn("tinytext", "latin1_swedish_ci"), sqlparser.NewStrVal(nil), strings.Repeat("é", 180), NoTruncated},
+		{newColumn("tinytext", "latin1_swedish_ci"), sqlparser.NewStrVal(nil), strings.Repeat("é", 256), Truncated},
+		// emoji写入utf8
+		{newColumn("varchar(10)", "utf8_general_ci"), sqlparser.NewStrVal(nil), "hi😀", TruncatedIncorrectString},
+		{newColumn("text", "utf8mb3_general_ci"), sqlparser.NewValArg(nil), "😀", TruncatedIncorrectString},
+		{newColumn("varchar(10)", "utf8mb4_general_ci"), sqlparser.NewStrVal(nil), "hi😀", NoTruncated},
+		{newColumn("varchar(10)", ""), sqlparser.NewStrVal(nil), "hi😀", NoTruncated},
+	}
+	for i, c := range cases {
+		if got := pcfl.checkIfMySQLTruncate(c.cr, c.sqlV, []byte(c.value), time.UTC); got != c.expected {
+			t.Errorf("case %v: checkIfMySQLTruncate(%v, %v) = %v, expected %v", i, c.cr.Type.String, c.value, got, c.expected)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package policy
+
+import (
+	"fmt"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+	"strconv"
+	"strings"
+)
+
+/*
+
+列约束的检测，非严格模式下以下写入都不会报错，而是被静默地转换为0或''：
+
+1. NULL写入没有默认值的NOT NULL列
+2. 负数写入UNSIGNED的数值列
+3. INSERT时省略了没有默认值的NOT NULL列
+4. 对生成列（VIRTUAL/STORED GENERATED）显式赋值，DEFAULT除外
+
+AUTO_INCREMENT的列写入NULL或被省略时自动生成，不做检测。
+
+*/
+
+var (
+	ErrFieldNullIntoNotNull  = fmt.Errorf("NULL into NOT NULL column without default")
+	ErrFieldNegativeUnsigned = fmt.Errorf("Negative value into UNSIGNED column")
+	ErrFieldMissingNotNull   = fmt.Errorf("NOT NULL column without default omitted")
+	ErrFieldGeneratedColumn  = fmt.Errorf("Value into generated column")
+)
+
+func (cr *ColumnRecord) IsNotNull() bool {
+	return cr.Null.Valid && strings.EqualFold(cr.Null.String, "NO")
+}
+
+func (cr *ColumnRecord) HasDefault() bool {
+	return cr.Default.Valid
+}
+
+func (cr *ColumnRecord) IsAutoIncrement() bool {
+	return cr.Extra.Valid && strings.Contains(strings.ToLower(cr.Extra.String), "auto_increment")
+}
+
+// Extra为VIRTUAL GENERATED或STORED GENERATED，8.0中默认值为表达式的DEFAULT_GENERATED不是生成列
+func (cr *ColumnRecord) IsGenerated() bool {
+	extra := strings.ToUpper(cr.Extra.String)
+	return cr.Extra.Valid && (strings.Contains(extra, "VIRTUAL GENERATED") || strings.Contains(extra, "STORED GENERATED"))
+}
+
+// 写入时必须给出值的列
+func (cr *ColumnRecord) RequiresValue() bool {
+	return cr.IsNotNull() && !cr.HasDefault() && !cr.IsAutoIncrement() && !cr.IsGenerated()
+}
+
+func isNumericFieldType(typeString string) bool {
+	switch typeString {
+	case msFieldTypeDecimal, msFieldTypeFloat, msFieldTypeDouble:
+		return true
+	}
+	return isIntegerFieldType(typeString)
+}
+
+// 表达式是否为NULL，占位符按序号取参数
+func isNullValue(expr sqlparser.Expr, args []interface{}) bool {
+	switch expr := expr.(type) {
+	case *sqlparser.NullVal:
+		return true
+	case *sqlparser.SQLVal:
+		if expr.Type != sqlparser.ValArg {
+			return false
+		}
+		idx, ok := posArgIndex(expr.Val)
+		return ok && idx < len(args) && args[idx] == nil
+	}
+	return false
+}
+
+// 表达式是否为负数，占位符按序号取参数
+func isNegativeValue(expr sqlparser.Expr, args []interface{}) bool {
+	// 除整数外，负数被解析为 UnaryExpr{-, SQLVal}
+	if unary, ok := expr.(*sqlparser.UnaryExpr); ok && unary.Operator == sqlparser.UMinusStr {
+		sqlVal, ok := unary.Expr.(*sqlparser.SQLVal)
+		if !ok || sqlVal.Type != sqlparser.FloatVal {
+			return false
+		}
+		f, err := strconv.ParseFloat(string(sqlVal.Val), 64)
+		return err == nil && f > 0
+	}
+	sqlVal, ok := expr.(*sqlparser.SQLVal)
+	if !ok {
+		return false
+	}
+	value := string(sqlVal.Val)
+	switch sqlVal.Type {
+	case sqlparser.IntVal, sqlparser.FloatVal, sqlparser.StrVal:
+	case sqlparser.ValArg:
+		idx, ok := posArgIndex(sqlVal.Val)
+		if !ok || idx >= len(args) || args[idx] == nil {
+			return false
+		}
+		value = string(formatArgValue(args[idx]))
+	default:
+		return false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return err == nil && f < 0
+}
+
+// 检测对columnSlice的赋值exprSlice，返回违反约束的列
+func checkColumnConstraints(columnSlice []string, exprSlice []sqlparser.Expr, columnTypeMap ColumnMap, args []interface{}) (string, error) {
+	for i := 0; i < len(columnSlice) && i < len(exprSlice); i++ {
+		cr, ok := columnTypeMap[columnSlice[i]]
+		if !ok {
+			continue
+		}
+		expr := exprSlice[i]
+		if _, isDefault := expr.(*sqlparser.Default); isDefault {
+			continue
+		}
+		if cr.IsGenerated() {
+			return columnSlice[i], ErrFieldGeneratedColumn
+		}
+		if isNullValue(expr, args) {
+			if cr.IsNotNull() && !cr.HasDefault() && !cr.IsAutoIncrement() {
+				return columnSlice[i], ErrFieldNullIntoNotNull
+			}
+			continue
+		}
+		typeString, _, unsigned := parseColumnType(cr.Type.String)
+		if unsigned && isNumericFieldType(typeString) && isNegativeValue(expr, args) {
+			return columnSlice[i], ErrFieldNegativeUnsigned
+		}
+	}
+	return "", nil
+}
+
+// INSERT时被省略的必填列
+func checkOmittedColumns(columnSlice []string, columnRecords []ColumnRecord) (string, error) {
+	given := map[string]struct{}{}
+	for _, column := range columnSlice {
+		given[column] = struct{}{}
+	}
+	for i := 0; i < len(columnRecords); i++ {
+		if _, ok := given[columnRecords[i].Field.String]; ok {
+			continue
+		}
+		if columnRecords[i].RequiresValue() {
+			return columnRecords[i].Field.String, ErrFieldMissingNotNull
+		}
+	}
+	return "", nil
+}
+
+func newFieldsConstraintPolicyError(table string, column string, err error) error {
+	// 生成列的显式赋值在任何sql_mode下都会报错
+	if err == ErrFieldGeneratedColumn {
+		return NewPolicyError(ErrPolicyCodeDataTruncateStrict, fmt.Sprintf("Statement will fail at runtime on table %v column %v with err %v",
+			table, column, err))
+	}
+	return NewPolicyError(ErrPolicyCodeDataTruncate, fmt.Sprintf("Possible data fields violation on table %v column %v with err %v",
+		table, column, err))
+}
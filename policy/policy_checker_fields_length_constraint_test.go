@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"database/sql"
+	logmsk "gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newConstraintColumnForTest(field, columnType, null string, defaultValue *string, extra string) ColumnRecord {
+	cr := ColumnRecord{
+		Field: sql.NullString{String: field, Valid: true},
+		Type:  sql.NullString{String: columnType, Valid: true},
+		Null:  sql.NullString{String: null, Valid: true},
+		Extra: sql.NullString{String: extra, Valid: true},
+	}
+	if defaultValue != nil {
+		cr.Default = sql.NullString{String: *defaultValue, Valid: true}
+	}
+	return cr
+}
+
+func TestPolicyFieldsConstraintColumnRecord(t *testing.T) {
+	empty := ""
+	cases := []struct {
+		cr            ColumnRecord
+		generated     bool
+		requiresValue bool
+	}{
+		{cr: newConstraintColumnForTest("ID", "int(11)", "NO", nil, "auto_increment"), requiresValue: false},
+		{cr: newConstraintColumnForTest("NAME", "varchar(10)", "NO", nil, ""), requiresValue: true},
+		{cr: newConstraintColumnForTest("NAME", "varchar(10)", "NO", &empty, ""), requiresValue: false},
+		{cr: newConstraintColumnForTest("NAME", "varchar(10)", "YES", nil, ""), requiresValue: false},
+		{cr: newConstraintColumnForTest("FULL", "varchar(20)", "NO", nil, "VIRTUAL GENERATED"), generated: true},
+		{cr: newConstraintColumnForTest("FULL", "varchar(20)", "YES", nil, "STORED GENERATED"), generated: true},
+		{cr: newConstraintColumnForTest("TS", "timestamp", "NO", nil, "DEFAULT_GENERATED on update CURRENT_TIMESTAMP"), requiresValue: true},
+	}
+	for _, testCase := range cases {
+		if testCase.cr.IsGenerated() != testCase.generated || testCase.cr.RequiresValue() != testCase.requiresValue {
+			t.Fatalf("column %v got generated %v requiresValue %v", testCase.cr, testCase.cr.IsGenerated(), testCase.cr.RequiresValue())
+		}
+	}
+}
+
+func TestPolicyFieldsConstraintCheck(t *testing.T) {
+	zero := "0"
+	records := []ColumnRecord{
+		newConstraintColumnForTest("ID", "int(11) unsigned", "NO", nil, "auto_increment"),
+		newConstraintColumnForTest("NAME", "varchar(10)", "NO", nil, ""),
+		newConstraintColumnForTest("SCORE", "decimal(10,2) unsigned", "NO", &zero, ""),
+		newConstraintColumnForTest("NOTE", "varchar(10)", "YES", nil, ""),
+		newConstraintColumnForTest("FULL_NAME", "varchar(20)", "YES", nil, "VIRTUAL GENERATED"),
+	}
+	columnMap := ColumnMap{}
+	for i := 0; i < len(records); i++ {
+		columnMap[records[i].Field.String] = &records[i]
+	}
+
+	cases := []struct {
+		query  string
+		args   []interface{}
+		column string
+		err    error
+	}{
+		{query: "insert into t(id, name, score) values (null, 'a', 1.5)"},
+		{query: "insert into t(id, name, score) values (1, null, 1.5)", column: "NAME", err: ErrFieldNullIntoNotNull},
+		{query: "insert into t(id, name, score) values (1, ?, 1.5)", args: []interface{}{nil}, column: "NAME", err: ErrFieldNullIntoNotNull},
+		{query: "insert into t(id, name, score) values (1, 'a', -1.5)", column: "SCORE", err: ErrFieldNegativeUnsigned},
+		{query: "insert into t(id, name, score) values (?, 'a', ?)", args: []interface{}{-1, 0}, column: "ID", err: ErrFieldNegativeUnsigned},
+		{query: "insert into t(id, name, note) values (1, 'a', null)"},
+		{query: "insert into t(id, name, full_name) values (1, 'a', 'b')", column: "FULL_NAME", err: ErrFieldGeneratedColumn},
+		{query: "insert into t(id, name, full_name) values (1, 'a', default)"},
+	}
+	for _, testCase := range cases {
+		stmt, err := sqlparser.Parse(testCase.query)
+		if err != nil {
+			t.Fatalf("parse %v failed %v", testCase.query, err)
+		}
+		insert := stmt.(*sqlparser.Insert)
+		columnSlice := []string{}
+		for _, column := range insert.Columns {
+			columnSlice = append(columnSlice, strings.ToUpper(column.String()))
+		}
+		column, err := checkColumnConstraints(columnSlice, insert.Rows.(sqlparser.Values)[0], columnMap, testCase.args)
+		if column != testCase.column || err != testCase.err {
+			t.Fatalf("checkColumnConstraints(%v) got %v %v", testCase.query, column, err)
+		}
+	}
+
+	if column, err := checkOmittedColumns([]string{"ID", "SCORE"}, records); column != "NAME" || err != ErrFieldMissingNotNull {
+		t.Fatalf("checkOmittedColumns got %v %v", column, err)
+	}
+	if column, err := checkOmittedColumns([]string{"NAME"}, records); err != nil {
+		t.Fatalf("checkOmittedColumns got %v %v", column, err)
+	}
+}
+
+func TestRawPolicyFieldsConstraint(t *testing.T) {
+	runRawPolicyTests(t, dsn+"&sql_mode=''", func(dbt *DBTest) {
+
+		logmsk.MSKLog().SetOutput(os.Stdout)
+
+		dbt.mustExec("CREATE TABLE `test_policy` (`id` int(11) unsigned NOT NULL AUTO_INCREMENT, `name` varchar(10) NOT NULL, " +
+			"`score` int(11) unsigned NOT NULL DEFAULT 0, `note` varchar(10) DEFAULT NULL, " +
+			"`upper_name` varchar(10) GENERATED ALWAYS AS (upper(`name`)) VIRTUAL, PRIMARY KEY (`id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;")
+
+		npc := NewPolicyCheckWraper(NewPolicyCheckerFieldsLength(), dbt.db)
+		violations := []struct {
+			query string
+			args  []interface{}
+			code  PolicyCode
+			err   error
+		}{
+			{query: "INSERT INTO test_policy(name) VALUES (NULL)", code: ErrPolicyCodeDataTruncate, err: ErrFieldNullIntoNotNull},
+			{query: "INSERT INTO test_policy(name, score) VALUES ('a', ?)", args: []interface{}{-1}, code: ErrPolicyCodeDataTruncate, err: ErrFieldNegativeUnsigned},
+			{query: "INSERT INTO test_policy(score) VALUES (1)", code: ErrPolicyCodeDataTruncate, err: ErrFieldMissingNotNull},
+			{query: "INSERT INTO test_policy(name, upper_name) VALUES ('a', 'A')", code: ErrPolicyCodeDataTruncateStrict, err: ErrFieldGeneratedColumn},
+			{query: "UPDATE test_policy SET name = ? WHERE id = 1", args: []interface{}{nil}, code: ErrPolicyCodeDataTruncate, err: ErrFieldNullIntoNotNull},
+			{query: "UPDATE test_policy SET score = -5", code: ErrPolicyCodeDataTruncate, err: ErrFieldNegativeUnsigned},
+		}
+		for _, violation := range violations {
+			err := npc.Check(violation.query, violation.args...)
+			pe, ok := err.(*PolicyError)
+			if !ok || pe.Code != violation.code || !strings.Contains(pe.Msg, violation.err.Error()) {
+				dbt.Errorf("%v should be failed with %v, got %v", violation.query, violation.err, err)
+			}
+		}
+
+		for _, query := range []string{
+			"INSERT INTO test_policy(name) VALUES ('a')",
+			"INSERT INTO test_policy(id, name, note, upper_name) VALUES (NULL, 'a', NULL, DEFAULT)",
+			"UPDATE test_policy SET note = NULL, score = score + 1",
+		} {
+			if err := npc.Check(query); err != nil {
+				dbt.Errorf("%v should be ok, got %v", query, err)
+			}
+		}
+
+		dbt.mustExec("DROP TABLE IF EXISTS test_policy")
+
+		logmsk.MSKLog().SetOutput(ioutil.Discard)
+	})
+}
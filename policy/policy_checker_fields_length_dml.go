@@ -0,0 +1,469 @@
+package policy
+
+import (
+	"database/sql"
+	"fmt"
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+字段长度检测所覆盖的DML
+
+1. INSERT/REPLACE ... VALUES (...), (...) 以及 INSERT ... SET col = val
+2. INSERT ... SELECT，根据SELECT中的常量，或源列与目标列的定义（源列更宽则可能截断）检测
+3. INSERT ... ON DUPLICATE KEY UPDATE col = val
+4. UPDATE t1, t2 / UPDATE t1 JOIN t2 ON ... SET t1.col = val，列通过表的别名找到对应的表
+
+sqlparser把?占位符依次解析为:v1, :v2 ...，参数按照占位符的序号取得，不受函数、表达式中的占位符影响。
+
+*/
+
+var posArgReg = regexp.MustCompile(`^:v(\d+)$`)
+
+// 占位符:vN对应的参数下标
+func posArgIndex(val []byte) (int, bool) {
+	matches := posArgReg.FindSubmatch(val)
+	if matches == nil {
+		return 0, false
+	}
+	idx, err := strconv.Atoi(string(matches[1]))
+	if err != nil || idx < 1 {
+		return 0, false
+	}
+	return idx - 1, true
+}
+
+func formatArgValue(arg interface{}) []byte {
+	if t, ok := arg.(time.Time); ok {
+		// 与TIMESTAMP的范围（UTC）比较，不使用本地时区
+		return []byte(t.UTC().Format("2006-01-02 15:04:05.999999"))
+	}
+	return []byte(fmt.Sprintf("%v", arg))
+}
+
+func tableNameString(tn sqlparser.TableName) string {
+	if tn.Qualifier.IsEmpty() {
+		return tn.Name.String()
+	}
+	return tn.Qualifier.String() + "." + tn.Name.String()
+}
+
+// 语句中的表（按出现的顺序）以及别名（大写） => 表名，派生表不在其中
+func collectTableAliases(tableExprs sqlparser.TableExprs) ([]string, map[string]string) {
+	tables := []string{}
+	aliases := map[string]string{}
+
+	var collect func(te sqlparser.TableExpr)
+	collect = func(te sqlparser.TableExpr) {
+		switch te := te.(type) {
+		case *sqlparser.AliasedTableExpr:
+			tn, ok := te.Expr.(sqlparser.TableName)
+			if !ok {
+				return
+			}
+			name := tableNameString(tn)
+			tables = append(tables, name)
+			aliases[strings.ToUpper(tn.Name.String())] = name
+			if !te.As.IsEmpty() {
+				aliases[strings.ToUpper(te.As.String())] = name
+			}
+		case *sqlparser.ParenTableExpr:
+			for _, e := range te.Exprs {
+				collect(e)
+			}
+		case *sqlparser.JoinTableExpr:
+			collect(te.LeftExpr)
+			collect(te.RightExpr)
+		}
+	}
+	for _, te := range tableExprs {
+		collect(te)
+	}
+	return tables, aliases
+}
+
+// 列所属的表：带限定的列通过别名查找，否则为唯一的表或者第一个包含该列的表
+func resolveColumnTable(col *sqlparser.ColName, tables []string, aliases map[string]string, columnMaps map[string]ColumnMap) string {
+	if !col.Qualifier.Name.IsEmpty() {
+		return aliases[strings.ToUpper(col.Qualifier.Name.String())]
+	}
+	if len(tables) == 1 {
+		return tables[0]
+	}
+	columnName := strings.ToUpper(col.Name.String())
+	for _, table := range tables {
+		if _, ok := columnMaps[table][columnName]; ok {
+			return table
+		}
+	}
+	return ""
+}
+
+// 表达式是否依赖于列（包括VALUES(col)）或子查询，这类表达式无法单独计算
+func dependsOnColumns(expr sqlparser.Expr) bool {
+	depends := false
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node.(type) {
+		case *sqlparser.ColName, *sqlparser.ValuesFuncExpr, *sqlparser.Subquery:
+			depends = true
+			return false, nil
+		}
+		return true, nil
+	}, expr)
+	return depends
+}
+
+// 表达式中占位符对应的参数
+func argsOfExpr(expr sqlparser.Expr, args []interface{}) ([]interface{}, bool) {
+	exprArgs := []interface{}{}
+	ok := true
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		sqlVal, isVal := node.(*sqlparser.SQLVal)
+		if !isVal || sqlVal.Type != sqlparser.ValArg {
+			return true, nil
+		}
+		idx, isPos := posArgIndex(sqlVal.Val)
+		if !isPos || idx >= len(args) {
+			ok = false
+			return false, nil
+		}
+		exprArgs = append(exprArgs, args[idx])
+		return true, nil
+	}, expr)
+	return exprArgs, ok
+}
+
+// 赋值表达式的值: SQLVal直接使用，不依赖于列的表达式通过SELECT计算，其余（例如 col + 1）忽略
+func valueOfAssignment(db *sql.DB, expr sqlparser.Expr, args []interface{}) (*sqlparser.SQLVal, bool) {
+	switch expr := expr.(type) {
+	case *sqlparser.SQLVal:
+		return expr, true
+	case *sqlparser.NullVal, *sqlparser.Default:
+		return nil, false
+	}
+	if dependsOnColumns(expr) {
+		return nil, false
+	}
+	exprArgs, ok := argsOfExpr(expr, args)
+	if !ok {
+		log.MSKLog().Warnf("valueOfAssignment(%v, %v args) mismatch of number of valArg", misc.FingerprintSQL(sqlparser.String(expr)), len(args))
+		return nil, false
+	}
+	strWithQues := misc.ReplaceColonMark(sqlparser.String(expr))
+	sqlVal, err := calExprValue(db, MaxTimeoutOfExplain, strWithQues, exprArgs...)
+	if err != nil {
+		log.MSKLog().Warnf("valueOfAssignment(%v, %v args) calExprValue failed %v", misc.FingerprintSQL(strWithQues), len(exprArgs), err)
+		return nil, false
+	}
+	return sqlVal, true
+}
+
+func newFieldsLengthPolicyError(table string, err error) error {
+	if err == WarnFieldDataMayTruncated {
+		return NewPolicyError(WarnPolicyCodeDataTruncate, fmt.Sprintf("Possible data fields near the edge of overflow on table %v with err %v",
+			table, err))
+	}
+	return NewPolicyError(ErrPolicyCodeDataTruncate, fmt.Sprintf("Possible data fields overflow on table %v with err %v",
+		table, err))
+}
+
+// UPDATE及ON DUPLICATE KEY UPDATE的赋值, 按表分组检测
+func (pcri *PolicyCheckerFieldsLength) checkUpdateExprs(db *sql.DB, exprs sqlparser.UpdateExprs, tables []string,
+	aliases map[string]string, columnMaps map[string]ColumnMap, args []interface{}) error {
+
+	assignedByTable := map[string][]string{}
+	exprsByTable := map[string][]sqlparser.Expr{}
+	columnsByTable := map[string][]string{}
+	valuesByTable := map[string][]*sqlparser.SQLVal{}
+	for _, expr := range exprs {
+		table := resolveColumnTable(expr.Name, tables, aliases, columnMaps)
+		if _, ok := columnMaps[table]; !ok {
+			log.MSKLog().Debugf("checkUpdateExprs: table of column %v not found in %v", sqlparser.String(expr.Name), tables)
+			continue
+		}
+		columnName := strings.ToUpper(expr.Name.Name.String())
+		assignedByTable[table] = append(assignedByTable[table], columnName)
+		exprsByTable[table] = append(exprsByTable[table], expr.Expr)
+
+		sqlVal, ok := valueOfAssignment(db, expr.Expr, args)
+		if !ok {
+			continue
+		}
+		columnsByTable[table] = append(columnsByTable[table], columnName)
+		valuesByTable[table] = append(valuesByTable[table], sqlVal)
+	}
+
+	loc := pcri.timeZoneOf(db)
+	for _, table := range tables {
+		if column, err := checkColumnConstraints(assignedByTable[table], exprsByTable[table], columnMaps[table], args); err != nil {
+			return newFieldsConstraintPolicyError(table, column, err)
+		}
+		if len(columnsByTable[table]) == 0 {
+			continue
+		}
+		var argIdx int
+		err := pcri.checkValueLengthBy(columnsByTable[table], valuesByTable[table], columnMaps[table], args, &argIdx, loc)
+		if err != nil {
+			return newFieldsLengthPolicyError(table, err)
+		}
+	}
+	return nil
+}
+
+func (pcri *PolicyCheckerFieldsLength) checkUpdate(db *sql.DB, stmt *sqlparser.Update, args []interface{}) error {
+	tables, aliases := collectTableAliases(stmt.TableExprs)
+	columnMaps := map[string]ColumnMap{}
+	for _, table := range tables {
+		columnTypeMap, _, err := MakeColumnRecords(db, table, MaxTimeoutOfExplain)
+		if err != nil {
+			log.MSKLog().Warnf("PolicyCheckerFieldsLength:checkUpdate(%v, %v args) MakeColumnRecords of %v failed %v",
+				misc.FingerprintSQL(sqlparser.String(stmt)), len(args), table, err)
+			continue
+		}
+		columnMaps[table] = columnTypeMap
+	}
+	return pcri.checkUpdateExprs(db, stmt.Exprs, tables, aliases, columnMaps, args)
+}
+
+func (pcri *PolicyCheckerFieldsLength) checkInsert(db *sql.DB, stmt *sqlparser.Insert, args []interface{}) error {
+	tableNameString := tableNameString(stmt.Table)
+
+	columnTypeMap, columnNameSlices, err := MakeColumnRecords(db, tableNameString, MaxTimeoutOfExplain)
+	if err != nil {
+		log.MSKLog().Warnf("PolicyCheckerFieldsLength:checkInsert(%v, %v args) MakeColumnRecords of %v failed %v",
+			misc.FingerprintSQL(sqlparser.String(stmt)), len(args), tableNameString, err)
+		return nil
+	}
+	columnSlice := []string{}
+	for _, column := range stmt.Columns {
+		columnSlice = append(columnSlice, strings.ToUpper(column.CompliantName()))
+	}
+	// For case of no explicit fields declared, eg. Insert TableName values ....
+	if len(columnSlice) <= 0 {
+		for i := 0; i < len(columnNameSlices); i++ {
+			columnSlice = append(columnSlice, columnNameSlices[i].Field.String)
+		}
+	} else if column, err := checkOmittedColumns(columnSlice, columnNameSlices); err != nil {
+		return newFieldsConstraintPolicyError(tableNameString, column, err)
+	}
+
+	switch rows := stmt.Rows.(type) {
+	case sqlparser.Values:
+		loc := pcri.timeZoneOf(db)
+		var argIdx int = 0
+		for _, rowValues := range rows {
+			if column, err := checkColumnConstraints(columnSlice, rowValues, columnTypeMap, args); err != nil {
+				return newFieldsConstraintPolicyError(tableNameString, column, err)
+			}
+			valueSlice := []*sqlparser.SQLVal{}
+			for _, columnValue := range rowValues {
+				insertValueColumn, ok := columnValue.(*sqlparser.SQLVal)
+				if ok {
+					valueSlice = append(valueSlice, insertValueColumn)
+				} else {
+					valueSlice = append(valueSlice, nil)
+				}
+			}
+
+			err = pcri.checkValueLengthBy(columnSlice, valueSlice, columnTypeMap, args, &argIdx, loc)
+			if err != nil {
+				return newFieldsLengthPolicyError(tableNameString, err)
+			}
+		}
+	case sqlparser.SelectStatement:
+		if err := pcri.checkInsertSelect(db, tableNameString, columnSlice, columnTypeMap, rows, args); err != nil {
+			return err
+		}
+	}
+
+	if len(stmt.OnDup) > 0 {
+		tables := []string{tableNameString}
+		aliases := map[string]string{strings.ToUpper(stmt.Table.Name.String()): tableNameString}
+		columnMaps := map[string]ColumnMap{tableNameString: columnTypeMap}
+		return pcri.checkUpdateExprs(db, sqlparser.UpdateExprs(stmt.OnDup), tables, aliases, columnMaps, args)
+	}
+	return nil
+}
+
+// INSERT ... SELECT，SELECT的各列依次写入columnSlice
+func (pcri *PolicyCheckerFieldsLength) checkInsertSelect(db *sql.DB, table string, columnSlice []string,
+	columnTypeMap ColumnMap, selStmt sqlparser.SelectStatement, args []interface{}) error {
+
+	switch selStmt := selStmt.(type) {
+	case *sqlparser.Union:
+		if err := pcri.checkInsertSelect(db, table, columnSlice, columnTypeMap, selStmt.Left, args); err != nil {
+			return err
+		}
+		return pcri.checkInsertSelect(db, table, columnSlice, columnTypeMap, selStmt.Right, args)
+	case *sqlparser.ParenSelect:
+		return pcri.checkInsertSelect(db, table, columnSlice, columnTypeMap, selStmt.Select, args)
+	case *sqlparser.Select:
+		return pcri.checkSelectColumns(db, table, columnSlice, columnTypeMap, selStmt, args)
+	}
+	return nil
+}
+
+func (pcri *PolicyCheckerFieldsLength) checkSelectColumns(db *sql.DB, table string, columnSlice []string,
+	columnTypeMap ColumnMap, sel *sqlparser.Select, args []interface{}) error {
+
+	sourceTables, aliases := collectTableAliases(sel.From)
+	sourceMaps := map[string]ColumnMap{}
+	sourceRecords := map[string][]ColumnRecord{}
+	for _, source := range sourceTables {
+		sourceMap, records, err := MakeColumnRecords(db, source, MaxTimeoutOfExplain)
+		if err != nil {
+			log.MSKLog().Warnf("PolicyCheckerFieldsLength:checkSelectColumns(%v, %v args) MakeColumnRecords of %v failed %v",
+				misc.FingerprintSQL(sqlparser.String(sel)), len(args), source, err)
+			return nil
+		}
+		sourceMaps[source] = sourceMap
+		sourceRecords[source] = records
+	}
+
+	// 展开SELECT的各列，nil表示无法得到定义的表达式
+	selected := []sqlparser.Expr{}
+	selectedColumns := []*ColumnRecord{}
+	for _, selectExpr := range sel.SelectExprs {
+		switch selectExpr := selectExpr.(type) {
+		case *sqlparser.StarExpr:
+			starTables := sourceTables
+			if !selectExpr.TableName.IsEmpty() {
+				starTables = []string{aliases[strings.ToUpper(selectExpr.TableName.Name.String())]}
+			}
+			for _, source := range starTables {
+				records, ok := sourceRecords[source]
+				if !ok {
+					return nil
+				}
+				for i := 0; i < len(records); i++ {
+					selected = append(selected, nil)
+					selectedColumns = append(selectedColumns, &records[i])
+				}
+			}
+		case *sqlparser.AliasedExpr:
+			var cr *ColumnRecord
+			if col, ok := selectExpr.Expr.(*sqlparser.ColName); ok {
+				source := resolveColumnTable(col, sourceTables, aliases, sourceMaps)
+				cr = sourceMaps[source][strings.ToUpper(col.Name.String())]
+			}
+			selected = append(selected, selectExpr.Expr)
+			selectedColumns = append(selectedColumns, cr)
+		default:
+			return nil
+		}
+	}
+	if len(selected) != len(columnSlice) {
+		log.MSKLog().Warnf("PolicyCheckerFieldsLength:checkSelectColumns(%v) mismatch of columns %v != %v",
+			misc.FingerprintSQL(sqlparser.String(sel)), len(selected), len(columnSlice))
+		return nil
+	}
+
+	if column, err := checkColumnConstraints(columnSlice, selected, columnTypeMap, args); err != nil {
+		return newFieldsConstraintPolicyError(table, column, err)
+	}
+	for i := 0; i < len(columnSlice); i++ {
+		target, ok := columnTypeMap[columnSlice[i]]
+		if !ok {
+			continue
+		}
+		if source := selectedColumns[i]; source != nil {
+			if checkIfColumnMayTruncate(source, target) != NoTruncated {
+				return NewPolicyError(WarnPolicyCodeDataTruncate, fmt.Sprintf(
+					"Possible data fields overflow on table %v: column %v %v is narrower than selected column %v %v",
+					table, columnSlice[i], target.Type.String, source.Field.String, source.Type.String))
+			}
+			continue
+		}
+		sqlVal, ok := selected[i].(*sqlparser.SQLVal)
+		if !ok {
+			continue
+		}
+		var argIdx int
+		err := pcri.checkValueLengthBy([]string{columnSlice[i]}, []*sqlparser.SQLVal{sqlVal}, columnTypeMap, args, &argIdx,
+			pcri.timeZoneOf(db))
+		if err != nil {
+			return newFieldsLengthPolicyError(table, err)
+		}
+	}
+	return nil
+}
+
+func isIntegerFieldType(typeString string) bool {
+	switch typeString {
+	case msFieldTypeTiny, msFieldTypeShort, msFieldTypeInt24, msFieldTypeLong, msFieldTypeLongLong:
+		return true
+	}
+	return false
+}
+
+func isStringFieldType(typeString string) bool {
+	switch typeString {
+	case msFieldTypeString, msFieldTypeVarString, msFieldTypeBinary, msFieldTypeVarBinary,
+		msFieldTypeTinyTEXT, msFieldTypeTEXT, msFieldTypeMediumTEXT, msFieldTypeLongTEXT,
+		msFieldTypeTinyBLOB, msFieldTypeBLOB, msFieldTypeMediumBLOB, msFieldTypeLongBLOB:
+		return true
+	}
+	return false
+}
+
+// 字符串列最多容纳的字符数及字节数
+func stringCapacityOf(typeString string, typeParams string, charset string) (int64, int64) {
+	length, _ := strconv.ParseInt(typeParams, 10, 64)
+	limit := calNumberOfBytesByFieldTypeValue(typeString, length)
+	switch typeString {
+	case msFieldTypeString, msFieldTypeVarString:
+		maxLen, ok := charsetMaxLen[charset]
+		if !ok {
+			maxLen = 1
+		}
+		return limit, limit * int64(maxLen)
+	}
+	return limit, limit
+}
+
+// 源列的值写入目标列是否可能被截断，只比较整数、定点数以及字符串的定义
+func checkIfColumnMayTruncate(source, target *ColumnRecord) int {
+	sType, sParams, sUnsigned := parseColumnType(source.Type.String)
+	tType, tParams, tUnsigned := parseColumnType(target.Type.String)
+
+	switch {
+	case isIntegerFieldType(sType) && isIntegerFieldType(tType):
+		sBits, tBits := calNumberOfBitsByFieldTypeInt(sType), calNumberOfBitsByFieldTypeInt(tType)
+		if (!sUnsigned && tUnsigned) || sBits > tBits || (sUnsigned && !tUnsigned && sBits >= tBits) {
+			return TruncatedWarn
+		}
+	case sType == msFieldTypeDecimal && tType == msFieldTypeDecimal:
+		sM, sD := parsePrecisionAndScale(sParams, 10, 0)
+		tM, tD := parsePrecisionAndScale(tParams, 10, 0)
+		if sM-sD > tM-tD || sD > tD {
+			return TruncatedWarn
+		}
+	case isStringFieldType(sType) && isStringFieldType(tType):
+		sChars, sBytes := stringCapacityOf(sType, sParams, source.Charset())
+		tChars, tBytes := stringCapacityOf(tType, tParams, target.Charset())
+		switch tType {
+		case msFieldTypeString, msFieldTypeVarString:
+			if sChars > tChars {
+				return TruncatedWarn
+			}
+		default:
+			// 字符集不同时，每个字符按目标字符集的最大字节数计算
+			bytesInTarget := sBytes
+			if maxLen, ok := charsetMaxLen[target.Charset()]; ok && source.Charset() != target.Charset() &&
+				source.Charset() != "" && source.Charset() != charsetBinary {
+				bytesInTarget = sChars * int64(maxLen)
+			}
+			if bytesInTarget > tBytes {
+				return TruncatedWarn
+			}
+		}
+	}
+	return NoTruncated
+}
@@ -0,0 +1,205 @@
+package policy
+
+import (
+	"database/sql"
+	logmsk "gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyFieldsLengthPosArgIndex(t *testing.T) {
+	cases := []struct {
+		input string
+		idx   int
+		ok    bool
+	}{
+		{input: ":v1", idx: 0, ok: true},
+		{input: ":v12", idx: 11, ok: true},
+		{input: ":v0", ok: false},
+		{input: ":name", ok: false},
+		{input: "11", ok: false},
+	}
+	for _, testCase := range cases {
+		idx, ok := posArgIndex([]byte(testCase.input))
+		if ok != testCase.ok || (ok && idx != testCase.idx) {
+			t.Fatalf("posArgIndex(%v) got %v %v", testCase.input, idx, ok)
+		}
+	}
+}
+
+func TestPolicyFieldsLengthFormatTimeArg(t *testing.T) {
+	// 本地时区的time.Time按UTC与TIMESTAMP的上限比较
+	loc := time.FixedZone("UTC+8", 8*3600)
+	arg := time.Date(2038, 1, 19, 11, 14, 7, 0, loc)
+	if value := string(formatArgValue(arg)); value != "2038-01-19 03:14:07" {
+		t.Fatalf("formatArgValue(%v) got %v", arg, value)
+	}
+	if res := checkTemporalTruncate(msFieldTypeTimestamp, string(formatArgValue(arg)), time.UTC); res != TruncatedWarn {
+		t.Fatalf("checkTemporalTruncate of %v got %v", arg, res)
+	}
+	arg = time.Date(2038, 1, 19, 12, 0, 0, 0, loc)
+	if res := checkTemporalTruncate(msFieldTypeTimestamp, string(formatArgValue(arg)), time.UTC); res != Truncated {
+		t.Fatalf("checkTemporalTruncate of %v got %v", arg, res)
+	}
+}
+
+func TestPolicyFieldsLengthTableAliases(t *testing.T) {
+	stmt, err := sqlparser.Parse("update db.t1 as a join (t2 b, (t3)) on a.id = b.id, (select * from t4) as sub set a.c = 1")
+	if err != nil {
+		t.Fatalf("parse failed %v", err)
+	}
+	tables, aliases := collectTableAliases(stmt.(*sqlparser.Update).TableExprs)
+	if strings.Join(tables, ",") != "db.t1,t2,t3" {
+		t.Fatalf("collectTableAliases got tables %v", tables)
+	}
+	expected := map[string]string{"T1": "db.t1", "A": "db.t1", "T2": "t2", "B": "t2", "T3": "t3"}
+	if len(aliases) != len(expected) {
+		t.Fatalf("collectTableAliases got aliases %v", aliases)
+	}
+	for alias, table := range expected {
+		if aliases[alias] != table {
+			t.Fatalf("collectTableAliases got aliases %v", aliases)
+		}
+	}
+
+	columnMaps := map[string]ColumnMap{
+		"db.t1": {"C": &ColumnRecord{}},
+		"t2":    {"D": &ColumnRecord{}},
+	}
+	if table := resolveColumnTable(&sqlparser.ColName{Name: sqlparser.NewColIdent("d")}, tables, aliases, columnMaps); table != "t2" {
+		t.Fatalf("resolveColumnTable of unqualified column got %v", table)
+	}
+	col := &sqlparser.ColName{Name: sqlparser.NewColIdent("c"), Qualifier: sqlparser.TableName{Name: sqlparser.NewTableIdent("a")}}
+	if table := resolveColumnTable(col, tables, aliases, columnMaps); table != "db.t1" {
+		t.Fatalf("resolveColumnTable of qualified column got %v", table)
+	}
+}
+
+func TestPolicyFieldsLengthArgsOfExpr(t *testing.T) {
+	stmt, err := sqlparser.Parse("update t1 set a = concat(?, 'x', ?), b = ? where c = ?")
+	if err != nil {
+		t.Fatalf("parse failed %v", err)
+	}
+	update := stmt.(*sqlparser.Update)
+	args := []interface{}{"a1", "a2", "b", "c"}
+	exprArgs, ok := argsOfExpr(update.Exprs[0].Expr, args)
+	if !ok || len(exprArgs) != 2 || exprArgs[0] != "a1" || exprArgs[1] != "a2" {
+		t.Fatalf("argsOfExpr got %v %v", exprArgs, ok)
+	}
+	if _, ok := argsOfExpr(update.Exprs[0].Expr, args[:1]); ok {
+		t.Fatalf("argsOfExpr should fail since args not enough")
+	}
+
+	if dependsOnColumns(update.Exprs[0].Expr) {
+		t.Fatalf("concat of args should not depend on columns")
+	}
+	stmt, _ = sqlparser.Parse("insert into t1(a) values (1) on duplicate key update a = values(a), b = b + 1")
+	for _, expr := range stmt.(*sqlparser.Insert).OnDup {
+		if !dependsOnColumns(expr.Expr) {
+			t.Fatalf("%v should depend on columns", sqlparser.String(expr.Expr))
+		}
+	}
+}
+
+func TestPolicyFieldsLengthColumnMayTruncate(t *testing.T) {
+	newColumn := func(columnType string, collation string) *ColumnRecord {
+		return &ColumnRecord{
+			Field:     sql.NullString{String: "field1", Valid: true},
+			Type:      sql.NullString{String: columnType, Valid: true},
+			Collation: sql.NullString{String: collation, Valid: collation != ""},
+		}
+	}
+	cases := []struct {
+		source   *ColumnRecord
+		target   *ColumnRecord
+		expected int
+	}{
+		{newColumn("int(11)", ""), newColumn("bigint(20)", ""), NoTruncated},
+		{newColumn("bigint(20)", ""), newColumn("int(11)", ""), TruncatedWarn},
+		{newColumn("int(11)", ""), newColumn("int(11) unsigned", ""), TruncatedWarn},
+		{newColumn("int(10) unsigned", ""), newColumn("int(11)", ""), TruncatedWarn},
+		{newColumn("int(10) unsigned", ""), newColumn("bigint(20)", ""), NoTruncated},
+		{newColumn("decimal(10,2)", ""), newColumn("decimal(12,2)", ""), NoTruncated},
+		{newColumn("decimal(10,4)", ""), newColumn("decimal(12,2)", ""), TruncatedWarn},
+		{newColumn("varchar(20)", "utf8mb4_general_ci"), newColumn("varchar(10)", "utf8mb4_general_ci"), TruncatedWarn},
+		{newColumn("varchar(10)", "latin1_swedish_ci"), newColumn("varchar(10)", "utf8mb4_general_ci"), NoTruncated},
+		{newColumn("text", "utf8mb4_general_ci"), newColumn("varchar(255)", "utf8mb4_general_ci"), TruncatedWarn},
+		{newColumn("varchar(255)", "utf8mb4_general_ci"), newColumn("text", "utf8mb4_general_ci"), NoTruncated},
+		{newColumn("text", "latin1_swedish_ci"), newColumn("text", "utf8mb4_general_ci"), TruncatedWarn},
+		{newColumn("mediumblob", ""), newColumn("blob", ""), TruncatedWarn},
+		{newColumn("datetime", ""), newColumn("varchar(2)", "utf8mb4_general_ci"), NoTruncated},
+	}
+	for _, testCase := range cases {
+		if got := checkIfColumnMayTruncate(testCase.source, testCase.target); got != testCase.expected {
+			t.Fatalf("checkIfColumnMayTruncate(%v, %v) got %v", testCase.source.Type.String, testCase.target.Type.String, got)
+		}
+	}
+}
+
+func TestRawPolicyFieldsLengthDML(t *testing.T) {
+	runRawPolicyTests(t, dsn, func(dbt *DBTest) {
+
+		logmsk.MSKLog().SetOutput(os.Stdout)
+
+		dbt.mustExec("DROP TABLE IF EXISTS test")
+		dbt.mustExec("CREATE TABLE `test` (`id` int(11) NOT NULL, `name` varchar(10) DEFAULT NULL, `note` varchar(30) DEFAULT NULL, PRIMARY KEY (`id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;")
+		dbt.mustExec("CREATE TABLE `test_policy` (`id` int(11) NOT NULL, `name` varchar(4) DEFAULT NULL, `cnt` tinyint(4) DEFAULT NULL, PRIMARY KEY (`id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;")
+
+		npc := NewPolicyCheckWraper(NewPolicyCheckerFieldsLength(), dbt.db)
+		expectCode := func(query string, code PolicyCode, args ...interface{}) {
+			err := npc.Check(query, args...)
+			if code == ErrPolicyCodeSafe {
+				if err != nil {
+					dbt.Errorf("%v should be ok, got %v", query, err)
+				}
+				return
+			}
+			pe, ok := err.(*PolicyError)
+			if !ok || pe.Code != code {
+				dbt.Errorf("%v should be failed with %v, got %v", query, code, err)
+			}
+		}
+
+		// INSERT ... SET 以及 REPLACE
+		expectCode("INSERT INTO test_policy SET id = 1, name = 'abcde'", ErrPolicyCodeDataTruncate)
+		expectCode("REPLACE INTO test_policy(id, name) VALUES (1, 'abcde')", ErrPolicyCodeDataTruncate)
+		expectCode("REPLACE INTO test_policy(id, name) VALUES (1, 'ab')", ErrPolicyCodeSafe)
+
+		// 多行且参数与函数混合，参数按占位符的序号对应
+		expectCode("INSERT INTO test_policy(id, name, cnt) VALUES (?, concat(?, 'x'), 1), (?, ?, ?)",
+			ErrPolicyCodeSafe, 1, "abcdefgh", 2, "ab", 1)
+		expectCode("INSERT INTO test_policy(id, name, cnt) VALUES (?, concat(?, 'x'), 1), (?, ?, ?)",
+			ErrPolicyCodeDataTruncate, 1, "ab", 2, "abcdefgh", 1)
+		expectCode("INSERT INTO test_policy(id, name, cnt) VALUES (?, concat(?, 'x'), 1), (?, ?, ?)",
+			ErrPolicyCodeDataTruncate, 1, "ab", 2, "ab", 128)
+
+		// ON DUPLICATE KEY UPDATE
+		expectCode("INSERT INTO test_policy(id, name) VALUES (1, 'ab') ON DUPLICATE KEY UPDATE name = ?",
+			ErrPolicyCodeDataTruncate, "abcdefgh")
+		expectCode("INSERT INTO test_policy(id, name) VALUES (1, 'ab') ON DUPLICATE KEY UPDATE name = concat('a', 'bcdefg')",
+			ErrPolicyCodeDataTruncate)
+		expectCode("INSERT INTO test_policy(id, name) VALUES (1, 'ab') ON DUPLICATE KEY UPDATE name = VALUES(name), cnt = cnt + 1",
+			ErrPolicyCodeSafe)
+
+		// INSERT ... SELECT
+		expectCode("INSERT INTO test_policy(id, name) SELECT id, name FROM test", WarnPolicyCodeDataTruncate)
+		expectCode("INSERT INTO test_policy(id, name) SELECT t.id, 'abcdefg' FROM test AS t", ErrPolicyCodeDataTruncate)
+		expectCode("INSERT INTO test(id, name) SELECT p.id, p.name FROM test_policy p", ErrPolicyCodeSafe)
+		expectCode("INSERT INTO test(id, name, note) SELECT * FROM test UNION SELECT id, name, name FROM test_policy", ErrPolicyCodeSafe)
+
+		// 多表UPDATE，列通过别名对应到表
+		expectCode("UPDATE test_policy AS p JOIN test AS t ON p.id = t.id SET t.name = 'abcdefg', p.cnt = 1", ErrPolicyCodeSafe)
+		expectCode("UPDATE test_policy AS p JOIN test AS t ON p.id = t.id SET t.name = 'abcd', p.name = ?", ErrPolicyCodeDataTruncate, "abcdefg")
+		expectCode("UPDATE test_policy p, test t SET note = ?, cnt = ? WHERE p.id = t.id AND t.id = ?", ErrPolicyCodeDataTruncate, "ok", 1000, 1)
+		expectCode("UPDATE test_policy p, test t SET p.name = t.name WHERE p.id = t.id", ErrPolicyCodeSafe)
+
+		dbt.mustExec("DROP TABLE IF EXISTS test_policy")
+		dbt.mustExec("DROP TABLE IF EXISTS test")
+
+		logmsk.MSKLog().SetOutput(ioutil.Discard)
+	})
+}
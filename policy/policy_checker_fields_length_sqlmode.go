@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"strings"
+	"time"
+)
+
+/*
+
+sql_mode相关的告警级别
+
+严格模式（STRICT_TRANS_TABLES或STRICT_ALL_TABLES）下，截断等写入会直接报错，应用在运行时会收到错误，
+告警为ErrPolicyCodeDataTruncateStrict；非严格模式下数据被静默地截断或转换为0、''，告警为ErrPolicyCodeDataTruncate。
+
+INSERT IGNORE在严格模式下同样把错误降级为warning，按非严格模式处理。
+sql_mode取自各连接（DSN）的@@SESSION.sql_mode，按*sql.DB缓存SQLModeCacheExpire。
+
+TIMESTAMP的字面值按会话的time_zone解释后再与UTC的范围比较，time_zone同sql_mode一起查询并缓存。
+会话时区以查询时NOW()与UTC_TIMESTAMP()的差值近似，不考虑夏令时的切换。
+
+*/
+
+const (
+	SQLModeStrictTransTables = "STRICT_TRANS_TABLES"
+	SQLModeStrictAllTables   = "STRICT_ALL_TABLES"
+)
+
+var SQLModeCacheExpire = 1 * time.Minute
+
+type sqlModeEntry struct {
+	sqlMode  string
+	timeZone *time.Location
+	expireAt time.Time
+}
+
+func IsStrictSQLMode(sqlMode string) bool {
+	for _, mode := range strings.Split(strings.ToUpper(sqlMode), ",") {
+		mode = strings.TrimSpace(mode)
+		if mode == SQLModeStrictTransTables || mode == SQLModeStrictAllTables {
+			return true
+		}
+	}
+	return false
+}
+
+// 返回会话的sql_mode及time_zone相对UTC的偏移（秒）
+func querySQLMode(db *sql.DB, timeout time.Duration) (string, int, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// 针对 mysql 5.7.x 版本在context方面的bug，workaround
+	if notSupportContext {
+		timeout = timeout * 100
+	}
+	defer time.AfterFunc(timeout, cancel).Stop()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		_ = safeRollback("querySQLMode() rollback", tx)
+	}()
+
+	var sqlMode sql.NullString
+	var offset sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT @@SESSION.sql_mode, TIME_TO_SEC(TIMEDIFF(NOW(), UTC_TIMESTAMP()))").
+		Scan(&sqlMode, &offset)
+	if err != nil {
+		return "", 0, err
+	}
+	return sqlMode.String, int(offset.Int64), tx.Commit()
+}
+
+// db对应连接的sql_mode及time_zone，查询失败时按非严格模式及UTC处理
+func (pcri *PolicyCheckerFieldsLength) sessionOf(db *sql.DB) sqlModeEntry {
+	pcri.mutex.Lock()
+	entry, ok := pcri.sqlModes[db]
+	pcri.mutex.Unlock()
+	if ok && time.Now().Before(entry.expireAt) {
+		return entry
+	}
+
+	sqlMode, offset, err := querySQLMode(db, MaxTimeoutOfExplain)
+	if err != nil {
+		log.MSKLog().Warnf("PolicyCheckerFieldsLength:sessionOf(%v) querySQLMode failed %v", db, err)
+		return sqlModeEntry{timeZone: time.UTC}
+	}
+
+	entry = sqlModeEntry{sqlMode: sqlMode, timeZone: time.UTC, expireAt: time.Now().Add(SQLModeCacheExpire)}
+	if offset != 0 {
+		entry.timeZone = time.FixedZone("session", offset)
+	}
+	pcri.mutex.Lock()
+	if pcri.sqlModes == nil {
+		pcri.sqlModes = make(map[*sql.DB]sqlModeEntry)
+	}
+	pcri.sqlModes[db] = entry
+	pcri.mutex.Unlock()
+	return entry
+}
+
+func (pcri *PolicyCheckerFieldsLength) sqlModeOf(db *sql.DB) string {
+	return pcri.sessionOf(db).sqlMode
+}
+
+func (pcri *PolicyCheckerFieldsLength) timeZoneOf(db *sql.DB) *time.Location {
+	return pcri.sessionOf(db).timeZone
+}
+
+// 根据sql_mode调整ErrPolicyCodeDataTruncate的告警码及描述
+func withSQLModeSeverity(err error, sqlMode string, ignore bool) error {
+	pe, ok := err.(*PolicyError)
+	if !ok || pe.Code != ErrPolicyCodeDataTruncate {
+		return err
+	}
+	if IsStrictSQLMode(sqlMode) && !ignore {
+		return NewPolicyError(ErrPolicyCodeDataTruncateStrict,
+			fmt.Sprintf("Statement will fail at runtime under strict sql_mode(%v): %v", sqlMode, pe.Msg))
+	}
+	return NewPolicyError(ErrPolicyCodeDataTruncate,
+		fmt.Sprintf("Data will be silently corrupted under non-strict sql_mode(%v): %v", sqlMode, pe.Msg))
+}
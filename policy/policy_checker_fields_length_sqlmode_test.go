@@ -0,0 +1,84 @@
+package policy
+
+import (
+	logmsk "gitlab.papegames.com/fringe/mskeeper/log"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPolicyFieldsLengthStrictSQLMode(t *testing.T) {
+	modes := map[string]bool{
+		"":                       false,
+		"NO_ENGINE_SUBSTITUTION": false,
+		"ONLY_FULL_GROUP_BY,STRICT_TRANS_TABLES,NO_ZERO_IN_DATE,NO_ZERO_DATE": true,
+		"strict_all_tables":    true,
+		"STRICT_TRANS_TABLESX": false,
+	}
+	for sqlMode, strict := range modes {
+		if IsStrictSQLMode(sqlMode) != strict {
+			t.Fatalf("IsStrictSQLMode(%v) should be %v", sqlMode, strict)
+		}
+	}
+}
+
+func TestPolicyFieldsLengthSQLModeSeverity(t *testing.T) {
+	truncated := NewPolicyError(ErrPolicyCodeDataTruncate, "Possible data fields overflow on table t")
+
+	err := withSQLModeSeverity(truncated, "STRICT_TRANS_TABLES", false)
+	pe := err.(*PolicyError)
+	if pe.Code != ErrPolicyCodeDataTruncateStrict || !strings.Contains(pe.Msg, "fail at runtime") {
+		t.Fatalf("strict sql_mode got %v", err)
+	}
+
+	err = withSQLModeSeverity(truncated, "STRICT_TRANS_TABLES", true)
+	pe = err.(*PolicyError)
+	if pe.Code != ErrPolicyCodeDataTruncate || !strings.Contains(pe.Msg, "silently corrupted") {
+		t.Fatalf("strict sql_mode with IGNORE got %v", err)
+	}
+
+	err = withSQLModeSeverity(truncated, "NO_ENGINE_SUBSTITUTION", false)
+	pe = err.(*PolicyError)
+	if pe.Code != ErrPolicyCodeDataTruncate || !strings.Contains(pe.Msg, "silently corrupted") {
+		t.Fatalf("non-strict sql_mode got %v", err)
+	}
+
+	// 接近上限的告警与sql_mode无关
+	edge := NewPolicyError(WarnPolicyCodeDataTruncate, "Possible data fields near the edge of overflow on table t")
+	if err := withSQLModeSeverity(edge, "STRICT_TRANS_TABLES", false); err != edge {
+		t.Fatalf("warn of data truncation should not be changed, got %v", err)
+	}
+}
+
+func TestRawPolicyFieldsLengthSQLMode(t *testing.T) {
+	cases := []struct {
+		dsn   string
+		query string
+		code  PolicyCode
+	}{
+		{dsn: dsn + "&sql_mode='STRICT_TRANS_TABLES'", query: "INSERT INTO test_policy(value) VALUES ('123')", code: ErrPolicyCodeDataTruncateStrict},
+		{dsn: dsn + "&sql_mode='STRICT_TRANS_TABLES'", query: "INSERT IGNORE INTO test_policy(value) VALUES ('123')", code: ErrPolicyCodeDataTruncate},
+		{dsn: dsn + "&sql_mode=''", query: "INSERT INTO test_policy(value) VALUES ('123')", code: ErrPolicyCodeDataTruncate},
+		{dsn: dsn + "&sql_mode=''", query: "UPDATE test_policy SET value = '123'", code: ErrPolicyCodeDataTruncate},
+	}
+	for _, testCase := range cases {
+		runRawPolicyTests(t, testCase.dsn, func(dbt *DBTest) {
+
+			logmsk.MSKLog().SetOutput(os.Stdout)
+
+			dbt.mustExec("CREATE TABLE `test_policy` (`value` varchar(2) DEFAULT NULL) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+
+			npc := NewPolicyCheckWraper(NewPolicyCheckerFieldsLength(), dbt.db)
+			err := npc.Check(testCase.query)
+			pe, ok := err.(*PolicyError)
+			if !ok || pe.Code != testCase.code {
+				dbt.Errorf("%v with %v should be failed with %v, got %v", testCase.query, testCase.dsn, testCase.code, err)
+			}
+
+			dbt.mustExec("DROP TABLE IF EXISTS test_policy")
+
+			logmsk.MSKLog().SetOutput(ioutil.Discard)
+		})
+	}
+}
@@ -111,14 +111,14 @@ func TestRawPolicyFieldsLengthOpening(t *testing.T) {
 
 		// For Update (table1, table2)
 		err = npc.Check("UPDATE (test, test_policy) set test_policy.value = '123'")
-		if err != nil {
-			dbt.Errorf("should be ok since Update (table1, table2), ignore")
+		if err == nil {
+			dbt.Errorf("should be failed since truncated!!!")
 		}
 
 		// For Update ((table1, table2))
 		err = npc.Check("UPDATE ((test, test_policy)) set test_policy.value = '123'")
-		if err != nil {
-			dbt.Errorf("should be ok since Update (table1, table2), ignore")
+		if err == nil {
+			dbt.Errorf("should be failed since truncated!!!")
 		}
 
 		// For Update (table1, table2)
@@ -133,14 +133,14 @@ func TestRawPolicyFieldsLengthOpening(t *testing.T) {
 
 		// For Update table join table
 		err = npc.Check("update test_policy join test on test_policy.value1 = test.value1 set test_policy.value = '123';")
-		if err != nil {
-			dbt.Errorf("should be ok since update table join table, ignore")
+		if err == nil {
+			dbt.Errorf("should be failed since truncated!!!")
 		}
 
 		// For Update (table join table)
 		err = npc.Check("update (test_policy join test on test_policy.value1 = test.value1) set test_policy.value = '123';")
-		if err != nil {
-			dbt.Errorf("should be ok since Update (table join table), ignore")
+		if err == nil {
+			dbt.Errorf("should be failed since truncated!!!")
 		}
 
 		dbt.mustExec("DROP TABLE IF EXISTS test_policy")
@@ -786,7 +786,7 @@ func TestCheckValueLengthBy(t *testing.T) {
 
 	var argIdx int
 	argIdx = 0
-	err := pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args, &argIdx)
+	err := pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args, &argIdx, time.UTC)
 	log.Printf("err %v", err)
 	if err == nil {
 		t.Fatalf("checkValueLengthBy (  args0[0].([]interface{}) ) should fail")
@@ -794,7 +794,7 @@ func TestCheckValueLengthBy(t *testing.T) {
 
 	argIdx = 0
 	args1 := []string{"1", "2", "3"}
-	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args1, &argIdx)
+	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args1, &argIdx, time.UTC)
 	log.Printf("err %v", err)
 	if err == nil {
 		t.Fatalf("checkValueLengthBy ( args1.([]interface{}) ) should fail")
@@ -807,7 +807,7 @@ func TestCheckValueLengthBy(t *testing.T) {
 
 	argIdx = 0
 	args2 := []interface{}{"1", "2", "3"}
-	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx)
+	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx, time.UTC)
 	log.Printf("err %v", err)
 	if err == nil {
 		t.Fatalf("checkValueLengthBy ( len(argsSlice) 3 != argCnt 1  ) should fail")
@@ -819,7 +819,7 @@ func TestCheckValueLengthBy(t *testing.T) {
 
 	argIdx = 0
 	args2 = []interface{}{"1"}
-	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx)
+	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx, time.UTC)
 	log.Printf("err %v", err)
 	if err == nil {
 		t.Fatalf("checkValueLengthBy (  len(columnSlice) 3 != len(valueSlice) 2  ) should fail")
@@ -829,7 +829,7 @@ func TestCheckValueLengthBy(t *testing.T) {
 		sqlparser.NewIntVal([]byte("123")),
 		sqlparser.NewIntVal([]byte("128")),
 		sqlparser.NewValArg([]byte("11"))}
-	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx)
+	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx, time.UTC)
 	log.Printf("err %v", err)
 
 	argIdx = 0
@@ -838,7 +838,7 @@ func TestCheckValueLengthBy(t *testing.T) {
 		sqlparser.NewIntVal([]byte("128")),
 		sqlparser.NewValArg([]byte("11")),
 		sqlparser.NewIntVal([]byte("128"))}
-	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx)
+	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx, time.UTC)
 	log.Printf("err %v", err)
 
 	// "128" of field2 is larger than tinyint's max signed int 127
@@ -853,7 +853,7 @@ func TestCheckValueLengthBy(t *testing.T) {
 		sqlparser.NewValArg([]byte("11"))}
 
 	args2 = []interface{}{"11"}
-	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx)
+	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx, time.UTC)
 	log.Printf("err %v", err)
 	//  len(argsSlice) < argCnt
 	if err == nil {
@@ -873,7 +873,7 @@ func TestCheckValueLengthBy(t *testing.T) {
 		sqlparser.NewIntVal([]byte("123")),
 		sqlparser.NewValArg([]byte("11")),
 		sqlparser.NewIntVal([]byte("123"))}
-	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx)
+	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx, time.UTC)
 	log.Printf("err %v", err)
 
 	log.Printf("err = 1111 %v", err)
@@ -887,7 +887,7 @@ func TestCheckValueLengthBy(t *testing.T) {
 		sqlparser.NewIntVal([]byte("127")),
 		sqlparser.NewStrVal([]byte("123456789")), // defaultly 0.8
 		sqlparser.NewIntVal([]byte("123"))}
-	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx)
+	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx, time.UTC)
 	log.Printf("err %v", err)
 
 	if err != WarnFieldDataMayTruncated {
@@ -900,7 +900,7 @@ func TestCheckValueLengthBy(t *testing.T) {
 		sqlparser.NewIntVal([]byte("127")),
 		sqlparser.NewStrVal([]byte("12345678")),
 		sqlparser.NewIntVal([]byte("123"))}
-	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx)
+	err = pcfl.checkValueLengthBy(columnSlice, valueSlice, columnTypeValueMap, args2, &argIdx, time.UTC)
 	log.Printf("err %v", err)
 
 	if err != nil {
@@ -916,7 +916,7 @@ func TestCheckIfMySQLTruncate(t *testing.T) {
 	sqlV := sqlparser.NewIntVal([]byte("123"))
 	value := []byte("123")
 
-	truncation := pcfl.checkIfMySQLTruncate(cr, sqlV, value)
+	truncation := pcfl.checkIfMySQLTruncate(cr, sqlV, value, time.UTC)
 	if truncation == Truncated {
 		t.Fatalf("checkIfMySQLTruncate(%v, %v, %v) failed", cr, sqlV, value)
 	}
@@ -924,7 +924,7 @@ func TestCheckIfMySQLTruncate(t *testing.T) {
 	cr = &ColumnRecord{Field: sql.NullString{String: "field1", Valid: true}, Type: sql.NullString{String: "tinyint(11)", Valid: true}}
 	sqlV = sqlparser.NewIntVal([]byte("1")) // meaningless
 	value = []byte("256")                   // truncated
-	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value)
+	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value, time.UTC)
 	if truncation == NoTruncated {
 		t.Fatalf("checkIfMySQLTruncate(%v, %v, %v) failed", cr, sqlV, value)
 	}
@@ -932,14 +932,14 @@ func TestCheckIfMySQLTruncate(t *testing.T) {
 	cr = &ColumnRecord{Field: sql.NullString{String: "field1", Valid: true}, Type: sql.NullString{String: "tinyint((a)", Valid: true}}
 	sqlV = sqlparser.NewIntVal([]byte("1")) // meaningless
 	value = []byte("256")
-	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value)
+	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value, time.UTC)
 
 	// bad hex val
 	cr = &ColumnRecord{Field: sql.NullString{String: "field1", Valid: true}, Type: sql.NullString{String: "varchar(11)", Valid: true}}
 	sqlV = sqlparser.NewHexVal([]byte("!!@@@@@"))
 	value = []byte("123")
 
-	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value)
+	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value, time.UTC)
 	if truncation == Truncated {
 		t.Fatalf("checkIfMySQLTruncate(%v, %v, %v) failed", cr, sqlV, value)
 	}
@@ -949,7 +949,7 @@ func TestCheckIfMySQLTruncate(t *testing.T) {
 	sqlV = sqlparser.NewIntVal([]byte("1")) // meaningless
 	value = []byte("1.2.3.4")
 
-	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value)
+	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value, time.UTC)
 	if truncation == Truncated {
 		t.Fatalf("checkIfMySQLTruncate(%v, %v, %v) failed", cr, sqlV, value)
 	}
@@ -962,7 +962,7 @@ func TestCheckIfMySQLTruncate(t *testing.T) {
 	cr = &ColumnRecord{Field: sql.NullString{String: "field1", Valid: true}, Type: sql.NullString{String: "tinyint*8389@@@)", Valid: true}}
 	sqlV = sqlparser.NewIntVal([]byte("1")) // meaningless
 	value = []byte("256")
-	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value)
+	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value, time.UTC)
 
 	// no truncation because of bad format of columnrecord 2
 	if truncation == Truncated {
@@ -973,7 +973,7 @@ func TestCheckIfMySQLTruncate(t *testing.T) {
 	cr = &ColumnRecord{Field: sql.NullString{String: "field1", Valid: true}, Type: sql.NullString{String: "tinyint(10)", Valid: true}}
 	sqlV = sqlparser.NewIntVal([]byte("1")) // meaningless
 	value = []byte("-25a")
-	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value)
+	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value, time.UTC)
 
 	// unknown type, no truncation
 	if truncation == Truncated {
@@ -984,7 +984,7 @@ func TestCheckIfMySQLTruncate(t *testing.T) {
 	cr = &ColumnRecord{Field: sql.NullString{String: "field1", Valid: true}, Type: sql.NullString{String: "tinyint(10)", Valid: true}}
 	sqlV = sqlparser.NewIntVal([]byte("1")) // meaningless
 	value = []byte("25a")
-	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value)
+	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value, time.UTC)
 
 	// unknown type, no truncation
 	if truncation == Truncated {
@@ -994,7 +994,7 @@ func TestCheckIfMySQLTruncate(t *testing.T) {
 	// nil SQLVAl
 	cr = &ColumnRecord{Field: sql.NullString{String: "field1", Valid: true}, Type: sql.NullString{String: "tinyint(10)", Valid: true}}
 	value = []byte("25a")
-	truncation = pcfl.checkIfMySQLTruncate(cr, nil, value)
+	truncation = pcfl.checkIfMySQLTruncate(cr, nil, value, time.UTC)
 
 	// nil SQLVal, no truncation
 	if truncation == Truncated {
@@ -1005,7 +1005,7 @@ func TestCheckIfMySQLTruncate(t *testing.T) {
 	cr = &ColumnRecord{Field: sql.NullString{String: "field1", Valid: true}, Type: sql.NullString{String: "tinyint(11) unsigned", Valid: true}}
 	sqlV = sqlparser.NewIntVal([]byte("1")) // meaningless
 	value = []byte("256")                   // truncated
-	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value)
+	truncation = pcfl.checkIfMySQLTruncate(cr, sqlV, value, time.UTC)
 	if truncation != Truncated {
 		t.Fatalf("checkIfMySQLTruncate(%v, %v, %v) failed", cr, sqlV, value)
 	}
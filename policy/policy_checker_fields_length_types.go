@@ -0,0 +1,436 @@
+package policy
+
+import (
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+DECIMAL、ENUM、SET、FLOAT/DOUBLE、BIT以及时间类型的截断检测。
+
+REF: https://dev.mysql.com/doc/refman/5.7/en/fixed-point-types.html
+     https://dev.mysql.com/doc/refman/5.7/en/floating-point-types.html
+     https://dev.mysql.com/doc/refman/5.7/en/bit-type.html
+     https://dev.mysql.com/doc/refman/5.7/en/enum.html
+     https://dev.mysql.com/doc/refman/5.7/en/set.html
+     https://dev.mysql.com/doc/refman/5.7/en/datetime.html
+
+1. DECIMAL(M,D): 整数部分位数 > M-D 则溢出, 小数部分位数 > D 则被四舍五入（告警）
+2. FLOAT/DOUBLE: 超出单/双精度的范围则溢出, FLOAT(M,D)/DOUBLE(M,D) 同DECIMAL
+3. BIT(M): 值的二进制位数 > M 则溢出, M < 16时多为标志位, 不做比例告警
+4. ENUM/SET: 值（忽略大小写及尾部空格）不在声明的成员内，或数字下标/位掩码超出成员数则被存为''
+5. DATE/DATETIME/TIMESTAMP/TIME/YEAR: 非法的日期（例如2月30日）或超出范围被存为零值
+   TIMESTAMP的范围为 '1970-01-01 00:00:01' UTC 到 '2038-01-19 03:14:07' UTC，字面值按会话的time_zone解释
+
+数值类型同样以uplimit（默认DataTruncationUplimit）作为告警的比例，TIMESTAMP在距离2038的上限不足
+TimestampWarnPeriod时告警。
+
+*/
+
+var (
+	TimestampWarnPeriod = 365 * 24 * time.Hour // TIMESTAMP距离2038的上限不足一年则告警
+
+	timestampMin = time.Date(1970, 1, 1, 0, 0, 1, 0, time.UTC)
+	timestampMax = time.Date(2038, 1, 19, 3, 14, 7, 0, time.UTC)
+
+	temporalDelimitedReg = regexp.MustCompile(`^(\d{2,4})[^\d](\d{1,2})[^\d](\d{1,2})(?:[ T]+(\d{1,2})[^\d](\d{1,2})(?:[^\d](\d{1,2})(?:\.\d*)?)?)?$`)
+	temporalCompactReg   = regexp.MustCompile(`^(\d{4})(\d{2})(\d{2})(?:(\d{2})(\d{2})(\d{2}))?(?:\.\d*)?$`)
+	timeCompactReg       = regexp.MustCompile(`^-?(\d{1,7})(?:\.\d*)?$`) // 'HHMMSS'、'MMSS'、'SS'
+	timeReg              = regexp.MustCompile(`^(-)?(?:(\d+) )?(\d+):(\d{1,2})(?::(\d{1,2}))?(?:\.\d*)?$`)
+)
+
+// 解析SHOW COLUMNS中的Type，eg. decimal(10,2) unsigned zerofill => decimal, "10,2", true
+func parseColumnType(columnType string) (string, string, bool) {
+	columnType = strings.TrimSpace(columnType)
+	typeEnd := strings.IndexAny(columnType, "( ")
+	if typeEnd == -1 {
+		return strings.ToLower(columnType), "", false
+	}
+	typeString := strings.ToLower(columnType[:typeEnd])
+	params := ""
+	rest := columnType[typeEnd:]
+	if strings.HasPrefix(rest, "(") {
+		end := strings.LastIndex(rest, ")")
+		if end == -1 {
+			return typeString, "", false
+		}
+		params = rest[1:end]
+		rest = rest[end+1:]
+	}
+	unsigned := strings.Contains(strings.ToLower(rest), "unsigned")
+	return typeString, params, unsigned
+}
+
+// 解析 (M,D) 形式的参数，缺省返回 defM, defD
+func parsePrecisionAndScale(params string, defM, defD int) (int, int) {
+	if params == "" {
+		return defM, defD
+	}
+	ps := strings.Split(params, ",")
+	m, err := strconv.Atoi(strings.TrimSpace(ps[0]))
+	if err != nil {
+		return defM, defD
+	}
+	d := 0
+	if len(ps) > 1 {
+		d, _ = strconv.Atoi(strings.TrimSpace(ps[1]))
+	}
+	return m, d
+}
+
+// 解析ENUM/SET的成员, eg. 'a','d,e' => [a, d,e], 成员内的单引号转义为两个单引号
+func parseEnumMembers(params string) []string {
+	members := []string{}
+	var member []byte
+	inQuote := false
+	for i := 0; i < len(params); i++ {
+		c := params[i]
+		switch {
+		case !inQuote && c == '\'':
+			inQuote = true
+			member = []byte{}
+		case inQuote && c == '\'' && i+1 < len(params) && params[i+1] == '\'':
+			member = append(member, '\'')
+			i++
+		case inQuote && c == '\\' && i+1 < len(params):
+			member = append(member, params[i+1])
+			i++
+		case inQuote && c == '\'':
+			inQuote = false
+			members = append(members, string(member))
+		case inQuote:
+			member = append(member, c)
+		}
+	}
+	return members
+}
+
+func normalizeEnumMember(member string) string {
+	return strings.ToLower(strings.TrimRight(member, " "))
+}
+
+// 把十进制数（允许科学计数法）拆分为符号、整数部分及小数部分的有效数字
+func splitDecimalDigits(value string) (bool, string, string, bool) {
+	value = strings.TrimSpace(value)
+	if strings.ContainsAny(value, "eE") {
+		f, _, err := big.ParseFloat(value, 10, 256, big.ToNearestEven)
+		if err != nil {
+			return false, "", "", false
+		}
+		value = f.Text('f', -1)
+	}
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimLeft(value, "+-")
+	intPart, fracPart := value, ""
+	if idx := strings.Index(value, "."); idx != -1 {
+		intPart, fracPart = value[:idx], value[idx+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return false, "", "", false
+	}
+	for _, part := range []string{intPart, fracPart} {
+		for i := 0; i < len(part); i++ {
+			if part[i] < '0' || part[i] > '9' {
+				return false, "", "", false
+			}
+		}
+	}
+	return negative, strings.TrimLeft(intPart, "0"), strings.TrimRight(fracPart, "0"), true
+}
+
+// 定点数(M,D)的检测，同样适用于FLOAT(M,D)/DOUBLE(M,D)
+func checkPrecisionAndScale(m, d int, unsigned bool, value string, uplimit float64, roundingWarn bool) int {
+	negative, intPart, fracPart, ok := splitDecimalDigits(value)
+	if !ok {
+		return NoTruncated
+	}
+	if unsigned && negative && (intPart != "" || fracPart != "") {
+		return Truncated
+	}
+	if len(intPart) > m-d {
+		return Truncated
+	}
+	// 整数部分接近 10^(M-D) 的上限
+	if m-d > 0 && intPart != "" {
+		intVal, _ := strconv.ParseFloat(intPart, 64)
+		if intVal > math.Pow(10, float64(m-d))*uplimit {
+			return TruncatedWarn
+		}
+	}
+	if roundingWarn && len(fracPart) > d {
+		return TruncatedWarn
+	}
+	return NoTruncated
+}
+
+func checkDecimalTruncate(params string, unsigned bool, value string, uplimit float64) int {
+	m, d := parsePrecisionAndScale(params, 10, 0)
+	return checkPrecisionAndScale(m, d, unsigned, value, uplimit, true)
+}
+
+func checkFloatTruncate(typeString string, params string, unsigned bool, value string, uplimit float64) int {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return Truncated
+		}
+		return NoTruncated
+	}
+	if unsigned && f < 0 {
+		return Truncated
+	}
+	// FLOAT(p) 中 p > 24 时为DOUBLE
+	maxValue := math.MaxFloat64
+	if typeString == msFieldTypeFloat {
+		maxValue = math.MaxFloat32
+		if p, _ := parsePrecisionAndScale(params, 0, 0); p > 24 && !strings.Contains(params, ",") {
+			maxValue = math.MaxFloat64
+		}
+	}
+	if math.Abs(f) > maxValue {
+		return Truncated
+	}
+	if strings.Contains(params, ",") {
+		m, d := parsePrecisionAndScale(params, 0, 0)
+		return checkPrecisionAndScale(m, d, unsigned, value, uplimit, false)
+	}
+	if math.Abs(f) > maxValue*uplimit {
+		return TruncatedWarn
+	}
+	return NoTruncated
+}
+
+// value的二进制值，BitVal为b'0101'中的0101，HexVal为x'0f'中的0f
+func bitValueOf(valType sqlparser.ValType, value []byte) (*big.Int, bool) {
+	v := new(big.Int)
+	switch valType {
+	case sqlparser.BitVal:
+		_, ok := v.SetString(string(value), 2)
+		return v, ok
+	case sqlparser.HexVal:
+		_, ok := v.SetString(string(value), 16)
+		return v, ok
+	case sqlparser.HexNum:
+		_, ok := v.SetString(strings.TrimPrefix(strings.ToLower(string(value)), "0x"), 16)
+		return v, ok
+	case sqlparser.IntVal:
+		_, ok := v.SetString(string(value), 10)
+		return v, ok
+	case sqlparser.ValArg:
+		// 参数为数字时按数字处理，否则按二进制串处理
+		if _, ok := v.SetString(string(value), 10); ok {
+			return v, true
+		}
+		return v.SetBytes(value), true
+	default:
+		return v.SetBytes(value), true
+	}
+}
+
+func checkBitTruncate(params string, valType sqlparser.ValType, value []byte, uplimit float64) int {
+	m, _ := parsePrecisionAndScale(params, 1, 0)
+	v, ok := bitValueOf(valType, value)
+	if !ok {
+		return NoTruncated
+	}
+	if v.Sign() < 0 || v.BitLen() > m {
+		return Truncated
+	}
+	// BIT(M)较小时多用作标志位，所有位都置1是正常的
+	if m < 16 {
+		return NoTruncated
+	}
+	maxValue := new(big.Float).SetInt(new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(m)), big.NewInt(1)))
+	if new(big.Float).SetInt(v).Cmp(maxValue.Mul(maxValue, big.NewFloat(uplimit))) > 0 {
+		return TruncatedWarn
+	}
+	return NoTruncated
+}
+
+func isUnsignedNumber(value string) bool {
+	if value == "" {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func checkEnumTruncate(params string, value string) int {
+	members := parseEnumMembers(params)
+	for i := 0; i < len(members); i++ {
+		if normalizeEnumMember(members[i]) == normalizeEnumMember(value) {
+			return NoTruncated
+		}
+	}
+	// 数字作为成员的下标，从1开始, 0为错误值''
+	if isUnsignedNumber(value) {
+		idx, err := strconv.ParseUint(value, 10, 64)
+		if err == nil && idx >= 1 && idx <= uint64(len(members)) {
+			return NoTruncated
+		}
+	}
+	return Truncated
+}
+
+func checkSetTruncate(params string, value string) int {
+	members := parseEnumMembers(params)
+	// 数字作为成员的位掩码
+	if isUnsignedNumber(value) {
+		mask, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || (len(members) < 64 && mask >= uint64(1)<<uint(len(members))) {
+			return Truncated
+		}
+		return NoTruncated
+	}
+	if value == "" {
+		return NoTruncated
+	}
+	memberSet := map[string]struct{}{}
+	for i := 0; i < len(members); i++ {
+		memberSet[normalizeEnumMember(members[i])] = struct{}{}
+	}
+	for _, v := range strings.Split(value, ",") {
+		if _, ok := memberSet[normalizeEnumMember(v)]; !ok {
+			return Truncated
+		}
+	}
+	return NoTruncated
+}
+
+func daysIn(year, month int) int {
+	return time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// 解析日期时间, 返回是否为合法的日期以及是否为零值 '0000-00-00'
+func parseTemporal(value string) (time.Time, bool, bool) {
+	value = strings.TrimSpace(value)
+	parts := temporalDelimitedReg.FindStringSubmatch(value)
+	if parts == nil {
+		parts = temporalCompactReg.FindStringSubmatch(value)
+	}
+	if parts == nil {
+		return time.Time{}, false, false
+	}
+	nums := make([]int, 6)
+	for i := 1; i < len(parts) && i <= 6; i++ {
+		nums[i-1], _ = strconv.Atoi(parts[i])
+	}
+	year, month, day, hour, minute, second := nums[0], nums[1], nums[2], nums[3], nums[4], nums[5]
+	if len(parts[1]) == 2 {
+		// 两位的年份: 70-99 => 1970-1999, 00-69 => 2000-2069
+		if year >= 70 {
+			year += 1900
+		} else {
+			year += 2000
+		}
+	}
+	if year == 0 && month == 0 && day == 0 {
+		return time.Time{}, true, true
+	}
+	if month < 1 || month > 12 || day < 1 || day > daysIn(year, month) ||
+		hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, false, false
+	}
+	return time.Date(year, time.Month(month), day, hour, minute, second, 0, time.UTC), true, false
+}
+
+func checkTimeTruncate(value string, uplimit float64) int {
+	value = strings.TrimSpace(value)
+	if compact := timeCompactReg.FindStringSubmatch(value); compact != nil {
+		// 没有分隔符的数字从右往左依次为秒、分、时，eg. '123045' => 12:30:45，'10' => 00:00:10
+		digits := compact[1]
+		if len(digits) < 6 {
+			digits = strings.Repeat("0", 6-len(digits)) + digits
+		}
+		hours, _ := strconv.Atoi(digits[:len(digits)-4])
+		minutes, _ := strconv.Atoi(digits[len(digits)-4 : len(digits)-2])
+		seconds, _ := strconv.Atoi(digits[len(digits)-2:])
+		return checkTimeRange(hours, minutes, seconds, uplimit)
+	}
+	parts := timeReg.FindStringSubmatch(value)
+	if parts == nil {
+		// 也可能是 'YYYY-MM-DD HH:MM:SS' 形式，取时间部分
+		if _, ok, _ := parseTemporal(value); ok {
+			return NoTruncated
+		}
+		return Truncated
+	}
+	days, _ := strconv.Atoi(parts[2])
+	hours, _ := strconv.Atoi(parts[3])
+	minutes, _ := strconv.Atoi(parts[4])
+	seconds, _ := strconv.Atoi(parts[5])
+	hours += days * 24
+	return checkTimeRange(hours, minutes, seconds, uplimit)
+}
+
+func checkTimeRange(hours, minutes, seconds int, uplimit float64) int {
+	if minutes > 59 || seconds > 59 || hours > 838 {
+		return Truncated
+	}
+	if float64(hours) > 838*uplimit {
+		return TruncatedWarn
+	}
+	return NoTruncated
+}
+
+func checkYearTruncate(value string) int {
+	value = strings.TrimSpace(value)
+	if !isUnsignedNumber(value) {
+		if _, ok, _ := parseTemporal(value); ok {
+			return NoTruncated
+		}
+		return Truncated
+	}
+	year, _ := strconv.Atoi(value)
+	// 1-2位的年份会被转换, 0 及 0000 为零值
+	if len(value) <= 2 || year == 0 {
+		return NoTruncated
+	}
+	if year < 1901 || year > 2155 {
+		return Truncated
+	}
+	return NoTruncated
+}
+
+// loc为会话的time_zone，仅影响TIMESTAMP的范围检测
+func checkTemporalTruncate(typeString string, value string, loc *time.Location) int {
+	switch typeString {
+	case msFieldTypeYear:
+		return checkYearTruncate(value)
+	}
+
+	t, ok, zero := parseTemporal(value)
+	if !ok {
+		return Truncated
+	}
+	if zero {
+		return NoTruncated
+	}
+	switch typeString {
+	case msFieldTypeDate, msFieldTypeDateTime:
+		if t.Year() < 1000 {
+			return Truncated
+		}
+	case msFieldTypeTimestamp:
+		if loc != nil {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+		}
+		if t.Before(timestampMin) || t.After(timestampMax) {
+			return Truncated
+		}
+		if timestampMax.Sub(t) < TimestampWarnPeriod {
+			return TruncatedWarn
+		}
+	}
+	return NoTruncated
+}
@@ -0,0 +1,235 @@
+package policy
+
+import (
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+	"testing"
+	"time"
+)
+
+func TestParseColumnType(t *testing.T) {
+	cases := []struct {
+		columnType string
+		typeString string
+		params     string
+		unsigned   bool
+	}{
+		{"int(11) unsigned", "int", "11", true},
+		{"decimal(10,2) unsigned zerofill", "decimal", "10,2", true},
+		{"enum('a','b(c)')", "enum", "'a','b(c)'", false},
+		{"datetime", "datetime", "", false},
+		{"double unsigned", "double", "", true},
+	}
+	for _, c := range cases {
+		typeString, params, unsigned := parseColumnType(c.columnType)
+		if typeString != c.typeString || params != c.params || unsigned != c.unsigned {
+			t.Errorf("parseColumnType(%v) = (%v, %v, %v), expected (%v, %v, %v)",
+				c.columnType, typeString, params, unsigned, c.typeString, c.params, c.unsigned)
+		}
+	}
+}
+
+func TestParseEnumMembers(t *testing.T) {
+	members := parseEnumMembers("'a','b''c','d,e',''")
+	expected := []string{"a", "b'c", "d,e", ""}
+	if len(members) != len(expected) {
+		t.Fatalf("parseEnumMembers got %v, expected %v", members, expected)
+	}
+	for i := 0; i < len(expected); i++ {
+		if members[i] != expected[i] {
+			t.Errorf("parseEnumMembers got %v, expected %v", members, expected)
+		}
+	}
+}
+
+func TestCheckDecimalTruncate(t *testing.T) {
+	cases := []struct {
+		params   string
+		unsigned bool
+		value    string
+		expected int
+	}{
+		{"10,2", false, "12345678.12", NoTruncated},
+		{"10,2", false, "123456789.1", Truncated},
+		{"10,2", false, "-12345.6", NoTruncated},
+		{"10,2", true, "-1", Truncated},
+		{"10,2", false, "1.005", TruncatedWarn},
+		{"10,2", false, "90000000", TruncatedWarn},
+		{"5,5", false, "0.12345", NoTruncated},
+		{"5,5", false, "1.1", Truncated},
+		{"", false, "9999999999", TruncatedWarn},
+		{"", false, "99999999999", Truncated},
+		{"10,2", false, "1.5e10", Truncated},
+		{"10,2", false, "abc", NoTruncated},
+	}
+	for _, c := range cases {
+		if got := checkDecimalTruncate(c.params, c.unsigned, c.value, DataTruncationUplimit); got != c.expected {
+			t.Errorf("checkDecimalTruncate(%v, %v, %v) = %v, expected %v", c.params, c.unsigned, c.value, got, c.expected)
+		}
+	}
+}
+
+func TestCheckFloatTruncate(t *testing.T) {
+	cases := []struct {
+		typeString string
+		params     string
+		unsigned   bool
+		value      string
+		expected   int
+	}{
+		{msFieldTypeFloat, "", false, "3.14", NoTruncated},
+		{msFieldTypeFloat, "", false, "1e39", Truncated},
+		{msFieldTypeFloat, "", false, "3.3e38", TruncatedWarn},
+		{msFieldTypeFloat, "53", false, "1e39", NoTruncated},
+		{msFieldTypeDouble, "", false, "1e309", Truncated},
+		{msFieldTypeDouble, "", true, "-1.5", Truncated},
+		{msFieldTypeFloat, "5,2", false, "1234.5", Truncated},
+		{msFieldTypeFloat, "5,2", false, "123.456", NoTruncated},
+	}
+	for _, c := range cases {
+		if got := checkFloatTruncate(c.typeString, c.params, c.unsigned, c.value, DataTruncationUplimit); got != c.expected {
+			t.Errorf("checkFloatTruncate(%v, %v, %v, %v) = %v, expected %v",
+				c.typeString, c.params, c.unsigned, c.value, got, c.expected)
+		}
+	}
+}
+
+func TestCheckBitTruncate(t *testing.T) {
+	cases := []struct {
+		params   string
+		valType  sqlparser.ValType
+		value    string
+		expected int
+	}{
+		{"1", sqlparser.IntVal, "1", NoTruncated},
+		{"1", sqlparser.IntVal, "2", Truncated},
+		{"8", sqlparser.BitVal, "11111111", NoTruncated},
+		{"8", sqlparser.BitVal, "111111111", Truncated},
+		{"8", sqlparser.HexVal, "1ff", Truncated},
+		{"16", sqlparser.HexNum, "0xfff0", TruncatedWarn},
+		{"16", sqlparser.ValArg, "4096", NoTruncated},
+		{"16", sqlparser.ValArg, "65536", Truncated},
+		{"16", sqlparser.StrVal, "abc", Truncated},
+	}
+	for _, c := range cases {
+		if got := checkBitTruncate(c.params, c.valType, []byte(c.value), DataTruncationUplimit); got != c.expected {
+			t.Errorf("checkBitTruncate(%v, %v, %v) = %v, expected %v", c.params, c.valType, c.value, got, c.expected)
+		}
+	}
+}
+
+func TestCheckEnumAndSetTruncate(t *testing.T) {
+	enumParams := "'small','medium','large'"
+	enumCases := map[string]int{
+		"small":  NoTruncated,
+		"LARGE ": NoTruncated,
+		"3":      NoTruncated,
+		"4":      Truncated,
+		"0":      Truncated,
+		"huge":   Truncated,
+	}
+	for value, expected := range enumCases {
+		if got := checkEnumTruncate(enumParams, value); got != expected {
+			t.Errorf("checkEnumTruncate(%v, %v) = %v, expected %v", enumParams, value, got, expected)
+		}
+	}
+
+	setParams := "'a','b','c'"
+	setCases := map[string]int{
+		"":      NoTruncated,
+		"a,c":   NoTruncated,
+		"A,b":   NoTruncated,
+		"a,d":   Truncated,
+		"7":     NoTruncated,
+		"8":     Truncated,
+		"a,b,c": NoTruncated,
+	}
+	for value, expected := range setCases {
+		if got := checkSetTruncate(setParams, value); got != expected {
+			t.Errorf("checkSetTruncate(%v, %v) = %v, expected %v", setParams, value, got, expected)
+		}
+	}
+}
+
+func TestCheckTemporalTruncate(t *testing.T) {
+	warnYear := timestampMax.Add(-TimestampWarnPeriod / 2).Format("2006-01-02 15:04:05")
+	cases := []struct {
+		typeString string
+		value      string
+		expected   int
+	}{
+		{msFieldTypeDate, "2020-02-29", NoTruncated},
+		{msFieldTypeDate, "2019-02-29", Truncated},
+		{msFieldTypeDate, "2020-13-01", Truncated},
+		{msFieldTypeDate, "0000-00-00", NoTruncated},
+		{msFieldTypeDate, "20200131", NoTruncated},
+		{msFieldTypeDate, "not a date", Truncated},
+		{msFieldTypeDateTime, "2020-01-31 23:59:59.123", NoTruncated},
+		{msFieldTypeDateTime, "2020-01-31 24:00:00", Truncated},
+		{msFieldTypeDateTime, "9999-12-31 23:59:59", NoTruncated},
+		{msFieldTypeDateTime, "2020-01-31 10:30", NoTruncated},
+		{msFieldTypeDateTime, "2020-01-31 10:30:00abc", Truncated},
+		{msFieldTypeDate, "2020-01-31xyz", Truncated},
+		{msFieldTypeTimestamp, "1969-12-31 23:59:59", Truncated},
+		{msFieldTypeTimestamp, "2038-01-19 03:14:08", Truncated},
+		{msFieldTypeTimestamp, "2020-06-01 12:00:00", NoTruncated},
+		{msFieldTypeTimestamp, warnYear, TruncatedWarn},
+		{msFieldTypeYear, "2155", NoTruncated},
+		{msFieldTypeYear, "2156", Truncated},
+		{msFieldTypeYear, "1900", Truncated},
+		{msFieldTypeYear, "69", NoTruncated},
+		{msFieldTypeYear, "0", NoTruncated},
+	}
+	for _, c := range cases {
+		if got := checkTemporalTruncate(c.typeString, c.value, time.UTC); got != c.expected {
+			t.Errorf("checkTemporalTruncate(%v, %v) = %v, expected %v", c.typeString, c.value, got, c.expected)
+		}
+	}
+}
+
+func TestCheckTimestampTruncateInTimeZone(t *testing.T) {
+	// 会话time_zone为+08:00时，字面值按本地时间解释
+	loc := time.FixedZone("session", 8*3600)
+	cases := []struct {
+		value    string
+		loc      *time.Location
+		expected int
+	}{
+		{"1970-01-01 05:00:00", time.UTC, NoTruncated},
+		{"1970-01-01 05:00:00", loc, Truncated},
+		{"1970-01-01 08:00:01", loc, NoTruncated},
+		{"2038-01-19 11:14:07", time.UTC, Truncated},
+		{"2038-01-19 11:14:07", loc, TruncatedWarn},
+		{"2038-01-19 11:14:08", loc, Truncated},
+	}
+	for _, c := range cases {
+		if got := checkTemporalTruncate(msFieldTypeTimestamp, c.value, c.loc); got != c.expected {
+			t.Errorf("checkTemporalTruncate(%v, %v) in %v = %v, expected %v", msFieldTypeTimestamp, c.value, c.loc, got, c.expected)
+		}
+	}
+}
+
+func TestCheckTimeTruncate(t *testing.T) {
+	cases := map[string]int{
+		"12:30:00":            NoTruncated,
+		"-12:00:00":           NoTruncated,
+		"-838:59:59":          TruncatedWarn,
+		"839:00:00":           Truncated,
+		"700:00:00":           TruncatedWarn,
+		"12:60:00":            Truncated,
+		"2 10:00":             NoTruncated,
+		"35 00:00:00":         Truncated,
+		"12:30:00.123456":     NoTruncated,
+		"abc":                 Truncated,
+		"123045":              NoTruncated,
+		"10":                  NoTruncated,
+		"-8385959":            TruncatedWarn,
+		"8390000":             Truncated,
+		"1260":                Truncated,
+		"2020-01-31 10:30:00": NoTruncated,
+	}
+	for value, expected := range cases {
+		if got := checkTimeTruncate(value, DataTruncationUplimit); got != expected {
+			t.Errorf("checkTimeTruncate(%v) = %v, expected %v", value, got, expected)
+		}
+	}
+}
@@ -27,7 +27,7 @@ func NewPolicyCheckerFieldsType() *PolicyCheckerFieldsType {
 }
 
 func (pcri *PolicyCheckerFieldsType) Check(db *sql.DB, explainRecords []ExplainRecord, query string, args []interface{}) error {
-	log.MSKLog().Infof("PolicyCheckerFieldsType:Check(%v, %v, %v) with %v", explainRecords, query, args, pcri)
+	log.MSKLog().Infof("PolicyCheckerFieldsType:Check(%v, %v, %v args) with %v", explainRecords, query, len(args), pcri)
 
 	for i := 0; i < len(explainRecords); i++ {
 		var rowsAffected int
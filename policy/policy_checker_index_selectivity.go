@@ -0,0 +1,340 @@
+package policy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+索引区分度检测策略
+
+explain中使用了索引(type = ref, key不为空)并不代表安全，像status、gender、zoneid这类
+低基数(cardinality)的索引，一次ref查找仍会扫描大半张表。
+
+通过 SHOW INDEX 获取所选索引的 Cardinality，结合表的总行数估算：
+
+	区分度(selectivity) = cardinality / 总行数
+	每次查找的行数      = 总行数 / cardinality
+
+区分度过低且每次查找的行数 > RowsSafeLine 则告警，并根据WHERE中的列给出组合索引的建议：
+等值条件的列在前，范围条件的列在后。
+
+SHOW INDEX 的输出（5.5 - 8.0 列数不同，按列名取值）
++-------+------------+----------+--------------+-------------+-----------+-------------+----------+--------+------+------------+
+| Table | Non_unique | Key_name | Seq_in_index | Column_name | Collation | Cardinality | Sub_part | Packed | Null | Index_type | ...
++-------+------------+----------+--------------+-------------+-----------+-------------+----------+--------+------+------------+
+
+*/
+
+const (
+	DefaultMinIndexSelectivity = 0.01 // 区分度低于1%
+)
+
+type IndexRecord struct {
+	Table       sql.NullString
+	NonUnique   sql.NullString
+	KeyName     sql.NullString
+	SeqInIndex  sql.NullString
+	ColumnName  sql.NullString
+	Cardinality sql.NullString
+}
+
+func NewIndexRecord() *IndexRecord {
+	return &IndexRecord{}
+}
+
+// 是否唯一索引
+func (ir *IndexRecord) IsUnique() bool {
+	return ir.NonUnique.Valid && ir.NonUnique.String == "0"
+}
+
+func MakeIndexRecords(db *sql.DB, table string, timeout time.Duration) ([]IndexRecord, error) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// 针对 mysql 5.7.x 版本在context方面的bug，workaround
+	if notSupportContext {
+		timeout = timeout * 100
+	}
+	defer time.AfterFunc(timeout, cancel).Stop()
+
+	var indexRecords []IndexRecord
+	query := "show index from " + table
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return indexRecords, err
+	}
+	defer func() {
+		_ = safeRollback(fmt.Sprintf("MakeIndexRecords() query of %v rollback", query), tx)
+	}()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return indexRecords, err
+	}
+	defer rows.Close()
+
+	indexRecords, err = genIndexRecordsFromRows(rows)
+	if err != nil {
+		return indexRecords, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return indexRecords, err
+	}
+	return indexRecords, nil
+}
+
+func genIndexRecordsFromRows(rows *sql.Rows) ([]IndexRecord, error) {
+	records := make([]IndexRecord, 0)
+	columns, err := rows.Columns()
+	if err != nil {
+		return records, err
+	}
+
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			log.MSKLog().Warnf("genIndexRecordsFromRows(%v) failed %v", rows, err)
+			return records, err
+		}
+
+		record := NewIndexRecord()
+		for i, column := range columns {
+			switch strings.ToUpper(column) {
+			case "TABLE":
+				record.Table = values[i]
+			case "NON_UNIQUE":
+				record.NonUnique = values[i]
+			case "KEY_NAME":
+				record.KeyName = values[i]
+			case "SEQ_IN_INDEX":
+				record.SeqInIndex = values[i]
+			case "COLUMN_NAME":
+				record.ColumnName = values[i]
+			case "CARDINALITY":
+				record.Cardinality = values[i]
+			}
+		}
+		records = append(records, *record)
+	}
+	return records, rows.Err()
+}
+
+// 获取索引keyName的前keyParts列的cardinality及索引的列
+func cardinalityOfIndex(indexRecords []IndexRecord, keyName string, keyParts int) (int, []string) {
+	cardinality := 0
+	columns := []string{}
+	for i := 0; i < len(indexRecords); i++ {
+		if !strings.EqualFold(indexRecords[i].KeyName.String, keyName) {
+			continue
+		}
+		columns = append(columns, strings.ToUpper(indexRecords[i].ColumnName.String))
+		seq, _ := strconv.Atoi(indexRecords[i].SeqInIndex.String)
+		if seq == keyParts {
+			cardinality, _ = strconv.Atoi(indexRecords[i].Cardinality.String)
+		}
+	}
+	return cardinality, columns
+}
+
+// 表的别名（或表名本身，均转为大写）到真实表名的映射
+func tableAliasesOf(stmt sqlparser.Statement) map[string]string {
+	aliases := map[string]string{}
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		aliaTable, ok := node.(*sqlparser.AliasedTableExpr)
+		if !ok {
+			return true, nil
+		}
+		tableName, ok := aliaTable.Expr.(sqlparser.TableName)
+		if !ok {
+			return true, nil
+		}
+		name := tableName.Name.String()
+		aliases[strings.ToUpper(name)] = name
+		if !aliaTable.As.IsEmpty() {
+			aliases[strings.ToUpper(aliaTable.As.String())] = name
+		}
+		return true, nil
+	}, stmt)
+	return aliases
+}
+
+// 从WHERE条件中，按 等值列在前、范围列在后 的顺序收集属于表table（或其别名）的列
+func whereColumnsOf(stmt sqlparser.Statement, table string, aliases map[string]string) ([]string, []string) {
+	var where *sqlparser.Where
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		where = stmt.Where
+	case *sqlparser.Update:
+		where = stmt.Where
+	case *sqlparser.Delete:
+		where = stmt.Where
+	}
+	if where == nil {
+		return nil, nil
+	}
+
+	equalColumns := []string{}
+	rangeColumns := []string{}
+	seen := map[string]struct{}{}
+	tables := map[string]struct{}{}
+	for _, name := range aliases {
+		tables[strings.ToUpper(name)] = struct{}{}
+	}
+	columnOf := func(expr sqlparser.Expr) string {
+		col, ok := expr.(*sqlparser.ColName)
+		if !ok {
+			return ""
+		}
+		qualifier := strings.ToUpper(col.Qualifier.Name.String())
+		if qualifier != "" && !strings.EqualFold(aliases[qualifier], table) {
+			return ""
+		}
+		// 无前缀的列只在单表的情况下认为属于该表
+		if qualifier == "" && len(tables) > 1 {
+			return ""
+		}
+		name := strings.ToUpper(col.Name.String())
+		if _, ok := seen[name]; ok {
+			return ""
+		}
+		seen[name] = struct{}{}
+		return name
+	}
+
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.Subquery:
+			return false, nil
+		case *sqlparser.ComparisonExpr:
+			switch node.Operator {
+			case sqlparser.EqualStr, sqlparser.NullSafeEqualStr, sqlparser.InStr:
+				if name := columnOf(node.Left); name != "" {
+					equalColumns = append(equalColumns, name)
+				}
+			case sqlparser.LessThanStr, sqlparser.GreaterThanStr, sqlparser.LessEqualStr,
+				sqlparser.GreaterEqualStr, sqlparser.LikeStr:
+				if name := columnOf(node.Left); name != "" {
+					rangeColumns = append(rangeColumns, name)
+				}
+			}
+		case *sqlparser.RangeCond:
+			if node.Operator == sqlparser.BetweenStr {
+				if name := columnOf(node.Left); name != "" {
+					rangeColumns = append(rangeColumns, name)
+				}
+			}
+		case *sqlparser.IsExpr:
+			if node.Operator == sqlparser.IsNullStr {
+				if name := columnOf(node.Expr); name != "" {
+					equalColumns = append(equalColumns, name)
+				}
+			}
+		}
+		return true, nil
+	}, where)
+	return equalColumns, rangeColumns
+}
+
+// 根据WHERE中的列，给出组合索引的建议
+func suggestCompositeIndex(table string, equalColumns, rangeColumns, indexColumns []string) string {
+	columns := append([]string{}, equalColumns...)
+	// 范围条件之后的列无法再使用索引，只保留一个范围列
+	if len(rangeColumns) > 0 {
+		columns = append(columns, rangeColumns[0])
+	}
+	// 建议的列已经是所选索引的前缀，则没有更好的选择
+	isPrefix := len(columns) <= len(indexColumns)
+	for i := 0; isPrefix && i < len(columns); i++ {
+		isPrefix = columns[i] == indexColumns[i]
+	}
+	if len(columns) <= 1 || isPrefix {
+		return "no better composite index derived from WHERE columns"
+	}
+	return fmt.Sprintf("consider ALTER TABLE %v ADD INDEX idx_%v (%v)",
+		table, strings.ToLower(strings.Join(columns, "_")), strings.ToLower(strings.Join(columns, ", ")))
+}
+
+type PolicyCheckerIndexSelectivity struct {
+	minSelectivity float64
+}
+
+func NewPolicyCheckerIndexSelectivity() *PolicyCheckerIndexSelectivity {
+
+	return &PolicyCheckerIndexSelectivity{minSelectivity: DefaultMinIndexSelectivity}
+}
+
+func (pcis *PolicyCheckerIndexSelectivity) Check(db *sql.DB, explainRecords []ExplainRecord, query string, args []interface{}) error {
+
+	log.MSKLog().Infof("PolicyCheckerIndexSelectivity:Check(%v, %v, %v args) with %v", explainRecords, query, len(args), pcis)
+
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		log.MSKLog().Warnf("PolicyCheckerIndexSelectivity:Check(%v, %v, %v args) sqlparser.Parse failed with err %v",
+			explainRecords, misc.FingerprintSQL(query), len(args), err)
+		return nil
+	}
+	aliases := tableAliasesOf(stmt)
+
+	for i := 0; i < len(explainRecords); i++ {
+		tp := strings.ToLower(explainRecords[i].Type.String)
+		if tp != "ref" && tp != "ref_or_null" {
+			continue
+		}
+		if !explainRecords[i].Key.Valid || !isTableName(explainRecords[i].Table.String) {
+			continue
+		}
+		table, ok := aliases[strings.ToUpper(explainRecords[i].Table.String)]
+		if !ok {
+			table = explainRecords[i].Table.String
+		}
+
+		indexRecords, err := MakeIndexRecords(db, table, MaxTimeoutOfExplain)
+		if err != nil {
+			continue
+		}
+		// ref字段中每一项对应索引的一列
+		keyParts := len(strings.Split(explainRecords[i].Ref.String, ","))
+		cardinality, indexColumns := cardinalityOfIndex(indexRecords, explainRecords[i].Key.String, keyParts)
+		if cardinality <= 0 {
+			continue
+		}
+
+		subTableCountQuery := "select count(1) from " + table
+		subExplainRecords, err := MakeExplainRecords(db, subTableCountQuery, MaxTimeoutOfExplain, []interface{}{})
+		if err != nil {
+			continue
+		}
+		tableRows := MaxRowsFromExplainRecords(subExplainRecords)
+		if tableRows <= 0 {
+			continue
+		}
+
+		selectivity := float64(cardinality) / float64(tableRows)
+		rowsPerLookup := tableRows / cardinality
+		log.MSKLog().Debugf("PolicyCheckerIndexSelectivity:Check table %v key %v cardinality %v tableRows %v selectivity %v",
+			table, explainRecords[i].Key.String, cardinality, tableRows, selectivity)
+		if selectivity < pcis.minSelectivity && rowsPerLookup > RowsSafeLine {
+			equalColumns, rangeColumns := whereColumnsOf(stmt, table, aliases)
+			return NewPolicyError(WarnPolicyCodeIndexSelectivity, fmt.Sprintf("Index %v on table %v filters poorly: cardinality %v / rows %v = selectivity %0.4f < %v, about %v rows per lookup, %v",
+				explainRecords[i].Key.String, table, cardinality, tableRows, selectivity, pcis.minSelectivity, rowsPerLookup,
+				suggestCompositeIndex(table, equalColumns, rangeColumns, indexColumns)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package policy
+
+import (
+	"database/sql"
+	logmsk "gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPolicyIndexSelectivityCardinality(t *testing.T) {
+	nullString := func(s string) sql.NullString {
+		return sql.NullString{String: s, Valid: true}
+	}
+	indexRecords := []IndexRecord{
+		{KeyName: nullString("PRIMARY"), NonUnique: nullString("0"), SeqInIndex: nullString("1"), ColumnName: nullString("id"), Cardinality: nullString("100000")},
+		{KeyName: nullString("idx_status_uid"), NonUnique: nullString("1"), SeqInIndex: nullString("1"), ColumnName: nullString("status"), Cardinality: nullString("3")},
+		{KeyName: nullString("idx_status_uid"), NonUnique: nullString("1"), SeqInIndex: nullString("2"), ColumnName: nullString("uid"), Cardinality: nullString("90000")},
+	}
+
+	cardinality, columns := cardinalityOfIndex(indexRecords, "idx_status_uid", 1)
+	if cardinality != 3 || strings.Join(columns, ",") != "STATUS,UID" {
+		t.Fatalf("cardinalityOfIndex got %v %v", cardinality, columns)
+	}
+	cardinality, _ = cardinalityOfIndex(indexRecords, "idx_status_uid", 2)
+	if cardinality != 90000 {
+		t.Fatalf("cardinalityOfIndex got %v", cardinality)
+	}
+	cardinality, _ = cardinalityOfIndex(indexRecords, "not_exists", 1)
+	if cardinality != 0 {
+		t.Fatalf("cardinalityOfIndex got %v", cardinality)
+	}
+
+	if !indexRecords[0].IsUnique() || indexRecords[1].IsUnique() {
+		t.Fatalf("IsUnique failed")
+	}
+}
+
+func TestPolicyIndexSelectivitySuggestion(t *testing.T) {
+	stmt, err := sqlparser.Parse("select * from test_policy t where t.status = 1 and t.zoneid in (1, 2) and t.ctime > 100 and t.uid between 1 and 10")
+	if err != nil {
+		t.Fatalf("sqlparser.Parse failed %v", err)
+	}
+	aliases := tableAliasesOf(stmt)
+	if aliases["T"] != "test_policy" || aliases["TEST_POLICY"] != "test_policy" {
+		t.Fatalf("tableAliasesOf got %v", aliases)
+	}
+
+	equalColumns, rangeColumns := whereColumnsOf(stmt, "test_policy", aliases)
+	if strings.Join(equalColumns, ",") != "STATUS,ZONEID" || strings.Join(rangeColumns, ",") != "CTIME,UID" {
+		t.Fatalf("whereColumnsOf got %v %v", equalColumns, rangeColumns)
+	}
+
+	suggestion := suggestCompositeIndex("test_policy", equalColumns, rangeColumns, []string{"STATUS"})
+	if suggestion != "consider ALTER TABLE test_policy ADD INDEX idx_status_zoneid_ctime (status, zoneid, ctime)" {
+		t.Fatalf("suggestCompositeIndex got %v", suggestion)
+	}
+
+	// 已经是所选索引的前缀
+	suggestion = suggestCompositeIndex("test_policy", []string{"STATUS"}, nil, []string{"STATUS", "UID"})
+	if !strings.HasPrefix(suggestion, "no better") {
+		t.Fatalf("suggestCompositeIndex got %v", suggestion)
+	}
+
+	// 多表时无前缀的列无法归属
+	stmt, _ = sqlparser.Parse("select * from a join b on a.id = b.id where status = 1 and b.uid = 2")
+	aliases = tableAliasesOf(stmt)
+	equalColumns, _ = whereColumnsOf(stmt, "b", aliases)
+	if strings.Join(equalColumns, ",") != "UID" {
+		t.Fatalf("whereColumnsOf got %v", equalColumns)
+	}
+}
+
+func TestRawPolicyIndexSelectivity(t *testing.T) {
+	runRawPolicyTests(t, dsn+"&columnsWithAlias=true", func(dbt *DBTest) {
+		logmsk.MSKLog().SetOutput(os.Stdout)
+
+		dbt.mustExec("CREATE TABLE `test_policy` (`id` int(11) NOT NULL AUTO_INCREMENT,`status` int(11) DEFAULT NULL,`uid` int(11) DEFAULT NULL,PRIMARY KEY (`id`),KEY `status` (`status`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+		for i := 0; i < 3000; i++ {
+			dbt.mustExec("INSERT INTO test_policy(status, uid) VALUES (?, ?)", i%2, i)
+		}
+		dbt.mustExec("ANALYZE TABLE test_policy")
+
+		npc := NewPolicyCheckWraper(NewPolicyCheckerIndexSelectivity(), dbt.db)
+		err := npc.Check("select * from test_policy where status = 1 and uid = 10")
+		log.Printf("err ==== %v", err)
+		pe, _ := err.(*PolicyError)
+		if pe == nil || pe.Code != WarnPolicyCodeIndexSelectivity {
+			dbt.Errorf("index selectivity not covered")
+		}
+
+		err = npc.Check("select * from test_policy where id = 10")
+		if err != nil {
+			dbt.Errorf("primary key lookup should be safe %v", err)
+		}
+
+		logmsk.MSKLog().SetOutput(ioutil.Discard)
+	})
+}
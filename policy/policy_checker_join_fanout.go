@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"database/sql"
+	"fmt"
+	"gitlab.papegames.com/fringe/mskeeper/log"
+)
+
+/*
+
+多表join的扇出检测策略
+
+MySQL的join是nested-loop，explain中同一id的多行按join顺序排列，
+前一张表输出的行数（rows × filtered）就是后一张表被探测的次数，因此：
+
+	第k张表扫描的行数 = (∏_{i<k} rows_i × filtered_i) × rows_k
+	总扫描行数       = Σ 第k张表扫描的行数
+
+eg. 1k × 1k × 10 的三表join，每张表单独看都不多，但总扫描行数在千万级。
+
+*/
+
+const DefaultMaxJoinRowsExamined = 100000 // 总扫描行数 > 10w 则告警
+
+type PolicyCheckerJoinFanOut struct {
+	maxRowsExamined int
+}
+
+func NewPolicyCheckerJoinFanOut(maxRowsExamined int) *PolicyCheckerJoinFanOut {
+
+	return &PolicyCheckerJoinFanOut{maxRowsExamined: maxRowsExamined}
+}
+
+// 计算同一id内nested-loop的总扫描行数，以及扇出放大倍数最大的表
+func joinRowsExaminedOf(explainRecords []ExplainRecord) (float64, string, float64) {
+	var examined float64
+	var explodedTable string
+	var maxGrowth float64
+
+	prefix := 1.0
+	for i := 0; i < len(explainRecords); i++ {
+		rowCnt, rate, err := explainRecords[i].GetExplainRowsAndFiltered()
+		if err != nil {
+			continue
+		}
+		examined += prefix * float64(rowCnt)
+
+		growth := float64(rowCnt) * (rate / float64(100.0))
+		if i > 0 && growth > maxGrowth {
+			maxGrowth = growth
+			explodedTable = explainRecords[i].Table.String
+		}
+		prefix *= growth
+	}
+	return examined, explodedTable, maxGrowth
+}
+
+func (pcjf *PolicyCheckerJoinFanOut) Check(db *sql.DB, explainRecords []ExplainRecord, query string, args []interface{}) error {
+
+	log.MSKLog().Infof("PolicyCheckerJoinFanOut:Check(%v, %v, %v args) with %v", explainRecords, query, len(args), pcjf)
+
+	ids, groups := groupExplainRecordsByID(explainRecords)
+	for _, id := range ids {
+		group := groups[id]
+		if len(group) < 2 {
+			continue
+		}
+		examined, explodedTable, growth := joinRowsExaminedOf(group)
+		log.MSKLog().Debugf("PolicyCheckerJoinFanOut:Check id %v examined %v explodedTable %v growth %v",
+			id, examined, explodedTable, growth)
+		if examined > float64(pcjf.maxRowsExamined) {
+			return NewPolicyError(ErrPolicyCodeJoinFanOut, fmt.Sprintf("Too many rows examined by nested-loop join: estimated %0.f > pcjf.maxRowsExamined %v, fan-out explodes on table %v (×%0.f)",
+				examined, pcjf.maxRowsExamined, explodedTable, growth))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package policy
+
+import (
+	logmsk "gitlab.papegames.com/fringe/mskeeper/log"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPolicyJoinFanOutThreeTables(t *testing.T) {
+	pcjf := NewPolicyCheckerJoinFanOut(DefaultMaxJoinRowsExamined)
+
+	// 1k × 1k × 10, 每张表单独的rows都不超过1000
+	explainRecords := []ExplainRecord{
+		newExplainRecordForTest("1", "SIMPLE", "t1", "ALL", "", "1000", "100.00", ""),
+		newExplainRecordForTest("1", "SIMPLE", "t2", "ALL", "", "1000", "100.00", "Using join buffer"),
+		newExplainRecordForTest("1", "SIMPLE", "t3", "ref", "idx", "10", "100.00", ""),
+	}
+	examined, table, growth := joinRowsExaminedOf(explainRecords)
+	if examined != 1000+1000*1000+1000*1000*10 {
+		t.Fatalf("joinRowsExaminedOf got %v", examined)
+	}
+	if table != "t2" || growth != 1000 {
+		t.Fatalf("joinRowsExaminedOf exploded table %v growth %v", table, growth)
+	}
+
+	err := pcjf.Check(nil, explainRecords, "select * from t1, t2, t3 where t2.c = t3.c", nil)
+	pe, ok := err.(*PolicyError)
+	if !ok || pe.Code != ErrPolicyCodeJoinFanOut {
+		t.Fatalf("join fan-out not covered %v", err)
+	}
+	if !strings.Contains(pe.Msg, "t2") {
+		t.Fatalf("exploded table not reported %v", pe.Msg)
+	}
+}
+
+func TestPolicyJoinFanOutFiltered(t *testing.T) {
+	pcjf := NewPolicyCheckerJoinFanOut(DefaultMaxJoinRowsExamined)
+
+	// eq_ref 的join每行只探测1行
+	explainRecords := []ExplainRecord{
+		newExplainRecordForTest("1", "SIMPLE", "t1", "range", "idx", "1000", "10.00", "Using where"),
+		newExplainRecordForTest("1", "SIMPLE", "t2", "eq_ref", "PRIMARY", "1", "100.00", ""),
+		newExplainRecordForTest("1", "SIMPLE", "t3", "ref", "idx", "10", "100.00", ""),
+		newExplainRecordForTest("2", "SUBQUERY", "t4", "ALL", "", "90000", "100.00", ""),
+	}
+	err := pcjf.Check(nil, explainRecords, "", nil)
+	if err != nil {
+		t.Fatalf("join fan-out should be safe %v", err)
+	}
+
+	// rows 为NULL的记录不参与计算
+	explainRecords = []ExplainRecord{
+		newExplainRecordForTest("1", "INSERT", "t1", "ALL", "", "", "", ""),
+		newExplainRecordForTest("1", "SIMPLE", "t2", "ALL", "", "1000", "100.00", ""),
+	}
+	err = pcjf.Check(nil, explainRecords, "", nil)
+	if err != nil {
+		t.Fatalf("join fan-out should be safe %v", err)
+	}
+}
+
+func TestRawPolicyJoinFanOut(t *testing.T) {
+	runRawPolicyTests(t, dsn+"&columnsWithAlias=true", func(dbt *DBTest) {
+		logmsk.MSKLog().SetOutput(os.Stdout)
+
+		dbt.mustExec("CREATE TABLE `test_policy` (`value` int(11) DEFAULT NULL,`value1` int(11) DEFAULT NULL) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+		for i := 0; i < 200; i++ {
+			dbt.mustExec("INSERT INTO test_policy VALUES (?, ?)", i, i)
+		}
+
+		npc := NewPolicyCheckWraper(NewPolicyCheckerJoinFanOut(10000), dbt.db)
+		err := npc.Check("select * from test_policy t1, test_policy t2 where t1.value1 = t2.value1")
+		log.Printf("err ==== %v", err)
+		pe, _ := err.(*PolicyError)
+		if pe == nil || pe.Code != ErrPolicyCodeJoinFanOut {
+			dbt.Errorf("join fan-out not covered")
+		}
+
+		err = npc.Check("select * from test_policy where value = 1")
+		if err != nil {
+			dbt.Errorf("single table should be safe %v", err)
+		}
+
+		logmsk.MSKLog().SetOutput(ioutil.Discard)
+	})
+}
@@ -0,0 +1,132 @@
+package policy
+
+import (
+	"database/sql"
+	"fmt"
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+	"strings"
+)
+
+/*
+
+加锁风险检测策略
+
+SELECT ... FOR UPDATE、SELECT ... LOCK IN SHARE MODE 以及 UPDATE/DELETE，InnoDB会对扫描到的
+每一行加行锁，在 REPEATABLE READ 下，非唯一索引或范围扫描还会加间隙锁(gap lock / next-key lock)，
+并发下极易死锁。
+
+按explain的访问类型估算：
+1. const/system、eq_ref，或者唯一索引上只扫描1行的range：只锁命中的行，无间隙锁
+2. ref/ref_or_null（非唯一索引）、range、index_merge：锁扫描到的行以及其间隙
+3. index/ALL（没有可用的索引）：锁全表的行以及间隙
+
+注：5.6/5.7 中 explain update ... where pk = 1 的type显示为range，因此需要结合索引的唯一性判断。
+
+*/
+
+const DefaultMaxLockedRows = 100
+
+type PolicyCheckerLockRisk struct {
+	maxLockedRows int
+}
+
+func NewPolicyCheckerLockRisk(maxLockedRows int) *PolicyCheckerLockRisk {
+
+	return &PolicyCheckerLockRisk{maxLockedRows: maxLockedRows}
+}
+
+// 返回语句的加锁类型，空字符串表示不加锁
+func lockClauseOf(stmt sqlparser.Statement) string {
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		return strings.TrimSpace(stmt.Lock)
+	case *sqlparser.Update:
+		return "update"
+	case *sqlparser.Delete:
+		return "delete"
+	}
+	return ""
+}
+
+// 判断表table上的索引key是否唯一索引
+func isUniqueKey(db *sql.DB, table, key string) bool {
+	if strings.EqualFold(key, "PRIMARY") {
+		return true
+	}
+	indexRecords, err := MakeIndexRecords(db, table, MaxTimeoutOfExplain)
+	if err != nil {
+		return false
+	}
+	for i := 0; i < len(indexRecords); i++ {
+		if strings.EqualFold(indexRecords[i].KeyName.String, key) {
+			return indexRecords[i].IsUnique()
+		}
+	}
+	return false
+}
+
+// 估算单张表上加锁的行数以及是否可能有间隙锁
+func lockedRowsOf(db *sql.DB, er *ExplainRecord, table string) (int, bool) {
+	rowCnt, _, err := er.GetExplainRowsAndFiltered()
+	if err != nil {
+		return 0, false
+	}
+	switch strings.ToLower(er.Type.String) {
+	case "const", "system":
+		return 1, false
+	case "eq_ref":
+		return rowCnt, false
+	case "range":
+		if rowCnt <= 1 && er.Key.Valid && isUniqueKey(db, table, er.Key.String) {
+			return rowCnt, false
+		}
+		return rowCnt, true
+	case "ref", "ref_or_null", "index_merge", "index", "all":
+		return rowCnt, true
+	}
+	return rowCnt, false
+}
+
+func (pclr *PolicyCheckerLockRisk) Check(db *sql.DB, explainRecords []ExplainRecord, query string, args []interface{}) error {
+
+	log.MSKLog().Infof("PolicyCheckerLockRisk:Check(%v, %v, %v args) with %v", explainRecords, query, len(args), pclr)
+
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		log.MSKLog().Warnf("PolicyCheckerLockRisk:Check(%v, %v, %v args) sqlparser.Parse failed with err %v",
+			explainRecords, misc.FingerprintSQL(query), len(args), err)
+		return nil
+	}
+	lockClause := lockClauseOf(stmt)
+	if lockClause == "" {
+		return nil
+	}
+	aliases := tableAliasesOf(stmt)
+
+	lockedRows := 0
+	gapTables := []string{}
+	for i := 0; i < len(explainRecords); i++ {
+		if !isTableName(explainRecords[i].Table.String) {
+			continue
+		}
+		table, ok := aliases[strings.ToUpper(explainRecords[i].Table.String)]
+		if !ok {
+			table = explainRecords[i].Table.String
+		}
+		rows, gap := lockedRowsOf(db, &explainRecords[i], table)
+		lockedRows += rows
+		if gap {
+			gapTables = append(gapTables, fmt.Sprintf("%v(type:%v,key:%v)",
+				table, explainRecords[i].Type.String, explainRecords[i].Key.String))
+		}
+	}
+
+	log.MSKLog().Debugf("PolicyCheckerLockRisk:Check lock %v lockedRows %v gapTables %v", lockClause, lockedRows, gapTables)
+	if lockedRows > pclr.maxLockedRows || len(gapTables) > 0 {
+		return NewPolicyError(ErrPolicyCodeLockRisk, fmt.Sprintf("Locking statement(%v) may hold too many InnoDB locks: estimated locked rows %v (maxLockedRows %v), gap locks likely %v on %v",
+			lockClause, lockedRows, pclr.maxLockedRows, len(gapTables) > 0, gapTables))
+	}
+	return nil
+}
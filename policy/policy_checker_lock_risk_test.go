@@ -0,0 +1,117 @@
+package policy
+
+import (
+	logmsk "gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestPolicyLockRiskLockClause(t *testing.T) {
+	lockClauses := []struct {
+		input  string
+		output string
+	}{
+		{input: "select * from t where id = 1 for update", output: "for update"},
+		{input: "select * from t where id = 1 lock in share mode", output: "lock in share mode"},
+		{input: "select * from t where id = 1", output: ""},
+		{input: "update t set a = 1 where id = 1", output: "update"},
+		{input: "delete from t where id = 1", output: "delete"},
+		{input: "insert into t values (1)", output: ""},
+	}
+	for _, testCase := range lockClauses {
+		stmt, err := sqlparser.Parse(testCase.input)
+		if err != nil {
+			t.Fatalf("sqlparser.Parse(%v) failed %v", testCase.input, err)
+		}
+		if lockClause := lockClauseOf(stmt); lockClause != testCase.output {
+			t.Fatalf("lockClauseOf(%v) got %v", testCase.input, lockClause)
+		}
+	}
+}
+
+func TestPolicyLockRiskAccessType(t *testing.T) {
+	pclr := NewPolicyCheckerLockRisk(DefaultMaxLockedRows)
+
+	// 主键等值, 不会有间隙锁
+	explainRecords := []ExplainRecord{
+		newExplainRecordForTest("1", "SIMPLE", "t", "const", "PRIMARY", "1", "100.00", ""),
+	}
+	err := pclr.Check(nil, explainRecords, "select * from t where id = 1 for update", nil)
+	if err != nil {
+		t.Fatalf("primary key lock should be safe %v", err)
+	}
+
+	// explain update 的主键等值显示为range
+	explainRecords = []ExplainRecord{
+		newExplainRecordForTest("1", "UPDATE", "t", "range", "PRIMARY", "1", "100.00", "Using where"),
+	}
+	err = pclr.Check(nil, explainRecords, "update t set a = 1 where id = 1", nil)
+	if err != nil {
+		t.Fatalf("primary key update should be safe %v", err)
+	}
+
+	// 非锁定读
+	explainRecords = []ExplainRecord{
+		newExplainRecordForTest("1", "SIMPLE", "t", "ALL", "", "100000", "100.00", ""),
+	}
+	err = pclr.Check(nil, explainRecords, "select * from t", nil)
+	if err != nil {
+		t.Fatalf("plain select should be safe %v", err)
+	}
+
+	// 全表扫描的delete
+	explainRecords = []ExplainRecord{
+		newExplainRecordForTest("1", "DELETE", "t", "ALL", "", "50", "100.00", "Using where"),
+	}
+	err = pclr.Check(nil, explainRecords, "delete from t where a = 1", nil)
+	pe, ok := err.(*PolicyError)
+	if !ok || pe.Code != ErrPolicyCodeLockRisk {
+		t.Fatalf("full scan delete not covered %v", err)
+	}
+
+	// 行数超限
+	explainRecords = []ExplainRecord{
+		newExplainRecordForTest("1", "SIMPLE", "t1", "eq_ref", "PRIMARY", "1000", "100.00", ""),
+	}
+	err = pclr.Check(nil, explainRecords, "select * from t1 for update", nil)
+	pe, ok = err.(*PolicyError)
+	if !ok || pe.Code != ErrPolicyCodeLockRisk {
+		t.Fatalf("too many locked rows not covered %v", err)
+	}
+
+	// 非法SQL
+	err = pclr.Check(nil, explainRecords, "select * from", nil)
+	if err != nil {
+		t.Fatalf("bad sql should be skipped %v", err)
+	}
+}
+
+func TestRawPolicyLockRisk(t *testing.T) {
+	runRawPolicyTests(t, dsn+"&columnsWithAlias=true", func(dbt *DBTest) {
+		logmsk.MSKLog().SetOutput(os.Stdout)
+
+		dbt.mustExec("CREATE TABLE `test_policy` (`id` int(11) NOT NULL AUTO_INCREMENT,`uid` int(11) DEFAULT NULL,`order_no` int(11) DEFAULT NULL,PRIMARY KEY (`id`),KEY `uid` (`uid`),UNIQUE KEY `order_no` (`order_no`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+		for i := 0; i < 100; i++ {
+			dbt.mustExec("INSERT INTO test_policy(uid, order_no) VALUES (?, ?)", i%10, i)
+		}
+
+		npc := NewPolicyCheckWraper(NewPolicyCheckerLockRisk(DefaultMaxLockedRows), dbt.db)
+		err := npc.Check("select * from test_policy where uid = 1 for update")
+		log.Printf("err ==== %v", err)
+		pe, _ := err.(*PolicyError)
+		if pe == nil || pe.Code != ErrPolicyCodeLockRisk {
+			dbt.Errorf("gap lock on non-unique index not covered")
+		}
+
+		err = npc.Check("update test_policy set uid = 2 where order_no = 10")
+		log.Printf("err ==== %v", err)
+		if err != nil {
+			dbt.Errorf("unique key update should be safe %v", err)
+		}
+
+		logmsk.MSKLog().SetOutput(ioutil.Discard)
+	})
+}
@@ -0,0 +1,639 @@
+package policy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+Online DDL 风险检测策略
+
+ALTER TABLE 无法explain，但恰恰是最容易锁住大表的语句。根据MySQL的版本及Online DDL矩阵，
+判断每一个ALTER子句使用的算法以及是否阻塞并发的DML，再结合 information_schema.TABLES 中
+表的大小估算执行时长。
+
+REF: https://dev.mysql.com/doc/refman/8.0/en/innodb-online-ddl-operations.html
+     https://dev.mysql.com/doc/refman/5.7/en/innodb-online-ddl-operations.html
+     https://dev.mysql.com/doc/refman/5.6/en/innodb-online-ddl-operations.html
+
++----------------------------+---------------------+---------------------+---------------------+-------+
+| 操作                        | 8.0                 | 5.7                 | 5.6                 | 5.5   |
++----------------------------+---------------------+---------------------+---------------------+-------+
+| ADD INDEX/UNIQUE            | INPLACE             | INPLACE             | INPLACE             | 阻塞DML |
+| ADD FULLTEXT/SPATIAL        | INPLACE, 阻塞DML     | INPLACE, 阻塞DML     | FULLTEXT同左         | COPY  |
+| DROP/RENAME INDEX           | INPLACE(元数据)      | INPLACE(元数据)      | DROP同左, RENAME COPY | 阻塞DML |
+| ADD PRIMARY KEY             | INPLACE, 重建        | INPLACE, 重建        | INPLACE, 重建        | COPY  |
+| DROP PRIMARY KEY            | COPY                | COPY                | COPY                | COPY  |
+| ADD COLUMN                  | INSTANT(8.0.12+)    | INPLACE, 重建        | INPLACE, 重建        | COPY  |
+| DROP COLUMN                 | INSTANT(8.0.29+)    | INPLACE, 重建        | INPLACE, 重建        | COPY  |
+| SET/DROP DEFAULT            | INSTANT             | INPLACE(元数据)      | INPLACE(元数据)      | COPY  |
+| CHANGE/MODIFY 列类型        | COPY                | COPY                | COPY                | COPY  |
+| CONVERT TO CHARACTER SET    | COPY                | COPY                | COPY                | COPY  |
+| ENGINE/FORCE/ROW_FORMAT     | INPLACE, 重建        | INPLACE, 重建        | INPLACE, 重建        | COPY  |
+| RENAME TABLE                | INSTANT             | INPLACE(元数据)      | INPLACE(元数据)      | 元数据 |
++----------------------------+---------------------+---------------------+---------------------+-------+
+
+注：
+1. CHANGE/MODIFY 只重命名列或在长度字节数不变的范围内扩展VARCHAR时是INPLACE，SQL本身无法判断，按COPY保守处理
+2. ADD FOREIGN KEY 在 foreign_key_checks=1 时只支持COPY
+3. 估算的时长只是量级参考，实际与磁盘、buffer pool及并发负载有关
+4. 阻塞DML或总时长超出阈值时分别以ErrPolicyCodeOnlineDDL、WarnPolicyCodeOnlineDDL告警，否则以Info级别的WarnPolicyCodeOnlineDDL给出分析结果
+
+*/
+
+type DDLAlgorithm int
+
+const (
+	DDLAlgorithmInstant DDLAlgorithm = iota
+	DDLAlgorithmInplace
+	DDLAlgorithmCopy
+)
+
+func (da DDLAlgorithm) String() string {
+	switch da {
+	case DDLAlgorithmInstant:
+		return "INSTANT"
+	case DDLAlgorithmInplace:
+		return "INPLACE"
+	default:
+		return "COPY"
+	}
+}
+
+const (
+	DefaultMaxDDLBlockingTime = 1 * time.Second  // 阻塞DML的DDL预估时长超过1s则告警
+	DefaultMaxDDLDuration     = 10 * time.Minute // 不阻塞DML的DDL预估时长超过10min也告警(主从延迟)
+
+	// 估算DDL时长的吞吐量，按单线程顺序读写的量级估算
+	DDLCopyBytesPerSecond    = 16 << 20
+	DDLRebuildBytesPerSecond = 32 << 20
+	DDLIndexBytesPerSecond   = 64 << 20
+)
+
+type MySQLVersion struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// eg. 5.7.25-log, 8.0.21, 5.5.62-0ubuntu0.14.04.1
+func ParseMySQLVersion(version string) MySQLVersion {
+	mv := MySQLVersion{}
+	vs := strings.SplitN(version, ".", 3)
+	nums := []*int{&mv.Major, &mv.Minor, &mv.Patch}
+	for i := 0; i < len(vs) && i < len(nums); i++ {
+		digits := vs[i]
+		for j := 0; j < len(digits); j++ {
+			if digits[j] < '0' || digits[j] > '9' {
+				digits = digits[:j]
+				break
+			}
+		}
+		*nums[i], _ = strconv.Atoi(digits)
+	}
+	return mv
+}
+
+func (mv MySQLVersion) AtLeast(major, minor, patch int) bool {
+	if mv.Major != major {
+		return mv.Major > major
+	}
+	if mv.Minor != minor {
+		return mv.Minor > minor
+	}
+	return mv.Patch >= patch
+}
+
+func (mv MySQLVersion) String() string {
+	return fmt.Sprintf("%v.%v.%v", mv.Major, mv.Minor, mv.Patch)
+}
+
+// 一个ALTER子句的Online DDL属性
+type OnlineDDLOperation struct {
+	Clause     string
+	Algorithm  DDLAlgorithm
+	Rebuild    bool // 是否重建表
+	BuildIndex bool // 是否需要扫描全表构建二级索引
+	BlocksDML  bool // 是否阻塞并发的DML
+}
+
+func (op OnlineDDLOperation) String() string {
+	attrs := []string{op.Algorithm.String()}
+	if op.Rebuild {
+		attrs = append(attrs, "rebuild")
+	}
+	if op.BuildIndex {
+		attrs = append(attrs, "build index")
+	}
+	if op.BlocksDML {
+		attrs = append(attrs, "blocks DML")
+	}
+	return fmt.Sprintf("%v(%v)", op.Clause, strings.Join(attrs, ", "))
+}
+
+type OnlineDDLAnalysis struct {
+	Table      string
+	Version    MySQLVersion
+	Operations []OnlineDDLOperation
+
+	Algorithm  DDLAlgorithm
+	Rebuild    bool
+	BlocksDML  bool
+	TableRows  int64
+	TableBytes int64 // DATA_LENGTH + INDEX_LENGTH
+	DataBytes  int64 // DATA_LENGTH
+
+	EstimatedDuration time.Duration
+}
+
+func (oda *OnlineDDLAnalysis) String() string {
+	ops := make([]string, 0, len(oda.Operations))
+	for i := 0; i < len(oda.Operations); i++ {
+		ops = append(ops, oda.Operations[i].String())
+	}
+	return fmt.Sprintf("ALTER TABLE %v on MySQL %v: algorithm %v, rebuild %v, blocks DML %v, rows %v, size %0.1fMB, estimated duration %v, operations [%v]",
+		oda.Table, oda.Version, oda.Algorithm, oda.Rebuild, oda.BlocksDML, oda.TableRows,
+		float64(oda.TableBytes)/float64(1<<20), oda.EstimatedDuration, strings.Join(ops, "; "))
+}
+
+// 按顶层的逗号切分，忽略括号及引号内的逗号
+func splitTopLevelCommas(s string) []string {
+	var parts []string
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	parts = append(parts, strings.TrimSpace(s[start:]))
+	return parts
+}
+
+func unquoteIdentifier(ident string) string {
+	names := strings.Split(ident, ".")
+	for i := 0; i < len(names); i++ {
+		names[i] = strings.Trim(names[i], "`")
+	}
+	return strings.Join(names, ".")
+}
+
+// 解析 ALTER TABLE、CREATE INDEX、DROP INDEX，返回表名以及转换为ALTER TABLE形式的子句
+func ParseAlterTable(query string) (string, []string, error) {
+	query = strings.TrimRight(misc.TrimConsecutiveSpaces(query), "; ")
+	words := strings.Split(query, " ")
+	upper := strings.Split(strings.ToUpper(query), " ")
+
+	// 跳过 ONLINE/OFFLINE/IGNORE 等修饰
+	skip := func(i int, modifiers ...string) int {
+		for i < len(upper) && findString(upper[i], modifiers) {
+			i++
+		}
+		return i
+	}
+
+	if len(upper) < 3 {
+		return "", nil, fmt.Errorf("not an alter table statement: %v", query)
+	}
+	switch upper[0] {
+	case "ALTER":
+		i := skip(1, "ONLINE", "OFFLINE", "IGNORE")
+		if i+1 >= len(upper) || upper[i] != "TABLE" {
+			return "", nil, fmt.Errorf("not an alter table statement: %v", query)
+		}
+		table := unquoteIdentifier(words[i+1])
+		rest := strings.Join(words[i+2:], " ")
+		if strings.TrimSpace(rest) == "" {
+			return table, nil, nil
+		}
+		return table, splitTopLevelCommas(rest), nil
+	case "CREATE":
+		// CREATE [UNIQUE|FULLTEXT|SPATIAL] INDEX name [USING type] ON tbl (cols)
+		i := skip(1, "ONLINE", "OFFLINE")
+		kind := ""
+		if i < len(upper) && findString(upper[i], []string{"UNIQUE", "FULLTEXT", "SPATIAL"}) {
+			kind = words[i] + " "
+			i++
+		}
+		if i+1 >= len(upper) || upper[i] != "INDEX" {
+			return "", nil, fmt.Errorf("not a create index statement: %v", query)
+		}
+		name := words[i+1]
+		for j := i + 2; j+1 < len(upper); j++ {
+			if upper[j] == "ON" {
+				table := words[j+1]
+				columns := strings.Join(words[j+2:], " ")
+				// ON tbl(cols) 表名与列之间没有空格
+				if idx := strings.Index(table, "("); idx != -1 {
+					columns = table[idx:] + " " + columns
+					table = table[:idx]
+				}
+				return unquoteIdentifier(table), []string{"ADD " + kind + "INDEX " + name + " " + strings.TrimSpace(columns)}, nil
+			}
+		}
+		return "", nil, fmt.Errorf("not a create index statement: %v", query)
+	case "DROP":
+		// DROP INDEX name ON tbl
+		i := skip(1, "ONLINE", "OFFLINE")
+		if i+3 >= len(upper) || upper[i] != "INDEX" || upper[i+2] != "ON" {
+			return "", nil, fmt.Errorf("not a drop index statement: %v", query)
+		}
+		return unquoteIdentifier(words[i+3]), []string{"DROP INDEX " + words[i+1]}, nil
+	}
+	return "", nil, fmt.Errorf("not an alter table statement: %v", query)
+}
+
+func findString(s string, list []string) bool {
+	for i := 0; i < len(list); i++ {
+		if s == list[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// 根据Online DDL矩阵判断子句的算法，返回false表示子句不是一个操作（例如ALGORITHM=、LOCK=）
+func ClassifyAlterClause(clause string, version MySQLVersion) (OnlineDDLOperation, bool) {
+	op := OnlineDDLOperation{Clause: clause, Algorithm: DDLAlgorithmCopy, BlocksDML: true}
+	upper := strings.ToUpper(clause)
+	words := strings.Fields(strings.NewReplacer("=", " = ", "(", " ( ").Replace(upper))
+	if len(words) == 0 {
+		return op, false
+	}
+
+	is56 := version.AtLeast(5, 6, 0)
+	is57 := version.AtLeast(5, 7, 0)
+	is80 := version.AtLeast(8, 0, 0)
+
+	inplace := func(rebuild, buildIndex, blocksDML bool) (OnlineDDLOperation, bool) {
+		op.Algorithm, op.Rebuild, op.BuildIndex, op.BlocksDML = DDLAlgorithmInplace, rebuild, buildIndex, blocksDML
+		return op, true
+	}
+	instant := func() (OnlineDDLOperation, bool) {
+		op.Algorithm, op.Rebuild, op.BuildIndex, op.BlocksDML = DDLAlgorithmInstant, false, false, false
+		return op, true
+	}
+	copying := func() (OnlineDDLOperation, bool) {
+		return op, true
+	}
+	// 只修改元数据: 8.0为INSTANT, 5.6/5.7为INPLACE
+	metadata := func() (OnlineDDLOperation, bool) {
+		if is80 {
+			return instant()
+		}
+		if is56 {
+			return inplace(false, false, false)
+		}
+		return copying()
+	}
+	// 跳过 CONSTRAINT [symbol]
+	next := func(i int) int {
+		if i < len(words) && words[i] == "CONSTRAINT" {
+			i++
+			if i < len(words) && !findString(words[i], []string{"PRIMARY", "UNIQUE", "FOREIGN", "CHECK"}) {
+				i++
+			}
+		}
+		return i
+	}
+	word := func(i int) string {
+		if i < len(words) {
+			return words[i]
+		}
+		return ""
+	}
+
+	switch words[0] {
+	case "ALGORITHM", "LOCK", "VALIDATION", "WITHOUT", "WITH":
+		return op, false
+	case "ADD":
+		i := next(1)
+		switch word(i) {
+		case "PRIMARY":
+			if is56 {
+				return inplace(true, false, false)
+			}
+			return copying()
+		case "UNIQUE", "INDEX", "KEY":
+			if is56 {
+				return inplace(false, true, false)
+			}
+			return inplace(false, true, true)
+		case "FULLTEXT":
+			if is56 {
+				return inplace(false, true, true)
+			}
+			return copying()
+		case "SPATIAL":
+			if is57 {
+				return inplace(false, true, true)
+			}
+			return copying()
+		case "FOREIGN":
+			return copying()
+		case "CHECK":
+			return metadata()
+		case "PARTITION":
+			if is56 {
+				return inplace(false, false, true)
+			}
+			return copying()
+		}
+		// ADD [COLUMN] col_def
+		if strings.Contains(upper, "AUTO_INCREMENT") {
+			if is56 {
+				return inplace(true, false, true)
+			}
+			return copying()
+		}
+		if strings.Contains(upper, " AS (") || strings.Contains(upper, " AS(") || strings.Contains(upper, "GENERATED ALWAYS") {
+			if strings.Contains(upper, "STORED") || !is57 {
+				return copying()
+			}
+			return metadata()
+		}
+		positioned := findString("FIRST", words) || findString("AFTER", words)
+		if version.AtLeast(8, 0, 29) || (version.AtLeast(8, 0, 12) && !positioned) {
+			return instant()
+		}
+		if is56 {
+			return inplace(true, false, false)
+		}
+		return copying()
+	case "DROP":
+		i := next(1)
+		switch word(i) {
+		case "PRIMARY":
+			return copying()
+		case "INDEX", "KEY":
+			if is56 {
+				return inplace(false, false, false)
+			}
+			return inplace(false, false, true)
+		case "FOREIGN":
+			if is56 {
+				return inplace(false, false, false)
+			}
+			return copying()
+		case "PARTITION":
+			if is56 {
+				return inplace(false, false, true)
+			}
+			return copying()
+		case "CHECK", "CONSTRAINT":
+			return metadata()
+		case "DEFAULT":
+			return metadata()
+		}
+		// DROP [COLUMN] col
+		if version.AtLeast(8, 0, 29) {
+			return instant()
+		}
+		if is56 {
+			return inplace(true, false, false)
+		}
+		return copying()
+	case "RENAME":
+		switch word(1) {
+		case "INDEX", "KEY":
+			if is57 {
+				return metadata()
+			}
+			return copying()
+		case "COLUMN":
+			if version.AtLeast(8, 0, 28) {
+				return instant()
+			}
+			if is80 {
+				return inplace(false, false, false)
+			}
+			return copying()
+		}
+		// RENAME [TO|AS] new_tbl
+		if is80 {
+			return instant()
+		}
+		return inplace(false, false, false)
+	case "ALTER":
+		// ALTER [COLUMN] col SET DEFAULT / DROP DEFAULT, ALTER INDEX idx VISIBLE
+		if word(1) == "INDEX" && is80 {
+			return instant()
+		}
+		if findString("DEFAULT", words) {
+			return metadata()
+		}
+		return copying()
+	case "CHANGE", "MODIFY":
+		return copying()
+	case "CONVERT":
+		return copying()
+	case "DEFAULT", "CHARACTER", "CHARSET", "COLLATE":
+		if is56 {
+			return inplace(true, false, false)
+		}
+		return copying()
+	case "ENGINE", "FORCE", "ROW_FORMAT", "KEY_BLOCK_SIZE":
+		if is56 {
+			return inplace(true, false, false)
+		}
+		return copying()
+	case "AUTO_INCREMENT", "COMMENT", "STATS_PERSISTENT", "STATS_AUTO_RECALC", "STATS_SAMPLE_PAGES":
+		return metadata()
+	}
+	// ORDER BY 以及其他未知的操作，按COPY保守处理
+	return copying()
+}
+
+// 汇总各个子句: 算法取最重的，任一子句阻塞DML则整个语句阻塞DML
+func summarizeOnlineDDL(oda *OnlineDDLAnalysis) {
+	addPrimary, dropPrimary := -1, -1
+	for i := 0; i < len(oda.Operations); i++ {
+		upper := strings.ToUpper(oda.Operations[i].Clause)
+		if strings.HasPrefix(upper, "ADD PRIMARY") || (strings.HasPrefix(upper, "ADD CONSTRAINT") && strings.Contains(upper, "PRIMARY KEY")) {
+			addPrimary = i
+		}
+		if strings.HasPrefix(upper, "DROP PRIMARY") {
+			dropPrimary = i
+		}
+	}
+	// 同一语句中 DROP PRIMARY KEY 并 ADD PRIMARY KEY 可以INPLACE
+	if addPrimary != -1 && dropPrimary != -1 && oda.Version.AtLeast(5, 6, 0) {
+		oda.Operations[dropPrimary].Algorithm = DDLAlgorithmInplace
+		oda.Operations[dropPrimary].Rebuild = true
+		oda.Operations[dropPrimary].BlocksDML = false
+	}
+
+	indexes := 0
+	for i := 0; i < len(oda.Operations); i++ {
+		op := oda.Operations[i]
+		if op.Algorithm > oda.Algorithm {
+			oda.Algorithm = op.Algorithm
+		}
+		oda.Rebuild = oda.Rebuild || op.Rebuild
+		oda.BlocksDML = oda.BlocksDML || op.BlocksDML
+		if op.BuildIndex {
+			indexes++
+		}
+	}
+
+	var seconds float64
+	switch {
+	case oda.Algorithm == DDLAlgorithmCopy:
+		seconds = float64(oda.TableBytes) / DDLCopyBytesPerSecond
+	case oda.Rebuild:
+		seconds = float64(oda.TableBytes) / DDLRebuildBytesPerSecond
+	default:
+		seconds = float64(oda.DataBytes) / DDLIndexBytesPerSecond * float64(indexes)
+	}
+	oda.EstimatedDuration = time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+}
+
+// 不访问数据库的分析，表的大小由调用方给出
+func AnalyzeAlterTable(query string, version MySQLVersion, tableRows, dataBytes, indexBytes int64) (*OnlineDDLAnalysis, error) {
+	table, clauses, err := ParseAlterTable(query)
+	if err != nil {
+		return nil, err
+	}
+	oda := &OnlineDDLAnalysis{
+		Table:      table,
+		Version:    version,
+		Algorithm:  DDLAlgorithmInstant,
+		TableRows:  tableRows,
+		TableBytes: dataBytes + indexBytes,
+		DataBytes:  dataBytes,
+	}
+	for i := 0; i < len(clauses); i++ {
+		if op, ok := ClassifyAlterClause(clauses[i], version); ok {
+			oda.Operations = append(oda.Operations, op)
+		}
+	}
+	summarizeOnlineDDL(oda)
+	return oda, nil
+}
+
+// 获取MySQL的版本以及表的行数、数据及索引大小
+func queryTableStatus(db *sql.DB, table string, timeout time.Duration) (MySQLVersion, int64, int64, int64, error) {
+	var version MySQLVersion
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// 针对 mysql 5.7.x 版本在context方面的bug，workaround
+	if notSupportContext {
+		timeout = timeout * 100
+	}
+	defer time.AfterFunc(timeout, cancel).Stop()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return version, 0, 0, 0, err
+	}
+	defer func() {
+		_ = safeRollback(fmt.Sprintf("queryTableStatus() of %v rollback", table), tx)
+	}()
+
+	var versionStr string
+	if err := tx.QueryRowContext(ctx, "SELECT version();").Scan(&versionStr); err != nil {
+		return version, 0, 0, 0, err
+	}
+	version = ParseMySQLVersion(versionStr)
+
+	schemaExpr := "DATABASE()"
+	args := []interface{}{}
+	names := strings.SplitN(table, ".", 2)
+	if len(names) == 2 {
+		schemaExpr = "?"
+		args = append(args, names[0])
+	}
+	args = append(args, names[len(names)-1])
+
+	var rows, dataLength, indexLength sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT TABLE_ROWS, DATA_LENGTH, INDEX_LENGTH FROM information_schema.TABLES WHERE TABLE_SCHEMA = "+
+		schemaExpr+" AND TABLE_NAME = ?", args...).Scan(&rows, &dataLength, &indexLength)
+	if err != nil {
+		return version, 0, 0, 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return version, 0, 0, 0, err
+	}
+	return version, rows.Int64, dataLength.Int64, indexLength.Int64, nil
+}
+
+// 分析ALTER TABLE语句的Online DDL风险，可在上线前单独调用
+func AnalyzeOnlineDDL(db *sql.DB, query string, timeout time.Duration) (*OnlineDDLAnalysis, error) {
+	table, _, err := ParseAlterTable(query)
+	if err != nil {
+		return nil, err
+	}
+	version, tableRows, dataBytes, indexBytes, err := queryTableStatus(db, table, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return AnalyzeAlterTable(query, version, tableRows, dataBytes, indexBytes)
+}
+
+type PolicyCheckerOnlineDDL struct {
+	maxBlockingTime time.Duration
+	maxDuration     time.Duration
+}
+
+func NewPolicyCheckerOnlineDDL() *PolicyCheckerOnlineDDL {
+
+	return &PolicyCheckerOnlineDDL{maxBlockingTime: DefaultMaxDDLBlockingTime, maxDuration: DefaultMaxDDLDuration}
+}
+
+// DDL语句无法explain，不会进入Check
+func (pcod *PolicyCheckerOnlineDDL) Check(db *sql.DB, explainRecords []ExplainRecord, query string, args []interface{}) error {
+
+	return nil
+}
+
+func (pcod *PolicyCheckerOnlineDDL) CheckDDL(db *sql.DB, query string) error {
+
+	log.MSKLog().Infof("PolicyCheckerOnlineDDL:CheckDDL(%v) with %v", query, pcod)
+
+	oda, err := AnalyzeOnlineDDL(db, query, MaxTimeoutOfExplain)
+	if err != nil {
+		log.MSKLog().Warnf("PolicyCheckerOnlineDDL:CheckDDL(%v) AnalyzeOnlineDDL failed with err %v", misc.FingerprintSQL(query), err)
+		return nil
+	}
+	return pcod.checkAnalysis(oda)
+}
+
+func (pcod *PolicyCheckerOnlineDDL) checkAnalysis(oda *OnlineDDLAnalysis) error {
+	blocking := oda.BlocksDML || oda.Algorithm == DDLAlgorithmCopy
+	if blocking && oda.EstimatedDuration > pcod.maxBlockingTime {
+		return NewPolicyError(ErrPolicyCodeOnlineDDL, fmt.Sprintf("DDL blocks concurrent DML for about %v > maxBlockingTime %v, %v",
+			oda.EstimatedDuration, pcod.maxBlockingTime, oda))
+	}
+	if oda.EstimatedDuration > pcod.maxDuration {
+		return NewPolicyError(WarnPolicyCodeOnlineDDL, fmt.Sprintf("DDL runs for about %v > maxDuration %v, replicas may lag behind, %v",
+			oda.EstimatedDuration, pcod.maxDuration, oda))
+	}
+	// 未超出阈值时同样给出算法、是否阻塞DML等分析结果，以Info级别通知
+	return NewPolicyError(WarnPolicyCodeOnlineDDL, fmt.Sprintf("DDL within maxBlockingTime %v and maxDuration %v, %v",
+		pcod.maxBlockingTime, pcod.maxDuration, oda)).WithSeverity(log.InfoLevel)
+}
@@ -0,0 +1,187 @@
+package policy
+
+import (
+	logmsk "gitlab.papegames.com/fringe/mskeeper/log"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyOnlineDDLParseVersion(t *testing.T) {
+	versions := []struct {
+		input  string
+		output MySQLVersion
+	}{
+		{input: "5.7.25-log", output: MySQLVersion{5, 7, 25}},
+		{input: "8.0.21", output: MySQLVersion{8, 0, 21}},
+		{input: "5.5.62-0ubuntu0.14.04.1", output: MySQLVersion{5, 5, 62}},
+		{input: "10", output: MySQLVersion{10, 0, 0}},
+	}
+	for _, testCase := range versions {
+		if v := ParseMySQLVersion(testCase.input); v != testCase.output {
+			t.Fatalf("ParseMySQLVersion(%v) got %v", testCase.input, v)
+		}
+	}
+	if !(MySQLVersion{8, 0, 29}).AtLeast(8, 0, 12) || (MySQLVersion{5, 7, 30}).AtLeast(8, 0, 0) {
+		t.Fatalf("AtLeast failed")
+	}
+}
+
+func TestPolicyOnlineDDLParseAlterTable(t *testing.T) {
+	statements := []struct {
+		input   string
+		table   string
+		clauses []string
+	}{
+		{input: "ALTER TABLE `db`.`t1` ADD COLUMN c int DEFAULT 0, ADD INDEX idx_ab (a, b);",
+			table: "db.t1", clauses: []string{"ADD COLUMN c int DEFAULT 0", "ADD INDEX idx_ab (a, b)"}},
+		{input: "alter online ignore table t1 modify c enum('a,b', 'c') not null",
+			table: "t1", clauses: []string{"modify c enum('a,b', 'c') not null"}},
+		{input: "create unique index idx_a on t1(a)",
+			table: "t1", clauses: []string{"ADD unique INDEX idx_a (a)"}},
+		{input: "CREATE INDEX idx_a ON t1 (a, b)",
+			table: "t1", clauses: []string{"ADD INDEX idx_a (a, b)"}},
+		{input: "drop index idx_a on `t1`",
+			table: "t1", clauses: []string{"DROP INDEX idx_a"}},
+	}
+	for _, testCase := range statements {
+		table, clauses, err := ParseAlterTable(testCase.input)
+		if err != nil {
+			t.Fatalf("ParseAlterTable(%v) failed %v", testCase.input, err)
+		}
+		if table != testCase.table || strings.Join(clauses, "|") != strings.Join(testCase.clauses, "|") {
+			t.Fatalf("ParseAlterTable(%v) got %v %v", testCase.input, table, clauses)
+		}
+	}
+	if _, _, err := ParseAlterTable("create table t1 (a int)"); err == nil {
+		t.Fatalf("create table should not be parsed")
+	}
+}
+
+func TestPolicyOnlineDDLClassify(t *testing.T) {
+	v55 := MySQLVersion{5, 5, 62}
+	v57 := MySQLVersion{5, 7, 30}
+	v80 := MySQLVersion{8, 0, 21}
+	v8029 := MySQLVersion{8, 0, 29}
+
+	clauses := []struct {
+		clause    string
+		version   MySQLVersion
+		algorithm DDLAlgorithm
+		rebuild   bool
+		blocksDML bool
+	}{
+		{"ADD INDEX idx_a (a)", v57, DDLAlgorithmInplace, false, false},
+		{"ADD INDEX idx_a (a)", v55, DDLAlgorithmInplace, false, true},
+		{"ADD CONSTRAINT uk UNIQUE KEY (a)", v57, DDLAlgorithmInplace, false, false},
+		{"ADD FULLTEXT INDEX ft (a)", v57, DDLAlgorithmInplace, false, true},
+		{"ADD PRIMARY KEY (id)", v57, DDLAlgorithmInplace, true, false},
+		{"DROP PRIMARY KEY", v57, DDLAlgorithmCopy, false, true},
+		{"ADD COLUMN c int", v57, DDLAlgorithmInplace, true, false},
+		{"ADD COLUMN c int", v80, DDLAlgorithmInstant, false, false},
+		{"ADD COLUMN c int AFTER b", v80, DDLAlgorithmInplace, true, false},
+		{"ADD COLUMN c int AFTER b", v8029, DDLAlgorithmInstant, false, false},
+		{"ADD COLUMN c int AUTO_INCREMENT", v57, DDLAlgorithmInplace, true, true},
+		{"ADD COLUMN c int", v55, DDLAlgorithmCopy, false, true},
+		{"DROP COLUMN c", v57, DDLAlgorithmInplace, true, false},
+		{"DROP COLUMN c", v8029, DDLAlgorithmInstant, false, false},
+		{"DROP INDEX idx_a", v57, DDLAlgorithmInplace, false, false},
+		{"ALTER COLUMN c SET DEFAULT 1", v57, DDLAlgorithmInplace, false, false},
+		{"ALTER COLUMN c SET DEFAULT 1", v80, DDLAlgorithmInstant, false, false},
+		{"MODIFY c bigint", v80, DDLAlgorithmCopy, false, true},
+		{"CHANGE c d varchar(20)", v57, DDLAlgorithmCopy, false, true},
+		{"CONVERT TO CHARACTER SET utf8mb4", v80, DDLAlgorithmCopy, false, true},
+		{"ENGINE=InnoDB", v57, DDLAlgorithmInplace, true, false},
+		{"RENAME INDEX a TO b", v57, DDLAlgorithmInplace, false, false},
+		{"RENAME TO t2", v80, DDLAlgorithmInstant, false, false},
+		{"ADD FOREIGN KEY (a) REFERENCES t2(id)", v80, DDLAlgorithmCopy, false, true},
+		{"ORDER BY a", v80, DDLAlgorithmCopy, false, true},
+	}
+	for _, testCase := range clauses {
+		op, ok := ClassifyAlterClause(testCase.clause, testCase.version)
+		if !ok || op.Algorithm != testCase.algorithm || op.Rebuild != testCase.rebuild || op.BlocksDML != testCase.blocksDML {
+			t.Fatalf("ClassifyAlterClause(%v, %v) got %v", testCase.clause, testCase.version, op)
+		}
+	}
+	if _, ok := ClassifyAlterClause("ALGORITHM=INPLACE", v57); ok {
+		t.Fatalf("ALGORITHM= should not be an operation")
+	}
+}
+
+func TestPolicyOnlineDDLAnalyze(t *testing.T) {
+	v57 := MySQLVersion{5, 7, 30}
+
+	// 1GB的表
+	oda, err := AnalyzeAlterTable("alter table t1 add column c int, modify d bigint, algorithm=copy", v57, 10000000, 1<<30, 0)
+	if err != nil {
+		t.Fatalf("AnalyzeAlterTable failed %v", err)
+	}
+	if len(oda.Operations) != 2 || oda.Algorithm != DDLAlgorithmCopy || !oda.BlocksDML || !oda.Rebuild {
+		t.Fatalf("AnalyzeAlterTable got %v", oda)
+	}
+	if oda.EstimatedDuration != 64*time.Second {
+		t.Fatalf("EstimatedDuration got %v", oda.EstimatedDuration)
+	}
+	pcod := NewPolicyCheckerOnlineDDL()
+	pe, ok := pcod.checkAnalysis(oda).(*PolicyError)
+	if !ok || pe.Code != ErrPolicyCodeOnlineDDL || !strings.Contains(pe.Msg, "algorithm COPY") {
+		t.Fatalf("copy DDL not covered %v", pe)
+	}
+
+	// DROP + ADD PRIMARY KEY 可以INPLACE
+	oda, _ = AnalyzeAlterTable("alter table t1 drop primary key, add primary key (id, uid)", v57, 10000000, 1<<30, 0)
+	if oda.Algorithm != DDLAlgorithmInplace || oda.BlocksDML {
+		t.Fatalf("drop and add primary key got %v", oda)
+	}
+
+	// 索引构建不阻塞DML，且时长较短
+	oda, _ = AnalyzeAlterTable("create index idx_a on t1 (a)", v57, 10000000, 1<<30, 0)
+	if oda.Algorithm != DDLAlgorithmInplace || oda.BlocksDML || oda.EstimatedDuration != 16*time.Second {
+		t.Fatalf("create index got %v", oda)
+	}
+	pe, ok = pcod.checkAnalysis(oda).(*PolicyError)
+	if lvl, hasSeverity := pe.Severity(); !ok || pe.Code != WarnPolicyCodeOnlineDDL || !hasSeverity || lvl != logmsk.InfoLevel ||
+		!strings.Contains(pe.Msg, "algorithm INPLACE") {
+		t.Fatalf("online index build should be reported at info level %v", pe)
+	}
+
+	// 超长的INPLACE重建
+	oda, _ = AnalyzeAlterTable("alter table t1 engine=innodb", v57, 1000000000, 100<<30, 0)
+	pe, ok = pcod.checkAnalysis(oda).(*PolicyError)
+	if !ok || pe.Code != WarnPolicyCodeOnlineDDL {
+		t.Fatalf("long rebuild not covered %v", pe)
+	}
+
+	// 空表的COPY无风险，只给出分析结果
+	oda, _ = AnalyzeAlterTable("alter table t1 modify d bigint", v57, 0, 16384, 0)
+	pe, ok = pcod.checkAnalysis(oda).(*PolicyError)
+	if lvl, hasSeverity := pe.Severity(); !ok || !hasSeverity || lvl != logmsk.InfoLevel || !strings.Contains(pe.Msg, "algorithm COPY") {
+		t.Fatalf("copy of small table should be reported at info level %v", pe)
+	}
+}
+
+func TestRawPolicyOnlineDDL(t *testing.T) {
+	runRawPolicyTests(t, dsn, func(dbt *DBTest) {
+		logmsk.MSKLog().SetOutput(os.Stdout)
+
+		dbt.mustExec("CREATE TABLE `test_policy` (`id` int(11) NOT NULL AUTO_INCREMENT,`value` int(11) DEFAULT NULL,PRIMARY KEY (`id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+		oda, err := AnalyzeOnlineDDL(dbt.db, "alter table test_policy modify value bigint", MaxTimeoutOfExplain)
+		log.Printf("oda ==== %v", oda)
+		if err != nil {
+			dbt.Fatalf("AnalyzeOnlineDDL failed %v", err)
+		}
+		if oda.Algorithm != DDLAlgorithmCopy || oda.Version.Major < 5 {
+			dbt.Errorf("AnalyzeOnlineDDL got %v", oda)
+		}
+
+		_, err = AnalyzeOnlineDDL(dbt.db, "alter table test_policy_not_exists add index (value)", MaxTimeoutOfExplain)
+		if err == nil {
+			dbt.Errorf("AnalyzeOnlineDDL of table not exists should fail")
+		}
+
+		logmsk.MSKLog().SetOutput(ioutil.Discard)
+	})
+}
@@ -0,0 +1,105 @@
+package policy
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"gitlab.papegames.com/fringe/mskeeper/log"
+)
+
+/*
+
+结果集大小检测策略（Driver方式，mysql的textRows/binaryRows在Rows.Close时上报实际读取的行数及字节数）
+
+1. 读取到应用内存的行数 > maxRows，或者字节数 > maxBytes：一次性加载过大的结果集，容易OOM
+2. 读取的行数 > explain估算的输出行数 × maxEstimateRatio（且不少于MinRowsOfEstimateCheck）：统计信息过期，
+   执行计划可能已不准确，建议ANALYZE TABLE
+
+估算的输出行数按顶层的SELECT（SIMPLE、PRIMARY、UNION）的 ∏(rows × filtered) 累加，子查询及派生表不计入。
+读取到一半就Close的结果集，只统计已经读取的部分。
+
+*/
+
+const (
+	DefaultMaxResultSetRows     = 10000
+	DefaultMaxResultSetBytes    = 16 << 20
+	DefaultMaxRowsEstimateRatio = 10.0
+	MinRowsOfEstimateCheck      = 1000
+)
+
+type PolicyCheckerResultSet struct {
+	maxRows          int64
+	maxBytes         int64
+	maxEstimateRatio float64
+}
+
+// 参数<=0时使用默认值
+func NewPolicyCheckerResultSet(maxRows int64, maxBytes int64, maxEstimateRatio float64) *PolicyCheckerResultSet {
+	if maxRows <= 0 {
+		maxRows = DefaultMaxResultSetRows
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxResultSetBytes
+	}
+	if maxEstimateRatio <= 0 {
+		maxEstimateRatio = DefaultMaxRowsEstimateRatio
+	}
+	return &PolicyCheckerResultSet{maxRows: maxRows, maxBytes: maxBytes, maxEstimateRatio: maxEstimateRatio}
+}
+
+// 结果集的大小在Rows.Close时才能确定，由CheckResultSet检查
+func (pcrs *PolicyCheckerResultSet) Check(db *sql.DB, explainRecords []ExplainRecord, query string, args []interface{}) error {
+	return nil
+}
+
+// 顶层SELECT估算的输出行数，false表示无法估算
+func estimatedOutputRows(explainRecords []ExplainRecord) (float64, bool) {
+	ids, groups := groupExplainRecordsByID(explainRecords)
+	estimated := 0.0
+	valid := false
+	for _, id := range ids {
+		records := groups[id]
+		switch strings.ToUpper(records[0].SelectType.String) {
+		case "SIMPLE", "PRIMARY", "UNION":
+		default:
+			continue
+		}
+		hasRows := false
+		for i := 0; i < len(records); i++ {
+			hasRows = hasRows || records[i].Rows.Valid
+		}
+		if !hasRows {
+			continue
+		}
+		estimated += fanOutOfExplainRecords(records)
+		valid = true
+	}
+	return estimated, valid
+}
+
+func (pcrs *PolicyCheckerResultSet) CheckResultSet(db *sql.DB, explainRecords []ExplainRecord, query string,
+	args []interface{}, stats ResultSetStats) error {
+
+	log.MSKLog().Infof("PolicyCheckerResultSet:CheckResultSet(%v, %v, %v args, %+v) with %v", explainRecords, query, len(args), stats, pcrs)
+
+	if stats.Rows > pcrs.maxRows || stats.Bytes > pcrs.maxBytes {
+		return NewPolicyError(ErrPolicyCodeResultSetSize,
+			fmt.Sprintf("Too large result set read into memory: rows %v (max %v), bytes %v (max %v), held for %v until Rows.Close",
+				stats.Rows, pcrs.maxRows, stats.Bytes, pcrs.maxBytes, stats.Duration))
+	}
+
+	if stats.Rows < MinRowsOfEstimateCheck {
+		return nil
+	}
+	estimated, ok := estimatedOutputRows(explainRecords)
+	if !ok {
+		return nil
+	}
+	if float64(stats.Rows) > estimated*pcrs.maxEstimateRatio {
+		return NewPolicyError(WarnPolicyCodeRowsEstimate,
+			fmt.Sprintf("Rows read far exceed the explain estimate: rows %v > estimated %0.f × %v, statistics may be stale, try ANALYZE TABLE",
+				stats.Rows, estimated, pcrs.maxEstimateRatio))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package policy
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func makeResultSetExplainRecord(id, selectType string, rows string, filtered string) ExplainRecord {
+	return ExplainRecord{
+		ID:         sql.NullString{String: id, Valid: true},
+		SelectType: sql.NullString{String: selectType, Valid: true},
+		Table:      sql.NullString{String: "test", Valid: true},
+		Rows:       sql.NullString{String: rows, Valid: rows != ""},
+		Filtered:   sql.NullString{String: filtered, Valid: filtered != ""},
+	}
+}
+
+func TestPolicyResultSetSize(t *testing.T) {
+	pc := NewPolicyCheckerResultSet(1000, 1<<20, 0)
+
+	cases := []struct {
+		stats ResultSetStats
+		code  PolicyCode
+	}{
+		{stats: ResultSetStats{Rows: 1000, Bytes: 1 << 20}},
+		{stats: ResultSetStats{Rows: 1001, Bytes: 100, Duration: time.Second}, code: ErrPolicyCodeResultSetSize},
+		{stats: ResultSetStats{Rows: 10, Bytes: 1<<20 + 1}, code: ErrPolicyCodeResultSetSize},
+	}
+	for _, testCase := range cases {
+		err := pc.CheckResultSet(nil, nil, "SELECT * FROM test", nil, testCase.stats)
+		if testCase.code == 0 {
+			if err != nil {
+				t.Fatalf("%+v should pass, got %v", testCase.stats, err)
+			}
+			continue
+		}
+		if pe, ok := err.(*PolicyError); !ok || pe.Code != testCase.code {
+			t.Fatalf("%+v should be failed with %v, got %v", testCase.stats, testCase.code, err)
+		}
+	}
+
+	if err := pc.Check(nil, nil, "SELECT * FROM test", nil); err != nil {
+		t.Fatalf("Check should always pass, got %v", err)
+	}
+}
+
+func TestPolicyResultSetRowsEstimate(t *testing.T) {
+	pc := NewPolicyCheckerResultSet(0, 0, 10)
+
+	// 顶层 100 × 50% + UNION 50，子查询不计入
+	er := []ExplainRecord{
+		makeResultSetExplainRecord("1", "PRIMARY", "100", "50.00"),
+		makeResultSetExplainRecord("2", "SUBQUERY", "100000", "100.00"),
+		makeResultSetExplainRecord("3", "UNION", "50", ""),
+		makeResultSetExplainRecord("", "UNION RESULT", "", ""),
+	}
+	if err := pc.CheckResultSet(nil, er, "SELECT ...", nil, ResultSetStats{Rows: 1000}); err != nil {
+		t.Fatalf("rows within estimate should pass, got %v", err)
+	}
+	err := pc.CheckResultSet(nil, er, "SELECT ...", nil, ResultSetStats{Rows: 1001})
+	if pe, ok := err.(*PolicyError); !ok || pe.Code != WarnPolicyCodeRowsEstimate {
+		t.Fatalf("rows exceed estimate should be warned, got %v", err)
+	}
+
+	// 行数过少、没有explain或者没有估算的行数时不检查
+	er = []ExplainRecord{makeResultSetExplainRecord("1", "SIMPLE", "1", "100.00")}
+	if err := pc.CheckResultSet(nil, er, "SELECT ...", nil, ResultSetStats{Rows: MinRowsOfEstimateCheck - 1}); err != nil {
+		t.Fatalf("small result set should pass, got %v", err)
+	}
+	if err := pc.CheckResultSet(nil, nil, "SELECT ...", nil, ResultSetStats{Rows: 5000}); err != nil {
+		t.Fatalf("result set without explain should pass, got %v", err)
+	}
+	er = []ExplainRecord{makeResultSetExplainRecord("1", "SIMPLE", "", "")}
+	if err := pc.CheckResultSet(nil, er, "SELECT ...", nil, ResultSetStats{Rows: 5000}); err != nil {
+		t.Fatalf("result set without estimated rows should pass, got %v", err)
+	}
+}
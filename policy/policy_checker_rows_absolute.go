@@ -24,7 +24,7 @@ func NewPolicyCheckerRowsAbsolute(mra int) *PolicyCheckerRowsAbsolute {
 
 func (pcri *PolicyCheckerRowsAbsolute) Check(db *sql.DB, explainRecords []ExplainRecord, query string, args []interface{}) error {
 
-	log.MSKLog().Infof("PolicyCheckerRowsAbsolute:Check(%v, %v, %v) with %v", explainRecords, query, args, pcri)
+	log.MSKLog().Infof("PolicyCheckerRowsAbsolute:Check(%v, %v, %v args) with %v", explainRecords, query, len(args), pcri)
 	for i := 0; i < len(explainRecords); i++ {
 		var rowsAffected int
 		var err error
@@ -23,7 +23,7 @@ func NewPolicyCheckerRowsInvolved() *PolicyCheckerRowsInvolved {
 
 func (pcri *PolicyCheckerRowsInvolved) Check(db *sql.DB, explainRecords []ExplainRecord, query string, args []interface{}) error {
 
-	log.MSKLog().Infof("PolicyCheckerRowsInvolved:Check(%v, %v, %v) with %v", explainRecords, query, args, pcri)
+	log.MSKLog().Infof("PolicyCheckerRowsInvolved:Check(%v, %v, %v args) with %v", explainRecords, query, len(args), pcri)
 	for i := 0; i < len(explainRecords); i++ {
 		// syslog.Printf("[DEBUG] ----- explainRecords[i].Table.String %v explainRecords[i].Rows %v query %v, explainRecords[i] %v",
 		// explainRecords[i].Table.String, explainRecords[i].Rows, query, explainRecords[i])
@@ -0,0 +1,376 @@
+package policy
+
+import (
+	"database/sql"
+	"fmt"
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+
+SQL注入及字符串拼接的检测策略
+
+通过字符串拼接生成的SQL，以下特征往往意味着被注入：
+
+1. WHERE/HAVING中OR的一侧恒为真，eg. OR 1=1、OR 'a'='a'、OR id=id（单独的 WHERE 1=1 是常见的拼接写法，不告警）
+2. 堆叠查询，SELECT之后追加了INSERT、UPDATE、DELETE等写语句，或追加了DROP、TRUNCATE、GRANT等语句，eg. SELECT ... WHERE id = 1; DROP TABLE t
+   （SELECT之后的SELECT、SET等是批量读取的常见写法，不告警）
+3. 单行SQL以 -- 或 # 注释结尾，用于截断原语句的剩余部分，eg. WHERE name = 'admin' -- ' AND password = '...'
+4. 带WHERE的查询之后UNION SELECT了常量、NULL或系统库（information_schema、mysql等），eg. UNION SELECT 1, user(), 3
+
+以上均为ErrPolicyCodeSQLInjection。
+
+另外，同一个指纹（misc.FingerprintSQL）反复以不同的内联常量而不是?参数出现时，说明SQL是拼接生成的，
+达到maxLiteralVariants个不同的SQL时告警WarnPolicyCodeUnparameterized。
+
+*/
+
+const (
+	DefaultMaxLiteralVariants = 5
+	MaxTrackedFingerprints    = 10000
+)
+
+var (
+	ErrInjectionTautology         = fmt.Errorf("tautology in OR condition")
+	ErrInjectionStackedQueries    = fmt.Errorf("stacked queries")
+	ErrInjectionCommentTruncation = fmt.Errorf("statement truncated by trailing comment")
+	ErrInjectionUnionSelect       = fmt.Errorf("UNION SELECT appended to lookup")
+)
+
+// 堆叠查询中出现即告警的语句
+var stackedDangerousKeywords = []string{"DROP", "TRUNCATE", "ALTER", "GRANT", "REVOKE", "CREATE", "RENAME", "SHUTDOWN"}
+
+// 堆叠在SELECT之后即告警的写语句
+var stackedWriteKeywords = []string{"INSERT", "UPDATE", "DELETE", "REPLACE", "LOAD"}
+
+// UNION SELECT这些库中的表即告警
+var systemSchemas = []string{"information_schema", "mysql", "performance_schema", "sys"}
+
+type PolicyCheckerSQLInjection struct {
+	maxLiteralVariants int
+
+	mutex    sync.Mutex
+	variants map[string]map[string]struct{} // 指纹 => 不同的内联常量SQL的签名
+}
+
+// maxLiteralVariants <= 0 时不检测未参数化的SQL
+func NewPolicyCheckerSQLInjection(maxLiteralVariants int) *PolicyCheckerSQLInjection {
+
+	return &PolicyCheckerSQLInjection{
+		maxLiteralVariants: maxLiteralVariants,
+		variants:           make(map[string]map[string]struct{}),
+	}
+}
+
+func newSQLInjectionPolicyError(err error, detail string) error {
+	return NewPolicyError(ErrPolicyCodeSQLInjection, fmt.Sprintf("Possible SQL injection: %v, %v", err, detail))
+}
+
+// 字面量的值，非字面量返回false
+func literalValueOf(expr sqlparser.Expr) (string, bool) {
+	switch expr := expr.(type) {
+	case *sqlparser.ParenExpr:
+		return literalValueOf(expr.Expr)
+	case sqlparser.BoolVal:
+		if expr {
+			return "1", true
+		}
+		return "0", true
+	case *sqlparser.SQLVal:
+		switch expr.Type {
+		case sqlparser.StrVal, sqlparser.IntVal, sqlparser.FloatVal, sqlparser.HexNum:
+			return string(expr.Val), true
+		}
+	}
+	return "", false
+}
+
+// 比较两个字面量，均为数值时按数值比较
+func compareLiterals(left, right string) int {
+	lf, lerr := strconv.ParseFloat(left, 64)
+	rf, rerr := strconv.ParseFloat(right, 64)
+	if lerr == nil && rerr == nil {
+		switch {
+		case lf < rf:
+			return -1
+		case lf > rf:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(left, right)
+}
+
+// 表达式是否恒为真
+func isTautology(expr sqlparser.Expr) bool {
+	switch expr := expr.(type) {
+	case *sqlparser.ParenExpr:
+		return isTautology(expr.Expr)
+	case *sqlparser.OrExpr:
+		return isTautology(expr.Left) || isTautology(expr.Right)
+	case *sqlparser.AndExpr:
+		return isTautology(expr.Left) && isTautology(expr.Right)
+	case sqlparser.BoolVal:
+		return bool(expr)
+	case *sqlparser.SQLVal:
+		if expr.Type != sqlparser.IntVal && expr.Type != sqlparser.FloatVal {
+			return false
+		}
+		f, err := strconv.ParseFloat(string(expr.Val), 64)
+		return err == nil && f != 0
+	case *sqlparser.ComparisonExpr:
+		// id = id
+		lcol, lok := expr.Left.(*sqlparser.ColName)
+		rcol, rok := expr.Right.(*sqlparser.ColName)
+		if lok && rok {
+			return lcol.Equal(rcol) && (expr.Operator == sqlparser.EqualStr || expr.Operator == sqlparser.LessEqualStr ||
+				expr.Operator == sqlparser.GreaterEqualStr)
+		}
+		left, lok := literalValueOf(expr.Left)
+		right, rok := literalValueOf(expr.Right)
+		if !lok || !rok {
+			return false
+		}
+		cmp := compareLiterals(left, right)
+		switch expr.Operator {
+		case sqlparser.EqualStr, sqlparser.NullSafeEqualStr:
+			return cmp == 0
+		case sqlparser.NotEqualStr:
+			return cmp != 0
+		case sqlparser.LessThanStr:
+			return cmp < 0
+		case sqlparser.GreaterThanStr:
+			return cmp > 0
+		case sqlparser.LessEqualStr:
+			return cmp <= 0
+		case sqlparser.GreaterEqualStr:
+			return cmp >= 0
+		case sqlparser.LikeStr:
+			return left == right
+		}
+	}
+	return false
+}
+
+// OR一侧恒为真的条件
+func findTautology(stmt sqlparser.Statement) string {
+	found := ""
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		orExpr, ok := node.(*sqlparser.OrExpr)
+		if !ok {
+			return true, nil
+		}
+		if isTautology(orExpr.Left) || isTautology(orExpr.Right) {
+			found = sqlparser.String(orExpr)
+			return false, fmt.Errorf("found")
+		}
+		return true, nil
+	}, stmt)
+	return found
+}
+
+// 最左侧的SELECT
+func leftmostSelect(stmt sqlparser.SelectStatement) *sqlparser.Select {
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		return stmt
+	case *sqlparser.ParenSelect:
+		return leftmostSelect(stmt.Select)
+	case *sqlparser.Union:
+		return leftmostSelect(stmt.Left)
+	}
+	return nil
+}
+
+// UNION右侧的SELECT是否为探测用的常量、NULL或系统库
+func isProbingSelect(sel *sqlparser.Select) bool {
+	for _, te := range sel.From {
+		ate, ok := te.(*sqlparser.AliasedTableExpr)
+		if !ok {
+			continue
+		}
+		tn, ok := ate.Expr.(sqlparser.TableName)
+		if !ok {
+			continue
+		}
+		if findIn(strings.ToLower(tn.Qualifier.String()), systemSchemas) {
+			return true
+		}
+	}
+	for _, se := range sel.SelectExprs {
+		ae, ok := se.(*sqlparser.AliasedExpr)
+		if !ok {
+			return false
+		}
+		switch expr := ae.Expr.(type) {
+		case *sqlparser.NullVal:
+		case *sqlparser.FuncExpr:
+			// user()、version()、database()等
+			if len(expr.Exprs) != 0 {
+				return false
+			}
+		default:
+			if _, ok := literalValueOf(expr); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 带WHERE的查询之后的UNION SELECT
+func findUnionSelect(stmt sqlparser.Statement) string {
+	found := ""
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		union, ok := node.(*sqlparser.Union)
+		if !ok {
+			return true, nil
+		}
+		left := leftmostSelect(union.Left)
+		right := leftmostSelect(union.Right)
+		if left != nil && left.Where != nil && right != nil && isProbingSelect(right) {
+			found = sqlparser.String(union.Right)
+			return false, fmt.Errorf("found")
+		}
+		return true, nil
+	}, stmt)
+	return found
+}
+
+// 单行SQL末尾的 -- 或 # 注释
+func findTruncatingComment(query string) string {
+	tokenizer := sqlparser.NewStringTokenizer(query)
+	last := ""
+	for {
+		tkn, val := tokenizer.Scan()
+		if tkn == 0 || tkn == sqlparser.LEX_ERROR {
+			break
+		}
+		if tkn == sqlparser.COMMENT && (strings.HasPrefix(string(val), "--") || strings.HasPrefix(string(val), "#")) {
+			last = string(val)
+			continue
+		}
+		last = ""
+	}
+	// 多行的SQL中，行尾的注释是正常的写法
+	if last == "" || strings.Contains(strings.TrimSpace(query), "\n") {
+		return ""
+	}
+	return strings.TrimSpace(last)
+}
+
+// 检测堆叠查询，statements为拆分后的各条语句
+func checkStackedQueries(statements []string) error {
+	if len(statements) <= 1 {
+		return nil
+	}
+	first := strings.ToUpper(sqlparser.StripLeadingComments(statements[0]))
+	afterSelect := strings.HasPrefix(first, "SELECT")
+	for i := 1; i < len(statements); i++ {
+		fields := strings.Fields(strings.ToUpper(sqlparser.StripLeadingComments(statements[i])))
+		if len(fields) <= 0 {
+			continue
+		}
+		if findIn(fields[0], stackedDangerousKeywords) {
+			return newSQLInjectionPolicyError(ErrInjectionStackedQueries,
+				fmt.Sprintf("statement %v/%v stacked: %v", i+1, len(statements), statements[i]))
+		}
+		if afterSelect && findIn(fields[0], stackedWriteKeywords) {
+			return newSQLInjectionPolicyError(ErrInjectionStackedQueries,
+				fmt.Sprintf("statement %v/%v stacked after SELECT: %v", i+1, len(statements), statements[i]))
+		}
+	}
+	return nil
+}
+
+func findIn(value string, values []string) bool {
+	for i := 0; i < len(values); i++ {
+		if value == values[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// 记录内联常量的SQL，返回该指纹已出现的不同SQL的个数
+func (pcsi *PolicyCheckerSQLInjection) recordLiteralVariant(fingerprint string, query string) int {
+	pcsi.mutex.Lock()
+	defer pcsi.mutex.Unlock()
+
+	variants, ok := pcsi.variants[fingerprint]
+	if !ok {
+		if len(pcsi.variants) >= MaxTrackedFingerprints {
+			pcsi.variants = make(map[string]map[string]struct{})
+		}
+		variants = make(map[string]struct{})
+		pcsi.variants[fingerprint] = variants
+	}
+	if len(variants) < pcsi.maxLiteralVariants {
+		variants[misc.MD5String(query)] = struct{}{}
+	}
+	return len(variants)
+}
+
+// 多语句需要整体检测堆叠查询
+func (pcsi *PolicyCheckerSQLInjection) CheckMultiStatements(db *sql.DB, query string, statements []string) error {
+
+	log.MSKLog().Infof("PolicyCheckerSQLInjection:CheckMultiStatements(%v, %v) with %v", query, statements, pcsi)
+
+	return checkStackedQueries(statements)
+}
+
+func (pcsi *PolicyCheckerSQLInjection) Check(db *sql.DB, explainRecords []ExplainRecord, query string, args []interface{}) error {
+
+	log.MSKLog().Infof("PolicyCheckerSQLInjection:Check(%v, %v, %v args) with %v", explainRecords, query, len(args), pcsi)
+
+	if strings.Contains(query, ";") {
+		if pieces, err := sqlparser.SplitStatementToPieces(query); err == nil {
+			statements := []string{}
+			for _, piece := range pieces {
+				if piece = strings.TrimSpace(piece); sqlparser.StripLeadingComments(piece) != "" {
+					statements = append(statements, piece)
+				}
+			}
+			if err := checkStackedQueries(statements); err != nil {
+				return err
+			}
+		}
+	}
+
+	if comment := findTruncatingComment(query); comment != "" {
+		return newSQLInjectionPolicyError(ErrInjectionCommentTruncation, fmt.Sprintf("comment(%v)", comment))
+	}
+
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		log.MSKLog().Warnf("PolicyCheckerSQLInjection:Check(%v, %v, %v args) sqlparser.Parse failed with err %v",
+			explainRecords, misc.FingerprintSQL(query), len(args), err)
+		return nil
+	}
+	if tautology := findTautology(stmt); tautology != "" {
+		return newSQLInjectionPolicyError(ErrInjectionTautology, fmt.Sprintf("condition(%v)", tautology))
+	}
+	if union := findUnionSelect(stmt); union != "" {
+		return newSQLInjectionPolicyError(ErrInjectionUnionSelect, fmt.Sprintf("union(%v)", union))
+	}
+
+	// 没有?参数，但有内联的常量
+	if pcsi.maxLiteralVariants <= 0 || len(args) != 0 || misc.CountQuestionMark(query) != 0 {
+		return nil
+	}
+	fingerprint := misc.FingerprintSQL(query)
+	if !strings.Contains(fingerprint, "?") {
+		return nil
+	}
+	if cnt := pcsi.recordLiteralVariant(fingerprint, query); cnt >= pcsi.maxLiteralVariants {
+		return NewPolicyError(WarnPolicyCodeUnparameterized,
+			fmt.Sprintf("Query fingerprint(%v) arrived with at least %v different inline literals instead of ? args, "+
+				"likely built by string concatenation", fingerprint, cnt))
+	}
+	return nil
+}
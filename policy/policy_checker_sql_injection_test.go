@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPolicySQLInjectionCheck(t *testing.T) {
+	cases := []struct {
+		query string
+		err   error
+	}{
+		{query: "SELECT * FROM user WHERE name = 'a' OR 1=1", err: ErrInjectionTautology},
+		{query: "SELECT * FROM user WHERE name = 'a' OR 'a'='a'", err: ErrInjectionTautology},
+		{query: "SELECT * FROM user WHERE name = 'a' OR (2 > 1)", err: ErrInjectionTautology},
+		{query: "SELECT * FROM user WHERE name = 'a' OR true", err: ErrInjectionTautology},
+		{query: "SELECT * FROM user WHERE id = 1 OR id = id", err: ErrInjectionTautology},
+		{query: "DELETE FROM user WHERE name = 'a' OR 1", err: ErrInjectionTautology},
+		{query: "SELECT * FROM user WHERE id = 1; DELETE FROM user", err: ErrInjectionStackedQueries},
+		{query: "UPDATE user SET name = 'a' WHERE id = 1; DROP TABLE user", err: ErrInjectionStackedQueries},
+		{query: "SELECT * FROM user WHERE name = 'admin' -- ' AND password = 'x'", err: ErrInjectionCommentTruncation},
+		{query: "SELECT * FROM user WHERE name = 'admin'#' AND password = 'x'", err: ErrInjectionCommentTruncation},
+		{query: "SELECT name FROM user WHERE id = 1 UNION SELECT 1", err: ErrInjectionUnionSelect},
+		{query: "SELECT name, age FROM user WHERE id = 1 UNION ALL SELECT NULL, user()", err: ErrInjectionUnionSelect},
+		{query: "SELECT name FROM user WHERE id = 1 UNION SELECT table_name FROM information_schema.tables", err: ErrInjectionUnionSelect},
+
+		{query: "SELECT * FROM user WHERE 1=1 AND name = ?"},
+		{query: "SELECT * FROM user WHERE name = ? OR age > ?"},
+		{query: "SELECT * FROM user WHERE name = 'a;b' OR name = '1=1'"},
+		{query: "UPDATE user SET name = 'a' WHERE id = 1; UPDATE user SET name = 'b' WHERE id = 2"},
+		{query: "SELECT * FROM user WHERE id = ? /* trace_id=1 */"},
+		{query: "SELECT *\nFROM user -- all users\nWHERE id = ? -- by id"},
+		{query: "SELECT name FROM user WHERE id = 1 UNION SELECT name FROM admin WHERE id = 1"},
+		{query: "SELECT 1 UNION SELECT 2"},
+		{query: "SELECT * FROM user WHERE id = 1; SELECT * FROM role WHERE uid = 1"},
+	}
+
+	pc := NewPolicyCheckerSQLInjection(0)
+	for _, testCase := range cases {
+		err := pc.Check(nil, nil, testCase.query, nil)
+		if testCase.err == nil {
+			if err != nil {
+				t.Fatalf("%v should pass, got %v", testCase.query, err)
+			}
+			continue
+		}
+		pe, ok := err.(*PolicyError)
+		if !ok || pe.Code != ErrPolicyCodeSQLInjection || !strings.Contains(pe.Msg, testCase.err.Error()) {
+			t.Fatalf("%v should be failed with %v, got %v", testCase.query, testCase.err, err)
+		}
+	}
+}
+
+func TestPolicySQLInjectionCheckMultiStatements(t *testing.T) {
+	pc := NewPolicyCheckerSQLInjection(0)
+
+	err := pc.CheckMultiStatements(nil, "SELECT * FROM user WHERE id = 1; DELETE FROM user",
+		[]string{"SELECT * FROM user WHERE id = 1", "DELETE FROM user"})
+	if pe, ok := err.(*PolicyError); !ok || pe.Code != ErrPolicyCodeSQLInjection {
+		t.Fatalf("stacked queries after SELECT should be failed, got %v", err)
+	}
+
+	err = pc.CheckMultiStatements(nil, "INSERT INTO log VALUES (1); TRUNCATE TABLE user",
+		[]string{"INSERT INTO log VALUES (1)", "TRUNCATE TABLE user"})
+	if pe, ok := err.(*PolicyError); !ok || pe.Code != ErrPolicyCodeSQLInjection || !strings.Contains(pe.Msg, "2/2") {
+		t.Fatalf("stacked TRUNCATE should be failed, got %v", err)
+	}
+
+	err = pc.CheckMultiStatements(nil, "SELECT * FROM user WHERE id = 1; SELECT FOUND_ROWS()",
+		[]string{"SELECT * FROM user WHERE id = 1", "SELECT FOUND_ROWS()"})
+	if err != nil {
+		t.Fatalf("batched reads should pass, got %v", err)
+	}
+
+	err = pc.CheckMultiStatements(nil, "SELECT * FROM user WHERE id = 1; /* x */ update user SET a = 1",
+		[]string{"SELECT * FROM user WHERE id = 1", "/* x */ update user SET a = 1"})
+	if pe, ok := err.(*PolicyError); !ok || pe.Code != ErrPolicyCodeSQLInjection || !strings.Contains(pe.Msg, "after SELECT") {
+		t.Fatalf("UPDATE stacked after SELECT should be failed, got %v", err)
+	}
+
+	err = pc.CheckMultiStatements(nil, "UPDATE user SET a = 1 WHERE id = 1; UPDATE user SET a = 2 WHERE id = 2",
+		[]string{"UPDATE user SET a = 1 WHERE id = 1", "UPDATE user SET a = 2 WHERE id = 2"})
+	if err != nil {
+		t.Fatalf("batched updates should pass, got %v", err)
+	}
+}
+
+func TestPolicySQLInjectionUnparameterized(t *testing.T) {
+	pc := NewPolicyCheckerSQLInjection(3)
+
+	for i := 0; i < 2; i++ {
+		query := fmt.Sprintf("SELECT * FROM user WHERE id = %v", i)
+		if err := pc.Check(nil, nil, query, nil); err != nil {
+			t.Fatalf("%v should pass, got %v", query, err)
+		}
+		// 同样的SQL不重复计数
+		if err := pc.Check(nil, nil, query, nil); err != nil {
+			t.Fatalf("%v should pass, got %v", query, err)
+		}
+	}
+
+	// 参数化的SQL不计数
+	if err := pc.Check(nil, nil, "SELECT * FROM user WHERE id = ?", []interface{}{3}); err != nil {
+		t.Fatalf("parameterized query should pass, got %v", err)
+	}
+
+	err := pc.Check(nil, nil, "SELECT * FROM user WHERE id = 3", nil)
+	pe, ok := err.(*PolicyError)
+	if !ok || pe.Code != WarnPolicyCodeUnparameterized || !strings.Contains(pe.Msg, "select * from user where id = ?") {
+		t.Fatalf("unparameterized query should be warned, got %v", err)
+	}
+
+	// 没有常量的SQL不计数
+	for i := 0; i < 5; i++ {
+		if err := pc.Check(nil, nil, "SELECT * FROM user", nil); err != nil {
+			t.Fatalf("query without literals should pass, got %v", err)
+		}
+	}
+}
@@ -212,6 +212,23 @@ func (pcw *PolicyCheckWrapper) Check(query string, args ...interface{}) error {
 	return pcw.checker.Check(pcw.db, nil, query, args)
 }
 
+// 构造explain记录, 空字符串表示NULL
+func newExplainRecordForTest(id, selectType, table, tp, key, rows, filtered, extra string) ExplainRecord {
+	nullString := func(s string) sql.NullString {
+		return sql.NullString{String: s, Valid: s != ""}
+	}
+	return ExplainRecord{
+		ID:         nullString(id),
+		SelectType: nullString(selectType),
+		Table:      nullString(table),
+		Type:       nullString(tp),
+		Key:        nullString(key),
+		Rows:       nullString(rows),
+		Filtered:   nullString(filtered),
+		Extra:      nullString(extra),
+	}
+}
+
 func TestPolicyCheckerIsTableName(t *testing.T) {
 
 	isTable1 := isTableName("<derived>")
@@ -0,0 +1,435 @@
+package policy
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"strings"
+
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+)
+
+/*
+
+策略的组合，不修改被组合的策略本身
+
+1. All(pcs...)：全部通过才通过，返回第一个错误
+2. Any(pcs...)：任一通过即通过，全部不通过时返回第一个错误
+3. Not(pc, code, msg)：pc通过时返回NewPolicyError(code, msg)，pc不通过时通过
+4. OnlyFor(pc, stmtTypes...)：只检查sqlparser.Preview分类为stmtTypes（sqlparser.StmtSelect、StmtUpdate等）的语句
+5. OnlyTables(pc, globs...)：只检查涉及的表名匹配globs（path.Match语法，不区分大小写）的语句，以!开头的glob表示排除
+6. WithSeverity(pc, lvl)：pc返回的PolicyError以lvl的级别通知
+
+eg. 只对UPDATE/DELETE检查全表扫描，日志表不检查扫描的行数
+	OnlyFor(NewPolicyCheckerFieldsType(), sqlparser.StmtUpdate, sqlparser.StmtDelete)
+	OnlyTables(NewPolicyCheckerRowsAbsolute(10000), "!log_*")
+
+组合后的策略同样转发DDLPolicyChecker、MultiStatementsPolicyChecker和ResultSetPolicyChecker的检查，被组合的策略没有实现的视为通过。
+被组合的策略均不检查结果集时，SupportsResultSet返回false，驱动不会为其上报结果集。
+Not只对实现了相应接口的策略取反。
+
+*/
+
+type policyCheckFunc func(pc PolicyChecker) (bool, error)
+
+func checkOf(db *sql.DB, er []ExplainRecord, query string, args []interface{}) policyCheckFunc {
+	return func(pc PolicyChecker) (bool, error) {
+		return true, pc.Check(db, er, query, args)
+	}
+}
+
+func checkDDLOf(db *sql.DB, query string) policyCheckFunc {
+	return func(pc PolicyChecker) (bool, error) {
+		dpc, ok := pc.(DDLPolicyChecker)
+		if !ok {
+			return false, nil
+		}
+		return true, dpc.CheckDDL(db, query)
+	}
+}
+
+func checkMultiStatementsOf(db *sql.DB, query string, statements []string) policyCheckFunc {
+	return func(pc PolicyChecker) (bool, error) {
+		mpc, ok := pc.(MultiStatementsPolicyChecker)
+		if !ok {
+			return false, nil
+		}
+		return true, mpc.CheckMultiStatements(db, query, statements)
+	}
+}
+
+func checkResultSetOf(db *sql.DB, er []ExplainRecord, query string, args []interface{}, stats ResultSetStats) policyCheckFunc {
+	return func(pc PolicyChecker) (bool, error) {
+		rpc, ok := pc.(ResultSetPolicyChecker)
+		if !ok || !SupportsResultSet(pc) {
+			return false, nil
+		}
+		return true, rpc.CheckResultSet(db, er, query, args, stats)
+	}
+}
+
+// 组合后的策略均实现了ResultSetPolicyChecker，是否需要结果集取决于被组合的策略
+type resultSetSupporter interface {
+	supportsResultSet() bool
+}
+
+// pc是否检查结果集，驱动据此决定是否上报结果集（并explain）
+func SupportsResultSet(pc PolicyChecker) bool {
+	if rss, ok := pc.(resultSetSupporter); ok {
+		return rss.supportsResultSet()
+	}
+	_, ok := pc.(ResultSetPolicyChecker)
+	return ok
+}
+
+func supportsResultSetAny(pcs []PolicyChecker) bool {
+	for _, pc := range pcs {
+		if SupportsResultSet(pc) {
+			return true
+		}
+	}
+	return false
+}
+
+type PolicyCheckerAll struct {
+	pcs []PolicyChecker
+}
+
+func All(pcs ...PolicyChecker) *PolicyCheckerAll {
+	return &PolicyCheckerAll{pcs: pcs}
+}
+
+func (pca *PolicyCheckerAll) check(fn policyCheckFunc) error {
+	for _, pc := range pca.pcs {
+		if _, err := fn(pc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pca *PolicyCheckerAll) Check(db *sql.DB, er []ExplainRecord, query string, args []interface{}) error {
+	return pca.check(checkOf(db, er, query, args))
+}
+
+func (pca *PolicyCheckerAll) CheckDDL(db *sql.DB, query string) error {
+	return pca.check(checkDDLOf(db, query))
+}
+
+func (pca *PolicyCheckerAll) CheckMultiStatements(db *sql.DB, query string, statements []string) error {
+	return pca.check(checkMultiStatementsOf(db, query, statements))
+}
+
+func (pca *PolicyCheckerAll) CheckResultSet(db *sql.DB, er []ExplainRecord, query string, args []interface{}, stats ResultSetStats) error {
+	return pca.check(checkResultSetOf(db, er, query, args, stats))
+}
+
+func (pca *PolicyCheckerAll) supportsResultSet() bool {
+	return supportsResultSetAny(pca.pcs)
+}
+
+type PolicyCheckerAny struct {
+	pcs []PolicyChecker
+}
+
+func Any(pcs ...PolicyChecker) *PolicyCheckerAny {
+	return &PolicyCheckerAny{pcs: pcs}
+}
+
+func (pca *PolicyCheckerAny) check(fn policyCheckFunc) error {
+	var first error
+	for _, pc := range pca.pcs {
+		checked, err := fn(pc)
+		if !checked {
+			continue
+		}
+		if err == nil {
+			return nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (pca *PolicyCheckerAny) Check(db *sql.DB, er []ExplainRecord, query string, args []interface{}) error {
+	return pca.check(checkOf(db, er, query, args))
+}
+
+func (pca *PolicyCheckerAny) CheckDDL(db *sql.DB, query string) error {
+	return pca.check(checkDDLOf(db, query))
+}
+
+func (pca *PolicyCheckerAny) CheckMultiStatements(db *sql.DB, query string, statements []string) error {
+	return pca.check(checkMultiStatementsOf(db, query, statements))
+}
+
+func (pca *PolicyCheckerAny) CheckResultSet(db *sql.DB, er []ExplainRecord, query string, args []interface{}, stats ResultSetStats) error {
+	return pca.check(checkResultSetOf(db, er, query, args, stats))
+}
+
+func (pca *PolicyCheckerAny) supportsResultSet() bool {
+	return supportsResultSetAny(pca.pcs)
+}
+
+type PolicyCheckerNot struct {
+	pc   PolicyChecker
+	code PolicyCode
+	msg  string
+}
+
+func Not(pc PolicyChecker, code PolicyCode, msg string) *PolicyCheckerNot {
+	return &PolicyCheckerNot{pc: pc, code: code, msg: msg}
+}
+
+func (pcn *PolicyCheckerNot) check(fn policyCheckFunc, query string) error {
+	checked, err := fn(pcn.pc)
+	if !checked || err != nil {
+		return nil
+	}
+	return NewPolicyError(pcn.code, fmt.Sprintf("%v: %v", pcn.msg, query))
+}
+
+func (pcn *PolicyCheckerNot) Check(db *sql.DB, er []ExplainRecord, query string, args []interface{}) error {
+	return pcn.check(checkOf(db, er, query, args), query)
+}
+
+func (pcn *PolicyCheckerNot) CheckDDL(db *sql.DB, query string) error {
+	return pcn.check(checkDDLOf(db, query), query)
+}
+
+func (pcn *PolicyCheckerNot) CheckMultiStatements(db *sql.DB, query string, statements []string) error {
+	return pcn.check(checkMultiStatementsOf(db, query, statements), query)
+}
+
+func (pcn *PolicyCheckerNot) CheckResultSet(db *sql.DB, er []ExplainRecord, query string, args []interface{}, stats ResultSetStats) error {
+	return pcn.check(checkResultSetOf(db, er, query, args, stats), query)
+}
+
+func (pcn *PolicyCheckerNot) supportsResultSet() bool {
+	return SupportsResultSet(pcn.pc)
+}
+
+type PolicyCheckerOnlyFor struct {
+	pc        PolicyChecker
+	stmtTypes map[int]struct{}
+}
+
+func OnlyFor(pc PolicyChecker, stmtTypes ...int) *PolicyCheckerOnlyFor {
+	pco := &PolicyCheckerOnlyFor{pc: pc, stmtTypes: map[int]struct{}{}}
+	for _, stmtType := range stmtTypes {
+		pco.stmtTypes[stmtType] = struct{}{}
+	}
+	return pco
+}
+
+func (pco *PolicyCheckerOnlyFor) match(query string) bool {
+	stmtType := sqlparser.Preview(query)
+	_, ok := pco.stmtTypes[stmtType]
+	log.MSKLog().Debugf("PolicyCheckerOnlyFor.match(%v) statement type %v matched %v",
+		query, sqlparser.StmtType(stmtType), ok)
+	return ok
+}
+
+func (pco *PolicyCheckerOnlyFor) Check(db *sql.DB, er []ExplainRecord, query string, args []interface{}) error {
+	if !pco.match(query) {
+		return nil
+	}
+	return pco.pc.Check(db, er, query, args)
+}
+
+func (pco *PolicyCheckerOnlyFor) CheckDDL(db *sql.DB, query string) error {
+	if !pco.match(query) {
+		return nil
+	}
+	_, err := checkDDLOf(db, query)(pco.pc)
+	return err
+}
+
+// 任一语句的类型匹配即检查
+func (pco *PolicyCheckerOnlyFor) CheckMultiStatements(db *sql.DB, query string, statements []string) error {
+	for _, statement := range statements {
+		if pco.match(statement) {
+			_, err := checkMultiStatementsOf(db, query, statements)(pco.pc)
+			return err
+		}
+	}
+	return nil
+}
+
+func (pco *PolicyCheckerOnlyFor) CheckResultSet(db *sql.DB, er []ExplainRecord, query string, args []interface{}, stats ResultSetStats) error {
+	if !pco.match(query) {
+		return nil
+	}
+	_, err := checkResultSetOf(db, er, query, args, stats)(pco.pc)
+	return err
+}
+
+func (pco *PolicyCheckerOnlyFor) supportsResultSet() bool {
+	return SupportsResultSet(pco.pc)
+}
+
+type PolicyCheckerOnlyTables struct {
+	pc       PolicyChecker
+	includes []string
+	excludes []string
+}
+
+func OnlyTables(pc PolicyChecker, globs ...string) *PolicyCheckerOnlyTables {
+	pco := &PolicyCheckerOnlyTables{pc: pc}
+	for _, glob := range globs {
+		glob = strings.ToLower(strings.TrimSpace(glob))
+		if strings.HasPrefix(glob, "!") {
+			pco.excludes = append(pco.excludes, glob[1:])
+		} else if glob != "" {
+			pco.includes = append(pco.includes, glob)
+		}
+	}
+	return pco
+}
+
+// 语句中涉及的表（小写，不包括dual），无法解析时取explain中的表
+// 只取FROM/JOIN中的表以及INSERT/DDL的目标表，列名的限定（eg. l.id中的别名l）不是表
+func TablesOfQuery(query string, er []ExplainRecord) []string {
+	tables := []string{}
+	stmt, err := sqlparser.Parse(query)
+	if err == nil {
+		add := func(tn sqlparser.TableName) {
+			if !tn.IsEmpty() && !strings.EqualFold(tn.Name.String(), "dual") {
+				tables = append(tables, strings.ToLower(tableNameString(tn)))
+			}
+		}
+		_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			switch node := node.(type) {
+			case *sqlparser.AliasedTableExpr:
+				if tn, ok := node.Expr.(sqlparser.TableName); ok {
+					add(tn)
+				}
+			case *sqlparser.Insert:
+				add(node.Table)
+			case *sqlparser.DDL:
+				add(node.Table)
+				if node.NewName != node.Table {
+					add(node.NewName)
+				}
+			}
+			return true, nil
+		}, stmt)
+		return tables
+	}
+	for i := 0; i < len(er); i++ {
+		if isTableName(er[i].Table.String) {
+			tables = append(tables, strings.ToLower(er[i].Table.String))
+		}
+	}
+	return tables
+}
+
+func MatchTableGlobs(table string, globs []string) bool {
+	name := table
+	if idx := strings.LastIndex(table, "."); idx >= 0 {
+		name = table[idx+1:]
+	}
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, table); ok {
+			return true
+		}
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 任一表被includes匹配（includes为空时视为匹配）且不被excludes匹配即检查
+func (pco *PolicyCheckerOnlyTables) match(query string, er []ExplainRecord) bool {
+	for _, table := range TablesOfQuery(query, er) {
+		if len(pco.includes) > 0 && !MatchTableGlobs(table, pco.includes) {
+			continue
+		}
+		if MatchTableGlobs(table, pco.excludes) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (pco *PolicyCheckerOnlyTables) Check(db *sql.DB, er []ExplainRecord, query string, args []interface{}) error {
+	if !pco.match(query, er) {
+		return nil
+	}
+	return pco.pc.Check(db, er, query, args)
+}
+
+func (pco *PolicyCheckerOnlyTables) CheckDDL(db *sql.DB, query string) error {
+	if !pco.match(query, nil) {
+		return nil
+	}
+	_, err := checkDDLOf(db, query)(pco.pc)
+	return err
+}
+
+func (pco *PolicyCheckerOnlyTables) CheckMultiStatements(db *sql.DB, query string, statements []string) error {
+	for _, statement := range statements {
+		if pco.match(statement, nil) {
+			_, err := checkMultiStatementsOf(db, query, statements)(pco.pc)
+			return err
+		}
+	}
+	return nil
+}
+
+func (pco *PolicyCheckerOnlyTables) CheckResultSet(db *sql.DB, er []ExplainRecord, query string, args []interface{}, stats ResultSetStats) error {
+	if !pco.match(query, er) {
+		return nil
+	}
+	_, err := checkResultSetOf(db, er, query, args, stats)(pco.pc)
+	return err
+}
+
+func (pco *PolicyCheckerOnlyTables) supportsResultSet() bool {
+	return SupportsResultSet(pco.pc)
+}
+
+type PolicyCheckerWithSeverity struct {
+	pc  PolicyChecker
+	lvl log.Level
+}
+
+func WithSeverity(pc PolicyChecker, lvl log.Level) *PolicyCheckerWithSeverity {
+	return &PolicyCheckerWithSeverity{pc: pc, lvl: lvl}
+}
+
+func (pcs *PolicyCheckerWithSeverity) withSeverity(err error) error {
+	pe, ok := err.(*PolicyError)
+	if !ok {
+		return err
+	}
+	return pe.WithSeverity(pcs.lvl)
+}
+
+func (pcs *PolicyCheckerWithSeverity) Check(db *sql.DB, er []ExplainRecord, query string, args []interface{}) error {
+	return pcs.withSeverity(pcs.pc.Check(db, er, query, args))
+}
+
+func (pcs *PolicyCheckerWithSeverity) CheckDDL(db *sql.DB, query string) error {
+	_, err := checkDDLOf(db, query)(pcs.pc)
+	return pcs.withSeverity(err)
+}
+
+func (pcs *PolicyCheckerWithSeverity) CheckMultiStatements(db *sql.DB, query string, statements []string) error {
+	_, err := checkMultiStatementsOf(db, query, statements)(pcs.pc)
+	return pcs.withSeverity(err)
+}
+
+func (pcs *PolicyCheckerWithSeverity) CheckResultSet(db *sql.DB, er []ExplainRecord, query string, args []interface{}, stats ResultSetStats) error {
+	_, err := checkResultSetOf(db, er, query, args, stats)(pcs.pc)
+	return pcs.withSeverity(err)
+}
+
+func (pcs *PolicyCheckerWithSeverity) supportsResultSet() bool {
+	return SupportsResultSet(pcs.pc)
+}
@@ -0,0 +1,221 @@
+package policy
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"gitlab.papegames.com/fringe/mskeeper/log"
+	"gitlab.papegames.com/fringe/mskeeper/sqlparser"
+)
+
+// 测试用的策略：err为nil时通过，并记录被调用的次数
+type policyCheckerStub struct {
+	err   error
+	calls int
+}
+
+func (pcs *policyCheckerStub) Check(db *sql.DB, er []ExplainRecord, query string, args []interface{}) error {
+	pcs.calls++
+	return pcs.err
+}
+
+type ddlPolicyCheckerStub struct {
+	policyCheckerStub
+	ddlCalls int
+}
+
+func (pcs *ddlPolicyCheckerStub) CheckDDL(db *sql.DB, query string) error {
+	pcs.ddlCalls++
+	return pcs.err
+}
+
+func (pcs *ddlPolicyCheckerStub) CheckMultiStatements(db *sql.DB, query string, statements []string) error {
+	return pcs.err
+}
+
+func TestPolicyCombinatorAllAnyNot(t *testing.T) {
+	errRows := NewPolicyError(ErrPolicyCodeRowsAbs, "rows")
+	errScan := NewPolicyError(ErrPolicyCodeAllTableScan, "scan")
+	pass := &policyCheckerStub{}
+	query := "SELECT * FROM user"
+
+	if err := All(pass, &policyCheckerStub{err: errRows}, &policyCheckerStub{err: errScan}).Check(nil, nil, query, nil); err != errRows {
+		t.Fatalf("All should return the first error, got %v", err)
+	}
+	if err := All(pass, pass).Check(nil, nil, query, nil); err != nil {
+		t.Fatalf("All should pass, got %v", err)
+	}
+	if err := Any(&policyCheckerStub{err: errRows}, pass).Check(nil, nil, query, nil); err != nil {
+		t.Fatalf("Any should pass, got %v", err)
+	}
+	if err := Any(&policyCheckerStub{err: errRows}, &policyCheckerStub{err: errScan}).Check(nil, nil, query, nil); err != errRows {
+		t.Fatalf("Any should return the first error, got %v", err)
+	}
+
+	err := Not(pass, ErrPolicyCodeAllTableScan, "must be failed").Check(nil, nil, query, nil)
+	if pe, ok := err.(*PolicyError); !ok || pe.Code != ErrPolicyCodeAllTableScan {
+		t.Fatalf("Not of passed policy should be failed, got %v", err)
+	}
+	if err := Not(&policyCheckerStub{err: errRows}, ErrPolicyCodeAllTableScan, "").Check(nil, nil, query, nil); err != nil {
+		t.Fatalf("Not of failed policy should pass, got %v", err)
+	}
+}
+
+func TestPolicyCombinatorDDL(t *testing.T) {
+	errDDL := NewPolicyError(ErrPolicyCodeOnlineDDL, "ddl")
+	ddl := &ddlPolicyCheckerStub{policyCheckerStub: policyCheckerStub{err: errDDL}}
+	pass := &policyCheckerStub{}
+	query := "ALTER TABLE user ADD COLUMN age int"
+
+	var _ DDLPolicyChecker = All()
+	var _ MultiStatementsPolicyChecker = All()
+
+	if err := All(pass, ddl).CheckDDL(nil, query); err != errDDL {
+		t.Fatalf("All should forward CheckDDL, got %v", err)
+	}
+	// 没有实现DDLPolicyChecker的策略不参与
+	if err := Any(pass, ddl).CheckDDL(nil, query); err != errDDL {
+		t.Fatalf("Any should ignore non-DDL policies, got %v", err)
+	}
+	if err := Not(pass, ErrPolicyCodeOnlineDDL, "").CheckDDL(nil, query); err != nil {
+		t.Fatalf("Not should ignore non-DDL policies, got %v", err)
+	}
+	if err := OnlyFor(ddl, sqlparser.StmtDDL).CheckDDL(nil, query); err != errDDL {
+		t.Fatalf("OnlyFor should forward CheckDDL, got %v", err)
+	}
+	if err := OnlyTables(ddl, "log_*").CheckDDL(nil, query); err != nil || ddl.ddlCalls != 3 {
+		t.Fatalf("OnlyTables should skip unmatched tables, got %v, calls %v", err, ddl.ddlCalls)
+	}
+	if err := All(pass, ddl).CheckMultiStatements(nil, "SELECT 1; "+query, []string{"SELECT 1", query}); err != errDDL {
+		t.Fatalf("All should forward CheckMultiStatements, got %v", err)
+	}
+}
+
+func TestPolicyCombinatorSupportsResultSet(t *testing.T) {
+	pass := &policyCheckerStub{}
+	rs := NewPolicyCheckerResultSet(100, 0, 0)
+
+	if SupportsResultSet(pass) || !SupportsResultSet(rs) {
+		t.Fatalf("SupportsResultSet of plain policies")
+	}
+	// 组合策略只有被组合的策略检查结果集时才需要结果集
+	without := []PolicyChecker{All(pass), Any(pass), Not(pass, ErrPolicyCodeRowsAbs, ""),
+		OnlyFor(pass, sqlparser.StmtSelect), OnlyTables(pass, "user"), WithSeverity(pass, log.WarnLevel), All(Any(pass))}
+	for _, pc := range without {
+		if SupportsResultSet(pc) {
+			t.Fatalf("%T without result set policies should not support result set", pc)
+		}
+	}
+	with := []PolicyChecker{All(pass, rs), Any(rs), Not(rs, ErrPolicyCodeRowsAbs, ""),
+		OnlyFor(rs, sqlparser.StmtSelect), OnlyTables(rs, "user"), WithSeverity(rs, log.WarnLevel), All(Any(pass, rs))}
+	for _, pc := range with {
+		if !SupportsResultSet(pc) {
+			t.Fatalf("%T with result set policies should support result set", pc)
+		}
+	}
+}
+
+func TestPolicyCombinatorOnlyFor(t *testing.T) {
+	pc := &policyCheckerStub{err: NewPolicyError(ErrPolicyCodeAllTableScan, "scan")}
+	onlyFor := OnlyFor(pc, sqlparser.StmtUpdate, sqlparser.StmtDelete)
+
+	cases := []struct {
+		query  string
+		failed bool
+	}{
+		{query: "SELECT * FROM user WHERE name = 'a'"},
+		{query: "INSERT INTO user(name) VALUES ('a')"},
+		{query: "UPDATE user SET age = 1 WHERE name = 'a'", failed: true},
+		{query: "/* trace */ delete FROM user WHERE name = 'a'", failed: true},
+	}
+	for _, testCase := range cases {
+		err := onlyFor.Check(nil, nil, testCase.query, nil)
+		if (err != nil) != testCase.failed {
+			t.Fatalf("OnlyFor(%v) expect failed %v, got %v", testCase.query, testCase.failed, err)
+		}
+	}
+	if pc.calls != 2 {
+		t.Fatalf("OnlyFor should only check UPDATE/DELETE, called %v", pc.calls)
+	}
+}
+
+func TestPolicyCombinatorOnlyTables(t *testing.T) {
+	pc := &policyCheckerStub{err: NewPolicyError(ErrPolicyCodeRowsAbs, "rows")}
+
+	cases := []struct {
+		globs  []string
+		query  string
+		er     []ExplainRecord
+		failed bool
+	}{
+		{globs: []string{"!log_*"}, query: "SELECT * FROM log_login WHERE uid = 1"},
+		{globs: []string{"!log_*"}, query: "SELECT * FROM user WHERE uid = 1", failed: true},
+		{globs: []string{"!LOG_*"}, query: "SELECT * FROM db.Log_Login l JOIN user u ON l.uid = u.uid", failed: true},
+		{globs: []string{"user*"}, query: "UPDATE user_item SET cnt = 1 WHERE id = ?", failed: true},
+		{globs: []string{"user*"}, query: "INSERT INTO item(id) SELECT id FROM user", failed: true},
+		{globs: []string{"user*"}, query: "DELETE FROM item WHERE id = 1"},
+		{globs: []string{"db.user"}, query: "SELECT * FROM db.user", failed: true},
+		{globs: []string{"!log_*"}, query: "SELECT 1"},
+		// 别名不是表
+		{globs: []string{"!log_*"}, query: "SELECT l.id FROM log_2024 l WHERE l.x > 1"},
+		{globs: []string{"l"}, query: "SELECT l.id FROM log_2024 l WHERE l.x > 1"},
+		// 无法解析时取explain中的表，忽略<derived2>等
+		{globs: []string{"user"}, query: "SELECT * FROM user WHERE", failed: true,
+			er: []ExplainRecord{{Table: sql.NullString{String: "user", Valid: true}}}},
+		{globs: []string{"user"}, query: "SELECT * FROM user WHERE",
+			er: []ExplainRecord{{Table: sql.NullString{String: "<derived2>", Valid: true}}}},
+	}
+	for _, testCase := range cases {
+		err := OnlyTables(pc, testCase.globs...).Check(nil, testCase.er, testCase.query, nil)
+		if (err != nil) != testCase.failed {
+			t.Fatalf("OnlyTables(%v).Check(%v) expect failed %v, got %v", testCase.globs, testCase.query, testCase.failed, err)
+		}
+	}
+}
+
+func TestPolicyTablesOfQuery(t *testing.T) {
+	cases := map[string][]string{
+		"SELECT l.id FROM log_2024 l WHERE l.x > 1":                         {"log_2024"},
+		"SELECT u.name FROM db.user u JOIN item i ON u.id = i.uid":          {"db.user", "item"},
+		"SELECT * FROM user WHERE id IN (SELECT uid FROM item i WHERE i.x)": {"user", "item"},
+		"UPDATE user u SET u.name = 'a' WHERE u.id = 1":                     {"user"},
+		"DELETE i FROM item i WHERE i.id = 1":                               {"item"},
+		"INSERT INTO item(id) VALUES (1)":                                   {"item"},
+		"ALTER TABLE user ADD COLUMN age INT":                               {"user"},
+		"SELECT 1 FROM dual":                                                {},
+	}
+	for query, expected := range cases {
+		if tables := TablesOfQuery(query, nil); !reflect.DeepEqual(tables, expected) {
+			t.Fatalf("TablesOfQuery(%v) got %v, expected %v", query, tables, expected)
+		}
+	}
+}
+
+func TestPolicyCombinatorWithSeverity(t *testing.T) {
+	pc := WithSeverity(&policyCheckerStub{err: NewPolicyError(ErrPolicyCodeRowsAbs, "rows")}, log.WarnLevel)
+
+	err := pc.Check(nil, nil, "SELECT * FROM user", nil)
+	pe, ok := err.(*PolicyError)
+	if !ok || pe.Code != ErrPolicyCodeRowsAbs {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if lvl, ok := pe.Severity(); !ok || lvl != log.WarnLevel {
+		t.Fatalf("unexpected severity %v %v", lvl, ok)
+	}
+	if lvl, ok := pe.WithMsg("msg").Severity(); !ok || lvl != log.WarnLevel {
+		t.Fatalf("severity should be kept, got %v %v", lvl, ok)
+	}
+	if _, ok := NewPolicyError(ErrPolicyCodeRowsAbs, "rows").Severity(); ok {
+		t.Fatalf("severity should not be set")
+	}
+
+	if err := WithSeverity(&policyCheckerStub{}, log.WarnLevel).Check(nil, nil, "SELECT 1", nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	other := fmt.Errorf("other")
+	if err := WithSeverity(&policyCheckerStub{err: other}, log.WarnLevel).Check(nil, nil, "SELECT 1", nil); err != other {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package policy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+performance_schema的语句摘要扫描
+
+未接入mskeeper的服务执行的SQL不经过mskeeper，周期性地读取当前库（DATABASE()）的
+performance_schema.events_statements_summary_by_digest，按DIGEST对比相邻两次采样的计数器：
+
+	执行次数、总耗时（SUM_TIMER_WAIT，皮秒）、扫描行数、返回行数、未使用索引（SUM_NO_INDEX_USED）的次数
+
+1. 首次出现的DIGEST只记录，不告警；计数器变小（TRUNCATE或被淘汰后重新统计）时以本次的值作为增量
+2. 增量中平均耗时 > MaxExecTime为ErrPolicyCodeExeCost；扫描行数/返回行数 > DigestRowsExaminedRatio，
+   或存在未使用索引的执行时为WarnPolicyCodeDigestInefficient
+3. 有QUERY_SAMPLE_TEXT（MySQL 8.0.3+）且未被截断时，由调用方对其explain并执行已挂载的策略
+
+*/
+
+const (
+	DefaultDigestScanPeriod        time.Duration = 10 * time.Minute
+	DefaultDigestRowsExaminedRatio               = 100.0
+	MinRowsExaminedOfDigestCheck                 = 1000 // 平均每次执行的扫描行数达到该值才检测扫描/返回比
+	MaxDigestsPerScan                            = 100  // 每次扫描至多检查的DIGEST数，按增量的耗时排序
+)
+
+type DigestSample struct {
+	Schema          string
+	Digest          string
+	DigestText      string
+	QuerySampleText string // 5.7中为空
+	CountStar       uint64
+	SumTimerWait    uint64 // 皮秒
+	SumRowsExamined uint64
+	SumRowsSent     uint64
+	SumNoIndexUsed  uint64
+	SampledAt       time.Time
+}
+
+// 相邻两次采样之间的增量
+type DigestDelta struct {
+	Sample       DigestSample // 本次的采样
+	ExecCount    uint64
+	TimerWait    time.Duration
+	RowsExamined uint64
+	RowsSent     uint64
+	NoIndexUsed  uint64
+}
+
+func (dd *DigestDelta) AvgLatency() time.Duration {
+	if dd.ExecCount == 0 {
+		return 0
+	}
+	return dd.TimerWait / time.Duration(dd.ExecCount)
+}
+
+// 可用于explain的SQL，没有或被截断（performance_schema_max_sql_text_length）时返回false
+func (dd *DigestDelta) ExplainableQuery() (string, bool) {
+	query := strings.TrimSpace(dd.Sample.QuerySampleText)
+	if query == "" || strings.HasSuffix(query, "...") {
+		return "", false
+	}
+	return query, true
+}
+
+func queryDigestRows(ctx context.Context, tx *sql.Tx, withSample bool) ([]DigestSample, error) {
+	sampleColumn := "NULL"
+	if withSample {
+		sampleColumn = "QUERY_SAMPLE_TEXT"
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT SCHEMA_NAME, DIGEST, DIGEST_TEXT, "+sampleColumn+", COUNT_STAR, "+
+		"SUM_TIMER_WAIT, SUM_ROWS_EXAMINED, SUM_ROWS_SENT, SUM_NO_INDEX_USED "+
+		"FROM performance_schema.events_statements_summary_by_digest "+
+		"WHERE SCHEMA_NAME = DATABASE() AND DIGEST IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	samples := []DigestSample{}
+	for rows.Next() {
+		var schema, digest, digestText, sampleText sql.NullString
+		var sample DigestSample
+		if err := rows.Scan(&schema, &digest, &digestText, &sampleText, &sample.CountStar,
+			&sample.SumTimerWait, &sample.SumRowsExamined, &sample.SumRowsSent, &sample.SumNoIndexUsed); err != nil {
+			return nil, err
+		}
+		sample.Schema = schema.String
+		sample.Digest = digest.String
+		sample.DigestText = digestText.String
+		sample.QuerySampleText = sampleText.String
+		sample.SampledAt = now
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// 当前库（DATABASE()）的语句摘要，5.7中没有QUERY_SAMPLE_TEXT列时不读取该列
+func QueryDigestSamples(db *sql.DB, timeout time.Duration) ([]DigestSample, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// 针对 mysql 5.7.x 版本在context方面的bug，workaround
+	if notSupportContext {
+		timeout = timeout * 100
+	}
+	defer time.AfterFunc(timeout, cancel).Stop()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = safeRollback("QueryDigestSamples() rollback", tx)
+	}()
+
+	samples, err := queryDigestRows(ctx, tx, true)
+	if err != nil && strings.Contains(err.Error(), "1054") { // 1054 Unknown column
+		samples, err = queryDigestRows(ctx, tx, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return samples, tx.Commit()
+}
+
+// 记录各DIGEST上一次的采样，用于计算计数器的增量
+type DigestMonitor struct {
+	mutex   sync.Mutex
+	samples map[string]DigestSample
+}
+
+func NewDigestMonitor() *DigestMonitor {
+	return &DigestMonitor{samples: make(map[string]DigestSample)}
+}
+
+// 与上一次采样对比，返回有新的执行的增量，按增量的耗时从大到小排序
+// 本次采样中不存在的DIGEST（被淘汰）不再保留
+func (dm *DigestMonitor) Diff(samples []DigestSample) []DigestDelta {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	prevs := dm.samples
+	dm.samples = make(map[string]DigestSample, len(samples))
+
+	deltas := []DigestDelta{}
+	for i := 0; i < len(samples); i++ {
+		cur := samples[i]
+		key := cur.Schema + "|" + cur.Digest
+		dm.samples[key] = cur
+
+		prev, ok := prevs[key]
+		if !ok {
+			continue
+		}
+		if cur.CountStar < prev.CountStar {
+			// 计数器被重置
+			prev = DigestSample{}
+		}
+		if cur.CountStar == prev.CountStar {
+			continue
+		}
+		deltas = append(deltas, DigestDelta{
+			Sample:       cur,
+			ExecCount:    cur.CountStar - prev.CountStar,
+			TimerWait:    time.Duration(counterDelta(cur.SumTimerWait, prev.SumTimerWait) / 1000),
+			RowsExamined: counterDelta(cur.SumRowsExamined, prev.SumRowsExamined),
+			RowsSent:     counterDelta(cur.SumRowsSent, prev.SumRowsSent),
+			NoIndexUsed:  counterDelta(cur.SumNoIndexUsed, prev.SumNoIndexUsed),
+		})
+	}
+	sort.SliceStable(deltas, func(i, j int) bool {
+		return deltas[i].TimerWait > deltas[j].TimerWait
+	})
+	return deltas
+}
+
+func counterDelta(cur, prev uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// 检测增量中的计数器，maxRowsExaminedRatio<=0时不检测扫描/返回比
+func CheckDigestDelta(delta DigestDelta, maxExecTime time.Duration, maxRowsExaminedRatio float64) []error {
+	errs := []error{}
+	if avg := delta.AvgLatency(); avg > maxExecTime {
+		errs = append(errs, NewPolicyError(ErrPolicyCodeExeCost,
+			fmt.Sprintf("Too much time spent in execution of digest %v: avg cost(%.3fms) of %v executions > MaxExecTime(%v)",
+				delta.Sample.Digest, float64(avg.Nanoseconds())/float64(1000000), delta.ExecCount, maxExecTime)))
+	}
+
+	reasons := []string{}
+	if delta.NoIndexUsed > 0 {
+		reasons = append(reasons, fmt.Sprintf("%v of %v executions used no index", delta.NoIndexUsed, delta.ExecCount))
+	}
+	if maxRowsExaminedRatio > 0 && delta.RowsExamined >= MinRowsExaminedOfDigestCheck*delta.ExecCount {
+		sent := delta.RowsSent
+		if sent == 0 {
+			sent = 1
+		}
+		if ratio := float64(delta.RowsExamined) / float64(sent); ratio > maxRowsExaminedRatio {
+			reasons = append(reasons, fmt.Sprintf("rows examined %v / rows sent %v = %0.f > %v",
+				delta.RowsExamined, delta.RowsSent, ratio, maxRowsExaminedRatio))
+		}
+	}
+	if len(reasons) > 0 {
+		errs = append(errs, NewPolicyError(WarnPolicyCodeDigestInefficient,
+			fmt.Sprintf("Inefficient statements of digest %v since last scan: %v",
+				delta.Sample.Digest, strings.Join(reasons, ", "))))
+	}
+	return errs
+}
@@ -0,0 +1,88 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDigestMonitorDiff(t *testing.T) {
+	dm := NewDigestMonitor()
+	first := []DigestSample{
+		{Schema: "test", Digest: "a", CountStar: 10, SumTimerWait: 10 * 1000000000, SumRowsExamined: 100, SumRowsSent: 10},
+		{Schema: "test", Digest: "b", CountStar: 5, SumTimerWait: 5000, SumRowsExamined: 5, SumRowsSent: 5},
+	}
+	// 首次出现只记录
+	if deltas := dm.Diff(first); len(deltas) != 0 {
+		t.Fatalf("unexpected deltas %+v", deltas)
+	}
+
+	second := []DigestSample{
+		{Schema: "test", Digest: "a", CountStar: 12, SumTimerWait: 30 * 1000000000, SumRowsExamined: 300, SumRowsSent: 12, SumNoIndexUsed: 2},
+		{Schema: "test", Digest: "b", CountStar: 5, SumTimerWait: 5000, SumRowsExamined: 5, SumRowsSent: 5},
+		{Schema: "test", Digest: "c", CountStar: 1},
+	}
+	deltas := dm.Diff(second)
+	if len(deltas) != 1 {
+		t.Fatalf("unexpected deltas %+v", deltas)
+	}
+	delta := deltas[0]
+	if delta.Sample.Digest != "a" || delta.ExecCount != 2 || delta.TimerWait != 20*time.Millisecond ||
+		delta.RowsExamined != 200 || delta.RowsSent != 2 || delta.NoIndexUsed != 2 {
+		t.Fatalf("unexpected delta %+v", delta)
+	}
+	if delta.AvgLatency() != 10*time.Millisecond {
+		t.Fatalf("unexpected avg latency %v", delta.AvgLatency())
+	}
+
+	// 计数器被重置，以本次的值作为增量；上一次出现而本次没有的b被淘汰
+	third := []DigestSample{
+		{Schema: "test", Digest: "a", CountStar: 3, SumTimerWait: 3000},
+		{Schema: "test", Digest: "c", CountStar: 4},
+	}
+	deltas = dm.Diff(third)
+	if len(deltas) != 2 || deltas[0].Sample.Digest != "a" || deltas[0].ExecCount != 3 || deltas[1].ExecCount != 3 {
+		t.Fatalf("unexpected deltas %+v", deltas)
+	}
+	if _, ok := dm.samples["test|b"]; ok {
+		t.Fatalf("evicted digest should not be kept")
+	}
+}
+
+func TestDigestDeltaExplainableQuery(t *testing.T) {
+	delta := DigestDelta{Sample: DigestSample{QuerySampleText: " SELECT * FROM user WHERE id = 1 "}}
+	if query, ok := delta.ExplainableQuery(); !ok || query != "SELECT * FROM user WHERE id = 1" {
+		t.Fatalf("unexpected query %v %v", query, ok)
+	}
+	delta = DigestDelta{Sample: DigestSample{QuerySampleText: "SELECT * FROM user WHERE name IN ('a', 'b', ..."}}
+	if _, ok := delta.ExplainableQuery(); ok {
+		t.Fatalf("truncated sample should not be explained")
+	}
+	delta = DigestDelta{Sample: DigestSample{DigestText: "SELECT * FROM `user`"}}
+	if _, ok := delta.ExplainableQuery(); ok {
+		t.Fatalf("empty sample should not be explained")
+	}
+}
+
+func TestCheckDigestDelta(t *testing.T) {
+	delta := DigestDelta{Sample: DigestSample{Digest: "a"}, ExecCount: 2, TimerWait: 8 * time.Second, RowsExamined: 10, RowsSent: 10}
+	errs := CheckDigestDelta(delta, DefaultMaxExecTime, DefaultDigestRowsExaminedRatio)
+	if len(errs) != 1 || errs[0].(*PolicyError).Code != ErrPolicyCodeExeCost {
+		t.Fatalf("unexpected errs %v", errs)
+	}
+
+	delta = DigestDelta{Sample: DigestSample{Digest: "a"}, ExecCount: 2, RowsExamined: 200000, RowsSent: 2, NoIndexUsed: 1}
+	errs = CheckDigestDelta(delta, DefaultMaxExecTime, DefaultDigestRowsExaminedRatio)
+	if len(errs) != 1 || errs[0].(*PolicyError).Code != WarnPolicyCodeDigestInefficient {
+		t.Fatalf("unexpected errs %v", errs)
+	}
+
+	// 扫描行数不足MinRowsExaminedOfDigestCheck，或ratio<=0时不检测扫描/返回比
+	delta = DigestDelta{Sample: DigestSample{Digest: "a"}, ExecCount: 2, RowsExamined: 1000, RowsSent: 0}
+	if errs = CheckDigestDelta(delta, DefaultMaxExecTime, DefaultDigestRowsExaminedRatio); len(errs) != 0 {
+		t.Fatalf("unexpected errs %v", errs)
+	}
+	delta.RowsExamined = 200000
+	if errs = CheckDigestDelta(delta, DefaultMaxExecTime, 0); len(errs) != 0 {
+		t.Fatalf("unexpected errs %v", errs)
+	}
+}
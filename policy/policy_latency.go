@@ -0,0 +1,84 @@
+package policy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+/*
+
+按SQL指纹自适应的执行时长检测
+
+MaxExecTime是全局的上限（默认3s），对1ms的主键查询过于宽松，对夜间的报表又过于严格。
+按指纹学习执行时长的基线（EWMA，α = LatencyEWMAAlpha），样本数达到warmup之后，
+执行时长 > 基线 × factor 且不短于minAnomaly时告警，即使远小于MaxExecTime。
+
+1. 异常的样本按 基线 × factor 计入基线，避免单次的毛刺拉高基线；持续变慢时基线会逐渐适应
+2. 基线至多保留MaxLatencyFingerprints个指纹，超出则淘汰最久未执行的
+
+*/
+
+const (
+	DefaultLatencyAnomalyFactor = 10.0
+	DefaultLatencyWarmupSamples = 20
+	DefaultMinLatencyAnomaly    = 10 * time.Millisecond
+	LatencyEWMAAlpha            = 0.1
+	MaxLatencyFingerprints      = 10000
+)
+
+type latencyStat struct {
+	ewma    float64 // 纳秒
+	samples int
+}
+
+type LatencyBaseline struct {
+	mutex     sync.Mutex
+	baselines *simplelru.LRU
+}
+
+func NewLatencyBaseline() *LatencyBaseline {
+	baselines, _ := simplelru.NewLRU(MaxLatencyFingerprints, nil)
+	return &LatencyBaseline{baselines: baselines}
+}
+
+// 指纹的基线及样本数
+func (lb *LatencyBaseline) Baseline(fingerprint string) (time.Duration, int) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	v, ok := lb.baselines.Peek(fingerprint)
+	if !ok {
+		return 0, 0
+	}
+	stat := v.(*latencyStat)
+	return time.Duration(stat.ewma), stat.samples
+}
+
+// 记录指纹的一次执行时长cost并更新基线，相对于之前的基线异常时返回WarnPolicyCodeLatencyAnomaly
+func (lb *LatencyBaseline) Observe(fingerprint string, cost time.Duration, factor float64, warmup int, minAnomaly time.Duration) error {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	v, ok := lb.baselines.Get(fingerprint)
+	if !ok {
+		lb.baselines.Add(fingerprint, &latencyStat{ewma: float64(cost), samples: 1})
+		return nil
+	}
+	stat := v.(*latencyStat)
+
+	var err error
+	sample := float64(cost)
+	ceiling := stat.ewma * factor
+	if stat.samples >= warmup && sample > ceiling && cost >= minAnomaly {
+		err = NewPolicyError(WarnPolicyCodeLatencyAnomaly,
+			fmt.Sprintf("Latency anomaly of fingerprint(%v): cost(%.3fms) > %v × baseline(%.3fms) of %v samples",
+				fingerprint, float64(cost.Nanoseconds())/float64(1000000), factor, stat.ewma/float64(1000000), stat.samples))
+		sample = ceiling
+	}
+	stat.ewma += LatencyEWMAAlpha * (sample - stat.ewma)
+	stat.samples++
+	return err
+}
@@ -0,0 +1,60 @@
+package policy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLatencyBaselineObserve(t *testing.T) {
+	lb := NewLatencyBaseline()
+	fp := "select * from user where id = ?"
+
+	// 预热期内不告警
+	for i := 0; i < 5; i++ {
+		if err := lb.Observe(fp, time.Millisecond, 10, 5, 5*time.Millisecond); err != nil {
+			t.Fatalf("%v: unexpected error %v", i, err)
+		}
+	}
+	if baseline, samples := lb.Baseline(fp); baseline != time.Millisecond || samples != 5 {
+		t.Fatalf("unexpected baseline %v of %v samples", baseline, samples)
+	}
+
+	// 短于minAnomaly的不告警
+	if err := lb.Observe(fp, 4*time.Millisecond, 10, 5, 5*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	baseline, _ := lb.Baseline(fp)
+	err := lb.Observe(fp, 100*time.Millisecond, 10, 5, 5*time.Millisecond)
+	pe, ok := err.(*PolicyError)
+	if !ok || pe.Code != WarnPolicyCodeLatencyAnomaly {
+		t.Fatalf("latency anomaly not covered, got %v", err)
+	}
+	// 异常的样本按 基线 × factor 计入
+	expect := time.Duration(float64(baseline) + LatencyEWMAAlpha*(float64(baseline)*10-float64(baseline)))
+	if after, _ := lb.Baseline(fp); after != expect {
+		t.Fatalf("anomaly should be clamped, baseline %v expect %v", after, expect)
+	}
+
+	// 其他指纹互不影响
+	if err := lb.Observe("select * from report", 2*time.Second, 10, 0, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := lb.Observe("select * from report", 3*time.Second, 10, 0, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestLatencyBaselineEviction(t *testing.T) {
+	lb := NewLatencyBaseline()
+	for i := 0; i <= MaxLatencyFingerprints; i++ {
+		_ = lb.Observe(fmt.Sprintf("select * from t%v", i), time.Millisecond, 10, 0, 0)
+	}
+	if _, samples := lb.Baseline("select * from t0"); samples != 0 {
+		t.Fatalf("the oldest fingerprint should be evicted")
+	}
+	if _, samples := lb.Baseline(fmt.Sprintf("select * from t%v", MaxLatencyFingerprints)); samples != 1 {
+		t.Fatalf("the newest fingerprint should be kept")
+	}
+}
@@ -0,0 +1,33 @@
+package policy
+
+import (
+	"fmt"
+)
+
+/*
+
+N+1查询检测策略
+
+ORM风格的循环中，同一个请求内会以不同的参数执行同一条SQL成百上千次：
+
+	for _, uid := range uids {
+		db.QueryRowContext(ctx, "select * from user where uid = ?", uid)
+	}
+
+每一条都很快，任何基于explain的策略都不会告警，但总代价往往远超一条
+select * from user where uid in (...) 或 JOIN。
+
+在同一个作用域（例如一次HTTP请求）内，按SQL指纹统计执行次数，超过上限则告警。
+
+*/
+
+const (
+	DefaultMaxRepeatedQueries = 20
+)
+
+func NewRepeatedQueryError(fingerprint string, count int, maxRepeated int, scope string, caller string) *PolicyError {
+	return NewPolicyError(ErrPolicyCodeRepeatedQuery,
+		fmt.Sprintf("Query fingerprint(%v) executed %v times > maxRepeatedQueries(%v) within scope %v, called from %v, "+
+			"consider batching via IN (...) or a JOIN",
+			fingerprint, count, maxRepeated, scope, caller))
+}
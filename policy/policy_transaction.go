@@ -0,0 +1,129 @@
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"gitlab.papegames.com/fringe/mskeeper/misc"
+)
+
+/*
+
+事务级别的检测策略
+
+单条SQL都很快，并不代表事务是安全的。事务从BEGIN到COMMIT/ROLLBACK期间一直持有锁，
+以下情况同样会导致锁等待甚至死锁:
+
+1. 事务的总时长过长
+2. 事务内的语句数过多
+3. 事务内两条语句之间的空闲时间过长（例如持有锁的同时进行HTTP调用）
+4. 事务在被GC回收之前都没有COMMIT/ROLLBACK
+
+*/
+
+const (
+	DefaultMaxTxDuration   time.Duration = 5 * time.Second
+	DefaultMaxTxStatements               = 100
+	DefaultMaxTxIdleTime   time.Duration = 1 * time.Second
+)
+
+type TxStatement struct {
+	Query string
+	Start time.Time     // 语句开始执行的时间
+	Cost  time.Duration // 语句执行的时间
+	Idle  time.Duration // 距离上一条语句结束（或事务开始）的空闲时间
+}
+
+type TxRecord struct {
+	Begin      time.Time
+	Statements []TxStatement
+
+	lastEnd time.Time
+}
+
+func NewTxRecord(begin time.Time) *TxRecord {
+	return &TxRecord{Begin: begin, lastEnd: begin}
+}
+
+// 记录事务内一条语句，start为开始执行的时间，end为执行结束的时间
+func (tr *TxRecord) Record(query string, start, end time.Time) {
+	idle := start.Sub(tr.lastEnd)
+	if idle < 0 {
+		idle = 0
+	}
+	tr.Statements = append(tr.Statements, TxStatement{
+		Query: query,
+		Start: start,
+		Cost:  end.Sub(start),
+		Idle:  idle,
+	})
+	tr.lastEnd = end
+}
+
+// 事务内最长的空闲时间及其之后的语句下标
+func (tr *TxRecord) MaxIdle() (time.Duration, int) {
+	maxIdle := time.Duration(0)
+	index := -1
+	for i := 0; i < len(tr.Statements); i++ {
+		if tr.Statements[i].Idle > maxIdle {
+			maxIdle = tr.Statements[i].Idle
+			index = i
+		}
+	}
+	return maxIdle, index
+}
+
+// 事务内的语句序列，每条语句相对事务开始的时间偏移、空闲时间及执行时间；
+// 语句以指纹给出：告警的SQL（BEGIN; ...; END）无法解析，其中的常量不会被脱敏
+func (tr *TxRecord) Sequence() string {
+	buf := bytes.NewBufferString("")
+	for i := 0; i < len(tr.Statements); i++ {
+		stmt := tr.Statements[i]
+		buf.WriteString(fmt.Sprintf("[%v] +%.3fms idle %.3fms cost %.3fms: %v; ",
+			i+1, msOfDuration(stmt.Start.Sub(tr.Begin)), msOfDuration(stmt.Idle), msOfDuration(stmt.Cost), misc.FingerprintSQL(stmt.Query)))
+	}
+	return buf.String()
+}
+
+func msOfDuration(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / float64(1000000)
+}
+
+// 在事务结束(end)时检查，finished为false表示事务直到被回收都没有COMMIT/ROLLBACK
+func (tr *TxRecord) Check(end time.Time, finished bool, maxDuration time.Duration, maxStatements int, maxIdle time.Duration) []error {
+	errs := make([]error, 0)
+
+	if !finished {
+		errs = append(errs, NewPolicyError(ErrPolicyCodeTxNotFinished,
+			fmt.Sprintf("Transaction begun at %v was garbage collected without Commit or Rollback, statements(%v): %v",
+				tr.Begin.Format(time.RFC3339), len(tr.Statements), tr.Sequence())))
+	}
+
+	duration := end.Sub(tr.Begin)
+	if duration > maxDuration {
+		errs = append(errs, NewPolicyError(ErrPolicyCodeTxDuration,
+			fmt.Sprintf("Transaction lasts too long: duration(%.3fms) > maxTxDuration(%v), statements(%v): %v",
+				msOfDuration(duration), maxDuration, len(tr.Statements), tr.Sequence())))
+	}
+
+	if len(tr.Statements) > maxStatements {
+		errs = append(errs, NewPolicyError(ErrPolicyCodeTxStatements,
+			fmt.Sprintf("Too many statements in transaction: statements(%v) > maxTxStatements(%v): %v",
+				len(tr.Statements), maxStatements, tr.Sequence())))
+	}
+
+	idle, index := tr.MaxIdle()
+	where := fmt.Sprintf("statement [%v]", index+1)
+	// 最后一条语句到COMMIT/ROLLBACK之间的空闲
+	if finished && end.Sub(tr.lastEnd) > idle {
+		idle = end.Sub(tr.lastEnd)
+		where = "Commit/Rollback"
+	}
+	if idle > maxIdle {
+		errs = append(errs, NewPolicyError(ErrPolicyCodeTxIdle,
+			fmt.Sprintf("Transaction idles too long while holding locks: idle(%.3fms) before %v > maxTxIdleTime(%v), statements(%v): %v",
+				msOfDuration(idle), where, maxIdle, len(tr.Statements), tr.Sequence())))
+	}
+	return errs
+}
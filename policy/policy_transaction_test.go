@@ -0,0 +1,92 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func hasPolicyCode(errs []error, code PolicyCode) bool {
+	for i := 0; i < len(errs); i++ {
+		if pe, ok := errs[i].(*PolicyError); ok && pe.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTxRecordSafe(t *testing.T) {
+	begin := time.Now()
+	tr := NewTxRecord(begin)
+	tr.Record("update t set a = 1 where id = 1", begin.Add(1*time.Millisecond), begin.Add(2*time.Millisecond))
+	tr.Record("update t set a = 2 where id = 2", begin.Add(3*time.Millisecond), begin.Add(4*time.Millisecond))
+
+	errs := tr.Check(begin.Add(5*time.Millisecond), true, DefaultMaxTxDuration, DefaultMaxTxStatements, DefaultMaxTxIdleTime)
+	if len(errs) != 0 {
+		t.Fatalf("safe transaction reported %v", errs)
+	}
+	if idle, index := tr.MaxIdle(); idle != 1*time.Millisecond || index != 0 {
+		t.Fatalf("MaxIdle() got %v %v", idle, index)
+	}
+}
+
+func TestTxRecordViolations(t *testing.T) {
+	begin := time.Now()
+	tr := NewTxRecord(begin)
+	tr.Record("select * from t where id = 1 for update", begin, begin.Add(1*time.Millisecond))
+	// 持有锁的同时进行了2秒的HTTP调用
+	tr.Record("update t set a = 1 where id = 1", begin.Add(2*time.Second), begin.Add(2*time.Second+time.Millisecond))
+
+	errs := tr.Check(begin.Add(6*time.Second), true, DefaultMaxTxDuration, 1, DefaultMaxTxIdleTime)
+	if !hasPolicyCode(errs, ErrPolicyCodeTxDuration) {
+		t.Fatalf("long transaction not covered %v", errs)
+	}
+	if !hasPolicyCode(errs, ErrPolicyCodeTxStatements) {
+		t.Fatalf("too many statements not covered %v", errs)
+	}
+	if !hasPolicyCode(errs, ErrPolicyCodeTxIdle) {
+		t.Fatalf("idle transaction not covered %v", errs)
+	}
+	if hasPolicyCode(errs, ErrPolicyCodeTxNotFinished) {
+		t.Fatalf("finished transaction reported as not finished %v", errs)
+	}
+	// 毫秒按定点数输出，而不是5e+03ms
+	for i := 0; i < len(errs); i++ {
+		if pe := errs[i].(*PolicyError); pe.Code == ErrPolicyCodeTxDuration && !strings.Contains(pe.Msg, "duration(6000.000ms)") {
+			t.Fatalf("unexpected duration format %v", pe)
+		}
+		if strings.Contains(errs[i].Error(), "e+") {
+			t.Fatalf("unexpected exponent format %v", errs[i])
+		}
+	}
+	// 告警中包含完整的语句序列，语句为其指纹
+	for i := 0; i < len(errs); i++ {
+		if !strings.Contains(errs[i].Error(), "[1]") || !strings.Contains(errs[i].Error(), "update t set a = ? where id = ?") ||
+			strings.Contains(errs[i].Error(), "id = 1") {
+			t.Fatalf("statement sequence missing in %v", errs[i])
+		}
+	}
+}
+
+func TestTxRecordIdleBeforeCommit(t *testing.T) {
+	begin := time.Now()
+	tr := NewTxRecord(begin)
+	tr.Record("update t set a = 1 where id = 1", begin, begin.Add(1*time.Millisecond))
+
+	errs := tr.Check(begin.Add(2*time.Second), true, DefaultMaxTxDuration, DefaultMaxTxStatements, DefaultMaxTxIdleTime)
+	if !hasPolicyCode(errs, ErrPolicyCodeTxIdle) || !strings.Contains(errs[0].Error(), "Commit/Rollback") {
+		t.Fatalf("idle before commit not covered %v", errs)
+	}
+}
+
+func TestTxRecordNotFinished(t *testing.T) {
+	begin := time.Now()
+	tr := NewTxRecord(begin)
+	tr.Record("update t set a = 1 where id = 1", begin, begin.Add(1*time.Millisecond))
+
+	// 未结束的事务不计算最后一条语句之后的空闲
+	errs := tr.Check(begin.Add(2*time.Second), false, DefaultMaxTxDuration, DefaultMaxTxStatements, DefaultMaxTxIdleTime)
+	if len(errs) != 1 || !hasPolicyCode(errs, ErrPolicyCodeTxNotFinished) {
+		t.Fatalf("unfinished transaction not covered %v", errs)
+	}
+}